@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -23,14 +27,38 @@ const (
 
 // ScrapeRequest defines the structure for a client's scrape request.
 type ScrapeRequest struct {
-	Queries []string `json:"queries,omitempty"`
-	Limit   int      `json:"limit,omitempty"`
-	Command string   `json:"command,omitempty"`
+	Queries       []string `json:"queries,omitempty"`
+	Limit         int      `json:"limit,omitempty"`
+	LimitPerQuery int      `json:"limitPerQuery,omitempty"`
+	Command       string   `json:"command,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	BatchSize     int      `json:"batchSize,omitempty"`
+	Topic         string   `json:"topic,omitempty"`
+}
+
+// zipMagic is the signature at the start of every zip archive.
+var zipMagic = []byte("PK\x03\x04")
+
+// chunkMagic marks binary frames that carry part of a chunked payload.
+var chunkMagic = []byte("RCNK")
+
+const chunkHeaderSize = 16
+
+// Message is the envelope shared by all JSON text messages from the server.
+type Message struct {
+	Type   string `json:"type"`
+	SHA256 string `json:"sha256,omitempty"`
 }
 
 type wsHandler struct {
 	outputDir  string
 	imageCount int64
+	batchCount int64
+	transfers  map[uint32][]byte
+	// expectedSHA256 is the checksum announced for the next binary payload.
+	expectedSHA256 string
+	// done is called once the server reports that the job has ended.
+	done context.CancelFunc
 }
 
 func (c *wsHandler) OnOpen(socket *gws.Conn) {
@@ -41,32 +69,89 @@ func (c *wsHandler) OnClose(socket *gws.Conn, err error) {
 	log.Printf("Socket closed: %v", err)
 }
 
-func (c *wsHandler) OnPing(socket *gws.Conn, payload []byte) {}
+func (c *wsHandler) OnPing(socket *gws.Conn, payload []byte) {
+	_ = socket.WritePong(payload)
+}
 func (c *wsHandler) OnPong(socket *gws.Conn, payload []byte) {}
 func (c *wsHandler) OnMessage(socket *gws.Conn, message *gws.Message) {
 	defer message.Close()
 
 	if message.Opcode == gws.OpcodeBinary {
-		count := atomic.AddInt64(&c.imageCount, 1)
-		fileName := fmt.Sprintf("image_%d.jpg", count)
-		filePath := filepath.Join(c.outputDir, fileName)
-		if err := os.WriteFile(filePath, message.Bytes(), 0644); err != nil {
-			log.Printf("Failed to save image: %v", err)
+		c.handleBinary(message.Bytes())
+	} else {
+		var msg Message
+		if err := json.Unmarshal(message.Bytes(), &msg); err == nil {
+			switch msg.Type {
+			case "image", "batch":
+				c.expectedSHA256 = msg.SHA256
+			case "complete":
+				log.Printf("Job complete: %s", string(message.Bytes()))
+				c.done()
+				return
+			}
+		}
+		log.Printf("Received message: %s", string(message.Bytes()))
+	}
+}
+
+// handleBinary saves a binary payload, reassembling chunked transfers first.
+func (c *wsHandler) handleBinary(data []byte) {
+	if bytes.HasPrefix(data, chunkMagic) && len(data) >= chunkHeaderSize {
+		id := binary.BigEndian.Uint32(data[4:])
+		index := binary.BigEndian.Uint32(data[8:])
+		count := binary.BigEndian.Uint32(data[12:])
+		c.transfers[id] = append(c.transfers[id], data[chunkHeaderSize:]...)
+		if index+1 < count {
+			return
+		}
+		data = c.transfers[id]
+		delete(c.transfers, id)
+	}
+
+	if c.expectedSHA256 != "" {
+		sum := sha256.Sum256(data)
+		expected := c.expectedSHA256
+		c.expectedSHA256 = ""
+		if hex.EncodeToString(sum[:]) != expected {
+			log.Printf("Checksum mismatch, discarding payload of %d bytes", len(data))
+			return
+		}
+	}
+
+	if bytes.HasPrefix(data, zipMagic) {
+		count := atomic.AddInt64(&c.batchCount, 1)
+		filePath := filepath.Join(c.outputDir, fmt.Sprintf("batch_%d.zip", count))
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			log.Printf("Failed to save batch: %v", err)
 		} else {
-			log.Printf("Saved image as %s", filePath)
+			log.Printf("Saved batch as %s", filePath)
 		}
+		return
+	}
+
+	count := atomic.AddInt64(&c.imageCount, 1)
+	fileName := fmt.Sprintf("image_%d.jpg", count)
+	filePath := filepath.Join(c.outputDir, fileName)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		log.Printf("Failed to save image: %v", err)
 	} else {
-		log.Printf("Received message: %s", string(message.Bytes()))
+		log.Printf("Saved image as %s", filePath)
 	}
 }
 
 func main() {
 	queriesFile := flag.String("queries", "queries.json", "Path to the JSON file containing a list of queries.")
 	limit := flag.Int("limit", 255, "The maximum number of images to download.")
+	limitPerQuery := flag.Int("limit-per-query", 0, "The maximum number of images to download per query (0 for no cap).")
 	outputDir := flag.String("output", "output", "The directory to save images to.")
 	clear := flag.Bool("clear", false, "Clear the client's history on the server.")
 	serverName := flag.String("server-name", "my-discord-bot", "The server name for authentication.")
 	password := flag.String("password", "super-secret-password", "The password for authentication.")
+	addr := flag.String("addr", "ws://localhost:8080/scrape", "The server's WebSocket URL; use wss:// for servers with TLS enabled.")
+	token := flag.String("token", "", "A JWT bearer token to authenticate with instead of the server name and password.")
+	mode := flag.String("mode", "stream", "Delivery mode: \"stream\" for single images or \"zip\" for batched archives.")
+	batchSize := flag.Int("batch-size", 50, "The number of images per archive in zip mode.")
+	topic := flag.String("topic", "", "Subscribe to a shared topic instead of sending the query list.")
 	flag.Parse()
 
 	if err := os.MkdirAll(*outputDir, 0755); err != nil {
@@ -80,16 +165,20 @@ func main() {
 	}
 
 	headers := http.Header{}
-	headers.Set("X-Server-Name", *serverName)
-	headers.Set("X-Password", *password)
-
-	handler := &wsHandler{outputDir: *outputDir}
+	if *token != "" {
+		headers.Set("Authorization", "Bearer "+*token)
+	} else {
+		headers.Set("X-Server-Name", *serverName)
+		headers.Set("X-Password", *password)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	handler := &wsHandler{outputDir: *outputDir, transfers: make(map[uint32][]byte), done: cancel}
+
 	socket, _, err := gws.NewClient(handler, &gws.ClientOption{
-		Addr:          "ws://localhost:8080/scrape",
+		Addr:          *addr,
 		RequestHeader: headers,
 		PermessageDeflate: gws.PermessageDeflate{
 			Enabled: true,
@@ -112,15 +201,21 @@ func main() {
 			time.Sleep(1 * time.Second) // Give server a moment to process
 		}
 
-		// Send the full list of queries to the server
+		// Subscribe to a shared topic, or send the full list of queries to the server
 		req := ScrapeRequest{
-			Queries: queries,
-			Limit:   *limit,
+			Queries:       queries,
+			Limit:         *limit,
+			LimitPerQuery: *limitPerQuery,
+			Mode:          *mode,
+			BatchSize:     *batchSize,
+		}
+		if *topic != "" {
+			req = ScrapeRequest{Command: "subscribe", Topic: *topic, Mode: *mode, BatchSize: *batchSize}
 		}
 		reqBytes, _ := json.Marshal(req)
-		log.Println("Sending query list to server...", "limit", *limit)
+		log.Println("Sending request to server...", "limit", *limit, "topic", *topic)
 		if err := socket.WriteMessage(gws.OpcodeText, reqBytes); err != nil {
-			log.Printf("Failed to send query list: %v", err)
+			log.Printf("Failed to send request: %v", err)
 			return
 		}
 	}()
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const consoleHelp = `Commands:
+  clients             List connected clients with their connections and jobs
+  clientstats [client]
+                      Show the lifetime statistics of every client, or of one with its queries
+  jobs                List running jobs and topic scrapes
+  stop <client>       Disconnect a client and stop its jobs
+  stopjob <id>        Stop a single job
+  stats               Show server and database statistics
+  loglevel [level]    Show or change the log level (debug, info, warn or error)
+  logs [level] [n]    Show the n newest log records (default 50), optionally from a level up
+  cleanup             Remove history and audit entries past their configured age
+  compact             Shrink the database file to the size of its entries
+  help                Show this help
+  exit                Leave the console
+`
+
+// adminClient calls the admin API of a running server as a client with the admin role.
+type adminClient struct {
+	base       string
+	http       *http.Client
+	serverName string
+	password   string
+	token      string
+}
+
+// newAdminClient returns a client for the server at addr, which is an HTTP(S) URL or "unix:" followed
+// by the path of a listener's Unix socket.
+func newAdminClient(addr, serverName, password, token string) *adminClient {
+	c := &adminClient{base: strings.TrimRight(addr, "/"), http: &http.Client{Timeout: 30 * time.Second}, serverName: serverName, password: password, token: token}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+// do sends a request and decodes the JSON response into out, if it isn't nil. Error responses are
+// returned as errors with the server's message.
+func (c *adminClient) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		req.Header.Set("X-Server-Name", c.serverName)
+		req.Header.Set("X-Password", c.password)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return errors.New(apiErr.Error)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// consoleJob is a running job as listed by the admin API.
+type consoleJob struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client"`
+	Started time.Time `json:"started"`
+	Query   string    `json:"query"`
+	Sent    int       `json:"sent"`
+	Limit   int       `json:"limit"`
+	Queued  int       `json:"queued"`
+}
+
+// console runs the admin console. Commands given as arguments are run once; otherwise they are read
+// from stdin until exit or end of input.
+func console(args []string) error {
+	fs := flag.NewFlagSet("console", flag.ExitOnError)
+	fs.Usage = flag.Usage
+	addr := fs.String("addr", "http://localhost:8081", "URL of a listener serving the admin routes, or unix:<path> for a Unix socket.")
+	serverName := fs.String("server-name", "", "Name of a client with the admin role.")
+	password := fs.String("password", "", "Password or API key of the client; defaults to $RENDER_PASSWORD.")
+	token := fs.String("token", "", "A JWT bearer token to authenticate with instead of the name and password.")
+	fs.Parse(args)
+
+	if *password == "" {
+		*password = os.Getenv("RENDER_PASSWORD")
+	}
+	if *token == "" && (*serverName == "" || *password == "") {
+		return errors.New("console needs -server-name and -password, or -token")
+	}
+	c := newAdminClient(*addr, *serverName, *password, *token)
+
+	if fs.NArg() > 0 {
+		return c.run(fs.Args())
+	}
+
+	// Check the credentials before prompting, so that a typo isn't reported on every command
+	if err := c.do(http.MethodGet, "/api/admin/stats", nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Connected to %s. Type help for the commands.\n", *addr)
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("render> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "exit" || fields[0] == "quit" {
+			return nil
+		}
+		if err := c.run(fields); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+	}
+}
+
+// run runs a single console command.
+func (c *adminClient) run(args []string) error {
+	switch {
+	case args[0] == "clients" && len(args) == 1:
+		return c.clients()
+	case args[0] == "clientstats" && len(args) <= 2:
+		return c.clientStats(args[1:])
+	case args[0] == "jobs" && len(args) == 1:
+		return c.jobs()
+	case args[0] == "stop" && len(args) == 2:
+		return c.stop(args[1])
+	case args[0] == "stopjob" && len(args) == 2:
+		if err := c.do(http.MethodDelete, "/api/admin/jobs/"+url.PathEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Stopped job", args[1])
+		return nil
+	case args[0] == "stats" && len(args) == 1:
+		return c.stats()
+	case args[0] == "loglevel" && len(args) <= 2:
+		return c.logLevel(args[1:])
+	case args[0] == "logs" && len(args) <= 3:
+		return c.logs(args[1:])
+	case args[0] == "cleanup" && len(args) == 1:
+		if err := c.do(http.MethodPost, "/api/admin/cleanup", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Database cleaned up")
+		return nil
+	case args[0] == "compact" && len(args) == 1:
+		var resp struct {
+			Before int64 `json:"before"`
+			After  int64 `json:"after"`
+		}
+		if err := c.do(http.MethodPost, "/api/admin/compact", nil, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("Database compacted from %s to %s\n", formatBytes(resp.Before), formatBytes(resp.After))
+		return nil
+	case args[0] == "help":
+		fmt.Print(consoleHelp)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, type help for the commands", strings.Join(args, " "))
+}
+
+// clients prints a table of the connected clients.
+func (c *adminClient) clients() error {
+	var resp struct {
+		Clients []struct {
+			Name        string `json:"name"`
+			Connections []struct {
+				RemoteAddr string    `json:"remoteAddr"`
+				Connected  time.Time `json:"connected"`
+				ImagesSent int64     `json:"imagesSent"`
+				BytesSent  int64     `json:"bytesSent"`
+			} `json:"connections"`
+			Jobs []consoleJob `json:"jobs"`
+		} `json:"clients"`
+	}
+	if err := c.do(http.MethodGet, "/api/admin/clients", nil, &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tCONNECTIONS\tJOBS\tIMAGES\tBYTES\tADDRESSES")
+	for _, client := range resp.Clients {
+		var images, bytes int64
+		addrs := make([]string, 0, len(client.Connections))
+		for _, conn := range client.Connections {
+			images += conn.ImagesSent
+			bytes += conn.BytesSent
+			addrs = append(addrs, conn.RemoteAddr)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\n", client.Name, len(client.Connections), len(client.Jobs), images, formatBytes(bytes), strings.Join(addrs, ", "))
+	}
+	return w.Flush()
+}
+
+// consoleClientStats are the lifetime statistics of a client as returned by the admin API.
+type consoleClientStats struct {
+	Images        int64     `json:"images"`
+	Bytes         int64     `json:"bytes"`
+	Deduped       int64     `json:"deduped"`
+	Jobs          int64     `json:"jobs"`
+	LastConnected time.Time `json:"lastConnected"`
+	Queries       map[string]struct {
+		Sent    int64 `json:"sent"`
+		Deduped int64 `json:"deduped"`
+		Failed  int64 `json:"failed"`
+	} `json:"queries"`
+}
+
+// clientStats prints a table of every client's statistics, or the statistics of one client by query.
+func (c *adminClient) clientStats(args []string) error {
+	lastConnected := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(args) == 0 {
+		var resp struct {
+			Clients map[string]consoleClientStats `json:"clients"`
+		}
+		if err := c.do(http.MethodGet, "/api/admin/clients/stats", nil, &resp); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "CLIENT\tIMAGES\tBYTES\tDEDUPED\tJOBS\tLAST CONNECTED")
+		for _, name := range slices.Sorted(maps.Keys(resp.Clients)) {
+			st := resp.Clients[name]
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%s\n", name, st.Images, formatBytes(st.Bytes), st.Deduped, st.Jobs, lastConnected(st.LastConnected))
+		}
+		return w.Flush()
+	}
+
+	var st consoleClientStats
+	if err := c.do(http.MethodGet, "/api/admin/clients/"+url.PathEscape(args[0])+"/stats", nil, &st); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Delivered\t%d images, %s\n", st.Images, formatBytes(st.Bytes))
+	fmt.Fprintf(w, "Deduped\t%d\n", st.Deduped)
+	fmt.Fprintf(w, "Jobs\t%d\n", st.Jobs)
+	fmt.Fprintf(w, "Last connected\t%s\n", lastConnected(st.LastConnected))
+	if len(st.Queries) > 0 {
+		fmt.Fprintln(w, "\nQUERY\tSENT\tDEDUPED\tFAILED")
+		for _, q := range slices.Sorted(maps.Keys(st.Queries)) {
+			t := st.Queries[q]
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", q, t.Sent, t.Deduped, t.Failed)
+		}
+	}
+	return w.Flush()
+}
+
+// jobs prints a table of the running jobs.
+func (c *adminClient) jobs() error {
+	var resp struct {
+		Jobs []consoleJob `json:"jobs"`
+	}
+	if err := c.do(http.MethodGet, "/api/admin/jobs", nil, &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tQUERY\tRUNNING\tSENT")
+	for _, job := range resp.Jobs {
+		sent := fmt.Sprint(job.Sent)
+		if job.Limit > 0 {
+			sent += fmt.Sprintf(" / %d", job.Limit)
+		}
+		running := time.Since(job.Started).Round(time.Second).String()
+		if job.Queued > 0 {
+			running = fmt.Sprintf("queued #%d", job.Queued)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.ID, job.Client, job.Query, running, sent)
+	}
+	return w.Flush()
+}
+
+// stop disconnects a client and stops its jobs.
+func (c *adminClient) stop(client string) error {
+	var resp struct {
+		Connections int `json:"connections"`
+		Jobs        int `json:"jobs"`
+	}
+	if err := c.do(http.MethodDelete, "/api/admin/clients/"+url.PathEscape(client), nil, &resp); err != nil {
+		return err
+	}
+	fmt.Printf("Closed %d connections and stopped %d jobs of %s\n", resp.Connections, resp.Jobs, client)
+	return nil
+}
+
+// stats prints the server and database statistics.
+func (c *adminClient) stats() error {
+	var resp struct {
+		Started         time.Time `json:"started"`
+		Draining        bool      `json:"draining"`
+		ReadOnly        bool      `json:"readOnly"`
+		Connections     int       `json:"connections"`
+		Jobs            int       `json:"jobs"`
+		Memory          int64     `json:"memory"`
+		MemoryState     string    `json:"memoryState"`
+		ImagesDelivered int64     `json:"imagesDelivered"`
+		BytesDelivered  int64     `json:"bytesDelivered"`
+		Database        struct {
+			Size          int64 `json:"size"`
+			Buckets       int   `json:"buckets"`
+			SchemaVersion int   `json:"schemaVersion"`
+			Clients       int   `json:"clients"`
+			SeenImages    int   `json:"seenImages"`
+			AuditEntries  int   `json:"auditEntries"`
+			APIKeys       int   `json:"apiKeys"`
+		} `json:"database"`
+		LastCleanup *struct {
+			Time     time.Time `json:"time"`
+			Duration string    `json:"duration"`
+			Error    string    `json:"error"`
+		} `json:"lastCleanup"`
+	}
+	if err := c.do(http.MethodGet, "/api/admin/stats", nil, &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	uptime := time.Since(resp.Started).Round(time.Second).String()
+	if resp.Draining {
+		uptime += " (draining)"
+	}
+	if resp.ReadOnly {
+		uptime += " (read-only)"
+	}
+	fmt.Fprintf(w, "Uptime\t%s\n", uptime)
+	fmt.Fprintf(w, "Connections\t%d\n", resp.Connections)
+	fmt.Fprintf(w, "Jobs\t%d\n", resp.Jobs)
+	fmt.Fprintf(w, "Memory\t%s (%s)\n", formatBytes(resp.Memory), resp.MemoryState)
+	fmt.Fprintf(w, "Delivered\t%d images, %s\n", resp.ImagesDelivered, formatBytes(resp.BytesDelivered))
+	fmt.Fprintf(w, "Database\t%s in %d buckets (schema v%d), %d history entries of %d clients, %d audit entries, %d API keys\n",
+		formatBytes(resp.Database.Size), resp.Database.Buckets, resp.Database.SchemaVersion, resp.Database.SeenImages, resp.Database.Clients, resp.Database.AuditEntries, resp.Database.APIKeys)
+	if last := resp.LastCleanup; last != nil {
+		cleanup := fmt.Sprintf("%s ago, took %s", time.Since(last.Time).Round(time.Second), last.Duration)
+		if last.Error != "" {
+			cleanup += ", failed: " + last.Error
+		}
+		fmt.Fprintf(w, "Last cleanup\t%s\n", cleanup)
+	}
+	return w.Flush()
+}
+
+// logLevel prints the log level, or changes it if a level is given.
+func (c *adminClient) logLevel(args []string) error {
+	var resp struct {
+		Level string `json:"level"`
+	}
+	var err error
+	if len(args) == 0 {
+		err = c.do(http.MethodGet, "/api/log/level", nil, &resp)
+	} else {
+		err = c.do(http.MethodPut, "/api/log/level", map[string]string{"level": args[0]}, &resp)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Log level:", resp.Level)
+	return nil
+}
+
+// logs prints the newest log records, oldest first like a log file. The arguments are an optional
+// minimum level and number of records, in either order.
+func (c *adminClient) logs(args []string) error {
+	query := url.Values{"limit": {"50"}}
+	for _, arg := range args {
+		if _, err := strconv.Atoi(arg); err == nil {
+			query.Set("limit", arg)
+		} else {
+			query.Set("level", arg)
+		}
+	}
+	var resp struct {
+		Entries []struct {
+			Time    time.Time      `json:"time"`
+			Level   string         `json:"level"`
+			Message string         `json:"message"`
+			Attrs   map[string]any `json:"attrs"`
+		} `json:"entries"`
+	}
+	if err := c.do(http.MethodGet, "/api/admin/logs?"+query.Encode(), nil, &resp); err != nil {
+		return err
+	}
+
+	for _, e := range slices.Backward(resp.Entries) {
+		line := fmt.Sprintf("%s %-5s %s", e.Time.Local().Format(time.DateTime), e.Level, e.Message)
+		for _, key := range slices.Sorted(maps.Keys(e.Attrs)) {
+			line += fmt.Sprintf(" %s=%v", key, e.Attrs[key])
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// formatBytes formats a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
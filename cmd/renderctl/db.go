@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopin/config"
+	"gopin/database"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// importBatch is how many history entries are written per transaction when importing.
+const importBatch = 10000
+
+// exportedEntry is an entry of a client's history in a JSON export. The hash is a string, as JSON
+// numbers lose precision above 2^53.
+type exportedEntry struct {
+	Hash uint64    `json:"hash,string"`
+	Seen time.Time `json:"seen"`
+}
+
+// dbCommand runs a db subcommand.
+func dbCommand(dbPath, configPath string, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "export":
+		fs := flag.NewFlagSet("db export", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		client := fs.String("client", "", "Only export the history of this client.")
+		format := fs.String("format", "", "Format of the export, json or csv; defaults to the extension of -o, or json.")
+		out := fs.String("o", "", "File to write the export to; defaults to stdout.")
+		fs.Parse(args[1:])
+		if fs.NArg() != 0 {
+			break
+		}
+		f, err := historyFormat(*format, *out)
+		if err != nil {
+			return err
+		}
+		return exportHistory(dbPath, configPath, *client, f, *out)
+	case "import":
+		fs := flag.NewFlagSet("db import", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		format := fs.String("format", "", "Format of the file, json or csv; defaults to its extension, or json.")
+		fs.Parse(args[1:])
+		if fs.NArg() > 1 {
+			break
+		}
+		f, err := historyFormat(*format, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return importHistory(dbPath, configPath, f, fs.Arg(0))
+	}
+	flag.Usage()
+	os.Exit(2)
+	return nil
+}
+
+// historyFormat returns the format of an export, picked by the extension of its file if not given.
+func historyFormat(format, path string) (string, error) {
+	if format == "" {
+		format = "json"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+	return format, nil
+}
+
+// openDatabase opens the database with the history backend and encryption key configured in the config
+// file, which is looked for like the server does if no path is given. Without a config file, the history
+// is in the database and it isn't encrypted. A database opened read-only can be read while a server in
+// read-only maintenance mode has it open.
+func openDatabase(dbPath, configPath string, readOnly bool) (*database.DB, error) {
+	opts := database.Options{Timeout: time.Second, ReadOnly: readOnly}
+	if configPath == "" {
+		configPath, _ = config.Find()
+	}
+	if configPath != "" {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		opts.History = cfg.Database.History
+		opts.SQLitePath = cfg.Database.SQLitePath
+		opts.PostgresURL = cfg.Database.PostgresURL
+		opts.RedisURL = cfg.Database.RedisURL
+		opts.RedisPrefix = cfg.Database.RedisPrefix
+		if opts.EncryptionKey, err = database.ParseEncryptionKey(cfg.Database.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := database.OpenOptions(dbPath, opts)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("%w: the database is in use, stop the server first", err)
+	}
+	return db, err
+}
+
+// exportHistory writes the history of a client, or of every client, to a file or stdout.
+func exportHistory(dbPath, configPath, client, format, out string) error {
+	db, err := openDatabase(dbPath, configPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w := os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create export: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	var count int
+	if format == "csv" {
+		count, err = exportCSV(db, client, bw)
+	} else {
+		count, err = exportJSON(db, client, bw)
+	}
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if w != os.Stdout {
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d entries\n", count)
+	return nil
+}
+
+// exportCSV writes the history as CSV rows of client, hash and the time it was seen.
+func exportCSV(db *database.DB, client string, w io.Writer) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"client", "hash", "seen"})
+	count := 0
+	err := db.ExportHistory(client, func(e database.HistoryEntry) error {
+		count++
+		return cw.Write([]string{e.Client, strconv.FormatUint(e.Hash, 10), e.Seen.UTC().Format(time.RFC3339)})
+	})
+	if err != nil {
+		return count, err
+	}
+	cw.Flush()
+	return count, cw.Error()
+}
+
+// exportJSON writes the history as a JSON object of each client's entries. It is written as it is read,
+// as the history of every client may not fit in memory.
+func exportJSON(db *database.DB, client string, w io.Writer) (int, error) {
+	count := 0
+	current := ""
+	io.WriteString(w, "{")
+	err := db.ExportHistory(client, func(e database.HistoryEntry) error {
+		if count == 0 || e.Client != current {
+			name, _ := json.Marshal(e.Client)
+			if count > 0 {
+				io.WriteString(w, "\n  ],")
+			}
+			fmt.Fprintf(w, "\n  %s: [", name)
+		} else {
+			io.WriteString(w, ",")
+		}
+		current = e.Client
+		count++
+
+		entry, err := json.Marshal(exportedEntry{Hash: e.Hash, Seen: e.Seen.UTC()})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "\n    %s", entry)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	if count > 0 {
+		io.WriteString(w, "\n  ]\n")
+	}
+	_, err = io.WriteString(w, "}\n")
+	return count, err
+}
+
+// importHistory merges an export from a file or stdin into the history. Entries that already exist keep
+// the later of both times.
+func importHistory(dbPath, configPath, format, in string) error {
+	r := os.Stdin
+	if in != "" && in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return fmt.Errorf("failed to open import: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	db, err := openDatabase(dbPath, configPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count := 0
+	batch := make([]database.HistoryEntry, 0, importBatch)
+	add := func(e database.HistoryEntry) error {
+		batch = append(batch, e)
+		if len(batch) < importBatch {
+			return nil
+		}
+		return flush(db, &batch, &count)
+	}
+
+	br := bufio.NewReader(r)
+	if format == "csv" {
+		err = importCSV(br, add)
+	} else {
+		err = importJSON(br, add)
+	}
+	if err == nil {
+		err = flush(db, &batch, &count)
+	}
+	if err != nil && count > 0 {
+		return fmt.Errorf("%w (imported %d entries before)", err, count)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d entries\n", count)
+	return nil
+}
+
+// flush writes a batch of entries and empties it.
+func flush(db *database.DB, batch *[]database.HistoryEntry, count *int) error {
+	if len(*batch) == 0 {
+		return nil
+	}
+	if err := db.ImportHistory(*batch); err != nil {
+		return err
+	}
+	*count += len(*batch)
+	*batch = (*batch)[:0]
+	return nil
+}
+
+// importCSV reads the rows of a CSV export. The header row is optional.
+func importCSV(r io.Reader, add func(database.HistoryEntry) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if line == 1 && record[0] == "client" && record[1] == "hash" {
+			continue
+		}
+		hash, err := strconv.ParseUint(record[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid hash %q", line, record[1])
+		}
+		seen, err := time.Parse(time.RFC3339, record[2])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid time %q", line, record[2])
+		}
+		if err := add(database.HistoryEntry{Client: record[0], Hash: hash, Seen: seen}); err != nil {
+			return err
+		}
+	}
+}
+
+// importJSON reads the entries of a JSON export one at a time, so that large exports don't have to fit
+// in memory.
+func importJSON(r io.Reader, add func(database.HistoryEntry) error) error {
+	dec := json.NewDecoder(r)
+	invalid := errors.New("invalid JSON export, expected an object of clients and their entries")
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return invalid
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return invalid
+		}
+		client := t.(string)
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return invalid
+		}
+		for dec.More() {
+			var e exportedEntry
+			if err := dec.Decode(&e); err != nil {
+				return fmt.Errorf("invalid entry of %s: %w", client, err)
+			}
+			if err := add(database.HistoryEntry{Client: client, Hash: e.Hash, Seen: e.Seen}); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return invalid
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return invalid
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Command renderctl manages a Render server's API keys and password hashes, reads its audit log and
+// administers a running server through its admin API.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"gopin/pkg/credential"
+	"gopin/storage"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const usage = `Usage: renderctl [-db path] [-config path] <command>
+
+Commands:
+  keys add [-role role] <client>
+                      Mint a new API key for a client, optionally with its own
+                      role (admin, scraper or read-only)
+  keys list [client]  List API keys, optionally only those of one client
+  keys revoke <id>    Revoke an API key
+  audit [-client name] [-action action] [-limit n]
+                      Print the audit log, newest first
+  db export [-client name] [-format json|csv] [-o file]
+                      Export the history of seen images, of every client or of one, to a file
+                      or stdout
+  db import [-format json|csv] [file]
+                      Import an export from a file or stdin, merging it into the history
+  hash [password]     Print the bcrypt hash of a password for config.json (reads stdin if omitted)
+  console [-addr url] [-server-name name] [-password password] [-token jwt] [command]
+                      Administer a running server through its admin API: run a single command,
+                      or an interactive console without one (see "help" inside it)
+
+The keys, audit and db commands open the database directly, so they need the server to be stopped
+or a database that is not in use; audit and db export also work while the server is in read-only
+maintenance mode. They use the history storage and encryption key of the config
+file, or of the first of config.json, config.yaml, config.yml and config.toml if -config is not given.
+`
+
+func main() {
+	dbPath := flag.String("db", "data/render.db", "Path to the server's database.")
+	configPath := flag.String("config", "", "Path to the server's config file, for the keys, audit and db commands.")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "keys":
+		err = keys(*dbPath, *configPath, args[1:])
+	case "audit":
+		err = audit(*dbPath, *configPath, args[1:])
+	case "db":
+		err = dbCommand(*dbPath, *configPath, args[1:])
+	case "hash":
+		err = hash(args[1:])
+	case "console":
+		err = console(args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "renderctl:", err)
+		os.Exit(1)
+	}
+}
+
+// keys runs a keys subcommand.
+func keys(dbPath, configPath string, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDatabase(dbPath, configPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "add":
+		fs := flag.NewFlagSet("keys add", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		role := fs.String("role", "", "Role of the key; defaults to the client's configured role.")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			break
+		}
+		return addKey(db, fs.Arg(0), *role)
+	case args[0] == "list" && len(args) <= 2:
+		client := ""
+		if len(args) == 2 {
+			client = args[1]
+		}
+		return listKeys(db, client)
+	case args[0] == "revoke" && len(args) == 2:
+		return revokeKey(db, args[1])
+	}
+	flag.Usage()
+	os.Exit(2)
+	return nil
+}
+
+// addKey mints a key for a client and prints it. Only its hash is stored, so it can't be shown again.
+func addKey(db storage.Store, client, role string) error {
+	if client == "" || strings.HasPrefix(client, "_") {
+		return fmt.Errorf("invalid client name %q", client)
+	}
+	switch role {
+	case "", "admin", "scraper", "read-only":
+	default:
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	id, secret, key := credential.NewKey()
+	hash, err := credential.HashPassword(secret)
+	if err != nil {
+		return fmt.Errorf("failed to hash key: %w", err)
+	}
+	if err := db.AddAPIKey(storage.APIKey{ID: id, Client: client, Hash: hash, Role: role, Created: time.Now()}); err != nil {
+		return err
+	}
+
+	recordAudit(db, client, storage.AuditKeyAdd, id)
+	fmt.Printf("Created API key %s for %s. Use it as the X-Password; it will not be shown again:\n%s\n", id, client, key)
+	return nil
+}
+
+// revokeKey revokes a key by ID.
+func revokeKey(db storage.Store, id string) error {
+	key, err := db.GetAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("no API key with ID %s", id)
+	}
+	if _, err := db.RevokeAPIKey(id); err != nil {
+		return err
+	}
+	recordAudit(db, key.Client, storage.AuditKeyRevoke, id)
+	fmt.Println("Revoked", id)
+	return nil
+}
+
+// recordAudit notes a key change in the server's audit log. Failing to do so doesn't undo the change.
+func recordAudit(db storage.Store, client, action, id string) {
+	entry := storage.AuditEntry{Time: time.Now(), Client: client, Action: action, Detail: "renderctl " + id}
+	if err := db.AddAuditEntry(entry); err != nil {
+		fmt.Fprintln(os.Stderr, "renderctl: failed to write audit entry:", err)
+	}
+}
+
+// listKeys prints a table of API keys.
+func listKeys(db storage.Store, client string) error {
+	keys, err := db.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tROLE\tCREATED\tSTATUS")
+	for _, k := range keys {
+		if client != "" && k.Client != client {
+			continue
+		}
+		status := "active"
+		if !k.Revoked.IsZero() {
+			status = "revoked " + k.Revoked.Format(time.RFC3339)
+		} else if !k.Expires.IsZero() {
+			status = "expires " + k.Expires.Format(time.RFC3339)
+		}
+		role := k.Role
+		if role == "" {
+			role = "(configured)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Client, role, k.Created.Format(time.RFC3339), status)
+	}
+	return w.Flush()
+}
+
+// audit prints the newest audit log entries.
+func audit(dbPath, configPath string, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Usage = flag.Usage
+	client := fs.String("client", "", "Only show entries of this client.")
+	action := fs.String("action", "", "Only show entries with this action.")
+	limit := fs.Int("limit", 50, "Number of entries to show.")
+	fs.Parse(args)
+
+	db, err := openDatabase(dbPath, configPath, true)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return fmt.Errorf("%w or use GET /api/audit", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := db.ListAuditEntries(storage.AuditFilter{Client: *client, Action: *action, Limit: *limit})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCLIENT\tACTION\tADDR\tDETAIL")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Client, e.Action, e.Addr, e.Detail)
+	}
+	return w.Flush()
+}
+
+// hash prints the bcrypt hash of a password given as an argument or on stdin.
+func hash(args []string) error {
+	var password string
+	switch len(args) {
+	case 0:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	case 1:
+		password = args[0]
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if password == "" {
+		return fmt.Errorf("empty password")
+	}
+
+	hashed, err := credential.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hashed)
+	return nil
+}
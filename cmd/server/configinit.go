@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"gopin/config"
+	"gopin/pkg/credential"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const configUsage = `Usage: Render-server config init [flags]
+
+Generates a complete config with every setting at its default. The format follows the
+extension of -o: .json, .yaml/.yml or .toml. YAML and TOML files get a comment per section.
+Settings not given as flags are asked for when run in a terminal, unless -y is set.
+
+Flags:
+`
+
+// runConfig runs a config subcommand and returns the exit code.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "init" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, configUsage)
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "config.json", "File to write.")
+	client := fs.String("client", "", "Name of the first client. (default \"my-discord-bot\")")
+	password := fs.String("password", "", "Password of the first client. A random one is generated if empty.")
+	port := fs.String("port", "", "Port of the HTTP and WebSocket server. (default \"8080\")")
+	topic := fs.String("topic", "", "Name of the sample topic. (default \"dark-pfp\")")
+	queries := fs.String("queries", "", "Comma-separated queries of the sample topic.")
+	plaintext := fs.Bool("plaintext", false, "Store the password as is instead of its bcrypt hash.")
+	force := fs.Bool("force", false, "Overwrite an existing file.")
+	yes := fs.Bool("y", false, "Don't ask, use the flags and defaults.")
+	fs.Parse(args[1:])
+
+	if err := initConfig(initOptions{
+		out:         *out,
+		client:      *client,
+		password:    *password,
+		port:        *port,
+		topic:       *topic,
+		queries:     *queries,
+		plaintext:   *plaintext,
+		force:       *force,
+		interactive: !*yes && isTerminal(os.Stdin),
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "config init:", err)
+		return 1
+	}
+	return 0
+}
+
+type initOptions struct {
+	out, client, password, port, topic, queries string
+	plaintext, force, interactive               bool
+}
+
+// initConfig writes a default config with the first client and sample topic filled in.
+func initConfig(opts initOptions) error {
+	if !opts.force {
+		if _, err := os.Stat(opts.out); err == nil {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", opts.out)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	cfg := config.Default()
+	var sampleTopic string
+	var sampleQueries []string
+	for name, qs := range cfg.Topics {
+		sampleTopic, sampleQueries = name, qs
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	ask := func(value *string, prompt, fallback string) {
+		if *value == "" && opts.interactive {
+			fmt.Printf("%s [%s]: ", prompt, fallback)
+			if in.Scan() {
+				*value = strings.TrimSpace(in.Text())
+			}
+		}
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	ask(&opts.client, "Client name", "my-discord-bot")
+	ask(&opts.password, "Password", "generate")
+	ask(&opts.port, "Port", cfg.Port)
+	ask(&opts.topic, "Sample topic", sampleTopic)
+	ask(&opts.queries, "Queries of the topic, comma-separated", strings.Join(sampleQueries, ", "))
+
+	if strings.HasPrefix(opts.client, "_") {
+		return fmt.Errorf("client names can't start with an underscore")
+	}
+	generated := opts.password == "generate"
+	if generated {
+		opts.password = credential.NewPassword()
+	}
+	stored := opts.password
+	if !opts.plaintext {
+		hash, err := credential.HashPassword(opts.password)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		stored = hash
+	}
+
+	cfg.Port = opts.port
+	cfg.Credentials[opts.client] = stored
+	var topicQueries []string
+	for q := range strings.SplitSeq(opts.queries, ",") {
+		if q = strings.TrimSpace(q); q != "" {
+			topicQueries = append(topicQueries, q)
+		}
+	}
+	cfg.Topics = map[string][]string{opts.topic: topicQueries}
+
+	data, err := config.Encode(cfg, opts.out)
+	if err != nil {
+		return err
+	}
+	// The file holds credentials, so keep it private
+	if err := os.WriteFile(opts.out, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	fmt.Printf("Wrote %s.\n", opts.out)
+	if generated {
+		fmt.Printf("Client %q can log in with the password below; it is not shown again:\n%s\n", opts.client, opts.password)
+	}
+	return nil
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -10,24 +10,73 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 const version = "1.0.0"
 
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--service" {
+		os.Exit(runService(os.Args[2:]))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop() // A second signal stops the server without waiting for the drain
+	}()
+	serve(ctx)
+}
+
+// serve runs the server until ctx is done, then drains and shuts it down.
+func serve(ctx context.Context) {
 	log := logger.New()
 
-	cfg, err := config.Load("config.json")
+	path, err := config.Find()
+	if err != nil {
+		log.Error("FATAL: Failed to find config", "error", err)
+		os.Exit(1)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Error("FATAL: Failed to load config", "path", path, "error", err)
+		os.Exit(1)
+	}
+	if cfg.Log.File != "" {
+		fileLog, err := logger.NewWithFile(logger.FileOptions{
+			Path:       cfg.Log.File,
+			MaxSize:    cfg.Log.MaxSize,
+			MaxAge:     time.Duration(cfg.Log.MaxAge),
+			MaxBackups: cfg.Log.MaxBackups,
+			Compress:   cfg.Log.Compress,
+		})
+		if err != nil {
+			log.Error("FATAL: Failed to open log file", "path", cfg.Log.File, "error", err)
+			os.Exit(1)
+		}
+		log = fileLog
+		defer log.Close()
+	}
+	recent := cfg.Log.Recent
+	if recent == 0 {
+		recent = logger.DefaultRecent
+	}
+	log = log.WithRecent(recent)
+	level, err := logger.ParseLevel(cfg.Log.Level)
 	if err != nil {
-		log.Error("FATAL: Failed to load config.json", "error", err)
+		log.Error("FATAL: Invalid log level", "error", err)
 		os.Exit(1)
 	}
+	log.SetLevel(level)
 
 	console.PrintBanner(version, network.GetLocalIP(), cfg.Port)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
-
 	s := server.New(cfg, log)
 
 	go func() {
@@ -36,6 +85,43 @@ func main() {
 		}
 	}()
 
-	<-ctx.Done()
-	s.Shutdown(context.Background())
+	// Apply config changes when the file is saved or on SIGHUP
+	reload := func() {
+		cfg, err := config.Load(path)
+		if err != nil {
+			log.Error("Failed to reload config", "path", path, "error", err)
+			return
+		}
+		if err := s.Reload(cfg); err != nil {
+			log.Error("Rejected config reload, keeping the current config", "path", path, "error", err)
+		}
+	}
+	go config.Watch(ctx, configPollInterval, reload, cfg.WatchedFiles(path)...)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("Received SIGHUP, reloading config")
+			reload()
+		}
+	}()
+
+	// Hand the sockets to a new process on SIGUSR2, such as after the binary has been upgraded
+	restart := restartSignals()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+			return
+		case <-restart:
+			log.Info("Received SIGUSR2, restarting server")
+			if err := s.Restart(); err != nil {
+				log.Error("Failed to restart, keeping the current process", "error", err)
+				continue
+			}
+			s.Shutdown(context.Background())
+			return
+		}
+	}
 }
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// restartSignals returns nil, since the sockets can't be passed on to a new process on this platform.
+func restartSignals() <-chan os.Signal {
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runService reports that service mode is only available on Windows; use systemd elsewhere.
+func runService(args []string) int {
+	fmt.Fprintln(os.Stderr, "--service is only supported on Windows; see the README for running under systemd")
+	return 2
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the server is installed under in the Windows service manager.
+const serviceName = "Render"
+
+// stopWaitHint is how long the service manager is told a stop may take, which covers the drain.
+const stopWaitHint = 2 * time.Minute
+
+const serviceUsage = `Usage: Render-server --service <command>
+
+Commands:
+  install    Register the server as a Windows service that starts with the system
+  uninstall  Remove the service
+  run        Run as the service; used by the service manager
+`
+
+// runService runs a --service command and returns the exit code.
+func runService(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, serviceUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService()
+	case "uninstall":
+		err = uninstallService()
+	case "run":
+		err = runAsService()
+	default:
+		fmt.Fprint(os.Stderr, serviceUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "service:", err)
+		return 1
+	}
+	return 0
+}
+
+// installService registers the executable as an automatically started service that is restarted
+// when it fails.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Render",
+		Description: "Scrapes images and serves them to clients over WebSocket, REST and gRPC.",
+		StartType:   mgr.StartAutomatic,
+	}, "--service", "run")
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+	fmt.Printf("Installed service %s. It reads config and data from %s.\nStart it with: sc start %s\n", serviceName, filepath.Dir(exe), serviceName)
+	return nil
+}
+
+// uninstallService removes the service. A running service keeps running until it is stopped.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	fmt.Printf("Uninstalled service %s.\n", serviceName)
+	return nil
+}
+
+// runAsService runs the server under the service manager. Services start in the system directory,
+// so the config and database are looked up next to the executable instead.
+func runAsService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	if err := os.Chdir(filepath.Dir(exe)); err != nil {
+		return fmt.Errorf("failed to change to the executable's directory: %w", err)
+	}
+	return svc.Run(serviceName, service{})
+}
+
+// service runs the server until the service manager stops it.
+type service struct{}
+
+func (service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		serve(ctx)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(stopWaitHint.Milliseconds())}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
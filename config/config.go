@@ -2,46 +2,593 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // ScrapingConfig holds the configuration for the scraping process.
 type ScrapingConfig struct {
-	MinDelay        string   `json:"minDelay"`
-	MaxDelay        string   `json:"maxDelay"`
-	PoolSize        int      `json:"poolSize"`
-	RefreshInterval string   `json:"refreshInterval"`
-	UserAgents      []string `json:"userAgents"`
+	// MinDelay and MaxDelay bound the random delay between two requests to a provider.
+	MinDelay Duration `json:"minDelay"`
+	MaxDelay Duration `json:"maxDelay"`
+	// PoolSize is the number of images the image pool holds, the newest ones being kept.
+	PoolSize int `json:"poolSize"`
+	// RefreshInterval is the least time between two refills of the same query of the pool. Defaults to 5m.
+	RefreshInterval Duration `json:"refreshInterval"`
+	// Queries are the queries the image pool is kept filled with.
+	Queries []string `json:"queries"`
+	// Modifiers expand the queries of the jobs that ask for it into one per modifier besides the query
+	// itself, such as "cats grunge" for "cats" and "grunge", to keep their results diverse.
+	Modifiers []string `json:"modifiers,omitempty"`
+	// Placeholders fill the query templates of every job and of Queries, turning "{color} aesthetic pfp"
+	// into one query per value of the placeholder color.
+	Placeholders map[string][]string `json:"placeholders,omitempty"`
+	// QueryWeights scrape some queries more often than others in every job and topic, unless a job asks
+	// for weights of its own. A query with a weight of 3 is scraped three times as often as one without
+	// a weight, up to 100.
+	QueryWeights map[string]int `json:"queryWeights,omitempty"`
+	// PoolPerQuery caps the images of a single query in the pool. Defaults to an even share of PoolSize.
+	PoolPerQuery int `json:"poolPerQuery"`
+	// PoolLowWater refills a query once a client drawing from the pool leaves fewer than this many of its
+	// images unseen. Defaults to a quarter of PoolPerQuery.
+	PoolLowWater int `json:"poolLowWater"`
+	// PoolFirst fills the image pool with the images of Queries and serves WebSocket jobs from it first,
+	// only scraping live for the images the pool can't provide.
+	PoolFirst bool `json:"poolFirst"`
+	// PoolDir keeps the image pool across restarts. Defaults to data/pool.
+	PoolDir string `json:"poolDir"`
+	// ExhaustedCooldown is how long every job skips a query that ran out of results without yielding a
+	// single image, across restarts. Defaults to 1h; a negative value disables it.
+	ExhaustedCooldown Duration `json:"exhaustedCooldown"`
+	UserAgents        []string `json:"userAgents"`
+	// Providers overrides the delays for individual providers, keyed by provider name, e.g. "pinterest".
+	Providers map[string]ProviderConfig `json:"providers,omitempty"`
+	// MaxBrowsers is how many browsers run at a time, further scrapes waiting for one to close before
+	// launching theirs. Defaults to 5; a negative value lifts the limit.
+	MaxBrowsers int `json:"maxBrowsers"`
+	// BrowserQueueTimeout is how long a scrape waits to launch its browser before it fails. Defaults to 5m.
+	BrowserQueueTimeout Duration `json:"browserQueueTimeout"`
+	// CircuitBreaker stops scraping a provider for a while after its scrapes keep failing.
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"`
+}
+
+// CircuitBreakerConfig holds the configuration of the circuit breaker of each provider.
+type CircuitBreakerConfig struct {
+	// MaxFailures is how many scrapes must fail in a row to open the breaker. Defaults to 3; a negative
+	// value disables the breaker.
+	MaxFailures int `json:"maxFailures"`
+	// Timeout is how long the breaker stays open before it lets trial scrapes through. Defaults to 1m.
+	Timeout Duration `json:"timeout"`
+	// SuccessThreshold is how many trial scrapes must succeed in a row to close the breaker again, which
+	// is also how many run at a time. Defaults to 1.
+	SuccessThreshold int `json:"successThreshold"`
+}
+
+// ProviderConfig overrides the scraping settings of a single provider. Unset fields use the scraping defaults.
+type ProviderConfig struct {
+	MinDelay Duration `json:"minDelay,omitempty"`
+	MaxDelay Duration `json:"maxDelay,omitempty"`
+}
+
+// Delays returns the delay bounds for a provider, applying its overrides to the scraping defaults.
+func (c ScrapingConfig) Delays(provider string) (minDelay, maxDelay time.Duration) {
+	minDelay, maxDelay = time.Duration(c.MinDelay), time.Duration(c.MaxDelay)
+	if override, ok := c.Providers[provider]; ok {
+		if override.MinDelay > 0 {
+			minDelay = time.Duration(override.MinDelay)
+		}
+		if override.MaxDelay > 0 {
+			maxDelay = time.Duration(override.MaxDelay)
+		}
+	}
+	return minDelay, maxDelay
 }
 
 // DatabaseConfig holds the configuration for the database.
 type DatabaseConfig struct {
-	CleanupInterval string `json:"cleanupInterval"`
-	MaxAge          string `json:"maxAge"`
+	// CleanupInterval is how often entries older than MaxAge are removed. Cleanup is off unless both are set.
+	CleanupInterval Duration `json:"cleanupInterval"`
+	MaxAge          Duration `json:"maxAge"`
+	// AuditMaxAge is how long audit log entries are kept. Defaults to 2160h (90 days).
+	AuditMaxAge Duration `json:"auditMaxAge"`
+	// JobHistoryMaxAge is how long finished jobs are kept in the job history. Defaults to 720h (30 days).
+	JobHistoryMaxAge Duration `json:"jobHistoryMaxAge"`
+	// History is where the history of seen images is kept: "bbolt", the default, in data/render.db with
+	// the rest of the data, "sqlite" in the SQLite database at SQLitePath, or "postgres" or "redis" in the
+	// Postgres database at PostgresURL or the Redis server at RedisURL, which several servers can share.
+	History string `json:"history"`
+	// SQLitePath is the file of the SQLite history. Defaults to data/history.db.
+	SQLitePath string `json:"sqlitePath"`
+	// PostgresURL is the connection URL of the Postgres history, e.g. "postgres://render:…@db/render".
+	PostgresURL string `json:"postgresUrl" secret:"true"`
+	// RedisURL is the URL of the Redis server of the Redis history, e.g. "redis://:…@cache:6379/0".
+	RedisURL string `json:"redisUrl" secret:"true"`
+	// RedisPrefix is put in front of the keys of the Redis history. Defaults to "render:".
+	RedisPrefix string `json:"redisPrefix"`
+	// EncryptionKey is a base64 encoded 32-byte key that encrypts the history, rotated passwords, API keys
+	// and client statistics in data/render.db, usually "env:NAME" or "file:PATH". Empty leaves them in the clear.
+	EncryptionKey string `json:"encryptionKey" secret:"true"`
+}
+
+// DeliveryConfig holds the configuration for delivering images to clients.
+type DeliveryConfig struct {
+	ChunkThreshold int `json:"chunkThreshold"`
+	ChunkSize      int `json:"chunkSize"`
+	// ImageCacheSize is the number of bytes of recent images kept for GET /images/{hash}.
+	ImageCacheSize int `json:"imageCacheSize"`
+	// WebhookHosts, if set, are the only hosts the webhooks of REST jobs may post to. They may resolve
+	// to private addresses, which the webhooks of other hosts may not.
+	WebhookHosts []string `json:"webhookHosts,omitempty"`
+}
+
+// DiscordSinkConfig configures a Discord channel that is fed images from a topic.
+type DiscordSinkConfig struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+	WebhookURL string `json:"webhookUrl" secret:"true"`
+	Username   string `json:"username,omitempty"`
+	// Interval is the minimum time between two posts, e.g. "10m". Empty posts as fast as images arrive.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// TelegramSinkConfig configures Telegram chats that are fed images from a topic.
+type TelegramSinkConfig struct {
+	Name     string   `json:"name"`
+	Topic    string   `json:"topic"`
+	BotToken string   `json:"botToken" secret:"true"`
+	ChatIDs  []string `json:"chatIds"`
+	// Interval is the minimum time between two posts, e.g. "1h". Empty posts as fast as images arrive.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// S3SinkConfig configures an S3-compatible bucket that receives images and their metadata.
+type S3SinkConfig struct {
+	Name      string   `json:"name"`
+	Topic     string   `json:"topic,omitempty"`
+	Interval  Duration `json:"interval,omitempty"`
+	Endpoint  string   `json:"endpoint"`
+	Region    string   `json:"region,omitempty"`
+	Bucket    string   `json:"bucket"`
+	Prefix    string   `json:"prefix,omitempty"`
+	AccessKey string   `json:"accessKey" secret:"true"`
+	SecretKey string   `json:"secretKey" secret:"true"`
+	Insecure  bool     `json:"insecure,omitempty"`
+	PathStyle bool     `json:"pathStyle,omitempty"`
+}
+
+// DirectorySinkConfig configures a local directory that receives images.
+type DirectorySinkConfig struct {
+	Name     string   `json:"name"`
+	Topic    string   `json:"topic,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	Path     string   `json:"path"`
+	Metadata bool     `json:"metadata,omitempty"`
+}
+
+// QueueSinkConfig configures a NATS subject or Kafka topic that receives image metadata.
+type QueueSinkConfig struct {
+	Name     string   `json:"name"`
+	Topic    string   `json:"topic,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	Brokers  []string `json:"brokers"`
+	Subject  string   `json:"subject"`
+	Payload  string   `json:"payload,omitempty"`
+	// Store names the S3 sink that holds the images in the "store" payload mode.
+	Store string `json:"store,omitempty"`
+}
+
+// ScheduleConfig configures a job that the server runs on a schedule, without a connected client, and
+// whose images go to a sink. Every schedule has its own history, so each run only delivers images that
+// earlier runs didn't.
+type ScheduleConfig struct {
+	Name string `json:"name"`
+	// Cron is when the job runs, in the server's local time, as a cron expression such as "0 3 * * *"
+	// (03:00 every day) or a shorthand such as "@hourly" or "@daily".
+	Cron string `json:"cron"`
+	// Topic runs the queries of a topic. Queries lists the queries instead.
+	Topic         string   `json:"topic,omitempty"`
+	Queries       []string `json:"queries,omitempty"`
+	Limit         int      `json:"limit"`
+	LimitPerQuery int      `json:"limitPerQuery,omitempty"`
+	// Sink names the server-side sink that receives the images, such as "s3:datasets". Webhook posts
+	// them to a URL instead.
+	Sink    string              `json:"sink,omitempty"`
+	Webhook WebhookTargetConfig `json:"webhook,omitzero"`
+}
+
+// WebhookTargetConfig is a URL that images are posted to, like the webhook of a REST job.
+type WebhookTargetConfig struct {
+	URL string `json:"url"`
+	// Secret signs every request with HMAC-SHA256 when set.
+	Secret string `json:"secret,omitempty" secret:"true"`
+	// Format is either "json" (default) or "multipart".
+	Format string `json:"format,omitempty"`
+}
+
+// SinksConfig holds the server-side sinks that deliver images without a connected client.
+// A sink with a topic is fed continuously; every sink can also be selected by REST jobs as "<type>:<name>".
+type SinksConfig struct {
+	Discord   []DiscordSinkConfig   `json:"discord"`
+	Telegram  []TelegramSinkConfig  `json:"telegram"`
+	S3        []S3SinkConfig        `json:"s3"`
+	Directory []DirectorySinkConfig `json:"directory"`
+	NATS      []QueueSinkConfig     `json:"nats"`
+	Kafka     []QueueSinkConfig     `json:"kafka"`
+}
+
+// JWTKeyConfig is a key that JWT bearer tokens can be signed with. Exactly one of Secret
+// (HS256/384/512) and PublicKeyFile (a PEM encoded RSA, ECDSA or Ed25519 public key) is set.
+type JWTKeyConfig struct {
+	// ID is matched against the token's "kid" header. Tokens without one are tried against every key.
+	ID            string `json:"id"`
+	Secret        string `json:"secret" secret:"true"`
+	PublicKeyFile string `json:"publicKeyFile"`
+}
+
+// JWTConfig enables JWT bearer tokens as an alternative to the credentials pairs.
+type JWTConfig struct {
+	Keys     []JWTKeyConfig `json:"keys"`
+	Issuer   string         `json:"issuer"`
+	Audience string         `json:"audience"`
+}
+
+// AutocertConfig obtains certificates from Let's Encrypt for the listed domains.
+type AutocertConfig struct {
+	Domains []string `json:"domains"`
+	Email   string   `json:"email"`
+	// CacheDir stores the issued certificates across restarts. Defaults to data/autocert.
+	CacheDir string `json:"cacheDir"`
+	// HTTPPort serves the ACME HTTP-01 challenges and redirects to HTTPS. Defaults to 80.
+	HTTPPort string `json:"httpPort"`
+}
+
+// TLSConfig enables TLS on the HTTP and gRPC listeners, either with a certificate and key file or with autocert.
+type TLSConfig struct {
+	CertFile string         `json:"certFile"`
+	KeyFile  string         `json:"keyFile"`
+	Autocert AutocertConfig `json:"autocert"`
+	// ClientCAFile enables client certificate authentication with the CAs in the PEM file.
+	ClientCAFile string `json:"clientCAFile"`
+	// RequireClientCert rejects connections without a valid client certificate during the handshake.
+	RequireClientCert bool `json:"requireClientCert"`
+	// ClientNames maps a certificate's common name or "sha256:<hex fingerprint>" to a client name.
+	// If empty, the common name is used as the client name.
+	ClientNames map[string]string `json:"clientNames"`
+}
+
+// QuotaConfig caps what a client may receive per UTC day and month. Zero means no cap.
+type QuotaConfig struct {
+	DailyImages   int   `json:"dailyImages"`
+	MonthlyImages int   `json:"monthlyImages"`
+	DailyBytes    int64 `json:"dailyBytes"`
+	MonthlyBytes  int64 `json:"monthlyBytes"`
+}
+
+// QuotasConfig holds the default quota of every client and per-client overrides.
+type QuotasConfig struct {
+	Default QuotaConfig            `json:"default"`
+	Clients map[string]QuotaConfig `json:"clients"`
+}
+
+// WebSocketConfig tunes the WebSocket endpoint.
+type WebSocketConfig struct {
+	// MaxConnections caps the number of simultaneous connections. Zero means no limit.
+	MaxConnections int `json:"maxConnections"`
+	// MaxConnectionsPerClient caps the simultaneous connections of each client. Zero means no limit.
+	MaxConnectionsPerClient int `json:"maxConnectionsPerClient"`
+	// PingInterval is how often the server pings each connection. Clients must answer, or ping on
+	// their own, at least as often. Defaults to 5s.
+	PingInterval Duration `json:"pingInterval"`
+	// PingWait is how long a pong or ping may be late before the connection is closed. Defaults to 10s.
+	PingWait Duration `json:"pingWait"`
+	// IdleTimeout closes connections that have sent no message and had no running job or subscription
+	// for this long. Empty keeps them open for as long as they ping.
+	IdleTimeout Duration `json:"idleTimeout"`
+	// MaxMessageSize is the largest message in bytes a client may send; larger ones close the
+	// connection. Defaults to 16 MiB.
+	MaxMessageSize int `json:"maxMessageSize"`
+	// ReadBufferSize is the size in bytes of each connection's read buffer. Defaults to 4 KiB.
+	ReadBufferSize int `json:"readBufferSize"`
+	// ParallelLimit is how many messages of a connection are handled at once. Defaults to 8; 1 handles
+	// them one at a time, in order.
+	ParallelLimit int `json:"parallelLimit"`
+	// Compression configures permessage-deflate compression.
+	Compression CompressionConfig `json:"compression"`
+}
+
+// CompressionConfig configures the permessage-deflate compression of WebSocket messages.
+type CompressionConfig struct {
+	// Disabled turns compression off, saving CPU and the memory of the compressors.
+	Disabled bool `json:"disabled"`
+	// Level is the deflate level from 1 (fastest) to 9 (smallest). Defaults to 1.
+	Level int `json:"level"`
+	// Threshold is the size in bytes below which messages are sent uncompressed. Defaults to 512.
+	// It has no effect with context takeover, which compresses every message.
+	Threshold int `json:"threshold"`
+	// ContextTakeover keeps the compression window between messages, which compresses better but
+	// costs memory for every connection.
+	ContextTakeover bool `json:"contextTakeover"`
+	// WindowBits sets the size of the compression window to 2^WindowBits bytes, from 8 to 15.
+	// Defaults to 12 with context takeover and 15 without.
+	WindowBits int `json:"windowBits"`
+}
+
+// ProfileConfig tailors the server to one client. It is applied whenever the client authenticates.
+type ProfileConfig struct {
+	// DefaultLimit is the limit of jobs that don't set one.
+	DefaultLimit int `json:"defaultLimit"`
+	// MaxLimit caps the limit of every job. Zero means no cap.
+	MaxLimit int `json:"maxLimit"`
+	// LimitPerQuery is the per-query cap of jobs that don't set one. Zero means no cap.
+	LimitPerQuery int `json:"limitPerQuery"`
+	// Sources lists where the client may get images from: "queries" for ad-hoc queries,
+	// "topic:<name>" for topics and "sink:<type>:<name>" for the sinks its REST jobs may deliver to.
+	// Empty means everywhere but the sinks, which must be listed, or granted with "*".
+	Sources []string `json:"sources"`
+	// MinImageBytes and MaxImageBytes drop images outside the size range. Zero means no bound.
+	MinImageBytes int `json:"minImageBytes"`
+	MaxImageBytes int `json:"maxImageBytes"`
+	// Quota replaces the default quota for the client.
+	Quota *QuotaConfig `json:"quota"`
+	// HistoryMaxAge replaces database.maxAge for the client's history, so that it is sent images again
+	// sooner or later than others. KeepHistory keeps its history forever instead.
+	HistoryMaxAge Duration `json:"historyMaxAge"`
+	KeepHistory   bool     `json:"keepHistory"`
+	// Priority is the priority of the client's jobs, "low", "normal" or "high", and the highest a job
+	// may ask for. Defaults to normal.
+	Priority string `json:"priority"`
+	// Weight is the client's share of the turns of queued jobs when jobs.maxConcurrent is reached: a
+	// client with weight 2 gets twice as many turns as one with the default of 1.
+	Weight int `json:"weight"`
+}
+
+// CORSConfig lists the browser origins that may use the server, such as "https://dashboard.example.com".
+// It governs both CORS on the REST and SSE endpoints and the Origin check of WebSocket handshakes.
+type CORSConfig struct {
+	// AllowedOrigins are the allowed origins. "*" allows every origin.
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// MaxAge is how long browsers may cache a preflight response. Defaults to 10m.
+	MaxAge Duration `json:"maxAge"`
+}
+
+// AccessConfig restricts which IPs may connect. Entries are CIDR ranges or single addresses.
+type AccessConfig struct {
+	// Allow, if set, is the only ranges that may connect.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// BanThreshold is the number of authentication failures within BanWindow after which an IP
+	// is banned for BanDuration. Defaults to 10; a negative value disables bans.
+	BanThreshold int      `json:"banThreshold"`
+	BanWindow    Duration `json:"banWindow"`
+	BanDuration  Duration `json:"banDuration"`
+	// AuthBackoff is the delay imposed after the first failed attempts of an IP or client name,
+	// doubling with every further failure up to AuthBackoffMax. Defaults to 1s and 5m.
+	AuthBackoff    Duration `json:"authBackoff"`
+	AuthBackoffMax Duration `json:"authBackoffMax"`
+}
+
+// AuthConfig holds the authentication settings beyond the plain credentials.
+type AuthConfig struct {
+	JWT JWTConfig `json:"jwt"`
+	// Roles maps client names to "admin", "scraper" or "read-only". Unlisted clients are scrapers.
+	Roles map[string]string `json:"roles"`
+	// RotationGracePeriod is how long a rotated password or API key stays valid. Defaults to 24h.
+	RotationGracePeriod Duration `json:"rotationGracePeriod"`
+}
+
+// LogConfig configures the log level and where logs are written besides the console.
+type LogConfig struct {
+	// Level is the minimum level logged: "debug", "info", "warn" or "error". Defaults to "info".
+	Level string `json:"level"`
+	// File receives every log record as a JSON line. Empty logs to the console only.
+	File string `json:"file"`
+	// MaxSize is the size in megabytes at which the file is rotated. Defaults to 100.
+	MaxSize int `json:"maxSize"`
+	// MaxAge is how long rotated files are kept, rounded up to whole days. Empty keeps them regardless of age.
+	MaxAge Duration `json:"maxAge"`
+	// MaxBackups is the number of rotated files kept. 0 keeps all of them.
+	MaxBackups int `json:"maxBackups"`
+	// Compress gzips rotated files.
+	Compress bool `json:"compress"`
+	// Recent is the number of the newest records kept in memory for GET /api/admin/logs. Defaults to
+	// 1000; a negative number keeps none.
+	Recent int `json:"recent"`
+}
+
+// TracingConfig exports OpenTelemetry traces of the scraping pipeline to an OTLP collector.
+// Tracing is off without an endpoint.
+type TracingConfig struct {
+	// Endpoint is the host:port of the collector, e.g. "localhost:4317".
+	Endpoint string `json:"endpoint"`
+	// Protocol is "grpc" or "http". Defaults to "grpc".
+	Protocol string `json:"protocol"`
+	// Insecure connects to the collector without TLS.
+	Insecure bool `json:"insecure"`
+	// Headers are sent with every export, e.g. an API key of a tracing service.
+	Headers map[string]string `json:"headers" secret:"true"`
+	// SampleRatio is the fraction of traces recorded, from 0 to 1. Defaults to 1.
+	SampleRatio float64 `json:"sampleRatio"`
+	// ServiceName identifies the server in traces. Defaults to "render".
+	ServiceName string `json:"serviceName"`
+}
+
+// ShutdownConfig configures how the server stops.
+type ShutdownConfig struct {
+	// DrainTimeout is how long running jobs may take to finish once the server is told to stop.
+	// Zero means 30 seconds.
+	DrainTimeout Duration `json:"drainTimeout"`
+}
+
+// JobsConfig limits the scrape jobs that run at the same time.
+type JobsConfig struct {
+	// MaxConcurrent caps the jobs that scrape at the same time, counting the shared scrapes of topics.
+	// Jobs beyond it wait in a queue until a running job ends. Zero means no cap.
+	MaxConcurrent int `json:"maxConcurrent"`
+	// MaxLifetime stops jobs that are still running after this long, including the time they were
+	// queued, with the reason "expired". Jobs may ask for a shorter lifetime. Zero means no limit.
+	MaxLifetime Duration `json:"maxLifetime"`
+}
+
+// BlocklistConfig rejects the jobs of every client whose queries match any of its terms or patterns,
+// to enforce a content policy.
+type BlocklistConfig struct {
+	// Terms block the queries that contain any of them as whole words, ignoring case.
+	Terms []string `json:"terms,omitempty"`
+	// Patterns block the queries that match any of these regular expressions, in Go's RE2 syntax, such
+	// as "(?i)^nsfw".
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// MaintenanceConfig puts the server into a read-only mode, for example while a backup is restored or
+// the database is compacted or migrated offline.
+type MaintenanceConfig struct {
+	// ReadOnly opens the database read-only and refuses jobs, subscriptions and other requests that
+	// would write to it. Changing it takes a restart.
+	ReadOnly bool `json:"readOnly"`
+	// Message is the error of refused requests. Defaults to "server in read-only maintenance mode".
+	Message string `json:"message"`
+}
+
+// MemoryConfig sets the memory use at which the server sheds load instead of running out of memory.
+// A limit of zero disables it.
+type MemoryConfig struct {
+	// HighWater is the memory use in bytes at which new browser launches are paused and the image cache
+	// is shrunk until memory use drops again.
+	HighWater int64 `json:"highWater"`
+	// Critical is the memory use in bytes at which new jobs are also rejected as "server overloaded".
+	Critical int64 `json:"critical"`
+	// CheckInterval is how often the memory use is read. Defaults to 5s.
+	CheckInterval Duration `json:"checkInterval"`
+}
+
+// DebugConfig enables diagnostics endpoints for admins.
+type DebugConfig struct {
+	// Pprof serves the net/http/pprof profiles under /debug/pprof/ to admins.
+	Pprof bool `json:"pprof"`
+}
+
+// ListenerConfig configures an address the HTTP server listens on and the routes it serves there.
+type ListenerConfig struct {
+	Name string `json:"name"`
+	// Address is a "host:port" TCP address, "unix:" followed by the path of a Unix socket, or "systemd:"
+	// followed by the name of a socket passed by systemd socket activation.
+	Address string `json:"address"`
+	// TLS serves HTTPS on the listener with the certificates of the tls section.
+	TLS bool `json:"tls,omitempty"`
+	// Routes are the route groups served: "scrape", "api", "admin", "ui", "schema", "debug" and "metrics". Empty serves all of them.
+	Routes []string `json:"routes,omitempty"`
 }
 
 // Config holds the application's configuration.
 type Config struct {
-	Port        string            `json:"port"`
-	Credentials map[string]string `json:"credentials"`
-	NumWorkers  int               `json:"numWorkers"`
-	Scraping    ScrapingConfig    `json:"scraping"`
-	Database    DatabaseConfig    `json:"database"`
+	Port     string `json:"port"`
+	GRPCPort string `json:"grpcPort"`
+	// AdminAddress is where the admin and debug routes are served, apart from Port, as a "host:port",
+	// "unix:" or "systemd:" address like those of Listeners. Defaults to "127.0.0.1:8081". It is not used
+	// with Listeners, whose route groups decide where the admin routes are served.
+	AdminAddress string `json:"adminAddress"`
+	// Listeners replace the listener on Port with one or more addresses, each with its own routes.
+	Listeners   []ListenerConfig  `json:"listeners"`
+	Credentials map[string]string `json:"credentials" secret:"true"`
+	// CredentialsFile is a JSON, YAML or TOML file of further client names and passwords, so the
+	// config can be shared without them. Relative paths are relative to the config file.
+	CredentialsFile string                   `json:"credentialsFile"`
+	Auth            AuthConfig               `json:"auth"`
+	TLS             TLSConfig                `json:"tls"`
+	Access          AccessConfig             `json:"access"`
+	WebSocket       WebSocketConfig          `json:"websocket"`
+	CORS            CORSConfig               `json:"cors"`
+	Quotas          QuotasConfig             `json:"quotas"`
+	Blocklist       BlocklistConfig          `json:"blocklist"`
+	Profiles        map[string]ProfileConfig `json:"profiles"`
+	NumWorkers      int                      `json:"numWorkers"`
+	Jobs            JobsConfig               `json:"jobs"`
+	Scraping        ScrapingConfig           `json:"scraping"`
+	Database        DatabaseConfig           `json:"database"`
+	Delivery        DeliveryConfig           `json:"delivery"`
+	Topics          map[string][]string      `json:"topics"`
+	Sinks           SinksConfig              `json:"sinks"`
+	Schedules       []ScheduleConfig         `json:"schedules"`
+	Log             LogConfig                `json:"log"`
+	Tracing         TracingConfig            `json:"tracing"`
+	Memory          MemoryConfig             `json:"memory"`
+	Debug           DebugConfig              `json:"debug"`
+	Shutdown        ShutdownConfig           `json:"shutdown"`
+	Maintenance     MaintenanceConfig        `json:"maintenance"`
 }
 
-// Load loads the configuration from a file.
-func Load(path string) (*Config, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// DefaultPaths are the config files looked for by Find, in order of preference.
+var DefaultPaths = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// Find returns the first of DefaultPaths that exists.
+func Find() (string, error) {
+	for _, path := range DefaultPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
 	}
-	defer file.Close()
+	return "", fmt.Errorf("no config file found, expected one of %s", strings.Join(DefaultPaths, ", "))
+}
 
+// WatchedFiles returns the files the config at path was loaded from.
+func (c *Config) WatchedFiles(path string) []string {
+	files := []string{path}
+	if c.CredentialsFile != "" {
+		if filepath.IsAbs(c.CredentialsFile) {
+			files = append(files, c.CredentialsFile)
+		} else {
+			files = append(files, filepath.Join(filepath.Dir(path), c.CredentialsFile))
+		}
+	}
+	return files
+}
+
+// Load loads the configuration from a file. The format is picked by the extension: .yaml and .yml files
+// are YAML, .toml files are TOML, and anything else is JSON. All formats use the same keys as config.json.
+// The credentials file, if any, is merged in and secret references are resolved.
+func Load(path string) (*Config, error) {
 	cfg := &Config{}
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(cfg); err != nil {
+	if err := decodeFile(path, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadSecrets(filepath.Dir(path)); err != nil {
 		return nil, err
 	}
-
 	return cfg, nil
 }
+
+// decodeFile decodes a JSON, YAML or TOML file into v.
+func decodeFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc map[string]any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to convert YAML: %w", err)
+		}
+	case ".toml":
+		var doc map[string]any
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse TOML: %w", err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to convert TOML: %w", err)
+		}
+	}
+
+	// YAML and TOML documents are converted to JSON so every format shares the json tags above.
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// sectionComments describe the top-level keys in generated YAML and TOML configs.
+var sectionComments = map[string]string{
+	"port":            "Port of the HTTP, REST and WebSocket server.",
+	"grpcPort":        "Port of the gRPC server. Leave empty to disable gRPC.",
+	"adminAddress":    "Address (host:port, unix:/path or systemd:name) of the admin API, dashboard, pprof profiles and metrics, kept off port so that they aren't exposed with it. Not used with listeners.",
+	"listeners":       "Addresses (host:port, unix:/path or systemd:name) to serve instead of port, each with its own TLS setting and route groups.",
+	"credentials":     "Client names and their passwords. Use renderctl hash to store bcrypt hashes instead of plain text.",
+	"credentialsFile": "A separate JSON, YAML or TOML file of client names and passwords, to keep them out of this file.",
+	"auth":            "Client roles (admin, scraper, read-only), JWT signing keys and how long rotated credentials stay valid.",
+	"tls":             "Certificate and key files, or autocert domains, to serve HTTPS and gRPC over TLS. Empty serves plain HTTP.",
+	"access":          "IP allow and deny lists, bans after repeated failed logins and authentication backoff.",
+	"websocket":       "Simultaneous WebSocket connections in total and per client (0 means unlimited), how often clients must ping, how long connections without a job may stay idle, and the message size, buffer, parallelism and compression settings that decide the memory each connection takes.",
+	"cors":            "Browser origins that may use the REST API and open WebSockets.",
+	"quotas":          "Daily and monthly image and byte quotas, by default and per client. 0 means unlimited.",
+	"profiles":        "Per-client defaults and restrictions: default and maximum limits, allowed sources, image size filters, quota, history retention, job priority and share of queued job turns.",
+	"numWorkers":      "Number of concurrent scraper workers.",
+	"jobs":            "Number of jobs, including topic scrapes, that run browser sessions at the same time, further jobs waiting in a queue, and how long a job may run before it expires. 0 means unlimited.",
+	"scraping":        "Random delay between requests, optionally overridden per provider, the image pool that jobs are served from first (poolFirst), the queries it holds, how many images of each and when they are refilled, and the directory it is kept in across restarts, and the user agents to rotate through.",
+	"database":        "How often old history is cleaned up, how long history, audit entries and finished jobs are kept, whether the history is kept in bbolt, SQLite, or a Postgres database or Redis server shared by several servers, and the key that encrypts it in bbolt.",
+	"delivery":        "Images above chunkThreshold bytes are sent in chunks of chunkSize; imageCacheSize bytes of recent images are kept for /images.",
+	"topics":          "Named query lists that clients and sinks can subscribe to.",
+	"sinks":           "Server-side destinations such as Discord, Telegram, S3, a directory, NATS or Kafka.",
+	"schedules":       "Jobs run on a cron schedule, e.g. \"0 3 * * *\" for 03:00 daily, whose images go to a sink or webhook. Changes take a restart.",
+	"log":             "Minimum log level (debug, info, warn, error), a JSON log file written besides the console output, rotated by size (in megabytes) and age, and the number of recent records admins can read back.",
+	"tracing":         "OTLP collector that receives OpenTelemetry traces of requests, jobs, queries, downloads and sends. Empty endpoint disables tracing.",
+	"memory":          "Memory use in bytes at which browser launches are paused and the image cache shrunk (highWater), and at which new jobs are rejected (critical). 0 disables the limit.",
+	"debug":           "Serve pprof profiles to admins under /debug/pprof/ for diagnosing leaks in production.",
+	"shutdown":        "How long running jobs may take to finish after SIGTERM before they are stopped.",
+	"maintenance":     "Open the database read-only and refuse jobs and other writes with message, e.g. while restoring a backup. Changing readOnly takes a restart.",
+}
+
+// Default returns a complete config with every setting at its default and one sample topic.
+func Default() *Config {
+	cfg := &Config{
+		Port:         "8080",
+		GRPCPort:     "9090",
+		AdminAddress: "127.0.0.1:8081",
+		NumWorkers:   10,
+		Auth: AuthConfig{
+			RotationGracePeriod: Duration(24 * time.Hour),
+		},
+		Access: AccessConfig{
+			BanThreshold:   10,
+			BanWindow:      Duration(time.Minute),
+			BanDuration:    Duration(15 * time.Minute),
+			AuthBackoff:    Duration(time.Second),
+			AuthBackoffMax: Duration(5 * time.Minute),
+		},
+		WebSocket: WebSocketConfig{
+			PingInterval:   Duration(5 * time.Second),
+			PingWait:       Duration(10 * time.Second),
+			MaxMessageSize: 16 << 20,
+			ReadBufferSize: 4 << 10,
+			ParallelLimit:  8,
+			Compression:    CompressionConfig{Level: 1, Threshold: 512},
+		},
+		CORS:   CORSConfig{MaxAge: Duration(10 * time.Minute)},
+		Log:    LogConfig{Level: "info", MaxSize: 100, MaxAge: Duration(30 * 24 * time.Hour), MaxBackups: 10, Recent: 1000},
+		Memory: MemoryConfig{CheckInterval: Duration(5 * time.Second)},
+		Jobs:   JobsConfig{MaxLifetime: Duration(time.Hour)},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: Duration(30 * time.Second),
+		},
+		Scraping: ScrapingConfig{
+			MinDelay:            Duration(5 * time.Second),
+			MaxDelay:            Duration(15 * time.Second),
+			PoolSize:            200,
+			RefreshInterval:     Duration(5 * time.Minute),
+			PoolDir:             "data/pool",
+			ExhaustedCooldown:   Duration(time.Hour),
+			Modifiers:           []string{"aesthetic", "grunge", "anime"},
+			MaxBrowsers:         5,
+			BrowserQueueTimeout: Duration(5 * time.Minute),
+			CircuitBreaker:      CircuitBreakerConfig{MaxFailures: 3, Timeout: Duration(time.Minute), SuccessThreshold: 1},
+			UserAgents: []string{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/536.36",
+			},
+		},
+		Database: DatabaseConfig{
+			CleanupInterval:  Duration(24 * time.Hour),
+			MaxAge:           Duration(720 * time.Hour),
+			AuditMaxAge:      Duration(2160 * time.Hour),
+			JobHistoryMaxAge: Duration(720 * time.Hour),
+			History:          "bbolt",
+			SQLitePath:       "data/history.db",
+			RedisPrefix:      "render:",
+		},
+		Delivery: DeliveryConfig{
+			ChunkThreshold: 4 << 20,
+			ChunkSize:      1 << 20,
+			ImageCacheSize: 256 << 20,
+		},
+		Topics: map[string][]string{
+			"dark-pfp": {"dark aesthetic discord pfp", "gothic profile picture"},
+		},
+	}
+	fillEmpty(reflect.ValueOf(cfg).Elem())
+	return cfg
+}
+
+// fillEmpty replaces nil slices and maps with empty ones, so generated configs show every key.
+func fillEmpty(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				fillEmpty(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	}
+}
+
+// Encode renders a config in the format matching the extension of path, like Load reads it.
+// YAML and TOML output carries a comment for every section.
+func Encode(cfg *Config, path string) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// JSON is valid YAML, so decoding it keeps the key order of the struct
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to convert to YAML: %w", err)
+		}
+		blockStyle(&doc)
+		root := doc.Content[0]
+		for i := 0; i < len(root.Content); i += 2 {
+			root.Content[i].HeadComment = sectionComments[root.Content[i].Value]
+		}
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to encode YAML: %w", err)
+		}
+		return buf.Bytes(), nil
+	case ".toml":
+		var doc map[string]any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(integers(doc)); err != nil {
+			return nil, fmt.Errorf("failed to encode TOML: %w", err)
+		}
+		return commentTOML(buf.Bytes()), nil
+	}
+	return append(data, '\n'), nil
+}
+
+// blockStyle switches the flow-style nodes of a document decoded from JSON to block style.
+func blockStyle(n *yaml.Node) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		n.Style = 0
+	} else if n.Style == yaml.DoubleQuotedStyle {
+		n.Style = 0
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// integers turns the JSON numbers of a decoded document into integers, which every number in the config is,
+// so TOML doesn't render them as floats.
+func integers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = integers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = integers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+// tomlKey matches the top-level key of a TOML key/value line or table header.
+var tomlKey = regexp.MustCompile(`^(?:\[+)?"?([A-Za-z0-9]+)`)
+
+// commentTOML puts the section comment above the first line of every top-level key.
+func commentTOML(data []byte) []byte {
+	var out bytes.Buffer
+	seen := make(map[string]bool)
+	for line := range strings.Lines(string(data)) {
+		if m := tomlKey.FindStringSubmatch(line); m != nil && !seen[m[1]] {
+			seen[m[1]] = true
+			if comment, ok := sectionComments[m[1]]; ok {
+				out.WriteString("# " + comment + "\n")
+			}
+		}
+		out.WriteString(line)
+	}
+	return out.Bytes()
+}
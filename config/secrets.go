@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Prefixes of secret references. A setting tagged as secret whose value is "env:NAME" is read from the
+// environment variable NAME, and one whose value is "file:PATH" from the file at PATH.
+const (
+	envPrefix  = "env:"
+	filePrefix = "file:"
+)
+
+// loadSecrets merges the credentials file into the credentials and resolves the secret references of
+// every setting tagged `secret:"true"`. Relative paths are resolved against dir.
+func (c *Config) loadSecrets(dir string) error {
+	if c.CredentialsFile != "" {
+		path := c.CredentialsFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		var creds map[string]string
+		if err := decodeFile(path, &creds); err != nil {
+			return fmt.Errorf("failed to load credentials file: %w", err)
+		}
+		if c.Credentials == nil {
+			c.Credentials = make(map[string]string, len(creds))
+		}
+		for name, password := range creds {
+			if _, exists := c.Credentials[name]; exists {
+				return fmt.Errorf("client %q is defined in both the config and the credentials file", name)
+			}
+			c.Credentials[name] = password
+		}
+	}
+	return resolveSecrets(reflect.ValueOf(c).Elem(), dir, false)
+}
+
+// resolveSecrets walks a config value and replaces the secret references in tagged fields.
+func resolveSecrets(v reflect.Value, dir string, secret bool) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range v.NumField() {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if err := resolveSecrets(v.Field(i), dir, t.Field(i).Tag.Get("secret") == "true"); err != nil {
+				name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := resolveSecrets(v.Index(i), dir, secret); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !secret || v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			value, err := resolveSecret(v.MapIndex(key).String(), dir)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			v.SetMapIndex(key, reflect.ValueOf(value))
+		}
+	case reflect.String:
+		if !secret {
+			return nil
+		}
+		value, err := resolveSecret(v.String(), dir)
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+// resolveSecret returns the value a secret reference points to, or the value itself if it isn't a reference.
+func resolveSecret(value, dir string) (string, error) {
+	if name, ok := strings.CutPrefix(value, envPrefix); ok {
+		secret, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+	if path, ok := strings.CutPrefix(value, filePrefix); ok {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return value, nil
+}
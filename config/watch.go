@@ -0,0 +1,43 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch calls onChange whenever the modification time of one of the files at paths changes, checking
+// every interval until ctx is cancelled. Polling keeps it working for editors that replace the file on save.
+func Watch(ctx context.Context, interval time.Duration, onChange func(), paths ...string) {
+	modTime := func(path string) time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	last := make([]time.Time, len(paths))
+	for i, path := range paths {
+		last[i] = modTime(path)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed := false
+			for i, path := range paths {
+				if current := modTime(path); !current.IsZero() && !current.Equal(last[i]) {
+					last[i] = current
+					changed = true
+				}
+			}
+			if changed {
+				onChange()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
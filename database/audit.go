@@ -0,0 +1,102 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// auditBucket holds the audit log, keyed by a big-endian sequence number so entries sort by age.
+const auditBucket = "_audit"
+
+// AddAuditEntry appends an entry to the audit log.
+func (d *DB) AddAuditEntry(entry storage.AuditEntry) error {
+	return d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(auditBucket))
+		if err != nil {
+			return err
+		}
+		entry.Seq, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(entry.Seq), data)
+	})
+}
+
+// ListAuditEntries returns the audit entries matching a filter, newest first.
+func (d *DB) ListAuditEntries(filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	entries := []storage.AuditEntry{}
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(auditBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k, v []byte
+		if filter.Before > 0 {
+			c.Seek(seqKey(filter.Before))
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			var entry storage.AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if (filter.Client != "" && entry.Client != filter.Client) || (filter.Action != "" && entry.Action != filter.Action) {
+				continue
+			}
+			entries = append(entries, entry)
+			if filter.Limit > 0 && len(entries) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// PruneAuditLog removes audit entries older than maxAge.
+func (d *DB) PruneAuditLog(maxAge time.Duration) error {
+	cutoff := time.Now().Add(-maxAge)
+	return d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(auditBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var entry storage.AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !entry.Time.Before(cutoff) {
+				return nil
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seqKey returns the key of a sequence number, which sorts by value.
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
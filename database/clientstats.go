@@ -0,0 +1,98 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+
+	"go.etcd.io/bbolt"
+)
+
+// clientStatsBucket holds the lifetime statistics of each client, keyed by client name. Its values are
+// sealed in an encrypted database.
+const clientStatsBucket = "_clientstats"
+
+// addClientStats adds counters to a client's statistics within a write transaction.
+func (d *DB) addClientStats(tx *bbolt.Tx, clientName string, delta storage.ClientStats) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(clientStatsBucket))
+	if err != nil {
+		return err
+	}
+	var stats storage.ClientStats
+	if data := b.Get([]byte(clientName)); data != nil {
+		data, err := d.openValue(data)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+	}
+	stats.Add(delta)
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(clientName), d.sealValue(data))
+}
+
+// AddClientStats adds counters to a client's statistics. A later LastConnected replaces the stored one.
+func (d *DB) AddClientStats(clientName string, delta storage.ClientStats) error {
+	err := d.update(func(tx *bbolt.Tx) error {
+		return d.addClientStats(tx, clientName, delta)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record client stats: %w", err)
+	}
+	return nil
+}
+
+// GetClientStats returns a client's statistics, which are empty if it never connected.
+func (d *DB) GetClientStats(clientName string) (storage.ClientStats, error) {
+	var stats storage.ClientStats
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(clientStatsBucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(clientName)); data != nil {
+			data, err := d.openValue(data)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(data, &stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.ClientStats{}, fmt.Errorf("failed to read client stats: %w", err)
+	}
+	return stats, nil
+}
+
+// ListClientStats returns the statistics of every client.
+func (d *DB) ListClientStats() (map[string]storage.ClientStats, error) {
+	all := make(map[string]storage.ClientStats)
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(clientStatsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			v, err := d.openValue(v)
+			if err != nil {
+				return err
+			}
+			var stats storage.ClientStats
+			if err := json.Unmarshal(v, &stats); err != nil {
+				return err
+			}
+			all[string(k)] = stats
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client stats: %w", err)
+	}
+	return all, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"gopin/storage"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxSize is how many bytes are copied per transaction while compacting.
+const compactTxSize = 64 << 20
+
+// Compact rewrites the bbolt file without the free pages left behind by deleted entries, which bbolt
+// reuses but never returns to the file system. The entries are copied into a new file next to it, which
+// then replaces the old one. Reads and writes wait until it is done. A separate history database is
+// not compacted.
+func (d *DB) Compact() (storage.CompactResult, error) {
+	if d.readOnly {
+		return storage.CompactResult{}, storage.ErrReadOnly
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var result storage.CompactResult
+	if info, err := os.Stat(d.path); err == nil {
+		result.Before = info.Size()
+	}
+
+	tmp := d.path + ".compact"
+	os.Remove(tmp) // Left behind by a compaction that failed
+	dst, err := bbolt.Open(tmp, 0600, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to create compacted database: %w", err)
+	}
+	if err := bbolt.Compact(dst, d.db, compactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return result, fmt.Errorf("failed to compact database: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return result, fmt.Errorf("failed to write compacted database: %w", err)
+	}
+
+	// Windows can't replace a file that is open, and bbolt keeps its own handle on the file
+	if err := d.db.Close(); err != nil {
+		os.Remove(tmp)
+		return result, fmt.Errorf("failed to close database: %w", err)
+	}
+	renameErr := os.Rename(tmp, d.path)
+	if renameErr != nil {
+		os.Remove(tmp)
+	}
+	db, err := bbolt.Open(d.path, 0600, &bbolt.Options{Timeout: d.timeout})
+	if err != nil {
+		return result, fmt.Errorf("failed to reopen database: %w", err) // Calls fail as the old one is closed
+	}
+	d.db = db
+	if renameErr != nil {
+		return result, fmt.Errorf("failed to replace database: %w", renameErr)
+	}
+
+	if info, err := os.Stat(d.path); err == nil {
+		result.After = info.Size()
+	}
+	return result, nil
+}
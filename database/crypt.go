@@ -0,0 +1,246 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// EncryptionKeySize is the size in bytes of the encryption key, which is given base64 encoded.
+const EncryptionKeySize = 32
+
+// cryptBucket holds a value sealed with the encryption key, which tells that the file is encrypted and
+// whether it is opened with the right key. Its absence means nothing is encrypted yet.
+const cryptBucket = "_crypt"
+
+// cryptCheck is the key of the sealed value in cryptBucket.
+var cryptCheck = []byte("check")
+
+// sealedVersion starts every sealed value. Plain values never start with it: history values are
+// RFC 3339 times and the others JSON objects.
+const sealedVersion = 1
+
+// ErrEncrypted is returned when opening an encrypted database without a key.
+var ErrEncrypted = errors.New("the database is encrypted, set database.encryptionKey")
+
+// ErrWrongKey is returned when opening an encrypted database with another key than it was encrypted with.
+var ErrWrongKey = errors.New("the database is encrypted with another key")
+
+// encryptedBuckets are the reserved buckets whose values are sealed: the credentials, and the client
+// statistics, saved jobs, job history, query cooldowns and query yields, which include the queries of
+// each client.
+var encryptedBuckets = []string{passwordsBucket, keysBucket, clientStatsBucket, jobsBucket, jobHistoryBucket, queryCooldownsBucket, queryYieldsBucket}
+
+// ParseEncryptionKey decodes a base64 encoded encryption key. An empty key turns encryption off and
+// returns nil.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key: got %d bytes, want %d", len(key), EncryptionKeySize)
+	}
+	return key, nil
+}
+
+// sealer encrypts values with AES-256-GCM and blinds the keys that are looked up by their value, such
+// as hashes and pin IDs, with HMAC-SHA256. Both use their own key derived from the encryption key.
+type sealer struct {
+	aead     cipher.AEAD
+	blindKey []byte
+}
+
+func newSealer(key []byte) (*sealer, error) {
+	sealKey, err := hkdf.Key(sha256.New, key, nil, "render seal", 32)
+	if err != nil {
+		return nil, err
+	}
+	blindKey, err := hkdf.Key(sha256.New, key, nil, "render blind", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead, blindKey: blindKey}, nil
+}
+
+// seal encrypts a value. The result starts with sealedVersion and a random nonce.
+func (s *sealer) seal(plain []byte) []byte {
+	out := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(plain)+s.aead.Overhead())
+	out[0] = sealedVersion
+	rand.Read(out[1:])
+	return s.aead.Seal(out, out[1:], plain, nil)
+}
+
+// open decrypts a value sealed by seal.
+func (s *sealer) open(data []byte) ([]byte, error) {
+	if !isSealed(data) || len(data) < 1+s.aead.NonceSize() {
+		return nil, errors.New("value is not encrypted")
+	}
+	nonce := data[1 : 1+s.aead.NonceSize()]
+	return s.aead.Open(nil, nonce, data[1+s.aead.NonceSize():], nil)
+}
+
+// blind returns a key that can be looked up like the given one but doesn't reveal it.
+func (s *sealer) blind(key []byte) []byte {
+	mac := hmac.New(sha256.New, s.blindKey)
+	mac.Write(key)
+	return mac.Sum(nil)[:16]
+}
+
+// isSealed reports whether a stored value was sealed.
+func isSealed(v []byte) bool {
+	return len(v) > 0 && v[0] == sealedVersion
+}
+
+// sealValue seals a value of an encrypted bucket if the database is encrypted.
+func (d *DB) sealValue(v []byte) []byte {
+	if d.crypt == nil {
+		return v
+	}
+	return d.crypt.seal(v)
+}
+
+// openValue decrypts a value of an encrypted bucket if it was sealed.
+func (d *DB) openValue(v []byte) ([]byte, error) {
+	if !isSealed(v) {
+		return v, nil
+	}
+	if d.crypt == nil {
+		return nil, ErrEncrypted
+	}
+	v, err := d.crypt.open(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return v, nil
+}
+
+// openEncryption checks the encryption of the bbolt file against the key, which is nil without
+// encryption. The first time the file is opened with a key, the data written before is encrypted,
+// bucket by bucket. If that is interrupted, it resumes on the next open.
+func openEncryption(d *DB, key []byte) error {
+	var check []byte
+	d.view(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(cryptBucket)); b != nil {
+			check = bytes.Clone(b.Get(cryptCheck))
+		}
+		return nil
+	})
+
+	if key == nil {
+		if check != nil {
+			return ErrEncrypted
+		}
+		return nil
+	}
+	s, err := newSealer(key)
+	if err != nil {
+		return fmt.Errorf("failed to set up encryption: %w", err)
+	}
+	if check != nil {
+		if _, err := s.open(check); err != nil {
+			return ErrWrongKey
+		}
+		d.crypt = s
+		return nil
+	}
+	if d.readOnly {
+		return nil // The data is encrypted once the file is opened for writing
+	}
+
+	d.crypt = s
+	if err := d.encryptExisting(); err != nil {
+		return fmt.Errorf("failed to encrypt database: %w", err)
+	}
+	return d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(cryptBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(cryptCheck, s.seal([]byte("render")))
+	})
+}
+
+// encryptExisting seals the values of the encrypted buckets and the history that aren't sealed yet.
+// History entries are moved to their blinded keys, so the time index is rebuilt.
+func (d *DB) encryptExisting() error {
+	var names []string
+	err := d.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		history := !IsReserved(name) || strings.HasPrefix(name, pinsPrefix)
+		if !history && !slices.Contains(encryptedBuckets, name) {
+			continue
+		}
+		err := d.update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket([]byte(name))
+			type entry struct{ k, v []byte }
+			var plain []entry
+			err := b.ForEach(func(k, v []byte) error {
+				if v != nil && !isSealed(v) {
+					plain = append(plain, entry{bytes.Clone(k), bytes.Clone(v)})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, e := range plain {
+				if !history {
+					if err := b.Put(e.k, d.crypt.seal(e.v)); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := b.Delete(e.k); err != nil {
+					return err
+				}
+				if err := b.Put(d.crypt.blind(e.k), d.crypt.seal(sealedEntry(e.k, e.v))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("bucket %s: %w", name, err)
+		}
+	}
+
+	return d.update(func(tx *bbolt.Tx) error { return indexHistory(d, tx) })
+}
+
+// sealedEntry returns the plaintext of a sealed history value: the entry's own key, a zero byte and
+// the time it was seen, so that the key can be recovered from its blinded one.
+func sealedEntry(key, seen []byte) []byte {
+	return append(append(append(make([]byte, 0, len(key)+1+len(seen)), key...), 0), seen...)
+}
@@ -2,88 +2,136 @@ package database
 
 import (
 	"fmt"
+	"gopin/storage"
+	"strings"
+	"sync"
 	"time"
 
 	"go.etcd.io/bbolt"
 )
 
-// DB is a wrapper around a bbolt database.
+// DB is a wrapper around a bbolt database, with the history of seen images in bbolt or another backend.
 type DB struct {
-	db *bbolt.DB
+	mu      sync.RWMutex // Held for writing while Compact replaces the file
+	db      *bbolt.DB
+	path    string
+	timeout time.Duration
+	history historyStore
+	crypt   *sealer // Nil unless the database is encrypted
+	// readOnly is set when the database was opened read-only, and makes every write fail with storage.ErrReadOnly.
+	readOnly bool
+	migrated []string // Names of the migrations run by OpenOptions
+}
+
+// DB is the bbolt implementation of storage.Store.
+var (
+	_ storage.Store     = (*DB)(nil)
+	_ storage.Compacter = (*DB)(nil)
+)
+
+// Options configure how a database is opened.
+type Options struct {
+	// Timeout is how long to wait for another process to release the bbolt file.
+	Timeout time.Duration
+	// History is the backend of the history of seen images: HistoryBolt, the default, HistorySQLite,
+	// HistoryPostgres or HistoryRedis.
+	History string
+	// SQLitePath is the file of the SQLite history.
+	SQLitePath string
+	// PostgresURL is the connection URL of the Postgres history.
+	PostgresURL string
+	// RedisURL is the URL of the Redis server of the Redis history, and RedisPrefix is put in front of its keys.
+	RedisURL    string
+	RedisPrefix string
+	// EncryptionKey encrypts the history and credentials in the bbolt file, see ParseEncryptionKey. A file
+	// opened with a key for the first time is encrypted; an encrypted file can't be opened without its key.
+	EncryptionKey []byte
+	// ReadOnly opens the bbolt file read-only, which lets other processes read it at the same time, and
+	// makes every write fail with storage.ErrReadOnly, also to a separate history database.
+	ReadOnly bool
 }
 
 // Open opens a database file at the given path.
 func Open(path string) (*DB, error) {
-	db, err := bbolt.Open(path, 0600, nil)
+	// Fail instead of blocking forever while another process, such as a running server, holds the file
+	return OpenTimeout(path, time.Second)
+}
+
+// OpenTimeout opens the database, waiting up to timeout for another process to release it.
+func OpenTimeout(path string, timeout time.Duration) (*DB, error) {
+	return OpenOptions(path, Options{Timeout: timeout})
+}
+
+// OpenOptions opens the database at path and the history backend chosen by opts.
+func OpenOptions(path string, opts Options) (*DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: opts.Timeout, ReadOnly: opts.ReadOnly})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-	return &DB{db: db}, nil
+
+	d := &DB{db: db, path: path, timeout: opts.Timeout, readOnly: opts.ReadOnly}
+	if err := openEncryption(d, opts.EncryptionKey); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(d); err != nil {
+		db.Close()
+		return nil, err
+	}
+	switch opts.History {
+	case "", HistoryBolt:
+		d.history = boltHistory{d: d}
+	case HistorySQLite:
+		d.history, err = openSQLiteHistory(opts.SQLitePath)
+	case HistoryPostgres:
+		d.history, err = openPostgresHistory(opts.PostgresURL)
+	case HistoryRedis:
+		d.history, err = openRedisHistory(opts.RedisURL, opts.RedisPrefix)
+	default:
+		err = fmt.Errorf("unknown history backend %q", opts.History)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 // Close closes the database.
 func (d *DB) Close() error {
-	return d.db.Close()
-}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-// HasClientSeenImage checks if a client has already seen an image with the given hash.
-func (d *DB) HasClientSeenImage(clientName string, hash uint64) (bool, error) {
-	var exists bool
-	hashStr := fmt.Sprintf("%d", hash)
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(clientName))
-		if b == nil {
-			return nil // Bucket doesn't exist, so the image hasn't been seen
-		}
-		exists = b.Get([]byte(hashStr)) != nil
-		return nil
-	})
-	if err != nil {
-		return false, fmt.Errorf("failed to check for hash: %w", err)
+	if err := d.history.close(); err != nil {
+		d.db.Close()
+		return err
 	}
-	return exists, nil
+	return d.db.Close()
 }
 
-// MarkImageAsSeen marks an image as seen for a specific client.
-func (d *DB) MarkImageAsSeen(clientName string, hash uint64) error {
-	hashStr := fmt.Sprintf("%d", hash)
-	timestamp := time.Now().Format(time.RFC3339)
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(clientName))
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(hashStr), []byte(timestamp))
-	})
+// Ping checks that the database can still be read.
+func (d *DB) Ping() error {
+	return d.view(func(tx *bbolt.Tx) error { return nil })
 }
 
-// ClearClientHistory removes all records for a given client.
-func (d *DB) ClearClientHistory(clientName string) error {
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		return tx.DeleteBucket([]byte(clientName))
-	})
+// view runs a read-only transaction on the bbolt file.
+func (d *DB) view(fn func(tx *bbolt.Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.db.View(fn)
 }
 
-// CleanupOldEntries removes entries from the database that are older than the specified maxAge.
-func (d *DB) CleanupOldEntries(maxAge time.Duration) error {
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
-			// Store timestamps with hashes
-			toDelete := [][]byte{}
-			b.ForEach(func(k, v []byte) error {
-				// Parse timestamp from value
-				if timestamp, err := time.Parse(time.RFC3339, string(v)); err == nil {
-					if time.Since(timestamp) > maxAge {
-						toDelete = append(toDelete, k)
-					}
-				}
-				return nil
-			})
+// update runs a read-write transaction on the bbolt file.
+func (d *DB) update(fn func(tx *bbolt.Tx) error) error {
+	if d.readOnly {
+		return storage.ErrReadOnly
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.db.Update(fn)
+}
 
-			for _, key := range toDelete {
-				b.Delete(key)
-			}
-			return nil
-		})
-	})
+// IsReserved reports whether a bucket name is reserved for server data such as API keys rather than a client's history.
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, "_")
 }
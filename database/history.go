@@ -0,0 +1,409 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"gopin/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// History backends.
+const (
+	// HistoryBolt keeps the history in the bbolt file, in a bucket per client.
+	HistoryBolt = "bbolt"
+	// HistorySQLite keeps the history in a separate SQLite database.
+	HistorySQLite = "sqlite"
+	// HistoryPostgres keeps the history in a Postgres database, which several servers can share.
+	HistoryPostgres = "postgres"
+	// HistoryRedis keeps the history in Redis, which several servers can share.
+	HistoryRedis = "redis"
+)
+
+// historyStore keeps the hashes and pin IDs of the images each client has seen and when it saw them.
+type historyStore interface {
+	hasSeen(clientName string, hash uint64) (bool, error)
+	hasSeenPin(clientName, pinID string) (bool, error)
+	// markSeen records a hash and, unless it is empty, a pin ID.
+	markSeen(clientName string, hash uint64, pinID string, at time.Time) error
+	// clear and cleanup remove pin IDs along with hashes.
+	clear(clientName string) error
+	cleanup(retention storage.Retention) error
+	// stats returns the number of entries of each client with a history and the size in bytes the
+	// store takes besides the bbolt file.
+	stats() (entries map[string]int, size int64, err error)
+	// each calls fn with the hash entries of a client, or of every client if clientName is empty, grouped by client.
+	each(clientName string, fn func(HistoryEntry) error) error
+	// merge adds entries, keeping the later time of those that already exist.
+	merge(entries []HistoryEntry) error
+	close() error
+}
+
+// HistoryEntry is an image a client has seen and when it saw it.
+type HistoryEntry struct {
+	Client string
+	Hash   uint64
+	Seen   time.Time
+}
+
+// pinsPrefix starts the name of the reserved bucket of a client's pin IDs, which maps each pin ID to
+// the time it was seen like the client's own bucket does with hashes.
+const pinsPrefix = "_pins:"
+
+// timeIndexBucket indexes the entries of the client and pin buckets by the time they were seen, so that
+// cleanup only reads the entries that expired. Its keys are the Unix time as 8 big-endian bytes, the
+// name of the entry's bucket, a zero byte and the entry's key. Seeing an entry again leaves its old
+// index key behind, which cleanup drops once it expires.
+const timeIndexBucket = "_bytime"
+
+const (
+	// cleanupBatch is how many expired entries cleanup removes per write transaction.
+	cleanupBatch = 1000
+	// cleanupPause is how long cleanup waits between batches, letting the writes it held up go first.
+	cleanupPause = 10 * time.Millisecond
+)
+
+// boltHistory keeps the history in a bucket per client, mapping each hash to the time it was seen. In
+// an encrypted database, the hashes and pin IDs are blinded and the values sealed, with the entry's own
+// key sealed along with the time.
+type boltHistory struct {
+	d *DB
+}
+
+// timeIndexKey returns the key of an entry in the time index.
+func timeIndexKey(seen time.Time, bucket, key []byte) []byte {
+	k := make([]byte, 8, 8+len(bucket)+1+len(key))
+	binary.BigEndian.PutUint64(k, uint64(max(seen.Unix(), 0)))
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// storedKey returns the key an entry is stored under, which is blinded in an encrypted database.
+func (h boltHistory) storedKey(key []byte) []byte {
+	if h.d.crypt == nil {
+		return key
+	}
+	return h.d.crypt.blind(key)
+}
+
+// readSeen returns the key of the entry stored under k with the value v and the time it was seen. It
+// reports false if the value isn't an entry.
+func (h boltHistory) readSeen(k, v []byte) (key []byte, seen time.Time, ok bool) {
+	key = k
+	if isSealed(v) {
+		if h.d.crypt == nil {
+			return nil, time.Time{}, false
+		}
+		plain, err := h.d.crypt.open(v)
+		if err != nil {
+			return nil, time.Time{}, false
+		}
+		if key, v, ok = bytes.Cut(plain, []byte{0}); !ok {
+			return nil, time.Time{}, false
+		}
+	}
+	seen, err := time.Parse(time.RFC3339, string(v))
+	return key, seen, err == nil
+}
+
+// putSeen stores when an entry of a client or pin bucket was seen and indexes it.
+func (h boltHistory) putSeen(tx *bbolt.Tx, bucket, key []byte, seen time.Time) error {
+	b, err := tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	stored, value := h.storedKey(key), []byte(seen.Format(time.RFC3339))
+	if h.d.crypt != nil {
+		value = h.d.crypt.seal(sealedEntry(key, value))
+	}
+	if err := b.Put(stored, value); err != nil {
+		return err
+	}
+	index, err := tx.CreateBucketIfNotExists([]byte(timeIndexBucket))
+	if err != nil {
+		return err
+	}
+	return index.Put(timeIndexKey(seen, bucket, stored), nil)
+}
+
+func (h boltHistory) hasSeen(clientName string, hash uint64) (bool, error) {
+	var exists bool
+	err := h.d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(clientName))
+		if b == nil {
+			return nil // Bucket doesn't exist, so the image hasn't been seen
+		}
+		exists = b.Get(h.storedKey([]byte(strconv.FormatUint(hash, 10)))) != nil
+		return nil
+	})
+	return exists, err
+}
+
+func (h boltHistory) hasSeenPin(clientName, pinID string) (bool, error) {
+	var exists bool
+	err := h.d.view(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(pinsPrefix + clientName)); b != nil {
+			exists = b.Get(h.storedKey([]byte(pinID))) != nil
+		}
+		return nil
+	})
+	return exists, err
+}
+
+func (h boltHistory) markSeen(clientName string, hash uint64, pinID string, at time.Time) error {
+	return h.d.update(func(tx *bbolt.Tx) error {
+		if err := h.putSeen(tx, []byte(clientName), []byte(strconv.FormatUint(hash, 10)), at); err != nil {
+			return err
+		}
+		if pinID == "" {
+			return nil
+		}
+		return h.putSeen(tx, []byte(pinsPrefix+clientName), []byte(pinID), at)
+	})
+}
+
+func (h boltHistory) clear(clientName string) error {
+	return h.d.update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(timeIndexBucket))
+		for _, name := range []string{pinsPrefix + clientName, clientName} {
+			b := tx.Bucket([]byte(name))
+			if b == nil {
+				continue
+			}
+			if index != nil {
+				// Index keys of earlier times of the entries are left for cleanup to drop
+				err := b.ForEach(func(k, v []byte) error {
+					if _, seen, ok := h.readSeen(k, v); ok {
+						return index.Delete(timeIndexKey(seen, []byte(name), k))
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// cleanup removes the expired entries in batches of cleanupBatch, each in its own write transaction, so
+// that marking images as seen isn't held up until every expired entry is gone.
+func (h boltHistory) cleanup(retention storage.Retention) error {
+	now := time.Now()
+	cutoff := func(age time.Duration) uint64 {
+		if age <= 0 {
+			return 0 // Nothing is older
+		}
+		return uint64(max(now.Add(-age).Unix(), 0))
+	}
+
+	// The index is read up to the latest cutoff of any client, skipping the entries of clients whose
+	// history is kept longer
+	latest := cutoff(retention.MaxAge)
+	for _, age := range retention.Clients {
+		latest = max(latest, cutoff(age))
+	}
+	clientCutoff := func(bucket []byte) uint64 {
+		return cutoff(retention.For(strings.TrimPrefix(string(bucket), pinsPrefix)))
+	}
+
+	var after []byte
+	for {
+		next, err := h.cleanupBatch(after, latest, clientCutoff)
+		if err != nil || next == nil {
+			return err
+		}
+		after = next
+		time.Sleep(cleanupPause)
+	}
+}
+
+// cleanupBatch reads up to cleanupBatch index keys after the key after, oldest first, that are before
+// latest, and removes the entries among them that are before the cutoff of their bucket. It returns the
+// last key it read, or nil once there are no more.
+func (h boltHistory) cleanupBatch(after []byte, latest uint64, cutoff func(bucket []byte) uint64) ([]byte, error) {
+	var read [][]byte
+	err := h.d.update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(timeIndexBucket))
+		if index == nil {
+			return nil
+		}
+
+		// Only the index keys before the latest cutoff are read
+		c := index.Cursor()
+		k, _ := c.First()
+		if after != nil {
+			if k, _ = c.Seek(after); bytes.Equal(k, after) {
+				k, _ = c.Next()
+			}
+		}
+		for ; k != nil && binary.BigEndian.Uint64(k) < latest && len(read) < cleanupBatch; k, _ = c.Next() {
+			read = append(read, append([]byte(nil), k...))
+		}
+
+		for _, k := range read {
+			name, key, ok := bytes.Cut(k[8:], []byte{0})
+			if b := tx.Bucket(name); ok && b != nil {
+				// An entry seen again since has a later time, and a later index key of its own
+				if v := b.Get(key); v != nil {
+					if _, seen, ok := h.readSeen(key, v); ok && uint64(max(seen.Unix(), 0)) == binary.BigEndian.Uint64(k) {
+						if binary.BigEndian.Uint64(k) >= cutoff(name) {
+							continue // Kept longer by its client, so it stays indexed
+						}
+						if err := b.Delete(key); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || len(read) < cleanupBatch {
+		return nil, err
+	}
+	return read[len(read)-1], nil
+}
+
+func (h boltHistory) stats() (entries map[string]int, size int64, err error) {
+	entries = make(map[string]int)
+	err = h.d.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if !IsReserved(string(name)) {
+				entries[string(name)] = b.Stats().KeyN
+			}
+			return nil
+		})
+	})
+	return entries, 0, err // The buckets are part of the bbolt file
+}
+
+func (h boltHistory) each(clientName string, fn func(HistoryEntry) error) error {
+	return h.d.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if IsReserved(string(name)) || (clientName != "" && string(name) != clientName) {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				key, seen, ok := h.readSeen(k, v)
+				if !ok {
+					return nil
+				}
+				hash, err := strconv.ParseUint(string(key), 10, 64)
+				if err != nil {
+					return nil // Not a hash
+				}
+				return fn(HistoryEntry{Client: string(name), Hash: hash, Seen: seen})
+			})
+		})
+	})
+}
+
+func (h boltHistory) merge(entries []HistoryEntry) error {
+	return h.d.update(func(tx *bbolt.Tx) error {
+		for _, e := range entries {
+			b, err := tx.CreateBucketIfNotExists([]byte(e.Client))
+			if err != nil {
+				return err
+			}
+			key := []byte(strconv.FormatUint(e.Hash, 10))
+			if v := b.Get(h.storedKey(key)); v != nil {
+				if _, seen, ok := h.readSeen(key, v); ok && !seen.Before(e.Seen) {
+					continue
+				}
+			}
+			if err := h.putSeen(tx, []byte(e.Client), key, e.Seen); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (h boltHistory) close() error {
+	return nil // The bbolt file is closed by DB
+}
+
+// HasClientSeenImage checks if a client has already seen an image with the given hash.
+func (d *DB) HasClientSeenImage(clientName string, hash uint64) (bool, error) {
+	exists, err := d.history.hasSeen(clientName, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for hash: %w", err)
+	}
+	return exists, nil
+}
+
+// HasClientSeenPin checks if a client has already been sent the pin with the given ID.
+func (d *DB) HasClientSeenPin(clientName, pinID string) (bool, error) {
+	exists, err := d.history.hasSeenPin(clientName, pinID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for pin: %w", err)
+	}
+	return exists, nil
+}
+
+// MarkImageAsSeen marks an image as seen for a specific client, by its hash and, unless pinID is
+// empty, the ID of its pin.
+func (d *DB) MarkImageAsSeen(clientName string, hash uint64, pinID string) error {
+	if d.readOnly {
+		return storage.ErrReadOnly
+	}
+	return d.history.markSeen(clientName, hash, pinID, time.Now())
+}
+
+// ClearClientHistory removes all records for a given client.
+func (d *DB) ClearClientHistory(clientName string) error {
+	if IsReserved(clientName) {
+		return fmt.Errorf("%q is a reserved name", clientName)
+	}
+	if d.readOnly {
+		return storage.ErrReadOnly
+	}
+	return d.history.clear(clientName)
+}
+
+// CleanupOldEntries removes the entries that are older than the retention of their client.
+func (d *DB) CleanupOldEntries(retention storage.Retention) error {
+	if d.readOnly {
+		return storage.ErrReadOnly
+	}
+	return d.history.cleanup(retention)
+}
+
+// ExportHistory calls fn with the history of a client, or of every client if clientName is empty,
+// grouped by client. It stops at the first error fn returns.
+func (d *DB) ExportHistory(clientName string, fn func(HistoryEntry) error) error {
+	if err := d.history.each(clientName, fn); err != nil {
+		return fmt.Errorf("failed to export history: %w", err)
+	}
+	return nil
+}
+
+// ImportHistory adds entries to the history, such as those exported from another server. An entry that
+// already exists keeps the later of both times, so that histories can be merged.
+func (d *DB) ImportHistory(entries []HistoryEntry) error {
+	for _, e := range entries {
+		if e.Client == "" || IsReserved(e.Client) {
+			return fmt.Errorf("invalid client name %q", e.Client)
+		}
+	}
+	if d.readOnly {
+		return storage.ErrReadOnly
+	}
+	if err := d.history.merge(entries); err != nil {
+		return fmt.Errorf("failed to import history: %w", err)
+	}
+	return nil
+}
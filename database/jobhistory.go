@@ -0,0 +1,110 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// jobHistoryBucket holds the finished jobs, keyed by a big-endian sequence number so they sort by the
+// time they finished. Its values are sealed in an encrypted database, as they include the queries.
+const jobHistoryBucket = "_jobhistory"
+
+// AddJobRecord appends a finished job to the history.
+func (d *DB) AddJobRecord(rec storage.JobRecord) error {
+	err := d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(jobHistoryBucket))
+		if err != nil {
+			return err
+		}
+		rec.Seq, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(rec.Seq), d.sealValue(data))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record job: %w", err)
+	}
+	return nil
+}
+
+// readJobRecord decodes a stored job.
+func (d *DB) readJobRecord(v []byte) (storage.JobRecord, error) {
+	var rec storage.JobRecord
+	data, err := d.openValue(v)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// ListJobRecords returns the finished jobs matching a filter, newest first.
+func (d *DB) ListJobRecords(filter storage.JobFilter) ([]storage.JobRecord, error) {
+	records := []storage.JobRecord{}
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(jobHistoryBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k, v []byte
+		if filter.Before > 0 {
+			c.Seek(seqKey(filter.Before))
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			rec, err := d.readJobRecord(v)
+			if err != nil {
+				return err
+			}
+			if filter.Client != "" && rec.Client != filter.Client {
+				continue
+			}
+			records = append(records, rec)
+			if filter.Limit > 0 && len(records) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job history: %w", err)
+	}
+	return records, nil
+}
+
+// PruneJobHistory removes the jobs that finished more than maxAge ago.
+func (d *DB) PruneJobHistory(maxAge time.Duration) error {
+	cutoff := time.Now().Add(-maxAge)
+	return d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(jobHistoryBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			rec, err := d.readJobRecord(v)
+			if err != nil {
+				return err
+			}
+			if !rec.Finished.Before(cutoff) {
+				return nil
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
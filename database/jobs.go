@@ -0,0 +1,72 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+
+	"go.etcd.io/bbolt"
+)
+
+// jobsBucket holds the saved interactive job of each client, keyed by client name. Its values are
+// sealed in an encrypted database, as they include the client's queries.
+const jobsBucket = "_jobs"
+
+// SaveJob stores the progress of a client's job, replacing the one saved before.
+func (d *DB) SaveJob(job storage.SavedJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	err = d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(jobsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(job.Client), d.sealValue(data))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// GetSavedJob returns the saved job of a client, or nil if it has none.
+func (d *DB) GetSavedJob(clientName string) (*storage.SavedJob, error) {
+	var job *storage.SavedJob
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(clientName))
+		if data == nil {
+			return nil
+		}
+		data, err := d.openValue(data)
+		if err != nil {
+			return err
+		}
+		job = &storage.SavedJob{}
+		return json.Unmarshal(data, job)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved job: %w", err)
+	}
+	return job, nil
+}
+
+// DeleteSavedJob removes the saved job of a client.
+func (d *DB) DeleteSavedJob(clientName string) error {
+	err := d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(clientName))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete saved job: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// keysBucket holds the API keys. Its values are sealed in an encrypted database.
+const keysBucket = "_apikeys"
+
+// AddAPIKey stores a new API key.
+func (d *DB) AddAPIKey(key storage.APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode API key: %w", err)
+	}
+	return d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(keysBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key.ID), d.sealValue(data))
+	})
+}
+
+// GetAPIKey returns the API key with the given ID, or nil if there is none.
+func (d *DB) GetAPIKey(id string) (*storage.APIKey, error) {
+	var key *storage.APIKey
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(keysBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		data, err := d.openValue(data)
+		if err != nil {
+			return err
+		}
+		key = &storage.APIKey{}
+		return json.Unmarshal(data, key)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all API keys, oldest first.
+func (d *DB) ListAPIKeys() ([]storage.APIKey, error) {
+	var keys []storage.APIKey
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(keysBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			v, err := d.openValue(v)
+			if err != nil {
+				return err
+			}
+			var key storage.APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked. It reports false if there is no such key.
+func (d *DB) RevokeAPIKey(id string) (bool, error) {
+	key, err := d.GetAPIKey(id)
+	if err != nil || key == nil {
+		return false, err
+	}
+	if key.Revoked.IsZero() {
+		key.Revoked = time.Now()
+	}
+	return true, d.AddAPIKey(*key)
+}
+
+// ExpireAPIKey ends the validity of an API key at the given time. It reports false if there is no such key.
+func (d *DB) ExpireAPIKey(id string, at time.Time) (bool, error) {
+	key, err := d.GetAPIKey(id)
+	if err != nil || key == nil {
+		return false, err
+	}
+	key.Expires = at
+	return true, d.AddAPIKey(*key)
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// metaBucket holds data about the bbolt file itself, such as its schema version.
+const metaBucket = "_meta"
+
+// schemaVersionKey is the key of the schema version in metaBucket, a big-endian uint64. Files written
+// before it existed have no version, which is version 0.
+var schemaVersionKey = []byte("schemaVersion")
+
+// migration upgrades the bbolt file from the version before it to its own. It runs in the same
+// transaction that stores the new version, so an interrupted upgrade resumes at the migration that
+// didn't finish, and must accept files that already have the change, as files written before the
+// schema version existed may have some of them.
+type migration struct {
+	name    string
+	migrate func(d *DB, tx *bbolt.Tx) error
+}
+
+// migrations are the changes of the schema in the order they were made. Version n is the file after
+// the first n of them; new ones are only ever appended.
+var migrations = []migration{
+	{"index the history by time", func(d *DB, tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(timeIndexBucket)) != nil {
+			return nil
+		}
+		return indexHistory(d, tx)
+	}},
+}
+
+// SchemaVersion is the schema version of the bbolt files this version of the server writes.
+var SchemaVersion = len(migrations)
+
+// migrate upgrades the bbolt file to SchemaVersion, one migration at a time. A file with a newer
+// version is refused, as this version may not read it correctly and would write it in the old schema.
+// A file opened read-only isn't upgraded; reads work with every older version.
+func migrate(d *DB) error {
+	var version int
+	d.view(func(tx *bbolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	if version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the version %d this server supports, upgrade the server", version, SchemaVersion)
+	}
+	if d.readOnly {
+		return nil
+	}
+
+	for ; version < SchemaVersion; version++ {
+		m := migrations[version]
+		err := d.update(func(tx *bbolt.Tx) error {
+			if err := m.migrate(d, tx); err != nil {
+				return err
+			}
+			meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+			if err != nil {
+				return err
+			}
+			return meta.Put(schemaVersionKey, binary.BigEndian.AppendUint64(nil, uint64(version+1)))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate database to schema version %d (%s): %w", version+1, m.name, err)
+		}
+		d.migrated = append(d.migrated, m.name)
+	}
+	return nil
+}
+
+// schemaVersion returns the schema version of the bbolt file.
+func schemaVersion(tx *bbolt.Tx) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+	v := b.Get(schemaVersionKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+// Migrated returns the names of the migrations that upgraded the database when it was opened.
+func (d *DB) Migrated() []string {
+	return d.migrated
+}
+
+// indexHistory builds the time index of the history in the bbolt file, replacing any existing one.
+func indexHistory(d *DB, tx *bbolt.Tx) error {
+	if tx.Bucket([]byte(timeIndexBucket)) != nil {
+		if err := tx.DeleteBucket([]byte(timeIndexBucket)); err != nil {
+			return err
+		}
+	}
+	index, err := tx.CreateBucket([]byte(timeIndexBucket))
+	if err != nil {
+		return err
+	}
+	h := boltHistory{d: d}
+	return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		if IsReserved(string(name)) && !strings.HasPrefix(string(name), pinsPrefix) {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			_, seen, ok := h.readSeen(k, v)
+			if !ok {
+				return nil // Not an entry, and so never removed by cleanup before either
+			}
+			return index.Put(timeIndexKey(seen, name, k), nil)
+		})
+	})
+}
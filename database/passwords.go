@@ -0,0 +1,64 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+
+	"go.etcd.io/bbolt"
+)
+
+// passwordsBucket holds the passwords that clients rotated, which take precedence over config.json. Its
+// values are sealed in an encrypted database.
+const passwordsBucket = "_passwords"
+
+// GetPassword returns the rotated password of a client, or nil if it never rotated its password.
+func (d *DB) GetPassword(clientName string) (*storage.StoredPassword, error) {
+	var stored *storage.StoredPassword
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(passwordsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(clientName))
+		if data == nil {
+			return nil
+		}
+		data, err := d.openValue(data)
+		if err != nil {
+			return err
+		}
+		stored = &storage.StoredPassword{}
+		return json.Unmarshal(data, stored)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	return stored, nil
+}
+
+// DeletePassword removes the rotated password of a client.
+func (d *DB) DeletePassword(clientName string) error {
+	return d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(passwordsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(clientName))
+	})
+}
+
+// SetPassword stores the rotated password of a client.
+func (d *DB) SetPassword(clientName string, stored storage.StoredPassword) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode password: %w", err)
+	}
+	return d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(passwordsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(clientName), d.sealValue(data))
+	})
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// queryCooldownsBucket holds when the queries of each source were last found exhausted, keyed by
+// source, as a JSON object of queries and times. Its values are sealed in an encrypted database.
+const queryCooldownsBucket = "_querycooldowns"
+
+// MarkQueryExhausted records that a query of a source ran out of results at the given time.
+func (d *DB) MarkQueryExhausted(source, query string, at time.Time) error {
+	err := d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(queryCooldownsBucket))
+		if err != nil {
+			return err
+		}
+		queries, err := d.exhaustedQueries(b, source)
+		if err != nil {
+			return err
+		}
+		queries[query] = at
+		return d.putExhaustedQueries(b, source, queries)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record exhausted query: %w", err)
+	}
+	return nil
+}
+
+// ExhaustedQueries returns when each query of a source was last found exhausted.
+func (d *DB) ExhaustedQueries(source string) (map[string]time.Time, error) {
+	queries := make(map[string]time.Time)
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(queryCooldownsBucket))
+		if b == nil {
+			return nil
+		}
+		var err error
+		queries, err = d.exhaustedQueries(b, source)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exhausted queries: %w", err)
+	}
+	return queries, nil
+}
+
+// PruneQueryCooldowns forgets the queries that were found exhausted more than maxAge ago.
+func (d *DB) PruneQueryCooldowns(maxAge time.Duration) error {
+	cutoff := time.Now().Add(-maxAge)
+	err := d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(queryCooldownsBucket))
+		if b == nil {
+			return nil
+		}
+		var sources []string
+		if err := b.ForEach(func(k, _ []byte) error {
+			sources = append(sources, string(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, source := range sources {
+			queries, err := d.exhaustedQueries(b, source)
+			if err != nil {
+				return err
+			}
+			pruned := false
+			for query, at := range queries {
+				if at.Before(cutoff) {
+					delete(queries, query)
+					pruned = true
+				}
+			}
+			switch {
+			case len(queries) == 0:
+				err = b.Delete([]byte(source))
+			case pruned:
+				err = d.putExhaustedQueries(b, source, queries)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune query cooldowns: %w", err)
+	}
+	return nil
+}
+
+// exhaustedQueries decodes the exhausted queries of a source.
+func (d *DB) exhaustedQueries(b *bbolt.Bucket, source string) (map[string]time.Time, error) {
+	queries := make(map[string]time.Time)
+	data := b.Get([]byte(source))
+	if data == nil {
+		return queries, nil
+	}
+	data, err := d.openValue(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// putExhaustedQueries stores the exhausted queries of a source.
+func (d *DB) putExhaustedQueries(b *bbolt.Bucket, source string, queries map[string]time.Time) error {
+	data, err := json.Marshal(queries)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(source), d.sealValue(data))
+}
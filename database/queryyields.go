@@ -0,0 +1,70 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+
+	"go.etcd.io/bbolt"
+)
+
+// queryYieldsBucket holds the lifetime yield of each query, keyed by query. Its values are sealed in an
+// encrypted database.
+const queryYieldsBucket = "_queryyields"
+
+// AddQueryYield adds counters to a query's yield. A later LastScraped replaces the stored one.
+func (d *DB) AddQueryYield(query string, delta storage.QueryYield) error {
+	err := d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(queryYieldsBucket))
+		if err != nil {
+			return err
+		}
+		var yield storage.QueryYield
+		if data := b.Get([]byte(query)); data != nil {
+			data, err := d.openValue(data)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &yield); err != nil {
+				return err
+			}
+		}
+		yield.Add(delta)
+		data, err := json.Marshal(yield)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(query), d.sealValue(data))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record query yield: %w", err)
+	}
+	return nil
+}
+
+// ListQueryYields returns the yield of every query that was scraped.
+func (d *DB) ListQueryYields() (map[string]storage.QueryYield, error) {
+	all := make(map[string]storage.QueryYield)
+	err := d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(queryYieldsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			v, err := d.openValue(v)
+			if err != nil {
+				return err
+			}
+			var yield storage.QueryYield
+			if err := json.Unmarshal(v, &yield); err != nil {
+				return err
+			}
+			all[string(k)] = yield
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query yields: %w", err)
+	}
+	return all, nil
+}
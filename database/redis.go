@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopin/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix is put in front of the keys of the Redis history if no prefix is configured.
+const DefaultRedisPrefix = "render:"
+
+// redisTimeout bounds connecting to Redis.
+const redisTimeout = 10 * time.Second
+
+// redisHistory keeps the history of each client in a Redis sorted set, with the hashes as members
+// scored by the Unix time they were seen, so old entries are removed by a range of scores. The pin IDs
+// of each client are kept the same way in a second sorted set.
+type redisHistory struct {
+	client *redis.Client
+	prefix string
+}
+
+// openRedisHistory connects to the Redis server at url, such as "redis://:password@host:6379/0".
+func openRedisHistory(url, prefix string) (*redisHistory, error) {
+	if url == "" {
+		return nil, errors.New("the Redis history needs a URL")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &redisHistory{client: client, prefix: prefix}, nil
+}
+
+// Kinds of sorted sets kept per client.
+const (
+	redisSeen = "seen:"
+	redisPins = "pins:"
+)
+
+// key returns the key of a client's sorted set of hashes.
+func (h *redisHistory) key(clientName string) string {
+	return h.prefix + redisSeen + clientName
+}
+
+// pinsKey returns the key of a client's sorted set of pin IDs.
+func (h *redisHistory) pinsKey(clientName string) string {
+	return h.prefix + redisPins + clientName
+}
+
+// keys calls fn with the key of every client's sorted set of the given kind.
+func (h *redisHistory) keys(ctx context.Context, kind string, fn func(key string) error) error {
+	iter := h.client.Scan(ctx, 0, h.prefix+kind+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (h *redisHistory) hasSeen(clientName string, hash uint64) (bool, error) {
+	err := h.client.ZScore(context.Background(), h.key(clientName), strconv.FormatUint(hash, 10)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *redisHistory) hasSeenPin(clientName, pinID string) (bool, error) {
+	err := h.client.ZScore(context.Background(), h.pinsKey(clientName), pinID).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *redisHistory) markSeen(clientName string, hash uint64, pinID string, at time.Time) error {
+	ctx := context.Background()
+	score := float64(at.Unix())
+	_, err := h.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, h.key(clientName), redis.Z{Score: score, Member: strconv.FormatUint(hash, 10)})
+		if pinID != "" {
+			pipe.ZAdd(ctx, h.pinsKey(clientName), redis.Z{Score: score, Member: pinID})
+		}
+		return nil
+	})
+	return err
+}
+
+func (h *redisHistory) clear(clientName string) error {
+	return h.client.Del(context.Background(), h.key(clientName), h.pinsKey(clientName)).Err()
+}
+
+func (h *redisHistory) cleanup(retention storage.Retention) error {
+	ctx := context.Background()
+	now := time.Now()
+	for _, kind := range []string{redisSeen, redisPins} {
+		err := h.keys(ctx, kind, func(key string) error {
+			age := retention.For(strings.TrimPrefix(key, h.prefix+kind))
+			if age <= 0 {
+				return nil // Kept forever
+			}
+			cutoff := "(" + strconv.FormatInt(now.Add(-age).Unix(), 10)
+			return h.client.ZRemRangeByScore(ctx, key, "-inf", cutoff).Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *redisHistory) stats() (entries map[string]int, size int64, err error) {
+	ctx := context.Background()
+	entries = make(map[string]int)
+	err = h.keys(ctx, redisSeen, func(key string) error {
+		n, err := h.client.ZCard(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		entries[strings.TrimPrefix(key, h.prefix+redisSeen)] = int(n)
+		return nil
+	})
+	return entries, 0, err // Redis keeps the history in memory, not on disk
+}
+
+func (h *redisHistory) each(clientName string, fn func(HistoryEntry) error) error {
+	ctx := context.Background()
+	export := func(key string) error {
+		client := strings.TrimPrefix(key, h.prefix+redisSeen)
+		members, err := h.client.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			hash, err := strconv.ParseUint(m.Member.(string), 10, 64)
+			if err != nil {
+				continue // Not a hash
+			}
+			if err := fn(HistoryEntry{Client: client, Hash: hash, Seen: time.Unix(int64(m.Score), 0)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if clientName != "" {
+		return export(h.key(clientName))
+	}
+	return h.keys(ctx, redisSeen, export)
+}
+
+func (h *redisHistory) merge(entries []HistoryEntry) error {
+	ctx := context.Background()
+	pipe := h.client.Pipeline()
+	for _, e := range entries {
+		// GT only replaces the time of existing entries with a later one, and adds new entries
+		member := redis.Z{Score: float64(e.Seen.Unix()), Member: strconv.FormatUint(e.Hash, 10)}
+		pipe.ZAddArgs(ctx, h.key(e.Client), redis.ZAddArgs{GT: true, Members: []redis.Z{member}})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (h *redisHistory) close() error {
+	return h.client.Close()
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// DefaultSQLitePath is the file of the SQLite history if no path is configured.
+const DefaultSQLitePath = "data/history.db"
+
+// sqliteSchema creates the history tables. Hashes are stored as the signed 64-bit integers with the
+// same bits, since SQLite has no unsigned integers, and times as Unix seconds.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS seen (
+	client  TEXT    NOT NULL,
+	hash    INTEGER NOT NULL,
+	seen_at INTEGER NOT NULL,
+	PRIMARY KEY (client, hash)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS seen_by_time ON seen (seen_at);
+CREATE TABLE IF NOT EXISTS seen_pins (
+	client  TEXT    NOT NULL,
+	pin     TEXT    NOT NULL,
+	seen_at INTEGER NOT NULL,
+	PRIMARY KEY (client, pin)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS seen_pins_by_time ON seen_pins (seen_at);
+`
+
+// sqliteHistory keeps the history in a SQLite table, one row per client and hash.
+type sqliteHistory struct {
+	db *sql.DB
+}
+
+// openSQLiteHistory opens or creates the SQLite history at path. The database is in WAL mode, so that
+// reading it, for example with the sqlite3 shell or for a backup, doesn't block the server.
+func openSQLiteHistory(path string) (*sqliteHistory, error) {
+	if path == "" {
+		path = DefaultSQLitePath
+	}
+	pragmas := url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "synchronous(NORMAL)"}}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite history: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create SQLite history: %w", err)
+	}
+	return &sqliteHistory{db: db}, nil
+}
+
+func (h *sqliteHistory) hasSeen(clientName string, hash uint64) (bool, error) {
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM seen WHERE client = ? AND hash = ?)`, clientName, int64(hash)).Scan(&exists)
+	return exists, err
+}
+
+func (h *sqliteHistory) hasSeenPin(clientName, pinID string) (bool, error) {
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM seen_pins WHERE client = ? AND pin = ?)`, clientName, pinID).Scan(&exists)
+	return exists, err
+}
+
+func (h *sqliteHistory) markSeen(clientName string, hash uint64, pinID string, at time.Time) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO seen (client, hash, seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (client, hash) DO UPDATE SET seen_at = excluded.seen_at`, clientName, int64(hash), at.Unix()); err != nil {
+		return err
+	}
+	if pinID != "" {
+		if _, err := tx.Exec(`INSERT INTO seen_pins (client, pin, seen_at) VALUES (?, ?, ?)
+			ON CONFLICT (client, pin) DO UPDATE SET seen_at = excluded.seen_at`, clientName, pinID, at.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (h *sqliteHistory) clear(clientName string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"seen", "seen_pins"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE client = ?`, clientName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// cleanup removes the expired rows in batches of cleanupBatch, as SQLite lets only one transaction
+// write at a time.
+func (h *sqliteHistory) cleanup(retention storage.Retention) error {
+	now := time.Now()
+	// The clients with a retention of their own are cleaned up one at a time, the others together
+	exempt, err := json.Marshal(exemptClients(retention))
+	if err != nil {
+		return err
+	}
+	for _, table := range []struct{ name, key string }{{"seen", "client, hash"}, {"seen_pins", "client, pin"}} {
+		if retention.MaxAge > 0 {
+			query := `DELETE FROM ` + table.name + ` WHERE (` + table.key + `) IN
+				(SELECT ` + table.key + ` FROM ` + table.name + ` WHERE seen_at < ? AND client NOT IN (SELECT value FROM json_each(?)) LIMIT ?)`
+			if err := deleteExpired(h.db, query, now.Add(-retention.MaxAge).Unix(), string(exempt)); err != nil {
+				return err
+			}
+		}
+		for client, age := range retention.Clients {
+			if age <= 0 {
+				continue
+			}
+			query := `DELETE FROM ` + table.name + ` WHERE (` + table.key + `) IN
+				(SELECT ` + table.key + ` FROM ` + table.name + ` WHERE client = ? AND seen_at < ? LIMIT ?)`
+			if err := deleteExpired(h.db, query, client, now.Add(-age).Unix()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exemptClients returns the clients with a retention of their own, as an empty rather than a nil slice
+// so that no client NOT IN it matches NULL.
+func exemptClients(retention storage.Retention) []string {
+	clients := make([]string, 0, len(retention.Clients))
+	for client := range retention.Clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// deleteExpired runs a delete of a SQL history with args and a batch size as its last argument until
+// it deletes less than a batch, pausing between batches.
+func deleteExpired(db *sql.DB, query string, args ...any) error {
+	args = append(args, cleanupBatch)
+	for {
+		res, err := db.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n < cleanupBatch {
+			return err
+		}
+		time.Sleep(cleanupPause)
+	}
+}
+
+func (h *sqliteHistory) stats() (entries map[string]int, size int64, err error) {
+	entries, err = countByClient(h.db)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = h.db.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	return entries, size, err
+}
+
+// countByClient returns the number of rows of each client in the seen table of a SQL history.
+func countByClient(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(`SELECT client, COUNT(*) FROM seen GROUP BY client`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make(map[string]int)
+	for rows.Next() {
+		var client string
+		var n int
+		if err := rows.Scan(&client, &n); err != nil {
+			return nil, err
+		}
+		entries[client] = n
+	}
+	return entries, rows.Err()
+}
+
+func (h *sqliteHistory) each(clientName string, fn func(HistoryEntry) error) error {
+	rows, err := h.db.Query(`SELECT client, hash, seen_at FROM seen WHERE ? = '' OR client = ? ORDER BY client, seen_at`, clientName, clientName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e HistoryEntry
+		var hash, seen int64
+		if err := rows.Scan(&e.Client, &hash, &seen); err != nil {
+			return err
+		}
+		e.Hash, e.Seen = uint64(hash), time.Unix(seen, 0)
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (h *sqliteHistory) merge(entries []HistoryEntry) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO seen (client, hash, seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (client, hash) DO UPDATE SET seen_at = MAX(seen_at, excluded.seen_at)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err := stmt.Exec(e.Client, int64(e.Hash), e.Seen.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (h *sqliteHistory) close() error {
+	return h.db.Close()
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"fmt"
+	"gopin/storage"
+
+	"go.etcd.io/bbolt"
+)
+
+// Stats counts the entries of the database.
+func (d *DB) Stats() (storage.Stats, error) {
+	var st storage.Stats
+	err := d.view(func(tx *bbolt.Tx) error {
+		st.Size = tx.Size()
+		st.SchemaVersion = schemaVersion(tx)
+		tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			st.Buckets++
+			return nil
+		})
+		if b := tx.Bucket([]byte(auditBucket)); b != nil {
+			st.AuditEntries = b.Stats().KeyN
+		}
+		if b := tx.Bucket([]byte(keysBucket)); b != nil {
+			st.APIKeys = b.Stats().KeyN
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.Stats{}, fmt.Errorf("failed to read database stats: %w", err)
+	}
+
+	entries, size, err := d.history.stats()
+	if err != nil {
+		return storage.Stats{}, fmt.Errorf("failed to read history stats: %w", err)
+	}
+	st.Clients, st.ClientEntries = len(entries), entries
+	for _, n := range entries {
+		st.SeenImages += n
+	}
+	st.HistorySize = size
+	st.Size += size
+	return st, nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/storage"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// usageBucket holds the number of images and bytes delivered to each client per day and month.
+const usageBucket = "_usage"
+
+// usageKeyEscaper escapes the slashes of client names in usage keys, so that a name such as "a/day/x"
+// can't collide with another client's keys. Names without a slash or percent sign are kept as they are.
+var usageKeyEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// usageKeys returns the keys of the UTC day and month containing t.
+func usageKeys(clientName string, t time.Time) (day, month []byte) {
+	t = t.UTC()
+	name := usageKeyEscaper.Replace(clientName)
+	return []byte(name + "/day/" + t.Format(time.DateOnly)), []byte(name + "/month/" + t.Format("2006-01"))
+}
+
+// AddUsage adds delivered images and bytes to a client's usage of the UTC day and month containing at,
+// and to its lifetime statistics.
+func (d *DB) AddUsage(clientName string, at time.Time, delta storage.Usage) error {
+	day, month := usageKeys(clientName, at)
+	return d.update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(usageBucket))
+		if err != nil {
+			return err
+		}
+		for _, key := range [][]byte{day, month} {
+			var u storage.Usage
+			if data := b.Get(key); data != nil {
+				if err := json.Unmarshal(data, &u); err != nil {
+					return err
+				}
+			}
+			u.Add(delta)
+			data, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return d.addClientStats(tx, clientName, storage.ClientStats{Images: int64(delta.Images), Bytes: delta.Bytes})
+	})
+}
+
+// GetUsage returns a client's usage of the UTC day and month containing at.
+func (d *DB) GetUsage(clientName string, at time.Time) (day, month storage.Usage, err error) {
+	dayKey, monthKey := usageKeys(clientName, at)
+	err = d.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(usageBucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get(dayKey); data != nil {
+			if err := json.Unmarshal(data, &day); err != nil {
+				return err
+			}
+		}
+		if data := b.Get(monthKey); data != nil {
+			return json.Unmarshal(data, &month)
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.Usage{}, storage.Usage{}, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return day, month, nil
+}
+
+// PruneUsage removes the usage of the UTC days and months that ended before the ones containing before.
+func (d *DB) PruneUsage(before time.Time) error {
+	before = before.UTC()
+	cutoffs := map[string]string{"day": before.Format(time.DateOnly), "month": before.Format("2006-01")}
+	err := d.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(usageBucket))
+		if b == nil {
+			return nil
+		}
+		var stale [][]byte
+		if err := b.ForEach(func(k, _ []byte) error {
+			parts := strings.Split(string(k), "/")
+			if len(parts) == 3 && parts[2] < cutoffs[parts[1]] {
+				stale = append(stale, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune usage: %w", err)
+	}
+	return nil
+}
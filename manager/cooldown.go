@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"errors"
+	"gopin/pinterest"
+	"gopin/storage"
+	"time"
+)
+
+// querySource is the source the exhausted queries of the manager are recorded under.
+const querySource = pinterest.Provider
+
+// SetQueryCooldown sets how long every job skips a query that ran out of results without yielding a
+// single image. It is remembered in the database, so it holds across jobs and restarts. Zero or a
+// negative duration disables it.
+func (m *ScrapeManager) SetQueryCooldown(d time.Duration) {
+	m.queryCooldown.Store(int64(d))
+}
+
+// cooldownLeft returns how much longer a query is skipped for, or zero if it isn't.
+func (m *ScrapeManager) cooldownLeft(query string) time.Duration {
+	cooldown := time.Duration(m.queryCooldown.Load())
+	if cooldown <= 0 {
+		return 0
+	}
+	exhausted, err := m.db.ExhaustedQueries(querySource)
+	if err != nil {
+		m.log.Error("Failed to read exhausted queries", "error", err)
+		return 0
+	}
+	at, ok := exhausted[query]
+	if !ok {
+		return 0
+	}
+	return max(cooldown-time.Since(at), 0)
+}
+
+// markDead records that a query ran out of results without yielding a single image, starting its cooldown.
+func (m *ScrapeManager) markDead(query string) {
+	if m.queryCooldown.Load() <= 0 {
+		return
+	}
+	err := m.db.MarkQueryExhausted(querySource, query, time.Now())
+	if err != nil && !errors.Is(err, storage.ErrReadOnly) {
+		m.log.Error("Failed to record exhausted query", "error", err, "query", query)
+	}
+}
@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression: minute, hour, day of month, month and day of week, such as
+// "0 3 * * *" for 03:00 every day. Fields are "*", numbers, ranges like "1-5", lists like "1,15" and
+// steps like "*/10" or "0-30/5". Sunday is 0 or 7. As in cron, a day matches if either the day of
+// month or the day of week does when both are restricted.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bit n is set if value n matches
+	domAny, dowAny                bool
+}
+
+// scheduleShorthands are the expressions that the @ shorthands stand for.
+var scheduleShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a cron expression or one of the shorthands @hourly, @daily, @weekly, @monthly
+// and @yearly.
+func ParseSchedule(expr string) (*Schedule, error) {
+	if full, ok := scheduleShorthands[expr]; ok {
+		expr = full
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var s Schedule
+	var err error
+	bounds := []struct {
+		bits        *uint64
+		first, last int
+	}{
+		{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.bits, err = parseCronField(fields[i], b.first, b.last); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // Sunday
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField returns the bits of the values a field matches.
+func parseCronField(field string, first, last int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := first, last
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			from, to, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(from)
+			hi, err2 = strconv.Atoi(to)
+			if err1 != nil || err2 != nil || lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = last // "5/15" means from 5 on
+			}
+		}
+		if lo < first || hi > last {
+			return 0, fmt.Errorf("%q is out of range %d-%d", rng, first, last)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that the schedule matches, in t's location. It returns the zero
+// time if the schedule never matches, such as on February 30.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches the day of month and day of week fields.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// ScheduledJob is a job that the manager starts every time its schedule comes up.
+type ScheduledJob struct {
+	Name     string
+	Schedule *Schedule
+	Options  JobOptions
+	// Run consumes the images of a run of the job and returns once the job has ended.
+	Run func(job *ScrapeJob)
+}
+
+// RunSchedule starts the scheduled job as the client "schedule:<name>" every time its schedule comes
+// up, in the server's local time, until ctx is done. The runs don't overlap: a run that is still going
+// when the next one is due delays it to the following time the schedule comes up.
+func (m *ScrapeManager) RunSchedule(ctx context.Context, sj ScheduledJob) {
+	clientName := "schedule:" + sj.Name
+	go func() {
+		for {
+			next := sj.Schedule.Next(time.Now())
+			if next.IsZero() {
+				m.log.Warn("Schedule never comes up, not running job", "schedule", sj.Name)
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			m.log.Info("Starting scheduled job", "schedule", sj.Name)
+			sj.Run(m.Submit(clientName, sj.Options))
+		}
+	}()
+}
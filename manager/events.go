@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Types of job events.
+const (
+	// EventStarted is emitted when a job starts, before it delivers pooled images or waits for its turn.
+	EventStarted = "started"
+	// EventQuery is emitted when a job starts scraping another query.
+	EventQuery = "query"
+	// EventBlocked is emitted when a provider refuses a search, as it does when it blocks the scraper.
+	// It concerns the query rather than a single job, so it carries no job ID.
+	EventBlocked = "blocked"
+	// EventCompleted is emitted when a job ends.
+	EventCompleted = "completed"
+	// EventFailed is emitted instead of EventCompleted when a job ends without delivering any image
+	// because its queries failed.
+	EventFailed = "failed"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before it misses events.
+const eventBuffer = 256
+
+// Event is a change in the lifecycle of a job.
+type Event struct {
+	Type   string
+	Time   time.Time
+	JobID  string
+	Client string
+	Query  string
+	// Provider is the provider that refused a search, for blocked events.
+	Provider string
+	// Reason, Sent and Errors describe how a job ended, for completed and failed events. Errors holds
+	// the last error of each query that failed.
+	Reason string
+	Sent   int
+	Errors map[string]string
+}
+
+// eventBus fans the job events out to its subscribers. Subscribers that can't keep up miss events
+// rather than holding up the jobs.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// SubscribeEvents returns a channel of the job events emitted from now on, and a function that ends the
+// subscription and closes the channel.
+func (m *ScrapeManager) SubscribeEvents() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	m.events.mu.Lock()
+	if m.events.subs == nil {
+		m.events.subs = make(map[chan Event]struct{})
+	}
+	m.events.subs[ch] = struct{}{}
+	m.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			defer m.events.mu.Unlock()
+			delete(m.events.subs, ch)
+			close(ch)
+		})
+	}
+}
+
+// publish sends an event to every subscriber, stamping it with the current time.
+func (b *eventBus) publish(ev Event) {
+	ev.Time = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default: // The subscriber is falling behind
+		}
+	}
+}
+
+// emit publishes an event of the job.
+func (j *ScrapeJob) emit(typ, query string) {
+	j.events.publish(Event{Type: typ, JobID: j.id, Client: j.clientName, Query: query})
+}
+
+// emitEnd publishes how the job ended, once run has set its reason.
+func (j *ScrapeJob) emitEnd() {
+	typ := EventCompleted
+	sent := int(j.sent.Load())
+	if sent == 0 && len(j.errors) > 0 {
+		typ = EventFailed
+	}
+	j.events.publish(Event{
+		Type:   typ,
+		JobID:  j.id,
+		Client: j.clientName,
+		Reason: j.reason,
+		Sent:   sent,
+		Errors: maps.Clone(j.errors),
+	})
+}
@@ -2,47 +2,211 @@ package manager
 
 import (
 	"context"
-	"gopin/database"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"gopin/pkg/logger"
+	"gopin/pkg/tracing"
 	"gopin/query"
 	"gopin/scraper"
+	"gopin/storage"
+	"maps"
+	"math"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reasons a job can end with.
+const (
+	ReasonLimit     = "limit"
+	ReasonExhausted = "exhausted"
+	ReasonStopped   = "stopped"
+	ReasonExpired   = "expired"
+	// ReasonSuspended ends an interactive job that was stopped to be resumed after a restart, see Suspend.
+	ReasonSuspended = "suspended"
 )
 
 // ScrapeManager manages the lifecycle of scraping jobs.
 type ScrapeManager struct {
 	scraper *scraper.Scraper
-	db      *database.DB
+	db      storage.Store
 	log     *logger.Logger
-	jobs    map[string]*ScrapeJob
+	jobs    map[string]*ScrapeJob // interactive job of each client
+	byID    map[string]*ScrapeJob // every running job
+	topics  map[string]*topic
 	mu      sync.Mutex
+	sched   *scheduler
+	shared  *sharedScrapes
+	events  eventBus
+	// queryWeights are the weights of queries and placeholders fill the query templates of every job,
+	// guarded by mu.
+	queryWeights map[string]int
+	placeholders map[string][]string
+
+	// delivered and deliveredBytes count the images recorded by RecordUsage since the server started.
+	delivered      atomic.Int64
+	deliveredBytes atomic.Int64
+	// droppedImages counts the topic images dropped for subscribers that fell behind.
+	droppedImages atomic.Int64
+	// usage counts the images delivered to each client for their quotas.
+	usage *usageTracker
+
+	quotaDefault QuotaLimits
+	quotaClients map[string]QuotaLimits
+	quotaMu      sync.RWMutex
+
+	// queryCooldown is how long a query that yielded no images is skipped, as a time.Duration.
+	queryCooldown atomic.Int64
+}
+
+// JobOptions configures a scraping job.
+type JobOptions struct {
+	Queries []string
+	// Modifiers expand every query into one per modifier besides the query itself, such as "cats grunge"
+	// for "cats" and "grunge", see query.Expand.
+	Modifiers []string
+	// QueryWeights scrape some queries more often than others, overriding the weights set with
+	// SetQueryWeights. Queries without a weight weigh 1.
+	QueryWeights map[string]int
+	// QueryOrder is the order the job scrapes its queries in. Empty means query.OrderRandom.
+	QueryOrder query.Order
+	// Variants, once every query is exhausted, scrape the variants of the queries before the job ends,
+	// see query.Variants.
+	Variants bool
+	// Limit is the total number of images the job delivers.
+	Limit int
+	// LimitPerQuery caps the images delivered for any single query. Zero means no cap.
+	LimitPerQuery int
+	// MinImageBytes and MaxImageBytes drop the images outside the size range before they count toward
+	// the limits. Zero leaves that side of the range open.
+	MinImageBytes int
+	MaxImageBytes int
+	// Exclude drops the pins whose title, description or alt text contains any of these keywords,
+	// ignoring case, before they are downloaded.
+	Exclude []string
+	// Trace is the span of the request that started the job, which the job's span is a child of.
+	Trace trace.SpanContext
+	// MaxLifetime, if set, stops the job with ReasonExpired once it has run that long, counting the time
+	// it was queued.
+	MaxLifetime time.Duration
+	// Priority orders the job among the queued jobs, see Priority.
+	Priority Priority
+	// Weight is the client's share of the turns of queued jobs relative to other clients, see SetMaxJobs.
+	// Zero counts as 1.
+	Weight int
+	// OnQueued, if set, is called with the job's position in the queue whenever it changes while the job
+	// waits for its turn, see SetMaxJobs.
+	OnQueued func(position int)
+	// Pooled are images scraped ahead of time, such as those of the server's image pool, that the job
+	// delivers before scraping. They count toward the limits like scraped images, and the job only waits
+	// for a turn to scrape if they don't reach its limit.
+	Pooled []scraper.ScrapedImage
+	// SeenPin, if set, decides which pins the job skips without downloading them instead of the client's
+	// history, such as the pins already in the server's image pool.
+	SeenPin func(pinID string) bool
+
+	// sentPerQuery counts the images a resumed job already delivered for each query.
+	sentPerQuery map[string]int
+	// cycleQueries starts over with all queries once every query is exhausted, instead of ending the job.
+	cycleQueries bool
 }
 
 // ScrapeJob represents an active scraping job.
 type ScrapeJob struct {
-	clientName   string
-	queryManager *query.Manager
-	imageChan    chan scraper.ScrapedImage
-	log          *logger.Logger
-	limit        int
-	scraper      *scraper.Scraper
-	ctx          context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
+	id            string
+	clientName    string
+	started       time.Time
+	sent          atomic.Int64
+	queries       []string
+	modifiers     []string
+	placeholders  map[string][]string
+	queryWeights  map[string]int
+	queryOrder    query.Order
+	queryManager  *query.Manager
+	imageChan     chan scraper.ScrapedImage
+	log           *logger.Logger
+	limit         int
+	limitPerQuery int
+	minImageBytes int
+	maxImageBytes int
+	filter        func(scraper.ScrapedImage) bool
+	exclude       []string
+	excluded      func(pin scraper.ScrapedImage) bool // Reports the pins matching exclude
+	// seenPin reports whether the client was already sent a pin, which is then not downloaded. It is
+	// nil for topics, whose images go to every subscriber.
+	seenPin       func(pinID string) bool
+	scrapes       *sharedScrapes
+	sched         *scheduler
+	events        *eventBus
+	ready         chan struct{} // Closed when a queued job gets its turn
+	weight        int
+	priority      Priority
+	position      int         // Position in the queue, guarded by sched.mu
+	preempted     atomic.Bool // Set when the job should yield its turn to a high-priority job
+	onQueued      func(position int)
+	pooled        []scraper.ScrapedImage
+	quotaExceeded func(pending storage.Usage) bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	span          trace.Span
+	wg            sync.WaitGroup
+
+	// cooldownLeft returns how much longer a query is skipped, and markDead starts its cooldown.
+	cooldownLeft func(query string) time.Duration
+	markDead     func(query string)
+	// addYield adds the yield of a scrape to the query's lifetime yield.
+	addYield func(query string, delta storage.QueryYield)
+
+	// current is the query being scraped and cancelCurrent aborts its browser session.
+	current       string
+	cancelCurrent context.CancelFunc
+	currentSpan   trace.Span
+	currentMu     sync.Mutex
+
+	// sentPerQuery, retries and variants are only used by run. variants is cleared once the variants
+	// of the queries were added.
+	sentPerQuery map[string]int
+	retries      map[string]queryRetry
+	variants     bool
+	// persist saves the progress of an interactive job, or deletes it once the job ended, if the job is
+	// nil. suspended keeps the job saved when it is stopped, to be resumed after a restart.
+	persist   func(job *storage.SavedJob)
+	suspended atomic.Bool
+	// outOfQuota is set when a consumer found the client out of quota before delivering an image.
+	outOfQuota atomic.Bool
+
+	// reason, failed and errors are written by run and must only be read once the image channel is closed.
+	reason string
+	failed map[string]int
+	errors map[string]string
 }
 
 // New creates a new ScrapeManager.
-func New(scraper *scraper.Scraper, db *database.DB, log *logger.Logger) *ScrapeManager {
-	return &ScrapeManager{
+func New(scraper *scraper.Scraper, db storage.Store, log *logger.Logger) *ScrapeManager {
+	m := &ScrapeManager{
 		scraper: scraper,
 		db:      db,
 		log:     log,
 		jobs:    make(map[string]*ScrapeJob),
+		byID:    make(map[string]*ScrapeJob),
+		topics:  make(map[string]*topic),
+		sched:   newScheduler(),
+		shared:  newSharedScrapes(scraper, log),
+		usage:   newUsageTracker(),
 	}
+	scraper.OnBlocked(func(provider, query string) {
+		m.events.publish(Event{Type: EventBlocked, Provider: provider, Query: query})
+	})
+	return m
 }
 
-// Start creates and starts a new scraping job for a client.
-func (m *ScrapeManager) Start(clientName string, queries []string, limit int) <-chan scraper.ScrapedImage {
+// Start creates and starts a new interactive scraping job for a client, replacing the client's previous one.
+func (m *ScrapeManager) Start(clientName string, opts JobOptions) *ScrapeJob {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -50,21 +214,166 @@ func (m *ScrapeManager) Start(clientName string, queries []string, limit int) <-
 		job.Stop()
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	job := &ScrapeJob{
-		clientName:   clientName,
-		queryManager: query.NewManager(queries),
-		imageChan:    make(chan scraper.ScrapedImage, 100),
-		log:          m.log,
-		scraper:      m.scraper,
-		ctx:          ctx,
-		cancel:       cancel,
-		limit:        limit,
+	job := m.newJob(clientName, opts)
+	job.seenPin = opts.SeenPin
+	if job.seenPin == nil {
+		job.seenPin = m.seenPin(clientName)
 	}
+	job.persist = m.persistJob(clientName)
 	m.jobs[clientName] = job
+	m.register(job)
 
 	job.Start()
-	return job.imageChan
+	return job
+}
+
+// Submit creates and starts a job for a client that runs alongside the client's other jobs.
+func (m *ScrapeManager) Submit(clientName string, opts JobOptions) *ScrapeJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.newJob(clientName, opts)
+	job.seenPin = opts.SeenPin
+	if job.seenPin == nil {
+		job.seenPin = m.seenPin(clientName)
+	}
+	m.register(job)
+
+	job.Start()
+	return job
+}
+
+// Get returns the running job with the given ID.
+func (m *ScrapeManager) Get(id string) (*ScrapeJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.byID[id]
+	return job, exists
+}
+
+// register tracks a job by ID until it ends. The caller must hold m.mu.
+func (m *ScrapeManager) register(job *ScrapeJob) {
+	m.byID[job.id] = job
+	go func() {
+		job.wg.Wait()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.byID, job.id)
+	}()
+}
+
+// seenPin returns a function that reports whether a client was already sent a pin. Pins whose history
+// can't be read are downloaded, leaving it to the hash check.
+func (m *ScrapeManager) seenPin(clientName string) func(string) bool {
+	return func(pinID string) bool {
+		seen, err := m.db.HasClientSeenPin(clientName, pinID)
+		if err != nil {
+			m.log.Error("Error checking if pin was seen", "error", err, "client", clientName)
+		}
+		return seen
+	}
+}
+
+// newJob creates a job without registering or starting it. Its queries are normalized, see
+// query.Normalize. The caller must hold m.mu.
+func (m *ScrapeManager) newJob(clientName string, opts JobOptions) *ScrapeJob {
+	opts.Queries = query.NormalizeAll(opts.Queries)
+	id := newJobID()
+	ctx, span := tracing.Start(trace.ContextWithSpanContext(context.Background(), opts.Trace), "job",
+		tracing.JobID.String(id), tracing.Client.String(clientName))
+	var cancel context.CancelFunc
+	if opts.MaxLifetime > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.MaxLifetime)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	weights := m.jobWeights(opts)
+	return &ScrapeJob{
+		id:            id,
+		clientName:    clientName,
+		started:       time.Now(),
+		queries:       slices.Clone(opts.Queries),
+		modifiers:     opts.Modifiers,
+		placeholders:  m.placeholders,
+		queryWeights:  weights,
+		queryOrder:    opts.QueryOrder,
+		variants:      opts.Variants,
+		queryManager:  newQueryManager(opts, m.placeholders, weights),
+		imageChan:     make(chan scraper.ScrapedImage, 100),
+		log:           m.log.With("job", id),
+		scrapes:       m.shared,
+		sched:         m.sched,
+		events:        &m.events,
+		ready:         make(chan struct{}),
+		weight:        max(opts.Weight, 1),
+		priority:      opts.Priority,
+		onQueued:      opts.OnQueued,
+		pooled:        opts.Pooled,
+		ctx:           ctx,
+		cancel:        cancel,
+		span:          span,
+		limit:         opts.Limit,
+		limitPerQuery: opts.LimitPerQuery,
+		minImageBytes: opts.MinImageBytes,
+		maxImageBytes: opts.MaxImageBytes,
+		filter:        SizeFilter(opts.MinImageBytes, opts.MaxImageBytes),
+		exclude:       opts.Exclude,
+		excluded:      keywordMatcher(opts.Exclude),
+		sentPerQuery:  maps.Clone(opts.sentPerQuery),
+		quotaExceeded: func(pending storage.Usage) bool { return m.quotaExceeded(clientName, pending) },
+		cooldownLeft:  m.cooldownLeft,
+		markDead:      m.markDead,
+		addYield:      m.addQueryYield,
+		failed:        make(map[string]int),
+		errors:        make(map[string]string),
+	}
+}
+
+// newQueryManager creates the query manager of a job.
+func newQueryManager(opts JobOptions, placeholders map[string][]string, weights map[string]int) *query.Manager {
+	queries := expandQueries(opts.Queries, placeholders, opts.Modifiers)
+	var m *query.Manager
+	if opts.cycleQueries {
+		m = query.NewCyclingManager(queries)
+	} else {
+		m = query.NewManager(queries)
+	}
+	m.SetWeights(weights)
+	if opts.QueryOrder != "" {
+		m.SetOrder(opts.QueryOrder)
+	}
+	return m
+}
+
+// SizeFilter returns a filter that keeps the images of at least minBytes and at most maxBytes, or nil
+// if neither is set. Zero leaves that side of the range open.
+func SizeFilter(minBytes, maxBytes int) func(scraper.ScrapedImage) bool {
+	if minBytes <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	return func(img scraper.ScrapedImage) bool {
+		size := len(img.Data)
+		return size >= minBytes && (maxBytes <= 0 || size <= maxBytes)
+	}
+}
+
+// keywordMatcher returns a function that reports whether the title, description or alt text of a pin
+// contains any of the keywords, ignoring case, or nil if there are no keywords.
+func keywordMatcher(keywords []string) func(scraper.ScrapedImage) bool {
+	var lower []string
+	for _, k := range keywords {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			lower = append(lower, k)
+		}
+	}
+	if len(lower) == 0 {
+		return nil
+	}
+	return func(pin scraper.ScrapedImage) bool {
+		text := strings.ToLower(pin.Title + "\n" + pin.Description + "\n" + pin.AltText)
+		return slices.ContainsFunc(lower, func(k string) bool { return strings.Contains(text, k) })
+	}
 }
 
 // Stop stops the scraping job for a client.
@@ -78,6 +387,142 @@ func (m *ScrapeManager) Stop(clientName string) {
 	}
 }
 
+// StopAll stops every running job and shared topic scrape, ending all subscriptions. The interactive
+// jobs stay saved, so that their clients can resume them after a restart.
+func (m *ScrapeManager) StopAll() {
+	m.mu.Lock()
+	jobs := make([]*ScrapeJob, 0, len(m.byID)+len(m.topics))
+	for _, job := range m.byID {
+		jobs = append(jobs, job)
+	}
+	for name, t := range m.topics {
+		for clientName, sub := range t.subs {
+			sub.close(ReasonStopped)
+			delete(t.subs, clientName)
+		}
+		jobs = append(jobs, t.job)
+		delete(m.topics, name)
+	}
+	clear(m.jobs)
+	m.mu.Unlock()
+
+	for _, job := range jobs {
+		job.suspended.Store(true)
+		job.Stop()
+	}
+}
+
+// JobInfo describes a running job.
+type JobInfo struct {
+	ID string
+	// Client is the client that owns the job, or "topic:<name>" for the shared scrape of a topic.
+	Client  string
+	Started time.Time
+	// Query is the query being scraped, if any.
+	Query string
+	Sent  int
+	// Limit is the number of images the job delivers. Zero means no limit, as for topics.
+	Limit int
+	// Position is the job's position in the queue while it waits for its turn, or zero once it runs.
+	Position int
+	Priority Priority
+	// Expires is when the job is stopped for reaching its maximum lifetime, if it has one.
+	Expires time.Time
+	// Exhausted are the queries that ran out of results, in the order they did.
+	Exhausted []string
+}
+
+// Jobs returns the running jobs and topic scrapes, oldest first.
+func (m *ScrapeManager) Jobs() []JobInfo {
+	m.mu.Lock()
+	jobs := make([]*ScrapeJob, 0, len(m.byID)+len(m.topics))
+	for _, job := range m.byID {
+		jobs = append(jobs, job)
+	}
+	for _, t := range m.topics {
+		jobs = append(jobs, t.job)
+	}
+	m.mu.Unlock()
+
+	infos := make([]JobInfo, len(jobs))
+	for i, job := range jobs {
+		infos[i] = job.Info()
+	}
+	slices.SortFunc(infos, func(a, b JobInfo) int { return a.Started.Compare(b.Started) })
+	return infos
+}
+
+// StopJob stops a running job or topic scrape by ID. Stopping a topic ends its subscriptions.
+// It reports whether the job was running.
+func (m *ScrapeManager) StopJob(id string) bool {
+	m.mu.Lock()
+	job, exists := m.byID[id]
+	if exists && m.jobs[job.clientName] == job {
+		delete(m.jobs, job.clientName)
+	}
+	if !exists {
+		for name, t := range m.topics {
+			if t.job.id == id {
+				job, exists = t.job, true
+				delete(m.topics, name)
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	job.Stop() // Outside the lock, as the fan-out of a topic needs it to let the job end
+	return true
+}
+
+// StopClient stops every job of a client and detaches it from its topics. It returns the number of jobs stopped.
+func (m *ScrapeManager) StopClient(clientName string) int {
+	m.mu.Lock()
+	var jobs []*ScrapeJob
+	for _, job := range m.byID {
+		if job.clientName == clientName {
+			jobs = append(jobs, job)
+		}
+	}
+	delete(m.jobs, clientName)
+	m.mu.Unlock()
+
+	for _, job := range jobs {
+		job.Stop()
+	}
+	m.UnsubscribeAll(clientName)
+	return len(jobs)
+}
+
+// CancelQuery removes a query from a client's running job, aborting its scrape if it is in flight.
+// It reports whether the client had a job containing the query.
+func (m *ScrapeManager) CancelQuery(clientName, query string) bool {
+	m.mu.Lock()
+	job, exists := m.jobs[clientName]
+	m.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	return job.CancelQuery(query)
+}
+
+// AddQueries appends queries to a client's running job and returns how many were new.
+// It reports false if the client has no running job.
+func (m *ScrapeManager) AddQueries(clientName string, queries []string) (int, bool) {
+	m.mu.Lock()
+	job, exists := m.jobs[clientName]
+	m.mu.Unlock()
+
+	if !exists || job.ctx.Err() != nil {
+		return 0, false
+	}
+	return job.queryManager.Add(expandQueries(queries, job.placeholders, job.modifiers)...), true
+}
+
 // Start initializes and runs the scraping job.
 func (j *ScrapeJob) Start() {
 	j.log.Info("Starting new scrape job", "client", j.clientName)
@@ -92,43 +537,412 @@ func (j *ScrapeJob) Stop() {
 	j.wg.Wait()
 }
 
+// CancelQuery removes a query from the job, along with its expansions, and aborts its scrape if it is
+// in flight.
+func (j *ScrapeJob) CancelQuery(q string) bool {
+	expanded := expandQueries([]string{q}, j.placeholders, j.modifiers)
+	removed := false
+	for _, e := range expanded {
+		removed = j.queryManager.Remove(e) || removed
+	}
+
+	j.currentMu.Lock()
+	defer j.currentMu.Unlock()
+	if slices.Contains(expanded, j.current) && j.cancelCurrent != nil {
+		j.log.Info("Aborting in-flight scrape for cancelled query", "query", j.current, "client", j.clientName)
+		j.cancelCurrent()
+		return true
+	}
+	return removed
+}
+
+// ID returns the unique identifier of the job.
+func (j *ScrapeJob) ID() string {
+	return j.id
+}
+
+// Info describes the job.
+func (j *ScrapeJob) Info() JobInfo {
+	j.currentMu.Lock()
+	current := j.current
+	j.currentMu.Unlock()
+
+	limit := j.limit
+	if limit == math.MaxInt {
+		limit = 0
+	}
+	expires, _ := j.ctx.Deadline()
+
+	return JobInfo{
+		ID:        j.id,
+		Client:    j.clientName,
+		Started:   j.started,
+		Query:     current,
+		Sent:      int(j.sent.Load()),
+		Limit:     limit,
+		Position:  j.Position(),
+		Priority:  j.priority,
+		Expires:   expires,
+		Exhausted: j.queryManager.Exhausted(),
+	}
+}
+
+// Position returns the job's position in the queue while it waits for its turn, or zero once it runs.
+func (j *ScrapeJob) Position() int {
+	return j.sched.position(j)
+}
+
+// ClientName returns the name of the client that owns the job.
+func (j *ScrapeJob) ClientName() string {
+	return j.clientName
+}
+
+// Images returns the channel of scraped images. It is closed when the job ends.
+func (j *ScrapeJob) Images() <-chan scraper.ScrapedImage {
+	return j.imageChan
+}
+
+// QuotaExceeded reports whether the job's client has used up its quota, counting pending, the usage of
+// images about to be delivered that isn't recorded yet. Consumers check it before each delivery, as the
+// images buffered in the channel were checked against the usage recorded when they were scraped. Once it
+// reports true, the job is stopped and ends with ReasonQuota.
+func (j *ScrapeJob) QuotaExceeded(pending storage.Usage) bool {
+	if j.outOfQuota.Load() {
+		return true
+	}
+	if !j.quotaExceeded(pending) {
+		return false
+	}
+	if j.outOfQuota.CompareAndSwap(false, true) {
+		j.log.Info("Client is out of quota, stopping job.", "client", j.clientName)
+		j.cancel()
+	}
+	return true
+}
+
+// Done returns a channel that is closed once the job ends or is stopped.
+func (j *ScrapeJob) Done() <-chan struct{} {
+	return j.ctx.Done()
+}
+
+// Reason returns why the job ended. It is only valid once the image channel is closed.
+func (j *ScrapeJob) Reason() string {
+	return j.reason
+}
+
+// Failed returns the number of failed scrapes and downloads per query.
+// It is only valid once the image channel is closed.
+func (j *ScrapeJob) Failed() map[string]int {
+	return j.failed
+}
+
+// Errors returns the last error of each query that failed. It is only valid once the image channel is closed.
+func (j *ScrapeJob) Errors() map[string]string {
+	return j.errors
+}
+
+// Queries returns the queries the job was started with.
+func (j *ScrapeJob) Queries() []string {
+	return j.queries
+}
+
+// Limit returns the number of images the job delivers.
+func (j *ScrapeJob) Limit() int {
+	return j.limit
+}
+
 // run is the main loop for the scraping job.
 func (j *ScrapeJob) run() {
 	defer j.wg.Done()
 	defer close(j.imageChan)
+	defer j.cancel() // Release the browser of the current query once the job ends
+	defer func() {
+		j.span.SetAttributes(tracing.Reason.String(j.reason))
+		j.span.End()
+	}()
+	defer j.endQuery()
+	defer j.emitEnd()
+	defer func() {
+		if j.reason == ReasonStopped && j.outOfQuota.Load() {
+			j.reason = ReasonQuota
+		}
+		if j.reason == ReasonStopped && errors.Is(j.ctx.Err(), context.DeadlineExceeded) {
+			j.log.Info("Job reached its maximum lifetime, stopping it.", "client", j.clientName)
+			j.reason = ReasonExpired
+		}
+		if j.reason == ReasonStopped && j.suspended.Load() && j.persist != nil {
+			j.reason = ReasonSuspended
+		}
+	}()
 
+	j.emit(EventStarted, "")
+	j.reason = ReasonStopped
 	sentCount := 0
+	if j.sentPerQuery == nil {
+		j.sentPerQuery = make(map[string]int)
+	}
+	sentPerQuery := j.sentPerQuery
+	j.retries = make(map[string]queryRetry)
+	if j.persist != nil {
+		defer func() { j.checkpoint(sentCount, true) }()
+		j.checkpoint(sentCount, false)
+	}
+	if sentCount = j.deliverPooled(sentPerQuery); sentCount >= j.limit || j.reason != ReasonStopped {
+		return
+	}
+	if !j.sched.acquire(j) {
+		return
+	}
+	defer func() {
+		j.cancel() // Hand the turn on once the browser sessions of the job are released
+		j.sched.release(j)
+	}()
+	skipped := make(map[string]bool) // Queries skipped while cooling down since the job last scraped
+	var yield queryYield             // Recorded as well if the job ends in the middle of a scrape
+	defer j.recordYield(&yield)
 	for sentCount < j.limit {
 		select {
 		case <-j.ctx.Done():
 			return
 		default:
-			query, ok := j.queryManager.GetRandom()
+			if j.preempted.Load() && !j.sched.yield(j) {
+				return
+			}
+			if j.quotaExceeded(storage.Usage{}) {
+				j.log.Info("Client is out of quota, stopping job.", "client", j.clientName)
+				j.reason = ReasonQuota
+				return
+			}
+
+			query, ok := j.nextQuery()
 			if !ok {
-				j.log.Warn("No more queries available, stopping job.", "client", j.clientName)
+				if j.ctx.Err() != nil {
+					return
+				}
+				if j.addVariants() {
+					continue
+				}
+				j.log.Info("Every query is exhausted or failed, stopping job.", "client", j.clientName, "exhausted", j.queryManager.Exhausted())
+				j.reason = ReasonExhausted
 				return
 			}
+			if wait := j.cooldownLeft(query); wait > 0 {
+				if !skipped[query] {
+					j.log.Info("Skipping query that recently yielded no images.", "query", query, "cooldown", wait.Round(time.Second))
+					skipped[query] = true
+					j.queryManager.Exhaust(query)
+					continue
+				}
+				// Every query left is cooling down, which a job that cycles through its queries waits out
+				// without holding its turn
+				j.log.Info("Every query is cooling down, waiting.", "client", j.clientName, "wait", wait.Round(time.Second))
+				if !j.sched.pause(j, wait) {
+					return
+				}
+			}
+			clear(skipped)
 
-			j.log.Info("Starting scrape for new random query", "query", query, "client", j.clientName)
-			imageChan, err := j.scraper.Scrape(j.ctx, query)
+			j.log.Info("Starting scrape for next query", "query", query, "client", j.clientName)
+			queryCtx := j.beginQuery(query)
+			j.emit(EventQuery, query)
+			imageChan, joined, err := j.scrapes.scrape(queryCtx, query, j.seenPin, j.excluded)
 			if err != nil {
 				j.log.Error("Failed to start scraping", "query", query, "error", err)
-				continue // Try another query
+				tracing.Fail(j.currentSpan, err)
+				j.failed[query]++
+				j.errors[query] = err.Error()
+				j.endQuery()
+				j.retryLater(query, err)
+				continue
 			}
 
 			// Process images from the current query
+			found := 0
+			retired := false // The query reached the job's limit per query
+			var scrapeErr error
+			yield = beginYield(query)
 			for img := range imageChan {
-				if sentCount >= j.limit {
+				if j.preempted.Load() {
+					break // The query is scraped again once the job gets another turn
+				}
+				if img.Err == nil {
+					found++
+					yield.delta.Found++
+					if !img.Seen {
+						yield.delta.Unseen++
+					}
+				}
+				if img.Err != nil {
+					j.failed[query]++
+					j.errors[query] = img.Err.Error()
+					if errors.Is(img.Err, scraper.ErrScrapeFailed) {
+						scrapeErr = img.Err
+					}
+					continue
+				}
+				if img.Seen {
+					// Passed on so the client's summary counts it as deduped, without counting toward the limits
+					select {
+					case j.imageChan <- img:
+					case <-j.ctx.Done():
+						return
+					}
+					continue
+				}
+				if j.filter != nil && !j.filter(img) {
+					continue
+				}
+				if j.quotaExceeded(storage.Usage{}) {
+					j.log.Info("Client is out of quota, stopping job.", "client", j.clientName)
+					j.reason = ReasonQuota
 					return
 				}
 				select {
 				case j.imageChan <- img:
 					sentCount++
+					j.sent.Add(1)
+					sentPerQuery[query]++
+					if sentCount >= j.limit {
+						j.reason = ReasonLimit
+						return
+					}
+					if j.persist != nil && sentCount%checkpointInterval == 0 {
+						j.checkpoint(sentCount, false)
+					}
 				case <-j.ctx.Done():
 					return
 				}
+
+				if j.limitPerQuery > 0 && sentPerQuery[query] >= j.limitPerQuery {
+					j.log.Info("Query reached its limit, retiring it.", "query", query, "client", j.clientName)
+					j.queryManager.Remove(query)
+					retired = true
+					break
+				}
+			}
+			// The query ran out of results unless its scrape failed or was cut short. A job that joined a
+			// running scrape missed its first results, and one that retired it stopped taking them, so the
+			// query isn't used up for it
+			exhausted := scrapeErr == nil && queryCtx.Err() == nil && !j.preempted.Load() && !joined && !retired
+			if scrapeErr != nil {
+				tracing.Fail(j.currentSpan, scrapeErr)
+			}
+			j.endQuery()
+			if scrapeErr != nil {
+				j.retryLater(query, scrapeErr)
+			} else {
+				delete(j.retries, query)
+			}
+			if exhausted && j.queryManager.Exhaust(query) {
+				j.log.Info("Query exhausted, selecting a new one.", "query", query, "found", found)
+			}
+			if exhausted && found == 0 {
+				j.markDead(query)
+			}
+			if exhausted {
+				yield.delta.Exhausted = 1
+			}
+			if scrapeErr != nil {
+				yield.delta.Failed = 1
+			}
+			j.recordYield(&yield)
+			if j.persist != nil {
+				j.checkpoint(sentCount, false)
+			}
+		}
+	}
+	j.reason = ReasonLimit
+}
+
+// deliverPooled delivers the pooled images of the job, up to its limits, and returns how many it
+// delivered. It sets the job's reason if the job ended on them.
+func (j *ScrapeJob) deliverPooled(sentPerQuery map[string]int) int {
+	sent := 0
+	for _, img := range j.pooled {
+		if j.limitPerQuery > 0 && sentPerQuery[img.Query] >= j.limitPerQuery {
+			continue
+		}
+		if (j.filter != nil && !j.filter(img)) || (j.excluded != nil && j.excluded(img)) {
+			continue
+		}
+		if j.quotaExceeded(storage.Usage{}) {
+			j.log.Info("Client is out of quota, stopping job.", "client", j.clientName)
+			j.reason = ReasonQuota
+			return sent
+		}
+		select {
+		case j.imageChan <- img:
+			sent++
+			j.sent.Add(1)
+			sentPerQuery[img.Query]++
+		case <-j.ctx.Done():
+			return sent
+		}
+		if sent >= j.limit {
+			j.log.Info("Job served from the image pool", "client", j.clientName, "sent", sent)
+			j.reason = ReasonLimit
+			return sent
+		}
+	}
+	j.pooled = nil
+
+	if j.limitPerQuery > 0 {
+		for query, n := range sentPerQuery {
+			if n >= j.limitPerQuery {
+				j.queryManager.Remove(query)
 			}
-			j.log.Info("Query exhausted, selecting a new one.", "query", query)
 		}
 	}
+	if sent > 0 {
+		j.log.Info("Delivered images from the image pool, scraping the rest", "client", j.clientName, "sent", sent, "limit", j.limit)
+	}
+	return sent
+}
+
+// beginQuery records the query being scraped and returns a context that is cancelled when the query is.
+// The context carries the query's span, which the downloads of its images are children of, and the
+// job's logger, so the records of the scrape carry the job ID.
+func (j *ScrapeJob) beginQuery(query string) context.Context {
+	j.currentMu.Lock()
+	defer j.currentMu.Unlock()
+
+	ctx, span := tracing.Start(j.ctx, "query", tracing.JobID.String(j.id), tracing.Query.String(query))
+	ctx, cancel := context.WithCancel(logger.NewContext(ctx, j.log))
+	j.current = query
+	j.cancelCurrent = cancel
+	j.currentSpan = span
+	return ctx
+}
+
+// cancelQuery aborts the scrape of the current query, if any, without retiring the query.
+func (j *ScrapeJob) cancelQuery() {
+	j.currentMu.Lock()
+	defer j.currentMu.Unlock()
+
+	if j.cancelCurrent != nil {
+		j.cancelCurrent()
+	}
+}
+
+// endQuery releases the context of the query that was being scraped and ends its span.
+func (j *ScrapeJob) endQuery() {
+	j.currentMu.Lock()
+	defer j.currentMu.Unlock()
+
+	if j.cancelCurrent != nil {
+		j.cancelCurrent()
+	}
+	if j.currentSpan != nil {
+		j.currentSpan.End()
+	}
+	j.current = ""
+	j.cancelCurrent = nil
+	j.currentSpan = nil
+}
+
+// newJobID returns a random identifier for a job.
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"gopin/storage"
+	"time"
+)
+
+// ReasonQuota is the reason reported by a job or subscription that ended because its client ran out of quota.
+const ReasonQuota = "quota"
+
+// QuotaLimits caps what a client may receive per UTC day and month. Zero means no cap.
+type QuotaLimits struct {
+	DailyImages   int
+	MonthlyImages int
+	DailyBytes    int64
+	MonthlyBytes  int64
+}
+
+// QuotaStatus describes a client's quota usage.
+type QuotaStatus struct {
+	Limits       QuotaLimits
+	Daily        storage.Usage
+	Monthly      storage.Usage
+	DailyReset   time.Time
+	MonthlyReset time.Time
+	// Exceeded names the limit the client has reached, such as "daily images", or is empty.
+	Exceeded string
+}
+
+// SetQuotas sets the default quota and per-client overrides.
+func (m *ScrapeManager) SetQuotas(defaults QuotaLimits, clients map[string]QuotaLimits) {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+
+	m.quotaDefault = defaults
+	m.quotaClients = clients
+}
+
+// quotaLimits returns the limits of a client.
+func (m *ScrapeManager) quotaLimits(clientName string) QuotaLimits {
+	m.quotaMu.RLock()
+	defer m.quotaMu.RUnlock()
+
+	if limits, ok := m.quotaClients[clientName]; ok {
+		return limits
+	}
+	return m.quotaDefault
+}
+
+// QuotaStatus returns a client's current usage, limits and when they reset.
+func (m *ScrapeManager) QuotaStatus(clientName string) (QuotaStatus, error) {
+	now := time.Now().UTC()
+	day, month, err := m.usage.usage(m.db, clientName, now)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+
+	st := QuotaStatus{
+		Limits:       m.quotaLimits(clientName),
+		Daily:        day,
+		Monthly:      month,
+		DailyReset:   time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		MonthlyReset: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	st.Exceeded = exceededLimit(st.Limits, day, month)
+	return st, nil
+}
+
+// exceededLimit names the limit that a daily and monthly usage reach, or returns an empty string.
+func exceededLimit(l QuotaLimits, day, month storage.Usage) string {
+	switch {
+	case l.DailyImages > 0 && day.Images >= l.DailyImages:
+		return "daily images"
+	case l.MonthlyImages > 0 && month.Images >= l.MonthlyImages:
+		return "monthly images"
+	case l.DailyBytes > 0 && day.Bytes >= l.DailyBytes:
+		return "daily bytes"
+	case l.MonthlyBytes > 0 && month.Bytes >= l.MonthlyBytes:
+		return "monthly bytes"
+	}
+	return ""
+}
+
+// RecordUsage counts images delivered to a client against its quota. The usage is kept in memory until
+// FlushUsage writes it to the database.
+func (m *ScrapeManager) RecordUsage(clientName string, images int, bytes int64) {
+	m.delivered.Add(int64(images))
+	m.deliveredBytes.Add(bytes)
+	m.usage.record(clientName, time.Now(), storage.Usage{Images: images, Bytes: bytes})
+}
+
+// FlushUsage writes the usage recorded since the last flush to the database, which should happen every
+// UsageFlushInterval and before the database is closed. Usage that fails to be written is kept for the
+// next flush.
+func (m *ScrapeManager) FlushUsage() {
+	if err := m.usage.flush(m.db, time.Now()); err != nil {
+		m.log.Error("Failed to record usage", "error", err)
+	}
+}
+
+// Delivered returns the number of images and bytes delivered to all clients since the server started.
+func (m *ScrapeManager) Delivered() (images, bytes int64) {
+	return m.delivered.Load(), m.deliveredBytes.Load()
+}
+
+// quotaExceeded reports whether a client has reached any of its limits, counting pending usage that
+// isn't recorded yet.
+func (m *ScrapeManager) quotaExceeded(clientName string, pending storage.Usage) bool {
+	limits := m.quotaLimits(clientName)
+	if limits == (QuotaLimits{}) {
+		return false // Skip the database for clients without quota
+	}
+	day, month, err := m.usage.usage(m.db, clientName, time.Now().UTC())
+	if err != nil {
+		m.log.Error("Failed to check quota", "error", err, "client", clientName)
+		return false
+	}
+	day.Images += pending.Images
+	day.Bytes += pending.Bytes
+	month.Images += pending.Images
+	month.Bytes += pending.Bytes
+	return exceededLimit(limits, day, month) != ""
+}
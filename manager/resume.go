@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"gopin/query"
+	"gopin/storage"
+	"time"
+)
+
+const (
+	// checkpointInterval is how many images an interactive job delivers between saves of its progress,
+	// besides the saves whenever it moves on to another query.
+	checkpointInterval = 10
+	// resumeMaxAge is how long an interrupted job can be resumed. A client that comes back later starts over.
+	resumeMaxAge = 24 * time.Hour
+)
+
+// persistJob returns the function that saves the progress of a client's interactive job.
+func (m *ScrapeManager) persistJob(clientName string) func(*storage.SavedJob) {
+	return func(job *storage.SavedJob) {
+		var err error
+		if job != nil {
+			err = m.db.SaveJob(*job)
+		} else {
+			err = m.db.DeleteSavedJob(clientName)
+		}
+		if err != nil {
+			m.log.Error("Failed to save job", "error", err, "client", clientName)
+		}
+	}
+}
+
+// checkpoint saves the progress of the job after it delivered sent images. Once the job ended, it is
+// deleted, unless it was suspended by StopAll or Suspend.
+func (j *ScrapeJob) checkpoint(sent int, ended bool) {
+	if ended && !j.suspended.Load() {
+		j.persist(nil)
+		return
+	}
+	j.persist(&storage.SavedJob{
+		Client:        j.clientName,
+		Queries:       j.queryManager.Queries(),
+		Limit:         j.limit - sent,
+		LimitPerQuery: j.limitPerQuery,
+		SentPerQuery:  j.sentPerQuery,
+		MinImageBytes: j.minImageBytes,
+		MaxImageBytes: j.maxImageBytes,
+		Exclude:       j.exclude,
+		QueryWeights:  j.queryWeights,
+		QueryOrder:    string(j.queryOrder),
+		Variants:      j.variants,
+		Saved:         time.Now(),
+	})
+}
+
+// Suspend stops the interactive job of a client like Stop, but keeps it saved, so that the client
+// can resume it after a restart.
+func (m *ScrapeManager) Suspend(clientName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, exists := m.jobs[clientName]; exists {
+		job.suspended.Store(true)
+		job.Stop()
+		delete(m.jobs, clientName)
+	}
+}
+
+// SavedJob returns the options that resume the interactive job of a client with Start, if a restart
+// interrupted one in the last day and it has images and queries left.
+func (m *ScrapeManager) SavedJob(clientName string) (JobOptions, bool) {
+	saved, err := m.db.GetSavedJob(clientName)
+	if err != nil {
+		m.log.Error("Failed to read saved job", "error", err, "client", clientName)
+		return JobOptions{}, false
+	}
+	if saved == nil {
+		return JobOptions{}, false
+	}
+	if time.Since(saved.Saved) > resumeMaxAge || saved.Limit <= 0 || len(saved.Queries) == 0 {
+		m.persistJob(clientName)(nil)
+		return JobOptions{}, false
+	}
+	return JobOptions{
+		Queries:       saved.Queries,
+		Limit:         saved.Limit,
+		LimitPerQuery: saved.LimitPerQuery,
+		MinImageBytes: saved.MinImageBytes,
+		MaxImageBytes: saved.MaxImageBytes,
+		Exclude:       saved.Exclude,
+		QueryWeights:  saved.QueryWeights,
+		QueryOrder:    query.Order(saved.QueryOrder),
+		Variants:      saved.Variants,
+		sentPerQuery:  saved.SentPerQuery,
+	}, true
+}
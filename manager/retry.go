@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"gopin/pkg/reliability"
+	"slices"
+	"time"
+)
+
+const (
+	// queryAttempts is how many times a job scrapes a query whose scrapes fail before retiring it.
+	queryAttempts = 3
+	// queryRetryDelay is the delay before scraping a failed query again, doubled after every failure.
+	queryRetryDelay = 10 * time.Second
+)
+
+// queryRetry is a query whose scrape failed, to be scraped again once it is due.
+type queryRetry struct {
+	failures int
+	due      time.Time
+}
+
+// nextQuery selects the query to scrape next: a failed query whose retry is due, or else a random
+// query that isn't waiting for a retry. If every query left is waiting, it waits for the first retry
+// without holding the job's turn. It reports false once no queries are left, or if the job was stopped
+// while waiting.
+func (j *ScrapeJob) nextQuery() (string, bool) {
+	queries := j.queryManager.Queries()
+	for query := range j.retries {
+		if !slices.Contains(queries, query) {
+			delete(j.retries, query) // Canceled while it was waiting
+		}
+	}
+
+	first := ""
+	for query, r := range j.retries {
+		if first == "" || r.due.Before(j.retries[first].due) {
+			first = query
+		}
+	}
+	if first != "" && !time.Now().Before(j.retries[first].due) {
+		return first, true
+	}
+
+	// The query manager returns every query once before any again, so a repeat means all are waiting
+	tried := make(map[string]bool)
+	for {
+		query, ok := j.queryManager.Next()
+		if !ok {
+			return "", false
+		}
+		if _, waiting := j.retries[query]; !waiting {
+			return query, true
+		}
+		if tried[query] {
+			break
+		}
+		tried[query] = true
+	}
+
+	wait := time.Until(j.retries[first].due)
+	j.log.Info("Every query left is waiting for a retry.", "client", j.clientName, "query", first, "wait", wait.Round(time.Second))
+	if !j.sched.pause(j, wait) {
+		return "", false
+	}
+	return first, true
+}
+
+// retryLater schedules another scrape of a query whose scrape failed, backing off exponentially, or
+// retires the query once it failed queryAttempts times in a row.
+func (j *ScrapeJob) retryLater(query string, err error) {
+	r := j.retries[query]
+	r.failures++
+	if r.failures >= queryAttempts {
+		j.log.Warn("Query failed too many times, retiring it.", "query", query, "attempts", r.failures, "error", err)
+		delete(j.retries, query)
+		j.queryManager.Remove(query)
+		return
+	}
+	wait := reliability.Backoff(queryRetryDelay, r.failures)
+	r.due = time.Now().Add(wait)
+	j.retries[query] = r
+	j.log.Info("Query failed, retrying it later.", "query", query, "attempt", r.failures, "retry", wait.Round(time.Second), "error", err)
+}
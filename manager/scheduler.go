@@ -0,0 +1,281 @@
+package manager
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Priority orders the jobs waiting for a turn. Queued jobs of a higher priority start first, and a
+// high-priority job that has to wait takes the turn of a running low-priority one.
+type Priority int
+
+const (
+	// PriorityLow is the priority of background work, such as the shared scrapes of topics, that gives
+	// way to high-priority jobs.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the priority of jobs that don't set one.
+	PriorityNormal
+	// PriorityHigh jobs start before all others and preempt running low-priority jobs.
+	PriorityHigh
+)
+
+// ParsePriority parses "low", "normal" or "high". An empty string is normal.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	}
+	return "normal"
+}
+
+// scheduler caps the jobs that scrape at the same time, as every job runs browser sessions of its
+// own. Jobs beyond the cap wait in a queue and get their turn by priority, and fairly across clients
+// within a priority: each client's jobs start in the order they were queued, but a client that was
+// given a turn goes behind the clients that are waiting for theirs, so one client queueing many jobs
+// can't starve the others. A client with a higher weight is given turns more often, in proportion to
+// its weight.
+type scheduler struct {
+	mu      sync.Mutex
+	max     int          // Zero means no cap
+	running []*ScrapeJob // In the order they got their turn
+	queue   []*ScrapeJob // In the order the jobs were queued
+	// pass is the virtual time of each client's next turn, which advances by 1/weight with every turn
+	// it is given. The queued job of the client with the lowest pass starts next.
+	pass map[string]float64
+	// vtime is the pass of the last turn given. Clients that start queueing begin from it.
+	vtime float64
+}
+
+// queuedJob is a job whose position in the queue changed.
+type queuedJob struct {
+	job      *ScrapeJob
+	position int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{pass: make(map[string]float64)}
+}
+
+// SetMaxJobs sets how many jobs scrape at the same time, including the shared scrapes of topics. Zero
+// removes the cap. Raising it starts queued jobs right away; lowering it lets the running jobs finish.
+func (m *ScrapeManager) SetMaxJobs(n int) {
+	s := m.sched
+	s.mu.Lock()
+	s.max = max(n, 0)
+	moved := s.dispatch()
+	s.mu.Unlock()
+	notifyQueued(moved)
+}
+
+// acquire waits until the job may scrape. It reports false if the job was stopped while queued.
+func (s *scheduler) acquire(j *ScrapeJob) bool {
+	s.mu.Lock()
+	s.pass[j.clientName] = max(s.pass[j.clientName], s.vtime)
+	return s.wait(j)
+}
+
+// yield gives the turn of a preempted job to the job that preempted it and queues the job again. It
+// reports false if the job was stopped while queued.
+func (s *scheduler) yield(j *ScrapeJob) bool {
+	s.mu.Lock()
+	s.running = slices.DeleteFunc(s.running, func(r *ScrapeJob) bool { return r == j })
+	j.preempted.Store(false)
+	j.ready = make(chan struct{})
+	j.log.Info("Job preempted by a high-priority job", "client", j.clientName)
+	return s.wait(j)
+}
+
+// pause gives the turn of a job to the next queued job for a while, then queues the job again. It
+// reports false if the job was stopped in the meantime.
+func (s *scheduler) pause(j *ScrapeJob, d time.Duration) bool {
+	s.release(j)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-j.ctx.Done():
+		return false
+	}
+
+	s.mu.Lock()
+	j.ready = make(chan struct{})
+	s.pass[j.clientName] = max(s.pass[j.clientName], s.vtime)
+	return s.wait(j)
+}
+
+// wait queues a job and waits for its turn. The caller must hold s.mu, which wait releases.
+func (s *scheduler) wait(j *ScrapeJob) bool {
+	s.queue = append(s.queue, j)
+	moved := s.dispatch()
+	position, ready := j.position, j.ready
+	s.mu.Unlock()
+	notifyQueued(moved)
+	if position == 0 {
+		return true
+	}
+
+	j.log.Info("Job queued, waiting for a running job to finish", "client", j.clientName, "position", position)
+	select {
+	case <-ready:
+		j.log.Info("Queued job starting", "client", j.clientName)
+		return true
+	case <-j.ctx.Done():
+	}
+
+	s.mu.Lock()
+	i := slices.Index(s.queue, j)
+	if i < 0 {
+		// The job got its turn as it was stopped
+		s.mu.Unlock()
+		s.release(j)
+		return false
+	}
+	s.queue = slices.Delete(s.queue, i, i+1)
+	s.forget(j.clientName)
+	moved = s.reposition()
+	s.mu.Unlock()
+	notifyQueued(moved)
+	return false
+}
+
+// release gives the turn of a job that stopped scraping to the next queued job.
+func (s *scheduler) release(j *ScrapeJob) {
+	s.mu.Lock()
+	s.running = slices.DeleteFunc(s.running, func(r *ScrapeJob) bool { return r == j })
+	s.forget(j.clientName)
+	moved := s.dispatch()
+	s.mu.Unlock()
+	notifyQueued(moved)
+}
+
+// forget drops the pass of a client that has no queued or running jobs left, so that clients that
+// come and go don't pile up. Once the client queues again, its pass starts from vtime as if it was
+// new. The caller must hold s.mu.
+func (s *scheduler) forget(clientName string) {
+	owned := func(j *ScrapeJob) bool { return j.clientName == clientName }
+	if !slices.ContainsFunc(s.running, owned) && !slices.ContainsFunc(s.queue, owned) {
+		delete(s.pass, clientName)
+	}
+}
+
+// hasRoom reports whether another job may start. The caller must hold s.mu.
+func (s *scheduler) hasRoom() bool {
+	return s.max <= 0 || len(s.running) < s.max
+}
+
+// dispatch starts queued jobs while there is room, preempts low-priority jobs for the high-priority
+// ones left waiting, and returns the jobs that moved in the queue. The caller must hold s.mu.
+func (s *scheduler) dispatch() []queuedJob {
+	for len(s.queue) > 0 && s.hasRoom() {
+		i := next(s.queue, s.pass)
+		j := s.queue[i]
+		s.queue = slices.Delete(s.queue, i, i+1)
+		s.vtime = s.pass[j.clientName]
+		s.pass[j.clientName] += 1 / float64(j.weight)
+		s.running = append(s.running, j)
+		j.position = 0
+		close(j.ready)
+	}
+	s.preempt()
+	return s.reposition()
+}
+
+// preempt asks as many running low-priority jobs to yield as there are high-priority jobs waiting
+// that no job is yielding for yet, the most recently started first. The caller must hold s.mu.
+func (s *scheduler) preempt() {
+	waiting := 0
+	for _, j := range s.queue {
+		if j.priority == PriorityHigh {
+			waiting++
+		}
+	}
+	for _, j := range s.running {
+		if j.preempted.Load() {
+			waiting--
+		}
+	}
+	for i := len(s.running) - 1; i >= 0 && waiting > 0; i-- {
+		if j := s.running[i]; j.priority == PriorityLow && !j.preempted.Load() {
+			j.preempted.Store(true)
+			j.cancelQuery() // Release the browser session right away
+			waiting--
+		}
+	}
+}
+
+// reposition updates the positions of the queued jobs and returns those whose position changed. The
+// caller must hold s.mu.
+func (s *scheduler) reposition() []queuedJob {
+	var moved []queuedJob
+	for i, j := range s.order() {
+		if j.position != i+1 {
+			j.position = i + 1
+			moved = append(moved, queuedJob{j, i + 1})
+		}
+	}
+	return moved
+}
+
+// order returns the queued jobs in the order they will start, unless others are queued before their
+// turn. The caller must hold s.mu.
+func (s *scheduler) order() []*ScrapeJob {
+	queue := slices.Clone(s.queue)
+	pass := maps.Clone(s.pass)
+	order := make([]*ScrapeJob, 0, len(queue))
+	for len(queue) > 0 {
+		i := next(queue, pass)
+		j := queue[i]
+		queue = slices.Delete(queue, i, i+1)
+		pass[j.clientName] += 1 / float64(j.weight)
+		order = append(order, j)
+	}
+	return order
+}
+
+// next returns the index of the queued job of the highest priority whose client has the lowest pass,
+// the earliest queued one on a tie.
+func next(queue []*ScrapeJob, pass map[string]float64) int {
+	best := 0
+	for i, j := range queue[1:] {
+		b := queue[best]
+		if j.priority > b.priority || j.priority == b.priority && pass[j.clientName] < pass[b.clientName] {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+// position returns the position of a job in the queue, or 0 if it isn't queued.
+func (s *scheduler) position(j *ScrapeJob) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return j.position
+}
+
+// notifyQueued tells the owners of queued jobs their new positions. It is called without holding the
+// scheduler's lock, as the owners write to their clients.
+func notifyQueued(moved []queuedJob) {
+	for _, q := range moved {
+		if q.job.onQueued != nil {
+			q.job.onQueued(q.position)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package manager
+
+import (
+	"context"
+	"gopin/pkg/logger"
+	"gopin/scraper"
+	"sync"
+	"sync/atomic"
+)
+
+// sharedBufferSize is how many images a job may fall behind a scrape it shares with other jobs before
+// it misses images.
+const sharedBufferSize = 100
+
+// sharedScrapes runs one scrape per query, however many jobs scrape it at the same time, and fans its
+// images out to each of them. A pin is only downloaded if one of the jobs hasn't seen it yet and
+// doesn't exclude it, and every job still gets the pins it has seen marked as such, so its own history
+// decides what it delivers. Jobs don't get the pins they exclude at all.
+//
+// A job that receives a scrape alone holds it up while it falls behind. Once jobs share it, those that
+// can't keep up miss images rather than stalling the others, like the subscribers of a topic.
+type sharedScrapes struct {
+	// start starts a scrape, which is Scraper.Scrape.
+	start   func(ctx context.Context, query string, skip func(pin scraper.ScrapedImage) bool) (<-chan scraper.ScrapedImage, error)
+	log     *logger.Logger
+	mu      sync.Mutex
+	scrapes map[string]*sharedScrape // keyed by query
+	// dropped counts the images dropped for jobs that fell behind a shared scrape.
+	dropped atomic.Int64
+}
+
+// sharedScrape is the scrape of a query and the jobs receiving its images.
+type sharedScrape struct {
+	query  string
+	cancel context.CancelFunc
+	subs   map[*scrapeSub]struct{} // guarded by sharedScrapes.mu
+}
+
+// scrapeSub receives the images of a shared scrape for a single job.
+type scrapeSub struct {
+	ctx     context.Context
+	log     *logger.Logger
+	seen    func(pinID string) bool
+	exclude func(pin scraper.ScrapedImage) bool
+	ch      chan scraper.ScrapedImage
+	mu      sync.Mutex // Held while sending to ch, so that it isn't closed during a send
+	closed  bool
+	// dropped counts the images the job missed because it fell behind.
+	dropped atomic.Int64
+}
+
+func newSharedScrapes(s *scraper.Scraper, log *logger.Logger) *sharedScrapes {
+	return &sharedScrapes{start: s.Scrape, log: log, scrapes: make(map[string]*sharedScrape)}
+}
+
+// scrape returns the images of a query like Scraper.Scrape, joining the running scrape of the query
+// if there is one, which joined reports. Pins that exclude, if it is set, reports true for are left
+// out. The channel is closed when ctx is done or the scrape ends.
+func (h *sharedScrapes) scrape(ctx context.Context, query string, seen func(pinID string) bool, exclude func(pin scraper.ScrapedImage) bool) (images <-chan scraper.ScrapedImage, joined bool, err error) {
+	log := logger.FromContext(ctx, h.log)
+	sub := &scrapeSub{ctx: ctx, log: log, seen: seen, exclude: exclude, ch: make(chan scraper.ScrapedImage, sharedBufferSize)}
+
+	h.mu.Lock()
+	s, running := h.scrapes[query]
+	if !running {
+		// The scrape keeps the trace and logger of the job that started it, but outlives it if others joined
+		scrapeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		s = &sharedScrape{query: query, cancel: cancel, subs: make(map[*scrapeSub]struct{})}
+		src, err := h.start(scrapeCtx, query, func(pin scraper.ScrapedImage) bool { return h.allSeen(s, pin) })
+		if err != nil {
+			h.mu.Unlock()
+			cancel()
+			return nil, false, err
+		}
+		h.scrapes[query] = s
+		go h.fanOut(s, src)
+	}
+	s.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	if running {
+		log.Info("Joined running scrape of query", "query", query)
+	}
+	go func() {
+		<-ctx.Done()
+		h.leave(s, sub)
+	}()
+	return sub.ch, running, nil
+}
+
+// allSeen reports whether every job receiving a scrape has seen or excludes a pin, so that it needn't
+// be downloaded.
+func (h *sharedScrapes) allSeen(s *sharedScrape, pin scraper.ScrapedImage) bool {
+	h.mu.Lock()
+	subs := make([]*scrapeSub, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.exclude != nil && sub.exclude(pin) {
+			continue
+		}
+		if sub.seen == nil || !sub.seen(pin.ID) {
+			return false
+		}
+	}
+	return len(subs) > 0
+}
+
+// fanOut hands every image of a scrape to the jobs receiving it, and closes their channels once the
+// scrape ends. The images dropped for a job are logged as their number reaches each power of ten.
+func (h *sharedScrapes) fanOut(s *sharedScrape, src <-chan scraper.ScrapedImage) {
+	for img := range src {
+		h.mu.Lock()
+		subs := make([]*scrapeSub, 0, len(s.subs))
+		for sub := range s.subs {
+			subs = append(subs, sub)
+		}
+		h.mu.Unlock()
+
+		for _, sub := range subs {
+			if sub.send(img, len(subs) == 1) {
+				continue
+			}
+			h.dropped.Add(1)
+			if n := sub.dropped.Add(1); isPowerOfTen(n) {
+				sub.log.Warn("Job is falling behind a shared scrape, dropped images", "query", s.query, "dropped", n)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	if h.scrapes[s.query] == s {
+		delete(h.scrapes, s.query)
+	}
+	subs := s.subs
+	s.subs = nil
+	h.mu.Unlock()
+	for sub := range subs {
+		sub.close()
+	}
+	s.cancel()
+}
+
+// leave detaches a job from a scrape, which is stopped once no job receives it anymore.
+func (h *sharedScrapes) leave(s *sharedScrape, sub *scrapeSub) {
+	h.mu.Lock()
+	delete(s.subs, sub)
+	last := len(s.subs) == 0 && h.scrapes[s.query] == s
+	if last {
+		delete(h.scrapes, s.query)
+	}
+	h.mu.Unlock()
+
+	sub.close()
+	if last {
+		s.cancel()
+	}
+}
+
+// send passes an image on to the job, marked as seen if the job has seen it although others haven't,
+// unless the job excludes it. If wait is false, the image is dropped rather than waiting for room in
+// the job's buffer. send reports false if the image was dropped.
+func (sub *scrapeSub) send(img scraper.ScrapedImage, wait bool) bool {
+	if img.ID != "" && sub.exclude != nil && sub.exclude(img) {
+		return true
+	}
+	if !img.Seen && img.Err == nil && sub.seen != nil && sub.seen(img.ID) {
+		img = scraper.ScrapedImage{ID: img.ID, URL: img.URL, Query: img.Query, Title: img.Title, Description: img.Description, AltText: img.AltText, Seen: true}
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return true
+	}
+	if !wait {
+		select {
+		case sub.ch <- img:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case sub.ch <- img:
+	case <-sub.ctx.Done():
+	}
+	return true
+}
+
+// close closes the job's channel once.
+func (sub *scrapeSub) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,18 @@
+package manager
+
+import "gopin/query"
+
+// SetPlaceholders sets the values of the placeholders that fill the query templates of every job, such
+// as "{color} aesthetic pfp", when the job starts, see query.Fill.
+func (m *ScrapeManager) SetPlaceholders(placeholders map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.placeholders = placeholders
+}
+
+// expandQueries turns the queries a job asked for into those it scrapes: its templates filled in, then
+// every query combined with the modifiers, normalized and without duplicates.
+func expandQueries(queries []string, placeholders map[string][]string, modifiers []string) []string {
+	return query.NormalizeAll(query.Expand(query.Fill(queries, placeholders), modifiers))
+}
@@ -0,0 +1,238 @@
+package manager
+
+import (
+	"gopin/scraper"
+	"gopin/storage"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ReasonUnsubscribed is the reason reported by a subscription that was cancelled by its client.
+const ReasonUnsubscribed = "unsubscribed"
+
+// topic is a continuous scrape shared by every client subscribed to it.
+type topic struct {
+	name string
+	job  *ScrapeJob
+	subs map[string]*Subscription // keyed by client name
+}
+
+// Subscription delivers the images of a shared topic to a single client.
+type Subscription struct {
+	clientName string
+	topic      string
+	imageChan  chan scraper.ScrapedImage
+	filter     func(scraper.ScrapedImage) bool
+	reason     string
+	closeOnce  sync.Once
+	// dropped counts the images the subscriber missed because it fell behind.
+	dropped atomic.Int64
+	// outOfQuota is set when the consumer found the client out of quota before delivering an image, for
+	// the fan-out to end the subscription.
+	quotaExceeded func(pending storage.Usage) bool
+	outOfQuota    atomic.Bool
+}
+
+// Subscribe attaches a client to a topic, starting the topic's shared scrape if it is not running yet.
+// Subscribing again to the same topic replaces the previous subscription. If filter is set, the
+// subscription only receives the images it returns true for.
+func (m *ScrapeManager) Subscribe(clientName, topicName string, queries []string, filter func(scraper.ScrapedImage) bool) *Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.topics[topicName]
+	if !exists {
+		t = &topic{
+			name: topicName,
+			job:  m.newJob("topic:"+topicName, JobOptions{Queries: queries, Limit: math.MaxInt, Priority: PriorityLow, cycleQueries: true}),
+			subs: make(map[string]*Subscription),
+		}
+		m.topics[topicName] = t
+		t.job.Start()
+		go m.fanOut(t)
+		m.log.Info("Started shared topic scrape", "topic", topicName, "queryCount", len(queries))
+	}
+
+	if old, ok := t.subs[clientName]; ok {
+		old.close(ReasonUnsubscribed)
+	}
+	sub := &Subscription{
+		clientName: clientName,
+		topic:      topicName,
+		imageChan:  make(chan scraper.ScrapedImage, 100),
+		filter:     filter,
+		quotaExceeded: func(pending storage.Usage) bool {
+			return m.quotaExceeded(clientName, pending)
+		},
+	}
+	t.subs[clientName] = sub
+	return sub
+}
+
+// Unsubscribe detaches a client from a topic and stops the topic once nobody is subscribed.
+func (m *ScrapeManager) Unsubscribe(clientName, topicName string) {
+	m.mu.Lock()
+	t, exists := m.topics[topicName]
+	if !exists {
+		m.mu.Unlock()
+		return
+	}
+	if sub, ok := t.subs[clientName]; ok {
+		sub.close(ReasonUnsubscribed)
+		delete(t.subs, clientName)
+	}
+	idle := len(t.subs) == 0
+	if idle {
+		delete(m.topics, topicName)
+	}
+	m.mu.Unlock()
+
+	if idle {
+		m.log.Info("Stopping shared topic scrape, no subscribers left", "topic", topicName)
+		t.job.Stop()
+	}
+}
+
+// UnsubscribeAll detaches a client from every topic it is subscribed to.
+func (m *ScrapeManager) UnsubscribeAll(clientName string) {
+	m.mu.Lock()
+	var names []string
+	for name, t := range m.topics {
+		if _, ok := t.subs[clientName]; ok {
+			names = append(names, name)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, name := range names {
+		m.Unsubscribe(clientName, name)
+	}
+}
+
+// fanOut copies every image of a topic's job to all of its subscribers.
+// Subscribers that can't keep up miss images rather than stalling the others. The missed images are
+// counted, and logged as their number reaches each power of ten.
+func (m *ScrapeManager) fanOut(t *topic) {
+	for img := range t.job.Images() {
+		// Quotas are checked without holding the lock, as a client's first check reads the database
+		m.mu.Lock()
+		subs := slices.Collect(maps.Values(t.subs))
+		m.mu.Unlock()
+		var exceeded map[*Subscription]bool
+		for _, sub := range subs {
+			if sub.outOfQuota.Load() || m.quotaExceeded(sub.clientName, storage.Usage{}) {
+				if exceeded == nil {
+					exceeded = make(map[*Subscription]bool)
+				}
+				exceeded[sub] = true
+			}
+		}
+
+		m.mu.Lock()
+		for clientName, sub := range t.subs {
+			if exceeded[sub] {
+				m.log.Info("Subscriber is out of quota, ending subscription", "topic", t.name, "client", clientName)
+				sub.close(ReasonQuota)
+				delete(t.subs, clientName)
+				continue
+			}
+			if sub.filter != nil && !sub.filter(img) {
+				continue
+			}
+			select {
+			case sub.imageChan <- img:
+			default:
+				m.droppedImages.Add(1)
+				if n := sub.dropped.Add(1); isPowerOfTen(n) {
+					m.log.Warn("Subscriber is falling behind, dropped images", "topic", t.name, "client", sub.clientName, "dropped", n)
+				}
+			}
+		}
+		if len(t.subs) == 0 && m.topics[t.name] == t {
+			// Cancel rather than Stop, which would wait for this loop to drain the job
+			m.log.Info("Stopping shared topic scrape, no subscribers left", "topic", t.name)
+			delete(m.topics, t.name)
+			t.job.cancel()
+		}
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sub := range t.subs {
+		sub.close(t.job.Reason())
+	}
+	if m.topics[t.name] == t {
+		delete(m.topics, t.name)
+	}
+}
+
+// isPowerOfTen reports whether n is 1, 10, 100 and so on.
+func isPowerOfTen(n int64) bool {
+	for n >= 10 && n%10 == 0 {
+		n /= 10
+	}
+	return n == 1
+}
+
+// SharedDroppedImages returns the number of images dropped for jobs that fell behind a scrape they
+// shared with other jobs since the server started.
+func (m *ScrapeManager) SharedDroppedImages() int64 {
+	return m.shared.dropped.Load()
+}
+
+// DroppedImages returns the number of topic images dropped for subscribers that fell behind since the
+// server started.
+func (m *ScrapeManager) DroppedImages() int64 {
+	return m.droppedImages.Load()
+}
+
+// close ends the subscription with the given reason.
+func (s *Subscription) close(reason string) {
+	s.closeOnce.Do(func() {
+		s.reason = reason
+		close(s.imageChan)
+	})
+}
+
+// Topic returns the name of the subscribed topic.
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Images returns the channel of topic images. It is closed when the subscription ends.
+func (s *Subscription) Images() <-chan scraper.ScrapedImage {
+	return s.imageChan
+}
+
+// Reason returns why the subscription ended. It is only valid once the image channel is closed.
+func (s *Subscription) Reason() string {
+	return s.reason
+}
+
+// QuotaExceeded reports whether the subscriber has used up its quota, counting pending, the usage of
+// images about to be delivered that isn't recorded yet. Once it reports true, the subscription ends with
+// ReasonQuota as the next topic image comes in.
+func (s *Subscription) QuotaExceeded(pending storage.Usage) bool {
+	if s.outOfQuota.Load() {
+		return true
+	}
+	if !s.quotaExceeded(pending) {
+		return false
+	}
+	s.outOfQuota.Store(true)
+	return true
+}
+
+// Dropped returns the number of images the subscription missed because its client fell behind.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Failed returns nil, as failures of a shared scrape are not attributed to individual subscribers.
+func (s *Subscription) Failed() map[string]int {
+	return nil
+}
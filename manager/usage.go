@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"errors"
+	"gopin/storage"
+	"sync"
+	"time"
+)
+
+// UsageFlushInterval is how often the usage recorded by RecordUsage should be written to the database
+// with FlushUsage.
+const UsageFlushInterval = 5 * time.Second
+
+// usageTracker keeps the usage of each client in memory, so that deliveries and quota checks don't wait
+// for the database. Recorded usage is written to it in batches by flush.
+type usageTracker struct {
+	// flushMu keeps usage from being loaded while a batch is written, which would count it twice or not
+	// at all.
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	pending map[usageDay]storage.Usage // Usage not written yet
+	totals  map[string]*clientUsage    // Usage of the current day and month by client, including pending
+}
+
+// usageDay is a UTC day of a client's usage.
+type usageDay struct {
+	client string
+	day    time.Time
+}
+
+// clientUsage is a client's usage of a UTC day and of its month.
+type clientUsage struct {
+	day            time.Time
+	daily, monthly storage.Usage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		pending: make(map[usageDay]storage.Usage),
+		totals:  make(map[string]*clientUsage),
+	}
+}
+
+// utcDay returns the start of the UTC day containing t.
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// sameMonth reports whether two UTC days are in the same month.
+func sameMonth(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month()
+}
+
+// record adds usage of a client at now.
+func (u *usageTracker) record(clientName string, now time.Time, delta storage.Usage) {
+	day := utcDay(now)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := usageDay{client: clientName, day: day}
+	pending := u.pending[key]
+	pending.Add(delta)
+	u.pending[key] = pending
+	if t, ok := u.totals[clientName]; ok {
+		if t.day.Equal(day) {
+			t.daily.Add(delta)
+			t.monthly.Add(delta)
+		} else {
+			delete(u.totals, clientName) // Loaded again for the new day
+		}
+	}
+}
+
+// usage returns a client's usage of the UTC day and month containing now, reading it from db the first
+// time it is asked for each day.
+func (u *usageTracker) usage(db storage.UsageStore, clientName string, now time.Time) (day, month storage.Usage, err error) {
+	today := utcDay(now)
+
+	u.mu.Lock()
+	if t, ok := u.totals[clientName]; ok && t.day.Equal(today) {
+		defer u.mu.Unlock()
+		return t.daily, t.monthly, nil
+	}
+	u.mu.Unlock()
+
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+	day, month, err = db.GetUsage(clientName, today)
+	if err != nil {
+		return storage.Usage{}, storage.Usage{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, pending := range u.pending {
+		if key.client != clientName {
+			continue
+		}
+		if key.day.Equal(today) {
+			day.Add(pending)
+		}
+		if sameMonth(key.day, today) {
+			month.Add(pending)
+		}
+	}
+	u.totals[clientName] = &clientUsage{day: today, daily: day, monthly: month}
+	return day, month, nil
+}
+
+// flush writes the recorded usage to db. Usage that fails to be written is kept for the next flush.
+func (u *usageTracker) flush(db storage.UsageStore, now time.Time) error {
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+
+	today := utcDay(now)
+	u.mu.Lock()
+	batch := u.pending
+	u.pending = make(map[usageDay]storage.Usage)
+	for client, t := range u.totals {
+		if !t.day.Equal(today) {
+			delete(u.totals, client)
+		}
+	}
+	u.mu.Unlock()
+
+	var errs []error
+	failed := make(map[usageDay]storage.Usage)
+	for key, delta := range batch {
+		if err := db.AddUsage(key.client, key.day, delta); err != nil {
+			errs = append(errs, err)
+			failed[key] = delta
+		}
+	}
+	if len(failed) > 0 {
+		u.mu.Lock()
+		for key, delta := range failed {
+			pending := u.pending[key]
+			pending.Add(delta)
+			u.pending[key] = pending
+		}
+		u.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
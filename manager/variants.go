@@ -0,0 +1,19 @@
+package manager
+
+import "gopin/query"
+
+// addVariants adds the variants of the exhausted queries to a job that asked for them, once, and
+// reports whether there are any new queries to scrape.
+func (j *ScrapeJob) addVariants() bool {
+	if !j.variants {
+		return false
+	}
+	j.variants = false
+	exhausted := j.queryManager.Exhausted()
+	variants := query.Variants(exhausted)
+	if j.queryManager.Add(variants...) == 0 {
+		return false
+	}
+	j.log.Info("Every query is exhausted, scraping their variants.", "client", j.clientName, "variants", variants)
+	return true
+}
@@ -0,0 +1,37 @@
+package manager
+
+import "gopin/query"
+
+// SetQueryWeights sets the weights of queries for every job, which the weights a job asks for override.
+// A query with a weight of 3 is scraped three times as often as one without a weight, see query.Manager.
+func (m *ScrapeManager) SetQueryWeights(weights map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queryWeights = weights
+}
+
+// jobWeights returns the weights of the queries of a job: those the job asked for, or else the
+// server's, matched by normalized query. The queries filled in from a template and the expansions of a
+// query weigh as much as the template or query itself. The caller must hold m.mu.
+func (m *ScrapeManager) jobWeights(opts JobOptions) map[string]int {
+	given := make(map[string]int, len(m.queryWeights)+len(opts.QueryWeights))
+	for q, w := range m.queryWeights {
+		given[query.Normalize(q)] = w
+	}
+	for q, w := range opts.QueryWeights {
+		given[query.Normalize(q)] = w
+	}
+
+	weights := make(map[string]int)
+	for _, base := range opts.Queries {
+		w, ok := given[query.Normalize(base)]
+		if !ok {
+			continue
+		}
+		for _, q := range expandQueries([]string{base}, m.placeholders, opts.Modifiers) {
+			weights[q] = w
+		}
+	}
+	return weights
+}
@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"errors"
+	"gopin/storage"
+	"time"
+)
+
+// queryYield counts what a scrape of a query yielded until it is recorded.
+type queryYield struct {
+	query string
+	delta storage.QueryYield
+}
+
+// beginYield starts counting the yield of a scrape of a query.
+func beginYield(query string) queryYield {
+	return queryYield{query: query, delta: storage.QueryYield{Scrapes: 1, LastScraped: time.Now()}}
+}
+
+// addQueryYield adds the yield of a scrape to the query's lifetime yield in the database.
+func (m *ScrapeManager) addQueryYield(query string, delta storage.QueryYield) {
+	err := m.db.AddQueryYield(query, delta)
+	if err != nil && !errors.Is(err, storage.ErrReadOnly) {
+		m.log.Error("Failed to record query yield", "error", err, "query", query)
+	}
+}
+
+// recordYield records the yield of the scrape being counted, if any, and stops counting it.
+func (j *ScrapeJob) recordYield(y *queryYield) {
+	if y.query == "" {
+		return
+	}
+	j.addYield(y.query, y.delta)
+	*y = queryYield{}
+}
@@ -3,14 +3,18 @@ package pinterest
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gopin/pkg/logger"
 	"gopin/pkg/reliability"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chromedp/cdproto/cdp"
@@ -18,51 +22,160 @@ import (
 	"github.com/chromedp/chromedp"
 )
 
+// Provider is the name of the Pinterest provider in the scraping config.
+const Provider = "pinterest"
+
+// browserResource is the resource of the browser limit, see SetBrowserLimit.
+const browserResource = "browser"
+
+// Default delays between two requests, used when none are configured.
+const (
+	DefaultMinDelay = 2 * time.Second
+	DefaultMaxDelay = 5 * time.Second
+)
+
 // Client is a client for scraping Pinterest using a headless browser.
 type Client struct {
 	log        *logger.Logger
 	userAgents []string
+	// delays bounds the delay between two requests, see SetDelays
+	delays atomic.Pointer[delayRange]
+	// launches holds back new browsers while it is closed
+	launches *reliability.Gate
+	// breaker stops scraping after scrapes keep failing, nil if it is disabled
+	breaker *reliability.CircuitBreaker
+	// browsers limits the browsers running at a time, nil if they are unlimited
+	browsers *reliability.Bulkhead
+	// onBlocked is called when Pinterest refuses a search.
+	onBlocked func(query string)
+	// onRelated is called with the related searches and hashtags of search results.
+	onRelated func(query string, related, hashtags []string)
 }
 
-// NewClient creates a new Pinterest client.
-func NewClient(log *logger.Logger, userAgents []string) *Client {
-	return &Client{
+// NewClient creates a new Pinterest client that waits a random delay between minDelay and maxDelay
+// between two requests. Zero delays use the defaults.
+func NewClient(log *logger.Logger, userAgents []string, minDelay, maxDelay time.Duration) (*Client, error) {
+	c := &Client{
 		log:        log,
 		userAgents: userAgents,
+		launches:   reliability.NewGate(),
+	}
+	if err := c.SetDelays(minDelay, maxDelay); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// delayRange bounds the random delay between two requests.
+type delayRange struct {
+	min, max time.Duration
+}
+
+// SetDelays changes the delays between two requests of running and future scrapes, as in NewClient. It
+// keeps the current delays if the new ones are invalid.
+func (c *Client) SetDelays(minDelay, maxDelay time.Duration) error {
+	if minDelay == 0 {
+		minDelay = DefaultMinDelay
+	}
+	if maxDelay == 0 {
+		maxDelay = max(DefaultMaxDelay, minDelay)
 	}
+	if minDelay > maxDelay {
+		return fmt.Errorf("minimum delay %s exceeds the maximum delay %s", minDelay, maxDelay)
+	}
+	c.delays.Store(&delayRange{min: minDelay, max: maxDelay})
+	return nil
+}
+
+// OnBlocked sets a function that is called with the query when Pinterest refuses a search with 403
+// Forbidden or 429 Too Many Requests, as it does when it blocks the scraper. It is called at most once
+// per scrape, and must be set before scraping starts.
+func (c *Client) OnBlocked(fn func(query string)) {
+	c.onBlocked = fn
 }
 
-// rateLimiter enforces a delay between requests.
+// OnRelated sets a function that is called with the query, the related searches Pinterest suggests
+// for it and the hashtags of its pins whenever a page of search results comes in, one hashtag per pin
+// that has it. It must be set before scraping starts.
+func (c *Client) OnRelated(fn func(query string, related, hashtags []string)) {
+	c.onRelated = fn
+}
+
+// SetCircuitBreaker makes scrapes go through cb, which refuses them for a while after they keep
+// failing. Scrapes that run out of results or are cancelled don't count as failures. A nil cb disables
+// it. It must be set before scraping starts.
+func (c *Client) SetCircuitBreaker(cb *reliability.CircuitBreaker) {
+	c.breaker = cb
+}
+
+// SetBrowserLimit makes scrapes take a slot of the "browser" resource of b before launching a browser,
+// and hold it until the browser is closed. A nil b lets every scrape launch its browser right away. It
+// must be set before scraping starts.
+func (c *Client) SetBrowserLimit(b *reliability.Bulkhead) {
+	c.browsers = b
+}
+
+// CircuitBreaker returns the circuit breaker set with SetCircuitBreaker, or nil if there is none.
+func (c *Client) CircuitBreaker() *reliability.CircuitBreaker {
+	return c.breaker
+}
+
+// PauseLaunches makes scrapes wait before launching a browser until ResumeLaunches is called.
+// Browsers that are already running keep scraping.
+func (c *Client) PauseLaunches() {
+	c.launches.Close()
+}
+
+// ResumeLaunches lets scrapes waiting for PauseLaunches launch their browsers.
+func (c *Client) ResumeLaunches() {
+	c.launches.Open()
+}
+
+// rateLimiter enforces a random delay between requests.
 type rateLimiter struct {
 	lastRequest time.Time
-	minDelay    time.Duration
-	maxDelay    time.Duration
+	delays      *atomic.Pointer[delayRange] // The client's, so that changed delays apply right away
 	mu          sync.Mutex
 }
 
-func newRateLimiter(minDelay, maxDelay time.Duration) *rateLimiter {
+func newRateLimiter(delays *atomic.Pointer[delayRange]) *rateLimiter {
 	return &rateLimiter{
-		minDelay: minDelay,
-		maxDelay: maxDelay,
+		delays: delays,
 	}
 }
 
-func (r *rateLimiter) wait() {
+// wait blocks until a random delay between the minimum and maximum delay has passed since the last
+// request, or ctx is done.
+func (r *rateLimiter) wait(ctx context.Context) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	elapsed := time.Since(r.lastRequest)
-	if elapsed < r.minDelay {
-		jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
-		time.Sleep(r.minDelay - elapsed + jitter)
+	d := r.delays.Load()
+	delay := d.min
+	if d.max > d.min {
+		delay += time.Duration(rand.Int63n(int64(d.max - d.min)))
+	}
+	if remaining := delay - time.Since(r.lastRequest); remaining > 0 {
+		select {
+		case <-time.After(remaining):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	r.lastRequest = time.Now()
+	return nil
 }
 
-// ScrapeResult represents an image URL found during scraping.
+// ScrapeResult represents an image URL found during scraping. If Err is set, the scrape failed and
+// the result is the last one.
 type ScrapeResult struct {
 	ID  string
 	URL string
+	// Title, Description and AltText describe the pin, if Pinterest has them.
+	Title       string
+	Description string
+	AltText     string
+	Err         error
 }
 
 // SearchResult represents the structure of the search results from Pinterest's API.
@@ -70,41 +183,103 @@ type SearchResult struct {
 	ResourceResponse struct {
 		Data struct {
 			Results []struct {
-				ID     string `json:"id"`
-				Images struct {
+				ID          string   `json:"id"`
+				Title       string   `json:"title"`
+				GridTitle   string   `json:"grid_title"`
+				Description string   `json:"description"`
+				AltText     string   `json:"auto_alt_text"`
+				Hashtags    []string `json:"hashtags"`
+				Images      struct {
 					Orig struct {
 						URL string `json:"url"`
 					} `json:"orig"`
 				} `json:"images"`
 			} `json:"results"`
+			// Guides are the related searches shown above the results.
+			Guides []struct {
+				Term    string `json:"term"`
+				Display string `json:"display"`
+			} `json:"guides"`
 		} `json:"data"`
 	} `json:"resource_response"`
 }
 
 var ErrQueryExhausted = fmt.Errorf("query exhausted")
 
+// hashtagPattern matches the hashtags in pin descriptions.
+var hashtagPattern = regexp.MustCompile(`#[\pL\pN_]+`)
+
+// related returns the related searches of search results and the hashtags of their pins, lowercase and
+// without the #, each hashtag once per pin.
+func (r *SearchResult) related() (related, hashtags []string) {
+	data := r.ResourceResponse.Data
+	for _, guide := range data.Guides {
+		term := guide.Term
+		if term == "" {
+			term = guide.Display
+		}
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			related = append(related, term)
+		}
+	}
+	for _, pin := range data.Results {
+		seen := make(map[string]bool)
+		for _, tag := range append(pin.Hashtags, hashtagPattern.FindAllString(pin.Description, -1)...) {
+			if tag = strings.ToLower(strings.TrimPrefix(tag, "#")); tag != "" && !seen[tag] {
+				seen[tag] = true
+				hashtags = append(hashtags, tag)
+			}
+		}
+	}
+	return related, hashtags
+}
+
 // Scrape starts a continuous scraping process for a given query.
 func (c *Client) Scrape(ctx context.Context, query string) (<-chan ScrapeResult, error) {
+	log := logger.FromContext(ctx, c.log)
 	resultChan := make(chan ScrapeResult, 100)
-	rateLimiter := newRateLimiter(time.Second*2, time.Second*5)
-	circuitBreaker := reliability.NewCircuitBreaker(3, time.Minute)
+	rateLimiter := newRateLimiter(&c.delays)
 
 	go func() {
 		defer close(resultChan)
 
-		err := circuitBreaker.Call(func() error {
-			return c.scrapeWithRetries(ctx, query, resultChan, rateLimiter)
+		err := c.call(ctx, func() error {
+			return c.scrapeWithRetries(ctx, log, query, resultChan, rateLimiter)
 		})
 
-		if err != nil && err != ErrQueryExhausted {
-			c.log.Error("Scraping call failed after multiple retries.", "error", err, "query", query)
+		if err != nil && err != ErrQueryExhausted && ctx.Err() == nil {
+			if errors.Is(err, reliability.ErrCircuitOpen) {
+				log.Warn("Skipped scrape while the circuit breaker is open.", "query", query)
+			} else {
+				log.Error("Scraping call failed after multiple retries.", "error", err, "query", query)
+			}
+			select {
+			case resultChan <- ScrapeResult{Err: err}:
+			case <-ctx.Done():
+			}
 		}
 	}()
 
 	return resultChan, nil
 }
 
-func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan chan<- ScrapeResult, rateLimiter *rateLimiter) error {
+// call runs a scrape through the circuit breaker, if there is one. Running out of results, waiting too
+// long for a browser and ctx being done are passed on, but the breaker records them as neither a
+// success nor a failure.
+func (c *Client) call(ctx context.Context, scrape func() error) error {
+	if c.breaker == nil {
+		return scrape()
+	}
+	return c.breaker.Call(func() error {
+		err := scrape()
+		if err == ErrQueryExhausted || errors.Is(err, reliability.ErrBulkheadFull) || ctx.Err() != nil {
+			return reliability.Ignore(err)
+		}
+		return err
+	})
+}
+
+func (c *Client) scrapeWithRetries(ctx context.Context, log *logger.Logger, query string, resultChan chan<- ScrapeResult, rateLimiter *rateLimiter) error {
 	var execPath string
 	for _, path := range []string{
 		`C:\Program Files (x86)\Microsoft\Edge\Application\msedge.exe`,
@@ -130,6 +305,23 @@ func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan
 		chromedp.Flag("excludeSwitches", "enable-automation"),
 	)
 
+	if !c.launches.IsOpen() {
+		log.Info("Waiting for memory to free up before launching a browser", "query", query)
+	}
+	if err := c.launches.Wait(ctx); err != nil {
+		return err
+	}
+	if c.browsers != nil {
+		if c.browsers.Available(browserResource) == 0 {
+			log.Info("Waiting for another browser to close before launching one", "query", query)
+		}
+		release, err := c.browsers.Acquire(ctx, browserResource)
+		if err != nil {
+			return fmt.Errorf("failed to get a browser slot: %w", err)
+		}
+		defer release()
+	}
+
 	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
 	defer cancelAlloc()
 	taskCtx, cancelTask := chromedp.NewContext(allocCtx)
@@ -138,10 +330,20 @@ func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan
 	searchURL := fmt.Sprintf("https://www.pinterest.com/search/pins/?q=%s", url.QueryEscape(query))
 	var seenIDs = make(map[string]bool)
 	responseChan := make(chan []byte)
+	var blocked sync.Once
 
 	chromedp.ListenTarget(taskCtx, func(ev interface{}) {
 		if resp, ok := ev.(*network.EventResponseReceived); ok {
 			if strings.Contains(resp.Response.URL, "BaseSearchResource") {
+				if status := resp.Response.Status; status == http.StatusForbidden || status == http.StatusTooManyRequests {
+					blocked.Do(func() {
+						log.Warn("Pinterest refused the search, the scraper may be blocked.", "query", query, "status", status)
+						if c.onBlocked != nil {
+							c.onBlocked(query)
+						}
+					})
+					return
+				}
 				go func(reqID network.RequestID) {
 					body, err := network.GetResponseBody(reqID).Do(cdp.WithExecutor(taskCtx, chromedp.FromContext(taskCtx).Target))
 					if err == nil {
@@ -159,17 +361,20 @@ func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan
 		network.Enable(),
 		chromedp.Navigate(searchURL),
 		chromedp.ActionFunc(func(actCtx context.Context) error {
-			c.log.Info("Navigated to search page, starting to scroll...", "url", searchURL)
+			log.Info("Navigated to search page, starting to scroll...", "url", searchURL)
 			var noNewResultsCount int
 			const maxConsecutiveTimeouts = 3
 
 			for {
 				select {
 				case <-ctx.Done():
-					c.log.Info("Scraping cancelled by parent context.", "query", query)
+					log.Info("Scraping cancelled by parent context.", "query", query)
 					return nil
 				default:
-					rateLimiter.wait()
+					if err := rateLimiter.wait(ctx); err != nil {
+						log.Info("Scraping cancelled by parent context.", "query", query)
+						return nil
+					}
 					err := chromedp.Run(actCtx,
 						chromedp.Evaluate(`window.scrollBy(0, Math.random() * 800 + 200);`, nil),
 						chromedp.Sleep(time.Duration(200+rand.Intn(300))*time.Millisecond), // Much faster scrolling
@@ -185,15 +390,24 @@ func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan
 						var searchResult SearchResult
 						if err := json.Unmarshal(body, &searchResult); err == nil {
 							if len(searchResult.ResourceResponse.Data.Results) == 0 {
-								c.log.Info("Received response with no image results.", "query", query)
+								log.Info("Received response with no image results.", "query", query)
 								noNewResultsCount++
 							}
+							if c.onRelated != nil {
+								if related, hashtags := searchResult.related(); len(related) > 0 || len(hashtags) > 0 {
+									c.onRelated(query, related, hashtags)
+								}
+							}
 
 							for _, pin := range searchResult.ResourceResponse.Data.Results {
 								if !seenIDs[pin.ID] && pin.Images.Orig.URL != "" {
 									seenIDs[pin.ID] = true
+									result := ScrapeResult{ID: pin.ID, URL: pin.Images.Orig.URL, Title: pin.Title, Description: pin.Description, AltText: pin.AltText}
+									if result.Title == "" {
+										result.Title = pin.GridTitle
+									}
 									select {
-									case resultChan <- ScrapeResult{ID: pin.ID, URL: pin.Images.Orig.URL}:
+									case resultChan <- result:
 									case <-ctx.Done():
 										return nil
 									}
@@ -202,11 +416,11 @@ func (c *Client) scrapeWithRetries(ctx context.Context, query string, resultChan
 						}
 					case <-time.After(5 * time.Second): // Faster timeout
 						noNewResultsCount++
-						c.log.Warn("Timeout waiting for new results.", "count", noNewResultsCount)
+						log.Warn("Timeout waiting for new results.", "count", noNewResultsCount)
 					}
 
 					if noNewResultsCount >= maxConsecutiveTimeouts {
-						c.log.Warn("Reached max consecutive timeouts, assuming query is exhausted.", "query", query)
+						log.Warn("Reached max consecutive timeouts, assuming query is exhausted.", "query", query)
 						return ErrQueryExhausted
 					}
 				}
@@ -0,0 +1,90 @@
+package credential
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Cache remembers successful checks for a short while, so that clients which authenticate every request
+// don't pay for a bcrypt comparison each time. Entries are keyed by an HMAC of the client name, the stored
+// hash and the password under a random key, so the cache holds no secrets, and a changed or rotated
+// hash misses it right away.
+type Cache struct {
+	ttl  time.Duration
+	size int
+	key  []byte
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]time.Time // Expiry of each successful check
+}
+
+// NewCache creates a Cache that remembers up to size successful checks for ttl each.
+func NewCache(ttl time.Duration, size int) *Cache {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &Cache{
+		ttl:     ttl,
+		size:    max(size, 1),
+		key:     key,
+		entries: make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Check reports whether a password of a client matches a stored hash, like the package's Check, answering
+// from the cache if the same check succeeded within the TTL.
+func (c *Cache) Check(name, stored, password string) bool {
+	id := c.id(name, stored, password)
+	now := time.Now()
+	if c.cached(id, now) {
+		return true
+	}
+
+	if !Check(stored, password) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		for k, expires := range c.entries {
+			if !now.Before(expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			clear(c.entries)
+		}
+	}
+	c.entries[id] = now.Add(c.ttl)
+	return true
+}
+
+// Cached reports whether the same check succeeded within the TTL, without comparing the password to the
+// hash otherwise.
+func (c *Cache) Cached(name, stored, password string) bool {
+	return c.cached(c.id(name, stored, password), time.Now())
+}
+
+// id returns the key of a check in the cache.
+func (c *Cache) id(name, stored, password string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, c.key)
+	for _, part := range []string{name, stored, password} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	var id [sha256.Size]byte
+	mac.Sum(id[:0])
+	return id
+}
+
+// cached reports whether a check is in the cache and hasn't expired at now.
+func (c *Cache) cached(id [sha256.Size]byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.entries[id]
+	return ok && now.Before(expires)
+}
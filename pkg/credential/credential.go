@@ -0,0 +1,70 @@
+// Package credential hashes passwords and mints API keys for client authentication.
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// KeyPrefix starts every API key so that keys can be told apart from passwords.
+const KeyPrefix = "rk_"
+
+// HashPassword returns the bcrypt hash of a password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// IsHash reports whether a stored password is a bcrypt hash rather than plaintext.
+func IsHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// Check reports whether a password matches a stored bcrypt hash or, for legacy configs, plaintext password.
+func Check(stored, password string) bool {
+	if IsHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// NewKey mints an API key. The ID identifies the key in storage, the secret is only ever stored hashed,
+// and the key is the string handed to the client.
+func NewKey() (id, secret, key string) {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	secretBytes := make([]byte, 24)
+	rand.Read(secretBytes)
+
+	id = hex.EncodeToString(idBytes)
+	secret = base64.RawURLEncoding.EncodeToString(secretBytes)
+	return id, secret, KeyPrefix + id + "_" + secret
+}
+
+// NewPassword returns a random password for a client that rotates its password.
+func NewPassword() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseKey splits an API key into its ID and secret.
+func ParseKey(key string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(key, KeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, "_")
+	if !found || len(id) != 16 || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
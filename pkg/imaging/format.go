@@ -0,0 +1,17 @@
+package imaging
+
+import "net/http"
+
+// Extension guesses a file extension, including the dot, from the image bytes.
+func Extension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
@@ -1,24 +1,172 @@
 package logger
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/lmittmann/tint"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Logger is a wrapper around slog.Logger.
+// Logger is a wrapper around slog.Logger whose level can be changed while it is in use.
 type Logger struct {
 	*slog.Logger
+	level  *slog.LevelVar
+	file   io.Closer
+	recent *recent
 }
 
-// New creates a new Logger.
+// FileOptions configures a log file that receives every record as a JSON line and is rotated
+// by size and age.
+type FileOptions struct {
+	Path string
+	// MaxSize is the size in megabytes at which the file is rotated. Zero means 100.
+	MaxSize int
+	// MaxAge is how long rotated files are kept, rounded up to whole days. Zero keeps them regardless of age.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept. Zero keeps all of them.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// New creates a new Logger that logs records from the info level up.
 func New() *Logger {
+	level := new(slog.LevelVar)
+	return &Logger{Logger: slog.New(consoleHandler(level)), level: level}
+}
+
+// NewWithFile creates a Logger that writes to the console like New and tees its records to a log file.
+func NewWithFile(opts FileOptions) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	// Fail now rather than on the first record if the file can't be written
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.Close()
+
+	file := &lumberjack.Logger{
+		Filename:   opts.Path,
+		MaxSize:    opts.MaxSize,
+		MaxAge:     int((opts.MaxAge + 24*time.Hour - 1) / (24 * time.Hour)),
+		MaxBackups: opts.MaxBackups,
+		LocalTime:  true,
+		Compress:   opts.Compress,
+	}
+	level := new(slog.LevelVar)
+	jsonHandler := slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})
 	return &Logger{
-		slog.New(tint.NewHandler(os.Stdout, &tint.Options{
-			Level:      slog.LevelDebug,
-			TimeFormat: time.Kitchen,
-		})),
+		Logger: slog.New(teeHandler{consoleHandler(level), jsonHandler}),
+		level:  level,
+		file:   file,
+	}, nil
+}
+
+// SetLevel changes the minimum level of the records that are logged.
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+// Level returns the minimum level of the records that are logged.
+func (l *Logger) Level() slog.Level {
+	return l.level.Level()
+}
+
+// ParseLevel parses a level name such as "debug", "info", "warn" or "error", ignoring case.
+// An empty name is the info level.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// With returns a Logger that adds attributes to every record, such as the IDs that correlate the
+// records of a connection or job. It shares the level, log file and recent records of l.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{Logger: l.Logger.With(args...), level: l.level, file: l.file, recent: l.recent}
+}
+
+// contextKey is the context key under which a Logger is stored.
+type contextKey struct{}
+
+// NewContext returns a context that carries a Logger, so code further down the pipeline logs
+// with the attributes of the connection or job it works for.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the Logger stored in ctx, or fallback if there is none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// Close closes the log file, if any.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// consoleHandler returns the handler for the tinted console output.
+func consoleHandler(level slog.Leveler) slog.Handler {
+	return tint.NewHandler(os.Stdout, &tint.Options{
+		Level:      level,
+		TimeFormat: time.Kitchen,
+	})
+}
+
+// teeHandler passes every record to each of its handlers that is enabled for it.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
 	}
+	return handlers
 }
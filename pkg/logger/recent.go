@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultRecent is the number of records kept by WithRecent if no size is given.
+const DefaultRecent = 1000
+
+// Entry is a log record kept in memory.
+type Entry struct {
+	Time    time.Time  `json:"time"`
+	Level   slog.Level `json:"level"`
+	Message string     `json:"message"`
+	// Attrs are the attributes of the record, with the keys of grouped attributes joined by dots.
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// recent keeps the newest records in a ring buffer.
+type recent struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func (r *recent) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns up to limit entries from the given level up, newest first.
+func (r *recent) list(level slog.Level, limit int) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	result := make([]Entry, 0, min(n, limit))
+	for i := 1; i <= n && len(result) < limit; i++ {
+		e := r.entries[(r.next-i+len(r.entries))%len(r.entries)]
+		if e.Level >= level {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// WithRecent returns a Logger that also keeps its newest records in memory, up to size of them, so
+// that they can be read back with Recent. A size of zero or less keeps none and returns l. It must be
+// called before the Logger is shared, since loggers derived from l before don't keep records.
+func (l *Logger) WithRecent(size int) *Logger {
+	if size <= 0 {
+		return l
+	}
+	r := &recent{entries: make([]Entry, size)}
+	return &Logger{
+		Logger: slog.New(teeHandler{l.Logger.Handler(), &recentHandler{recent: r, level: l.level}}),
+		level:  l.level,
+		file:   l.file,
+		recent: r,
+	}
+}
+
+// Recent returns up to limit of the newest records from the given level up, newest first. It returns
+// nothing if the Logger doesn't keep records.
+func (l *Logger) Recent(level slog.Level, limit int) []Entry {
+	if l.recent == nil {
+		return nil
+	}
+	return l.recent.list(level, limit)
+}
+
+// recentHandler adds the records it handles to a ring buffer.
+type recentHandler struct {
+	recent *recent
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *recentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *recentHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]any, len(h.attrs)+r.NumAttrs())
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a) // Already prefixed
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, h.prefix, a)
+		return true
+	})
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+	h.recent.add(Entry{Time: r.Time, Level: r.Level, Message: r.Message, Attrs: attrs})
+	return nil
+}
+
+func (h *recentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefixed := slices.Clone(h.attrs)
+	for _, a := range attrs {
+		prefixed = append(prefixed, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &recentHandler{recent: h.recent, level: h.level, attrs: prefixed, prefix: h.prefix}
+}
+
+func (h *recentHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &recentHandler{recent: h.recent, level: h.level, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// addAttr adds an attribute to attrs as a value that encodes to JSON the way it reads in the console,
+// so that errors and durations don't become empty objects or nanoseconds.
+func addAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(attrs, prefix, ga)
+		}
+		return
+	case slog.KindString, slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool, slog.KindTime:
+		attrs[prefix+a.Key] = v.Any()
+	default:
+		attrs[prefix+a.Key] = v.String()
+	}
+}
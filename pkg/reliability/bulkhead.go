@@ -0,0 +1,92 @@
+package reliability
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBulkheadFull is returned by Bulkhead.Acquire when no slot of the resource frees up within the queue
+// timeout.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// Bulkhead limits how many callers use each resource at a time, such as browsers or the downloads from
+// a host, so a burst of work queues up instead of exhausting the resource. Resources are named by their
+// callers and each gets its own slots.
+type Bulkhead struct {
+	limit        int
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	slots map[string]chan struct{} // Holds a value per caller using the resource
+}
+
+// NewBulkhead creates a Bulkhead that lets limit callers use each resource at a time. Callers wait at
+// most queueTimeout for a slot, or as long as their context allows if it is zero.
+func NewBulkhead(limit int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		limit:        max(limit, 1),
+		queueTimeout: queueTimeout,
+		slots:        make(map[string]chan struct{}),
+	}
+}
+
+// resource returns the slots of a resource, creating them on first use.
+func (b *Bulkhead) resource(name string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slots, ok := b.slots[name]
+	if !ok {
+		slots = make(chan struct{}, b.limit)
+		b.slots[name] = slots
+	}
+	return slots
+}
+
+// Acquire blocks until a slot of the resource is free and takes it, returning a function that gives it
+// back. It returns ErrBulkheadFull if the queue timeout passes first, or the context's error if ctx is
+// done first.
+func (b *Bulkhead) Acquire(ctx context.Context, resource string) (release func(), err error) {
+	slots := b.resource(resource)
+	release = sync.OnceFunc(func() { <-slots })
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if b.queueTimeout > 0 {
+		timer := time.NewTimer(b.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, ErrBulkheadFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Do runs fn while holding a slot of the resource, see Acquire.
+func (b *Bulkhead) Do(ctx context.Context, resource string, fn func() error) error {
+	release, err := b.Acquire(ctx, resource)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+// Available returns how many slots of the resource are free. Acquire returns right away while it is above
+// zero, unless other callers take the slots first.
+func (b *Bulkhead) Available(resource string) int {
+	slots := b.resource(resource)
+	return b.limit - len(slots)
+}
@@ -1,57 +1,198 @@
 package reliability
 
 import (
-	"fmt"
+	"errors"
 	"sync"
 	"time"
 )
 
-// CircuitBreaker is a state machine to prevent repeated execution of a failing function.
+// ErrCircuitOpen is returned by CircuitBreaker.Call while the breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ignoredError wraps an error that CircuitBreaker.Call records as neither a success nor a failure.
+type ignoredError struct {
+	err error
+}
+
+func (e ignoredError) Error() string { return e.err.Error() }
+
+func (e ignoredError) Unwrap() error { return e.err }
+
+// Ignore wraps err so that CircuitBreaker.Call records it as neither a success nor a failure, such as for
+// work that was cancelled or had nothing to do. Call returns err itself. Ignore(nil) is nil.
+func Ignore(err error) error {
+	if err == nil {
+		return nil
+	}
+	return ignoredError{err: err}
+}
+
+// State is the state of a CircuitBreaker.
+type State string
+
+const (
+	// StateClosed lets every call through.
+	StateClosed State = "closed"
+	// StateOpen refuses every call until the timeout has passed since the last failure.
+	StateOpen State = "open"
+	// StateHalfOpen lets a few trial calls through, which close the breaker if they succeed and open it
+	// again if any of them fails.
+	StateHalfOpen State = "half-open"
+)
+
+// CircuitBreaker is a state machine to prevent repeated execution of a failing function. It opens after
+// maxFailures failures in a row, and lets trial calls through again once the timeout has passed. The
+// wrapped function runs without holding the breaker's lock, so calls run concurrently.
 type CircuitBreaker struct {
-	failures     int
-	maxFailures  int
-	state        string // "closed", "open", "half-open"
-	lastFailTime time.Time
-	timeout      time.Duration
-	mu           sync.RWMutex
+	maxFailures      int
+	timeout          time.Duration
+	successThreshold int
+	onStateChange    func(from, to State)
+
+	mu           sync.Mutex
+	state        State
+	failures     int       // Failures in a row while closed
+	successes    int       // Successful trial calls while half-open
+	trials       int       // Trial calls in flight while half-open
+	lastFailTime time.Time // When the breaker last opened
+	generation   uint64    // Counts state changes, so calls that began in an earlier state are ignored
 }
 
-// NewCircuitBreaker creates a new CircuitBreaker.
+// NewCircuitBreaker creates a new CircuitBreaker that opens after maxFailures failures in a row and
+// stays open for timeout. A single successful trial call closes it again, see SetSuccessThreshold.
 func NewCircuitBreaker(maxFailures int, timeout time.Duration) *CircuitBreaker {
 	return &CircuitBreaker{
-		maxFailures: maxFailures,
-		timeout:     timeout,
-		state:       "closed",
+		maxFailures:      max(maxFailures, 1),
+		timeout:          timeout,
+		successThreshold: 1,
+		state:            StateClosed,
 	}
 }
 
-// Call executes the given function, applying the circuit breaker logic.
-func (cb *CircuitBreaker) Call(fn func() error) error {
+// SetSuccessThreshold sets how many trial calls must succeed in a row while the breaker is half-open
+// before it closes, which is also how many trial calls it lets through at a time. It must be set before
+// the breaker is used.
+func (cb *CircuitBreaker) SetSuccessThreshold(n int) {
+	cb.successThreshold = max(n, 1)
+}
+
+// OnStateChange sets a function that is called whenever the breaker changes state, for example to log
+// or count its trips. It is called without holding the breaker's lock, and must be set before the
+// breaker is used.
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to State)) {
+	cb.onStateChange = fn
+}
+
+// State returns the current state of the breaker. An open breaker whose timeout has passed is reported
+// as open until the next call makes it half-open.
+func (cb *CircuitBreaker) State() State {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	// Check if circuit is open
-	if cb.state == "open" {
-		if time.Since(cb.lastFailTime) > cb.timeout {
-			cb.state = "half-open"
-			cb.failures = 0
+	return cb.state
+}
+
+// Call executes the given function, applying the circuit breaker logic. It returns ErrCircuitOpen
+// without calling fn while the breaker is open, or while it is half-open and enough trial calls are in
+// flight. Errors fn wraps with Ignore count as neither a success nor a failure.
+func (cb *CircuitBreaker) Call(fn func() error) error {
+	generation, err := cb.before()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if ignored, ok := err.(ignoredError); ok {
+		cb.after(generation, outcomeIgnored)
+		return ignored.err
+	}
+	if err != nil {
+		cb.after(generation, outcomeFailure)
+	} else {
+		cb.after(generation, outcomeSuccess)
+	}
+	return err
+}
+
+// outcome is how a call that was let through ended.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored
+)
+
+// before admits a call and returns the generation it was admitted in.
+func (cb *CircuitBreaker) before() (uint64, error) {
+	cb.mu.Lock()
+	from := cb.state
+	if cb.state == StateOpen && time.Since(cb.lastFailTime) > cb.timeout {
+		cb.setState(StateHalfOpen)
+	}
+	var err error
+	switch cb.state {
+	case StateOpen:
+		err = ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.trials >= cb.successThreshold {
+			err = ErrCircuitOpen
 		} else {
-			return fmt.Errorf("circuit breaker is open")
+			cb.trials++
 		}
 	}
+	generation, to := cb.generation, cb.state
+	cb.mu.Unlock()
 
-	err := fn()
-	if err != nil {
-		cb.failures++
-		cb.lastFailTime = time.Now()
-		if cb.failures >= cb.maxFailures {
-			cb.state = "open"
+	cb.notify(from, to)
+	return generation, err
+}
+
+// after records the outcome of a call admitted in the given generation.
+func (cb *CircuitBreaker) after(generation uint64, result outcome) {
+	cb.mu.Lock()
+	from := cb.state
+	if generation == cb.generation {
+		switch cb.state {
+		case StateClosed:
+			switch result {
+			case outcomeSuccess:
+				cb.failures = 0
+			case outcomeFailure:
+				if cb.failures++; cb.failures >= cb.maxFailures {
+					cb.setState(StateOpen)
+				}
+			}
+		case StateHalfOpen:
+			cb.trials--
+			switch result {
+			case outcomeSuccess:
+				if cb.successes++; cb.successes >= cb.successThreshold {
+					cb.setState(StateClosed)
+				}
+			case outcomeFailure:
+				cb.setState(StateOpen)
+			}
 		}
-		return err
 	}
+	to := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
 
-	// Success - reset
-	cb.failures = 0
-	cb.state = "closed"
-	return nil
+// setState moves the breaker into a state, starting a new generation. The caller must hold cb.mu.
+func (cb *CircuitBreaker) setState(state State) {
+	cb.state = state
+	cb.generation++
+	cb.failures, cb.successes, cb.trials = 0, 0, 0
+	if state == StateOpen {
+		cb.lastFailTime = time.Now()
+	}
+}
+
+// notify calls the state change function if the state changed.
+func (cb *CircuitBreaker) notify(from, to State) {
+	if from != to && cb.onStateChange != nil {
+		cb.onStateChange(from, to)
+	}
 }
@@ -0,0 +1,71 @@
+package reliability
+
+import (
+	"context"
+	"sync"
+)
+
+// Gate holds callers back while it is closed, for example to stop starting new work while a resource
+// runs short. A new Gate is open.
+type Gate struct {
+	mu   sync.Mutex
+	open chan struct{} // Closed while the gate is open
+}
+
+// NewGate creates an open Gate.
+func NewGate() *Gate {
+	open := make(chan struct{})
+	close(open)
+	return &Gate{open: open}
+}
+
+// Close makes Wait block until the gate is opened again.
+func (g *Gate) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open:
+		g.open = make(chan struct{})
+	default: // Already closed
+	}
+}
+
+// Open lets the waiting callers through.
+func (g *Gate) Open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open: // Already open
+	default:
+		close(g.open)
+	}
+}
+
+// IsOpen reports whether Wait returns right away.
+func (g *Gate) IsOpen() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the gate is open or ctx is done, in which case it returns the context's error.
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	open := g.open
+	g.mu.Unlock()
+
+	select {
+	case <-open:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
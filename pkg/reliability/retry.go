@@ -0,0 +1,55 @@
+package reliability
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// permanentError wraps an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+// Permanent marks an error as not worth retrying.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Backoff returns the delay before retrying after the given number of failures: baseDelay, doubled
+// for every failure after the first, with up to 50% random jitter added.
+func Backoff(baseDelay time.Duration, failures int) time.Duration {
+	delay := baseDelay << max(failures-1, 0)
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// Retry calls fn until it succeeds, returns a permanent error, the context is done,
+// or the number of attempts is used up. The delay between attempts starts at baseDelay
+// and doubles after each failure, with up to 50% random jitter added.
+func Retry(ctx context.Context, attempts int, baseDelay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if i == attempts-1 {
+			break
+		}
+
+		select {
+		case <-time.After(Backoff(baseDelay, i+1)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+// Package systemd implements the parts of the systemd service protocol the server uses: readiness and
+// watchdog notifications over $NOTIFY_SOCKET and sockets passed by socket activation. Outside of systemd
+// the environment variables are unset and every function does nothing.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification states understood by systemd.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Notify sends a state to the service manager, such as Ready or "STATUS=...". It reports false without
+// an error when the process was not started by systemd with Type=notify.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:] // Abstract socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a Watchdog notification, or zero if the
+// service has no watchdog.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // Meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+var (
+	activated     map[string]net.Listener
+	activatedErr  error
+	activatedOnce sync.Once
+)
+
+// Listener returns the socket passed by socket activation under the given name, which is the
+// FileDescriptorName= of the socket unit and defaults to the unit's name, such as "render.socket".
+func Listener(name string) (net.Listener, error) {
+	activatedOnce.Do(func() {
+		activated, activatedErr = listeners()
+	})
+	if activatedErr != nil {
+		return nil, activatedErr
+	}
+	lis, ok := activated[name]
+	if !ok {
+		return nil, fmt.Errorf("systemd passed no socket named %q", name)
+	}
+	return lis, nil
+}
+
+// listeners takes over the sockets passed by socket activation. The environment variables are cleared
+// so that they aren't passed on to child processes such as the browser.
+func listeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	result := make(map[string]net.Listener, count)
+	for i := range count {
+		fd := listenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		lis, err := net.FileListener(f)
+		f.Close() // FileListener keeps a close-on-exec duplicate
+		if err != nil {
+			return nil, fmt.Errorf("failed to use socket %s passed by systemd: %w", name, err)
+		}
+		result[name] = lis
+	}
+	return result, nil
+}
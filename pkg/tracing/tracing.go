@@ -0,0 +1,122 @@
+// Package tracing instruments the scraping pipeline with OpenTelemetry spans and exports them over OTLP.
+// Until Setup installs an exporter, spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes recorded on the spans of the pipeline.
+const (
+	Client   = attribute.Key("render.client")
+	JobID    = attribute.Key("render.job.id")
+	Query    = attribute.Key("render.query")
+	PinID    = attribute.Key("render.pin.id")
+	Reason   = attribute.Key("render.job.reason")
+	Bytes    = attribute.Key("render.image.bytes")
+	Delivery = attribute.Key("render.delivery")
+)
+
+// Config configures the OTLP exporter.
+type Config struct {
+	// Endpoint is the host:port of the collector.
+	Endpoint string
+	// Protocol is "grpc" or "http". Empty means gRPC.
+	Protocol string
+	// Insecure disables TLS to the collector.
+	Insecure bool
+	// Headers are sent with every export, e.g. to authenticate with a tracing service.
+	Headers map[string]string
+	// SampleRatio is the fraction of new traces that are recorded. Zero means all of them.
+	SampleRatio float64
+	// ServiceName identifies the server in traces. Empty means "render".
+	ServiceName string
+}
+
+var tracer = otel.Tracer("gopin")
+
+// Setup installs an OTLP exporter as the global tracer provider and the W3C trace context as the
+// propagator. The returned function flushes pending spans and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var client otlptrace.Client
+	switch cfg.Protocol {
+	case "", "grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint), otlptracegrpc.WithHeaders(cfg.Headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		client = otlptracegrpc.NewClient(opts...)
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint), otlptracehttp.WithHeaders(cfg.Headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		client = otlptracehttp.NewClient(opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", cfg.Protocol)
+	}
+
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = "render"
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartFrom starts a span as a child of a span that was carried outside of a context, such as with an image.
+func StartFrom(parent trace.SpanContext, name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := Start(trace.ContextWithSpanContext(context.Background(), parent), name, attrs...)
+	return span
+}
+
+// Fail records an error on a span and marks it as failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Extract returns ctx with the trace context propagated by the caller in HTTP headers or gRPC
+// metadata, which map to the same W3C traceparent header.
+func Extract(ctx context.Context, header map[string][]string) context.Context {
+	carrier := propagation.HeaderCarrier(http.Header(header))
+	if _, ok := header["traceparent"]; ok {
+		// gRPC metadata keys are lower case, which http.Header.Get would not find
+		carrier = propagation.HeaderCarrier{"Traceparent": header["traceparent"], "Tracestate": header["tracestate"]}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
@@ -0,0 +1,28 @@
+package query
+
+import "strings"
+
+// Expand combines every base query with every modifier, turning "cats" and the modifiers "aesthetic"
+// and "grunge" into "cats", "cats aesthetic" and "cats grunge". A modifier that a base query already
+// contains, ignoring case, isn't added to it, and duplicates are left out.
+func Expand(bases, modifiers []string) []string {
+	expanded := make([]string, 0, len(bases)*(len(modifiers)+1))
+	seen := make(map[string]bool)
+	add := func(q string) {
+		if !seen[q] {
+			seen[q] = true
+			expanded = append(expanded, q)
+		}
+	}
+	for _, base := range bases {
+		add(base)
+		for _, mod := range modifiers {
+			mod = strings.TrimSpace(mod)
+			if mod == "" || strings.Contains(strings.ToLower(base), strings.ToLower(mod)) {
+				continue
+			}
+			add(base + " " + mod)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,22 @@
+package query
+
+import "strings"
+
+// Normalize trims a query, lowercases it and collapses its whitespace, so that "Dark  PFP " and
+// "dark pfp" are the same query.
+func Normalize(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// NormalizeAll normalizes queries, leaving out those that are empty and duplicates.
+func NormalizeAll(queries []string) []string {
+	normalized := make([]string, 0, len(queries))
+	seen := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		if q = Normalize(q); q != "" && !seen[q] {
+			seen[q] = true
+			normalized = append(normalized, q)
+		}
+	}
+	return normalized
+}
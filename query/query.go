@@ -1,33 +1,221 @@
 package query
 
 import (
+	"fmt"
 	"math/rand"
+	"slices"
 	"sync"
-	"time"
 )
 
-// Manager manages a list of queries and selects them randomly.
+// MaxWeight is the highest weight of a query. Higher weights count as MaxWeight, and lower ones than 1
+// as 1.
+const MaxWeight = 100
+
+// Order is the order a Manager selects its queries in.
+type Order string
+
+const (
+	// OrderRandom selects the queries randomly, in proportion to their weights.
+	OrderRandom Order = "random"
+	// OrderRoundRobin selects the queries one after another in list order, starting over after the last.
+	OrderRoundRobin Order = "roundrobin"
+	// OrderSequential selects the first query in the list until it is exhausted, so every query is
+	// visited once, in list order.
+	OrderSequential Order = "sequential"
+)
+
+// ParseOrder parses the name of an order. An empty name is OrderRandom.
+func ParseOrder(name string) (Order, error) {
+	switch order := Order(name); order {
+	case "":
+		return OrderRandom, nil
+	case OrderRandom, OrderRoundRobin, OrderSequential:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown query order %q, expected %q, %q or %q", name, OrderRandom, OrderRoundRobin, OrderSequential)
+	}
+}
+
+// Manager manages a list of queries and selects them in its order, randomly by default. In random order
+// every query is selected as many times as its weight, which defaults to 1, before any is selected
+// again. Queries that are exhausted are not selected anymore.
 type Manager struct {
 	queries []string
-	mu      sync.Mutex
+	order   Order
+	// next is the index of the query selected next in round-robin order.
+	next    int
+	weights map[string]int
+	// visited counts the selections of each query in the current round, which ends once every query was
+	// selected as many times as its weight.
+	visited map[string]int
+	// exhausted are the queries that ran out of results, in the order they did.
+	exhausted []string
+	// cycle starts over with the exhausted queries once every query is exhausted.
+	cycle bool
+	mu    sync.Mutex
 }
 
 // NewManager creates a new query manager.
 func NewManager(queries []string) *Manager {
 	return &Manager{
-		queries: queries,
+		queries: slices.Clone(queries),
+		order:   OrderRandom,
+		visited: make(map[string]int),
 	}
 }
 
-// GetRandom returns a random query from the list.
-func (m *Manager) GetRandom() (string, bool) {
+// SetOrder sets the order the queries are selected in.
+func (m *Manager) SetOrder(order Order) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.order = order
+	m.next = 0
+}
+
+// SetWeights sets how many times each query is selected per round in random order. Queries without a
+// weight are selected once.
+func (m *Manager) SetWeights(weights map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.weights = weights
+}
+
+// weight returns the weight of a query. The caller must hold m.mu.
+func (m *Manager) weight(query string) int {
+	return min(max(m.weights[query], 1), MaxWeight)
+}
+
+// NewCyclingManager creates a query manager that never runs out of queries: once every query is
+// exhausted, it starts over with all of them.
+func NewCyclingManager(queries []string) *Manager {
+	m := NewManager(queries)
+	m.cycle = true
+	return m
+}
+
+// remaining returns how many more times a query is selected in the current round. The caller must hold
+// m.mu.
+func (m *Manager) remaining(query string) int {
+	return max(m.weight(query)-m.visited[query], 0)
+}
+
+// Next returns the next query in the manager's order. In random order it prefers the queries that
+// weren't returned as many times as their weight yet in the current round, in proportion to the times
+// they have left. It reports false once there are no queries left.
+func (m *Manager) Next() (string, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if len(m.queries) == 0 && m.cycle && len(m.exhausted) > 0 {
+		m.queries, m.exhausted = m.exhausted, nil
+		m.next = 0
+		clear(m.visited)
+	}
 	if len(m.queries) == 0 {
 		return "", false
 	}
 
-	rand.Seed(time.Now().UnixNano())
-	return m.queries[rand.Intn(len(m.queries))], true
+	switch m.order {
+	case OrderSequential:
+		return m.queries[0], true
+	case OrderRoundRobin:
+		if m.next >= len(m.queries) {
+			m.next = 0
+		}
+		query := m.queries[m.next]
+		m.next++
+		return query, true
+	}
+
+	left := 0
+	for _, q := range m.queries {
+		left += m.remaining(q)
+	}
+	if left == 0 {
+		// Every query was selected as many times as its weight, so a new round starts
+		clear(m.visited)
+		for _, q := range m.queries {
+			left += m.weight(q)
+		}
+	}
+
+	n := rand.Intn(left)
+	query := m.queries[0]
+	for _, query = range m.queries {
+		if n -= m.remaining(query); n < 0 {
+			break
+		}
+	}
+	m.visited[query]++
+	return query, true
+}
+
+// Exhaust takes a query that ran out of results out of the list and reports whether it was present.
+func (m *Manager) Exhaust(query string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.remove(query) {
+		return false
+	}
+	m.exhausted = append(m.exhausted, query)
+	return true
+}
+
+// Exhausted returns the queries that ran out of results, in the order they did.
+func (m *Manager) Exhausted() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.exhausted)
+}
+
+// Queries returns a copy of the list.
+func (m *Manager) Queries() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.queries)
+}
+
+// Add appends queries that are not already in the list and returns how many were added. Queries that
+// were exhausted are added again.
+func (m *Manager) Add(queries ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := 0
+	for _, q := range queries {
+		if !slices.Contains(m.queries, q) {
+			m.queries = append(m.queries, q)
+			m.exhausted = slices.DeleteFunc(m.exhausted, func(e string) bool { return e == q })
+			added++
+		}
+	}
+	return added
+}
+
+// Remove deletes a query from the list and reports whether it was present.
+func (m *Manager) Remove(query string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.remove(query)
+}
+
+// remove deletes a query from the list. The caller must hold m.mu.
+func (m *Manager) remove(query string) bool {
+	for i, q := range m.queries {
+		if q == query {
+			m.queries = append(m.queries[:i], m.queries[i+1:]...)
+			if i < m.next {
+				m.next--
+			}
+			delete(m.visited, query)
+			return true
+		}
+	}
+	return false
 }
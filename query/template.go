@@ -0,0 +1,64 @@
+package query
+
+import (
+	"slices"
+	"strings"
+)
+
+// Fill expands query templates into every combination of the values of their placeholders, turning
+// "{color} aesthetic pfp" with the placeholder color listing "red" and "blue" into "red aesthetic pfp"
+// and "blue aesthetic pfp". Every occurrence of a placeholder in a template takes the same value, and
+// placeholder names are matched ignoring case. Queries without placeholders and placeholders that
+// aren't defined are kept as they are, and duplicates are left out.
+func Fill(queries []string, placeholders map[string][]string) []string {
+	lower := make(map[string][]string, len(placeholders))
+	for name, values := range placeholders {
+		lower[strings.ToLower(name)] = values
+	}
+	placeholders = lower
+
+	filled := make([]string, 0, len(queries))
+	seen := make(map[string]bool)
+	for _, q := range queries {
+		names := placeholderNames(q, placeholders)
+		pairs := make([]string, 0, 2*len(names))
+		var fill func(i int)
+		fill = func(i int) {
+			if i == len(names) {
+				// The values are substituted in a single pass, so placeholders in them are kept as they are
+				if f := strings.NewReplacer(pairs...).Replace(q); !seen[f] {
+					seen[f] = true
+					filled = append(filled, f)
+				}
+				return
+			}
+			for _, value := range placeholders[strings.ToLower(names[i])] {
+				pairs = append(pairs, "{"+names[i]+"}", value)
+				fill(i + 1)
+				pairs = pairs[:len(pairs)-2]
+			}
+		}
+		fill(0)
+	}
+	return filled
+}
+
+// placeholderNames returns the names of the defined placeholders of a query, in the order they first
+// appear. The placeholders are keyed by lowercase name.
+func placeholderNames(q string, placeholders map[string][]string) []string {
+	var names []string
+	for {
+		start := strings.IndexByte(q, '{')
+		if start < 0 {
+			return names
+		}
+		end := strings.IndexByte(q[start:], '}')
+		if end < 0 {
+			return names
+		}
+		if name := q[start+1 : start+end]; len(placeholders[strings.ToLower(name)]) > 0 && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		q = q[start+end+1:]
+	}
+}
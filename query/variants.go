@@ -0,0 +1,111 @@
+package query
+
+import (
+	_ "embed"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var (
+	//go:embed synonyms.txt
+	synonymList string
+	//go:embed translations.txt
+	translationList string
+
+	synonyms     = parseWordlist(synonymList)
+	translations = parseWordlist(translationList)
+)
+
+// parseWordlist reads a wordlist with one group of interchangeable words per line, separated by commas,
+// and maps every word to the other words of its groups. Lines starting with # are comments.
+func parseWordlist(list string) map[string][]string {
+	words := make(map[string][]string)
+	for line := range strings.Lines(list) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var group []string
+		for word := range strings.SplitSeq(line, ",") {
+			if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+				group = append(group, word)
+			}
+		}
+		for _, word := range group {
+			for _, other := range group {
+				if other != word && !slices.Contains(words[word], other) {
+					words[word] = append(words[word], other)
+				}
+			}
+		}
+	}
+	return words
+}
+
+// Variants returns variants of queries that may find pins the queries don't: every query with its last
+// word in the plural or singular, and with any of its words replaced by a synonym or translation from
+// the bundled wordlists. The variants are lowercase, and those that equal a query, ignoring case, and
+// duplicates are left out.
+func Variants(queries []string) []string {
+	known := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		known[strings.Join(strings.Fields(strings.ToLower(q)), " ")] = true
+	}
+
+	var variants []string
+	add := func(words []string, i int, word string) {
+		variant := slices.Clone(words)
+		variant[i] = word
+		if q := strings.Join(variant, " "); !known[q] {
+			known[q] = true
+			variants = append(variants, q)
+		}
+	}
+	for _, q := range queries {
+		words := strings.Fields(strings.ToLower(q))
+		if len(words) == 0 {
+			continue
+		}
+		if last := len(words) - 1; inflectable(words[last]) {
+			add(words, last, inflect(words[last]))
+		}
+		for i, word := range words {
+			for _, other := range synonyms[word] {
+				add(words, i, other)
+			}
+			for _, other := range translations[word] {
+				add(words, i, other)
+			}
+		}
+	}
+	return variants
+}
+
+// inflectable reports whether a word is long enough and made of letters only to be put in the plural or
+// singular.
+func inflectable(word string) bool {
+	return len(word) >= 3 && strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }) < 0
+}
+
+// inflect turns an English noun in the singular into the plural and the other way around, following the
+// regular rules: "cat" and "cats", "puppy" and "puppies", "box" and "boxes".
+func inflect(word string) string {
+	switch {
+	case strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "ches"),
+		strings.HasSuffix(word, "shes"):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"):
+		return word + "es"
+	case strings.HasSuffix(word, "s"):
+		return strings.TrimSuffix(word, "s")
+	case strings.HasSuffix(word, "x"), strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		return word + "es"
+	case strings.HasSuffix(word, "y") && !strings.ContainsRune("aeiou", rune(word[len(word)-2])):
+		return strings.TrimSuffix(word, "y") + "ies"
+	default:
+		return word + "s"
+	}
+}
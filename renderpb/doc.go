@@ -0,0 +1,4 @@
+// Package renderpb contains the protobuf messages and gRPC service definitions of the Render API.
+package renderpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative render.proto
@@ -0,0 +1,581 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: render.proto
+
+package renderpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ScrapeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Queries       []string               `protobuf:"bytes,1,rep,name=queries,proto3" json:"queries,omitempty"`
+	Limit         int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	LimitPerQuery int32                  `protobuf:"varint,3,opt,name=limit_per_query,json=limitPerQuery,proto3" json:"limit_per_query,omitempty"`
+	// "low", "normal" or "high", up to the priority of the client's profile.
+	Priority string `protobuf:"bytes,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	// Shortens the server's maximum job lifetime for the job.
+	MaxLifetime   *durationpb.Duration `protobuf:"bytes,5,opt,name=max_lifetime,json=maxLifetime,proto3" json:"max_lifetime,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScrapeRequest) Reset() {
+	*x = ScrapeRequest{}
+	mi := &file_render_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScrapeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScrapeRequest) ProtoMessage() {}
+
+func (x *ScrapeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScrapeRequest.ProtoReflect.Descriptor instead.
+func (*ScrapeRequest) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ScrapeRequest) GetQueries() []string {
+	if x != nil {
+		return x.Queries
+	}
+	return nil
+}
+
+func (x *ScrapeRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ScrapeRequest) GetLimitPerQuery() int32 {
+	if x != nil {
+		return x.LimitPerQuery
+	}
+	return 0
+}
+
+func (x *ScrapeRequest) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *ScrapeRequest) GetMaxLifetime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxLifetime
+	}
+	return nil
+}
+
+type ScrapeEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*ScrapeEvent_Image
+	//	*ScrapeEvent_Complete
+	//	*ScrapeEvent_Queued
+	Event         isScrapeEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScrapeEvent) Reset() {
+	*x = ScrapeEvent{}
+	mi := &file_render_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScrapeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScrapeEvent) ProtoMessage() {}
+
+func (x *ScrapeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScrapeEvent.ProtoReflect.Descriptor instead.
+func (*ScrapeEvent) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ScrapeEvent) GetEvent() isScrapeEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ScrapeEvent) GetImage() *Image {
+	if x != nil {
+		if x, ok := x.Event.(*ScrapeEvent_Image); ok {
+			return x.Image
+		}
+	}
+	return nil
+}
+
+func (x *ScrapeEvent) GetComplete() *Complete {
+	if x != nil {
+		if x, ok := x.Event.(*ScrapeEvent_Complete); ok {
+			return x.Complete
+		}
+	}
+	return nil
+}
+
+func (x *ScrapeEvent) GetQueued() *Queued {
+	if x != nil {
+		if x, ok := x.Event.(*ScrapeEvent_Queued); ok {
+			return x.Queued
+		}
+	}
+	return nil
+}
+
+type isScrapeEvent_Event interface {
+	isScrapeEvent_Event()
+}
+
+type ScrapeEvent_Image struct {
+	Image *Image `protobuf:"bytes,1,opt,name=image,proto3,oneof"`
+}
+
+type ScrapeEvent_Complete struct {
+	Complete *Complete `protobuf:"bytes,2,opt,name=complete,proto3,oneof"`
+}
+
+type ScrapeEvent_Queued struct {
+	Queued *Queued `protobuf:"bytes,3,opt,name=queued,proto3,oneof"`
+}
+
+func (*ScrapeEvent_Image) isScrapeEvent_Event() {}
+
+func (*ScrapeEvent_Complete) isScrapeEvent_Event() {}
+
+func (*ScrapeEvent_Queued) isScrapeEvent_Event() {}
+
+type Queued struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Position      int32                  `protobuf:"varint,1,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Queued) Reset() {
+	*x = Queued{}
+	mi := &file_render_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Queued) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Queued) ProtoMessage() {}
+
+func (x *Queued) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Queued.ProtoReflect.Descriptor instead.
+func (*Queued) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Queued) GetPosition() int32 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type Image struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Url           string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	Query         string                 `protobuf:"bytes,3,opt,name=query,proto3" json:"query,omitempty"`
+	Hash          uint64                 `protobuf:"varint,4,opt,name=hash,proto3" json:"hash,omitempty"`
+	Data          []byte                 `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`
+	Crc32         uint32                 `protobuf:"varint,6,opt,name=crc32,proto3" json:"crc32,omitempty"`
+	Sha256        string                 `protobuf:"bytes,7,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Image) Reset() {
+	*x = Image{}
+	mi := &file_render_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Image) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Image) ProtoMessage() {}
+
+func (x *Image) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Image.ProtoReflect.Descriptor instead.
+func (*Image) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Image) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *Image) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Image) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *Image) GetHash() uint64 {
+	if x != nil {
+		return x.Hash
+	}
+	return 0
+}
+
+func (x *Image) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Image) GetCrc32() uint32 {
+	if x != nil {
+		return x.Crc32
+	}
+	return 0
+}
+
+func (x *Image) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type QueryTotals struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sent          int32                  `protobuf:"varint,1,opt,name=sent,proto3" json:"sent,omitempty"`
+	Deduped       int32                  `protobuf:"varint,2,opt,name=deduped,proto3" json:"deduped,omitempty"`
+	Failed        int32                  `protobuf:"varint,3,opt,name=failed,proto3" json:"failed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryTotals) Reset() {
+	*x = QueryTotals{}
+	mi := &file_render_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryTotals) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryTotals) ProtoMessage() {}
+
+func (x *QueryTotals) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryTotals.ProtoReflect.Descriptor instead.
+func (*QueryTotals) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *QueryTotals) GetSent() int32 {
+	if x != nil {
+		return x.Sent
+	}
+	return 0
+}
+
+func (x *QueryTotals) GetDeduped() int32 {
+	if x != nil {
+		return x.Deduped
+	}
+	return 0
+}
+
+func (x *QueryTotals) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+type Complete struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Reason        string                  `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	Sent          int32                   `protobuf:"varint,2,opt,name=sent,proto3" json:"sent,omitempty"`
+	Deduped       int32                   `protobuf:"varint,3,opt,name=deduped,proto3" json:"deduped,omitempty"`
+	Failed        int32                   `protobuf:"varint,4,opt,name=failed,proto3" json:"failed,omitempty"`
+	Queries       map[string]*QueryTotals `protobuf:"bytes,5,rep,name=queries,proto3" json:"queries,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Complete) Reset() {
+	*x = Complete{}
+	mi := &file_render_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Complete) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Complete) ProtoMessage() {}
+
+func (x *Complete) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Complete.ProtoReflect.Descriptor instead.
+func (*Complete) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Complete) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Complete) GetSent() int32 {
+	if x != nil {
+		return x.Sent
+	}
+	return 0
+}
+
+func (x *Complete) GetDeduped() int32 {
+	if x != nil {
+		return x.Deduped
+	}
+	return 0
+}
+
+func (x *Complete) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *Complete) GetQueries() map[string]*QueryTotals {
+	if x != nil {
+		return x.Queries
+	}
+	return nil
+}
+
+var File_render_proto protoreflect.FileDescriptor
+
+const file_render_proto_rawDesc = "" +
+	"\n" +
+	"\frender.proto\x12\trender.v1\x1a\x1egoogle/protobuf/duration.proto\"\xc1\x01\n" +
+	"\rScrapeRequest\x12\x18\n" +
+	"\aqueries\x18\x01 \x03(\tR\aqueries\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12&\n" +
+	"\x0flimit_per_query\x18\x03 \x01(\x05R\rlimitPerQuery\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\tR\bpriority\x12<\n" +
+	"\fmax_lifetime\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\vmaxLifetime\"\xa0\x01\n" +
+	"\vScrapeEvent\x12(\n" +
+	"\x05image\x18\x01 \x01(\v2\x10.render.v1.ImageH\x00R\x05image\x121\n" +
+	"\bcomplete\x18\x02 \x01(\v2\x13.render.v1.CompleteH\x00R\bcomplete\x12+\n" +
+	"\x06queued\x18\x03 \x01(\v2\x11.render.v1.QueuedH\x00R\x06queuedB\a\n" +
+	"\x05event\"$\n" +
+	"\x06Queued\x12\x1a\n" +
+	"\bposition\x18\x01 \x01(\x05R\bposition\"\x97\x01\n" +
+	"\x05Image\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x14\n" +
+	"\x05query\x18\x03 \x01(\tR\x05query\x12\x12\n" +
+	"\x04hash\x18\x04 \x01(\x04R\x04hash\x12\x12\n" +
+	"\x04data\x18\x05 \x01(\fR\x04data\x12\x14\n" +
+	"\x05crc32\x18\x06 \x01(\rR\x05crc32\x12\x16\n" +
+	"\x06sha256\x18\a \x01(\tR\x06sha256\"S\n" +
+	"\vQueryTotals\x12\x12\n" +
+	"\x04sent\x18\x01 \x01(\x05R\x04sent\x12\x18\n" +
+	"\adeduped\x18\x02 \x01(\x05R\adeduped\x12\x16\n" +
+	"\x06failed\x18\x03 \x01(\x05R\x06failed\"\xf8\x01\n" +
+	"\bComplete\x12\x16\n" +
+	"\x06reason\x18\x01 \x01(\tR\x06reason\x12\x12\n" +
+	"\x04sent\x18\x02 \x01(\x05R\x04sent\x12\x18\n" +
+	"\adeduped\x18\x03 \x01(\x05R\adeduped\x12\x16\n" +
+	"\x06failed\x18\x04 \x01(\x05R\x06failed\x12:\n" +
+	"\aqueries\x18\x05 \x03(\v2 .render.v1.Complete.QueriesEntryR\aqueries\x1aR\n" +
+	"\fQueriesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.render.v1.QueryTotalsR\x05value:\x028\x012K\n" +
+	"\x06Render\x12A\n" +
+	"\vStartScrape\x12\x18.render.v1.ScrapeRequest\x1a\x16.render.v1.ScrapeEvent0\x01B\x10Z\x0egopin/renderpbb\x06proto3"
+
+var (
+	file_render_proto_rawDescOnce sync.Once
+	file_render_proto_rawDescData []byte
+)
+
+func file_render_proto_rawDescGZIP() []byte {
+	file_render_proto_rawDescOnce.Do(func() {
+		file_render_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_render_proto_rawDesc), len(file_render_proto_rawDesc)))
+	})
+	return file_render_proto_rawDescData
+}
+
+var file_render_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_render_proto_goTypes = []any{
+	(*ScrapeRequest)(nil),       // 0: render.v1.ScrapeRequest
+	(*ScrapeEvent)(nil),         // 1: render.v1.ScrapeEvent
+	(*Queued)(nil),              // 2: render.v1.Queued
+	(*Image)(nil),               // 3: render.v1.Image
+	(*QueryTotals)(nil),         // 4: render.v1.QueryTotals
+	(*Complete)(nil),            // 5: render.v1.Complete
+	nil,                         // 6: render.v1.Complete.QueriesEntry
+	(*durationpb.Duration)(nil), // 7: google.protobuf.Duration
+}
+var file_render_proto_depIdxs = []int32{
+	7, // 0: render.v1.ScrapeRequest.max_lifetime:type_name -> google.protobuf.Duration
+	3, // 1: render.v1.ScrapeEvent.image:type_name -> render.v1.Image
+	5, // 2: render.v1.ScrapeEvent.complete:type_name -> render.v1.Complete
+	2, // 3: render.v1.ScrapeEvent.queued:type_name -> render.v1.Queued
+	6, // 4: render.v1.Complete.queries:type_name -> render.v1.Complete.QueriesEntry
+	4, // 5: render.v1.Complete.QueriesEntry.value:type_name -> render.v1.QueryTotals
+	0, // 6: render.v1.Render.StartScrape:input_type -> render.v1.ScrapeRequest
+	1, // 7: render.v1.Render.StartScrape:output_type -> render.v1.ScrapeEvent
+	7, // [7:8] is the sub-list for method output_type
+	6, // [6:7] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_render_proto_init() }
+func file_render_proto_init() {
+	if File_render_proto != nil {
+		return
+	}
+	file_render_proto_msgTypes[1].OneofWrappers = []any{
+		(*ScrapeEvent_Image)(nil),
+		(*ScrapeEvent_Complete)(nil),
+		(*ScrapeEvent_Queued)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_render_proto_rawDesc), len(file_render_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_render_proto_goTypes,
+		DependencyIndexes: file_render_proto_depIdxs,
+		MessageInfos:      file_render_proto_msgTypes,
+	}.Build()
+	File_render_proto = out.File
+	file_render_proto_goTypes = nil
+	file_render_proto_depIdxs = nil
+}
@@ -0,0 +1,144 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: render.proto
+
+package renderpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Render_StartScrape_FullMethodName = "/render.v1.Render/StartScrape"
+)
+
+// RenderClient is the client API for Render service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Render streams unique Pinterest images to authenticated clients.
+//
+// Clients authenticate with the "x-server-name" and "x-password" metadata
+// keys, using the same credentials as the WebSocket API, or with a JWT in
+// "authorization: Bearer <token>" metadata.
+type RenderClient interface {
+	// StartScrape runs a scrape job and streams every image the client hasn't
+	// seen yet, followed by a single Complete event when the job ends. While
+	// the job waits for a running job to finish, Queued events report its
+	// position in the queue.
+	StartScrape(ctx context.Context, in *ScrapeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScrapeEvent], error)
+}
+
+type renderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRenderClient(cc grpc.ClientConnInterface) RenderClient {
+	return &renderClient{cc}
+}
+
+func (c *renderClient) StartScrape(ctx context.Context, in *ScrapeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScrapeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Render_ServiceDesc.Streams[0], Render_StartScrape_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ScrapeRequest, ScrapeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Render_StartScrapeClient = grpc.ServerStreamingClient[ScrapeEvent]
+
+// RenderServer is the server API for Render service.
+// All implementations must embed UnimplementedRenderServer
+// for forward compatibility.
+//
+// Render streams unique Pinterest images to authenticated clients.
+//
+// Clients authenticate with the "x-server-name" and "x-password" metadata
+// keys, using the same credentials as the WebSocket API, or with a JWT in
+// "authorization: Bearer <token>" metadata.
+type RenderServer interface {
+	// StartScrape runs a scrape job and streams every image the client hasn't
+	// seen yet, followed by a single Complete event when the job ends. While
+	// the job waits for a running job to finish, Queued events report its
+	// position in the queue.
+	StartScrape(*ScrapeRequest, grpc.ServerStreamingServer[ScrapeEvent]) error
+	mustEmbedUnimplementedRenderServer()
+}
+
+// UnimplementedRenderServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRenderServer struct{}
+
+func (UnimplementedRenderServer) StartScrape(*ScrapeRequest, grpc.ServerStreamingServer[ScrapeEvent]) error {
+	return status.Error(codes.Unimplemented, "method StartScrape not implemented")
+}
+func (UnimplementedRenderServer) mustEmbedUnimplementedRenderServer() {}
+func (UnimplementedRenderServer) testEmbeddedByValue()                {}
+
+// UnsafeRenderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RenderServer will
+// result in compilation errors.
+type UnsafeRenderServer interface {
+	mustEmbedUnimplementedRenderServer()
+}
+
+func RegisterRenderServer(s grpc.ServiceRegistrar, srv RenderServer) {
+	// If the following call panics, it indicates UnimplementedRenderServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Render_ServiceDesc, srv)
+}
+
+func _Render_StartScrape_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScrapeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RenderServer).StartScrape(m, &grpc.GenericServerStream[ScrapeRequest, ScrapeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Render_StartScrapeServer = grpc.ServerStreamingServer[ScrapeEvent]
+
+// Render_ServiceDesc is the grpc.ServiceDesc for Render service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Render_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "render.v1.Render",
+	HandlerType: (*RenderServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StartScrape",
+			Handler:       _Render_StartScrape_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "render.proto",
+}
@@ -2,11 +2,16 @@ package scraper
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"gopin/config"
 	"gopin/pinterest"
 	"gopin/pkg/imaging"
 	"gopin/pkg/logger"
+	"gopin/pkg/reliability"
+	"gopin/pkg/tracing"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -15,16 +20,48 @@ import (
 	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	_ "golang.org/x/image/webp"
 )
 
+// ErrScrapeFailed is wrapped by the error of the last image of a scrape that failed, such as when the
+// browser crashed, rather than ran out of results.
+var ErrScrapeFailed = errors.New("scrape failed")
+
+// Defaults of the circuit breaker of each provider, used when none are configured.
+const (
+	DefaultBreakerFailures = 3
+	DefaultBreakerTimeout  = time.Minute
+)
+
+// Defaults of the browser limit, used when none are configured.
+const (
+	DefaultMaxBrowsers         = 5
+	DefaultBrowserQueueTimeout = 5 * time.Minute
+)
+
 // ScrapedImage contains the raw data and hash of a scraped image.
+// If Err is set, the image could not be downloaded or decoded and only ID and Query are valid, or it
+// wraps ErrScrapeFailed and only Query is valid.
 type ScrapedImage struct {
-	Data []byte
-	Hash uint64
-	ID   string
+	Data  []byte
+	Hash  uint64
+	ID    string
+	URL   string
+	Query string
+	// Title, Description and AltText describe the pin, if the provider has them.
+	Title       string
+	Description string
+	AltText     string
+	Err         error
+	// Seen is set if the pin was skipped without downloading it, as the client was already sent it.
+	// Only ID, URL, Query and the pin's description are valid then.
+	Seen bool
+	// Trace is the span of the image's download, which spans of its delivery are children of.
+	Trace trace.SpanContext
 }
 
 // Scraper is a service that scrapes images from Pinterest.
@@ -33,22 +70,46 @@ type Scraper struct {
 	log        *logger.Logger
 	client     *pinterest.Client
 	httpClient *http.Client
-	userAgents []string
+	userAgents atomic.Pointer[[]string]
 }
 
-// New creates a new Scraper service.
-func New(numWorkers int, log *logger.Logger, userAgents []string) (*Scraper, error) {
-	return &Scraper{
+// New creates a new Scraper service. The delays between Pinterest requests, its circuit breaker and the
+// browser limit are taken from cfg.
+func New(numWorkers int, log *logger.Logger, cfg config.ScrapingConfig) (*Scraper, error) {
+	minDelay, maxDelay := cfg.Delays(pinterest.Provider)
+	client, err := pinterest.NewClient(log, cfg.UserAgents, minDelay, maxDelay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s delays: %w", pinterest.Provider, err)
+	}
+	client.SetCircuitBreaker(newBreaker(cfg.CircuitBreaker))
+	if cfg.MaxBrowsers >= 0 {
+		client.SetBrowserLimit(reliability.NewBulkhead(cmp.Or(cfg.MaxBrowsers, DefaultMaxBrowsers), cfg.BrowserQueueTimeout.Or(DefaultBrowserQueueTimeout)))
+	}
+	s := &Scraper{
 		numWorkers: numWorkers,
 		log:        log,
-		client:     pinterest.NewClient(log, userAgents),
+		client:     client,
 		httpClient: &http.Client{Timeout: 20 * time.Second},
-		userAgents: userAgents,
-	}, nil
+	}
+	s.userAgents.Store(&cfg.UserAgents)
+	return s, nil
 }
 
-// Scrape starts a continuous scraping process for a given query.
-func (s *Scraper) Scrape(ctx context.Context, query string) (<-chan ScrapedImage, error) {
+// Reload applies the delays and user agents of cfg to running and future scrapes. The circuit breaker
+// and browser limit keep the settings the scraper was created with. Nothing changes if cfg is invalid.
+func (s *Scraper) Reload(cfg config.ScrapingConfig) error {
+	minDelay, maxDelay := cfg.Delays(pinterest.Provider)
+	if err := s.client.SetDelays(minDelay, maxDelay); err != nil {
+		return fmt.Errorf("invalid %s delays: %w", pinterest.Provider, err)
+	}
+	s.userAgents.Store(&cfg.UserAgents)
+	return nil
+}
+
+// Scrape starts a continuous scraping process for a given query. Pins that skip, if it is set, reports
+// true for are not downloaded but passed on with Seen set. It is given the pins without their image.
+func (s *Scraper) Scrape(ctx context.Context, query string, skip func(pin ScrapedImage) bool) (<-chan ScrapedImage, error) {
+	log := logger.FromContext(ctx, s.log)
 	pinterestImageChan, err := s.client.Scrape(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error starting pinterest scrape: %w", err)
@@ -71,21 +132,19 @@ func (s *Scraper) Scrape(ctx context.Context, query string) (<-chan ScrapedImage
 						return // Channel closed
 					}
 
-					imageData, err := s.downloadImage(imgResult.URL)
-					if err != nil {
-						s.log.Warn("Failed to download image", "url", imgResult.URL, "error", err)
-						continue
+					var result ScrapedImage
+					pin := newPin(query, imgResult)
+					if imgResult.Err != nil {
+						result = ScrapedImage{Query: query, Err: fmt.Errorf("%w: %w", ErrScrapeFailed, imgResult.Err)}
+					} else if skip != nil && skip(pin) {
+						pin.Seen = true
+						result = pin
+					} else {
+						result = s.fetch(ctx, log, query, imgResult)
 					}
 
-					imgDec, _, err := image.Decode(bytes.NewReader(imageData))
-					if err != nil {
-						s.log.Warn("Failed to decode image", "url", imgResult.URL, "error", err)
-						continue
-					}
-
-					hash := imaging.DHash(imgDec)
 					select {
-					case scrapedImageChan <- ScrapedImage{Data: imageData, Hash: hash, ID: imgResult.ID}:
+					case scrapedImageChan <- result:
 					case <-ctx.Done():
 						return
 					}
@@ -103,14 +162,52 @@ func (s *Scraper) Scrape(ctx context.Context, query string) (<-chan ScrapedImage
 	return scrapedImageChan, nil
 }
 
-func (s *Scraper) downloadImage(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// newPin returns a pin found for a query without its image.
+func newPin(query string, found pinterest.ScrapeResult) ScrapedImage {
+	return ScrapedImage{
+		ID:          found.ID,
+		URL:         found.URL,
+		Query:       query,
+		Title:       found.Title,
+		Description: found.Description,
+		AltText:     found.AltText,
+	}
+}
+
+// fetch downloads and hashes an image found for a query.
+func (s *Scraper) fetch(ctx context.Context, log *logger.Logger, query string, found pinterest.ScrapeResult) ScrapedImage {
+	ctx, span := tracing.Start(ctx, "download", tracing.Query.String(query), tracing.PinID.String(found.ID))
+	defer span.End()
+
+	result := newPin(query, found)
+	result.Trace = span.SpanContext()
+	imageData, err := s.downloadImage(ctx, found.URL)
+	if err != nil {
+		log.Warn("Failed to download image", "url", found.URL, "error", err)
+		result.Err = err
+	} else if imgDec, _, err := image.Decode(bytes.NewReader(imageData)); err != nil {
+		log.Warn("Failed to decode image", "url", found.URL, "error", err)
+		result.Err = err
+	} else {
+		result.Data = imageData
+		result.Hash = imaging.DHash(imgDec)
+		span.SetAttributes(tracing.Bytes.Int(len(imageData)))
+	}
+	if result.Err != nil {
+		tracing.Fail(span, result.Err)
+	}
+	return result
+}
+
+func (s *Scraper) downloadImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set a random user agent
-	userAgent := s.userAgents[rand.Intn(len(s.userAgents))]
+	userAgents := *s.userAgents.Load()
+	userAgent := userAgents[rand.Intn(len(userAgents))]
 	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Referer", "https://www.pinterest.com/")
 
@@ -132,5 +229,47 @@ func (s *Scraper) downloadImage(url string) ([]byte, error) {
 	return imageData, nil
 }
 
+// OnBlocked sets a function that is called with the provider and query when a provider refuses a
+// search, as it does when it blocks the scraper. It must be set before scraping starts.
+func (s *Scraper) OnBlocked(fn func(provider, query string)) {
+	s.client.OnBlocked(func(query string) { fn(pinterest.Provider, query) })
+}
+
+// OnBreakerStateChange sets a function that is called with the provider whenever its circuit breaker
+// changes state, such as when it opens after the provider's scrapes kept failing. It must be set before
+// scraping starts.
+func (s *Scraper) OnBreakerStateChange(fn func(provider string, from, to reliability.State)) {
+	if cb := s.client.CircuitBreaker(); cb != nil {
+		cb.OnStateChange(func(from, to reliability.State) { fn(pinterest.Provider, from, to) })
+	}
+}
+
+// OnRelated sets a function that is called with the provider, the query, the related searches the
+// provider suggests for it and the hashtags of its pins as search results come in. It must be set
+// before scraping starts.
+func (s *Scraper) OnRelated(fn func(provider, query string, related, hashtags []string)) {
+	s.client.OnRelated(func(query string, related, hashtags []string) { fn(pinterest.Provider, query, related, hashtags) })
+}
+
+// PauseLaunches makes new scrapes wait before launching a browser, for example while memory runs short.
+func (s *Scraper) PauseLaunches() {
+	s.client.PauseLaunches()
+}
+
+// ResumeLaunches lets the scrapes held back by PauseLaunches launch their browsers.
+func (s *Scraper) ResumeLaunches() {
+	s.client.ResumeLaunches()
+}
+
+// newBreaker creates the circuit breaker of a provider, or nil if cfg disables it.
+func newBreaker(cfg config.CircuitBreakerConfig) *reliability.CircuitBreaker {
+	if cfg.MaxFailures < 0 {
+		return nil
+	}
+	cb := reliability.NewCircuitBreaker(cmp.Or(cfg.MaxFailures, DefaultBreakerFailures), cfg.Timeout.Or(DefaultBreakerTimeout))
+	cb.SetSuccessThreshold(cfg.SuccessThreshold)
+	return cb
+}
+
 // Close is no longer needed as each scrape job manages its own browser instance.
 func (s *Scraper) Close() {}
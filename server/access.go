@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"gopin/config"
+	"gopin/pkg/logger"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for automatic bans and authentication backoff.
+const (
+	DefaultBanThreshold   = 10
+	DefaultBanWindow      = time.Minute
+	DefaultBanDuration    = 15 * time.Minute
+	DefaultAuthBackoff    = time.Second
+	DefaultAuthBackoffMax = 5 * time.Minute
+
+	// authFreeAttempts is the number of failed attempts allowed before backoff starts.
+	authFreeAttempts = 3
+	// maxAccessRecords caps the ban and backoff records each, so that failures under made-up client
+	// names or from many IPs can't grow them without bound. The oldest records are dropped first.
+	maxAccessRecords = 10000
+)
+
+// accessControl decides which client IPs may reach the server, based on the configured
+// allow and deny lists and temporary bans for repeated authentication failures. It also
+// slows down password guessing by backing off failed authentication per IP and client name.
+// Backoff only ever refuses failed attempts, so that nobody can lock a client out by failing
+// under its name; valid credentials are always accepted.
+type accessControl struct {
+	log *logger.Logger
+
+	// mu guards the rules, which change on reload, as well as the records.
+	mu          sync.Mutex
+	allow       []netip.Prefix
+	deny        []netip.Prefix
+	threshold   int // zero disables bans
+	window      time.Duration
+	banDuration time.Duration
+	backoff     time.Duration
+	backoffMax  time.Duration
+	failures    map[netip.Addr]*failureRecord
+	backoffs    map[string]*backoffRecord // keyed by "ip:<addr>" and "client:<name>"
+}
+
+// failureRecord counts the authentication failures of one IP within the current window.
+type failureRecord struct {
+	count       int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+// backoffRecord tracks the consecutive authentication failures of an IP or client name.
+type backoffRecord struct {
+	failures int
+	last     time.Time
+	next     time.Time // no attempts are accepted before this time
+}
+
+func newAccessControl(cfg config.AccessConfig, log *logger.Logger) (*accessControl, error) {
+	ac := &accessControl{
+		threshold:   cfg.BanThreshold,
+		window:      cfg.BanWindow.Or(DefaultBanWindow),
+		banDuration: cfg.BanDuration.Or(DefaultBanDuration),
+		backoff:     cfg.AuthBackoff.Or(DefaultAuthBackoff),
+		backoffMax:  cfg.AuthBackoffMax.Or(DefaultAuthBackoffMax),
+		log:         log,
+		failures:    make(map[netip.Addr]*failureRecord),
+		backoffs:    make(map[string]*backoffRecord),
+	}
+
+	var err error
+	if ac.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("invalid access.allow: %w", err)
+	}
+	if ac.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("invalid access.deny: %w", err)
+	}
+
+	switch {
+	case ac.threshold == 0:
+		ac.threshold = DefaultBanThreshold
+	case ac.threshold < 0:
+		ac.threshold = 0
+	}
+	return ac, nil
+}
+
+// parsePrefixes parses CIDR ranges. Bare addresses are treated as single-address ranges.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// update replaces the rules with those of a freshly configured access control, keeping the failure records.
+func (ac *accessControl) update(rules *accessControl) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.allow = rules.allow
+	ac.deny = rules.deny
+	ac.threshold = rules.threshold
+	ac.window = rules.window
+	ac.banDuration = rules.banDuration
+	ac.backoff = rules.backoff
+	ac.backoffMax = rules.backoffMax
+}
+
+// check returns why an IP may not connect, or an empty string if it may.
+func (ac *accessControl) check(addr netip.Addr) string {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if containsAddr(ac.deny, addr) {
+		return "denied"
+	}
+	if len(ac.allow) > 0 && !containsAddr(ac.allow, addr) {
+		return "not allowed"
+	}
+	if rec, ok := ac.failures[addr]; ok && time.Now().Before(rec.bannedUntil) {
+		return "banned"
+	}
+	return ""
+}
+
+// backoffKeys returns the keys under which the attempts of an IP and client name are tracked.
+func backoffKeys(addr netip.Addr, clientName string) []string {
+	keys := []string{"ip:" + addr.String()}
+	if clientName != "" {
+		keys = append(keys, "client:"+clientName)
+	}
+	return keys
+}
+
+// retryAfter returns how long an IP or client name must wait before its next failed authentication attempt is
+// answered as such rather than refused as too many.
+func (ac *accessControl) retryAfter(addr netip.Addr, clientName string) time.Duration {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range backoffKeys(addr, clientName) {
+		if rec, ok := ac.backoffs[key]; ok {
+			wait = max(wait, time.Until(rec.next))
+		}
+	}
+	return wait
+}
+
+// authFailed records an authentication failure: it logs a security event, backs off further attempts of the
+// IP and client name exponentially, and bans the IP once it reaches the ban threshold within the window.
+func (ac *accessControl) authFailed(addr netip.Addr, clientName string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	var failures int
+	var wait time.Duration
+	for _, key := range backoffKeys(addr, clientName) {
+		rec, ok := ac.backoffs[key]
+		if !ok || now.Sub(rec.last) > ac.backoffMax {
+			if !ok && len(ac.backoffs) >= maxAccessRecords {
+				ac.dropOldestBackoff()
+			}
+			rec = &backoffRecord{}
+			ac.backoffs[key] = rec
+		}
+		rec.failures++
+		rec.last = now
+		if extra := rec.failures - authFreeAttempts; extra > 0 {
+			d := min(ac.backoff<<min(extra-1, 30), ac.backoffMax)
+			rec.next = now.Add(d)
+			wait = max(wait, d)
+		}
+		failures = max(failures, rec.failures)
+	}
+	ac.log.Warn("Security event: authentication failed", "event", "auth_failure", "ip", addr, "client", clientName, "failures", failures, "backoff", wait)
+
+	if ac.threshold == 0 || !addr.IsValid() {
+		return
+	}
+	rec, ok := ac.failures[addr]
+	if !ok || now.Sub(rec.windowStart) > ac.window {
+		if !ok && len(ac.failures) >= maxAccessRecords {
+			ac.dropOldestFailure(now)
+		}
+		rec = &failureRecord{windowStart: now}
+		ac.failures[addr] = rec
+	}
+	rec.count++
+	if rec.count >= ac.threshold && now.After(rec.bannedUntil) {
+		rec.bannedUntil = now.Add(ac.banDuration)
+		ac.log.Warn("Security event: banned IP after repeated authentication failures", "event", "ip_banned", "ip", addr, "failures", rec.count, "duration", ac.banDuration)
+	}
+}
+
+// dropOldestBackoff drops the backoff record whose last failure is the oldest. The caller must hold ac.mu.
+func (ac *accessControl) dropOldestBackoff() {
+	var oldest string
+	var last time.Time
+	for key, rec := range ac.backoffs {
+		if oldest == "" || rec.last.Before(last) {
+			oldest, last = key, rec.last
+		}
+	}
+	delete(ac.backoffs, oldest)
+}
+
+// dropOldestFailure drops the ban record of the IP that isn't banned and whose window started first. If
+// every IP is banned, it drops the ban that ends first. The caller must hold ac.mu.
+func (ac *accessControl) dropOldestFailure(now time.Time) {
+	var oldest, soonest netip.Addr
+	var start, end time.Time
+	for addr, rec := range ac.failures {
+		if now.Before(rec.bannedUntil) {
+			if !soonest.IsValid() || rec.bannedUntil.Before(end) {
+				soonest, end = addr, rec.bannedUntil
+			}
+		} else if !oldest.IsValid() || rec.windowStart.Before(start) {
+			oldest, start = addr, rec.windowStart
+		}
+	}
+	if oldest.IsValid() {
+		delete(ac.failures, oldest)
+	} else {
+		delete(ac.failures, soonest)
+	}
+}
+
+// authSucceeded forgets the failures of an IP and client name after they authenticate.
+func (ac *accessControl) authSucceeded(addr netip.Addr, clientName string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for _, key := range backoffKeys(addr, clientName) {
+		delete(ac.backoffs, key)
+	}
+	if rec, ok := ac.failures[addr]; ok && time.Now().After(rec.bannedUntil) {
+		delete(ac.failures, addr)
+	}
+}
+
+// prune drops the ban records whose window and ban have both passed and the backoff records that expired.
+func (ac *accessControl) prune() {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	for addr, rec := range ac.failures {
+		if now.Sub(rec.windowStart) > ac.window && now.After(rec.bannedUntil) {
+			delete(ac.failures, addr)
+		}
+	}
+	for key, rec := range ac.backoffs {
+		if now.Sub(rec.last) > ac.backoffMax && now.After(rec.next) {
+			delete(ac.backoffs, key)
+		}
+	}
+}
+
+// startPruning periodically prunes failure records until ctx is cancelled.
+func (ac *accessControl) startPruning(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ac.prune()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// remoteIP returns the IP of a network address such as http.Request.RemoteAddr.
+func remoteIP(addr string) netip.Addr {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, _ := netip.ParseAddr(host)
+	return ip.Unmap()
+}
+
+// accessMiddleware rejects requests from IPs that are denied, not allowed or banned before any other handling.
+func (s *Server) accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r.RemoteAddr)
+		if reason := s.access.check(ip); reason != "" {
+			s.log.Warn("Rejected request by IP", "ip", ip, "reason", reason, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
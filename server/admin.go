@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"gopin/manager"
+	"gopin/storage"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClientInfo describes a client with open WebSocket connections or running jobs.
+type ClientInfo struct {
+	Name        string           `json:"name"`
+	Connections []ConnectionInfo `json:"connections"`
+	Jobs        []JobInfo        `json:"jobs"`
+}
+
+// ConnectionInfo describes an open WebSocket connection and what has been delivered over it.
+type ConnectionInfo struct {
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	ImagesSent int64     `json:"imagesSent"`
+	BytesSent  int64     `json:"bytesSent"`
+}
+
+// JobInfo describes a running job.
+type JobInfo struct {
+	ID string `json:"id"`
+	// Client owns the job, or is "topic:<name>" for the shared scrape of a topic.
+	Client  string    `json:"client"`
+	Started time.Time `json:"started"`
+	// Query is the query being scraped, if any.
+	Query string `json:"query,omitempty"`
+	Sent  int    `json:"sent"`
+	// Limit is the number of images the job delivers. It is omitted for topics, which run until stopped.
+	Limit int `json:"limit,omitempty"`
+	// Queued is the job's position in the queue while it waits for a running job to finish.
+	Queued   int    `json:"queued,omitempty"`
+	Priority string `json:"priority"`
+	// Expires is when the job is stopped for reaching jobs.maxLifetime or the lifetime it asked for.
+	Expires time.Time `json:"expires,omitzero"`
+	// Exhausted are the queries that ran out of results, which the job doesn't scrape again.
+	Exhausted []string `json:"exhausted,omitempty"`
+}
+
+// ClientsResponse is the body of GET /api/admin/clients.
+type ClientsResponse struct {
+	Clients []ClientInfo `json:"clients"`
+}
+
+// JobsResponse is the body of GET /api/admin/jobs.
+type JobsResponse struct {
+	Jobs []JobInfo `json:"jobs"`
+}
+
+// DisconnectResponse is the body of DELETE /api/admin/clients/{name}.
+type DisconnectResponse struct {
+	Connections int `json:"connections"`
+	Jobs        int `json:"jobs"`
+}
+
+func newJobInfo(info manager.JobInfo) JobInfo {
+	return JobInfo{
+		ID:        info.ID,
+		Client:    info.Client,
+		Started:   info.Started,
+		Query:     info.Query,
+		Sent:      info.Sent,
+		Limit:     info.Limit,
+		Queued:    info.Position,
+		Priority:  info.Priority.String(),
+		Expires:   info.Expires,
+		Exhausted: info.Exhausted,
+	}
+}
+
+// handleListClients lists the clients with open WebSocket connections or running jobs, in order of
+// their first connection or job.
+func (s *Server) handleListClients() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var clients []ClientInfo
+		index := make(map[string]int)
+		client := func(name string) *ClientInfo {
+			i, ok := index[name]
+			if !ok {
+				i = len(clients)
+				index[name] = i
+				clients = append(clients, ClientInfo{Name: name, Connections: []ConnectionInfo{}, Jobs: []JobInfo{}})
+			}
+			return &clients[i]
+		}
+
+		for _, conn := range s.connections.list() {
+			c := client(conn.clientName)
+			c.Connections = append(c.Connections, ConnectionInfo{
+				ID:         conn.id,
+				RemoteAddr: conn.remoteAddr,
+				Connected:  conn.connected,
+				ImagesSent: conn.images.Load(),
+				BytesSent:  conn.bytes.Load(),
+			})
+		}
+		for _, job := range s.scrapeManager.Jobs() {
+			if strings.HasPrefix(job.Client, "topic:") {
+				continue // Topics belong to every subscriber, and are listed by /api/admin/jobs
+			}
+			c := client(job.Client)
+			c.Jobs = append(c.Jobs, newJobInfo(job))
+		}
+
+		if clients == nil {
+			clients = []ClientInfo{}
+		}
+		writeAPIJSON(w, http.StatusOK, ClientsResponse{Clients: clients})
+	}
+}
+
+// handleListJobs lists the running jobs and topic scrapes, oldest first.
+func (s *Server) handleListJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs := []JobInfo{}
+		for _, job := range s.scrapeManager.Jobs() {
+			jobs = append(jobs, newJobInfo(job))
+		}
+		writeAPIJSON(w, http.StatusOK, JobsResponse{Jobs: jobs})
+	}
+}
+
+// handleStopJob stops a running job. Its client receives the completion summary with the reason "stopped".
+func (s *Server) handleStopJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !s.scrapeManager.StopJob(id) {
+			writeAPIError(w, http.StatusNotFound, "job not found")
+			return
+		}
+
+		p := principalFrom(r.Context())
+		s.log.Warn("Admin stopped job", "job", id, "client", p.Name)
+		s.audit(p, storage.AuditStopJob, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDisconnectClient closes every WebSocket connection of a client and stops its jobs, which also
+// ends its gRPC streams.
+func (s *Server) handleDisconnectClient() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		var resp DisconnectResponse
+		for _, conn := range s.connections.list() {
+			if conn.clientName == name {
+				conn.socket.WriteClose(CloseDisconnected, []byte("disconnected by admin"))
+				resp.Connections++
+			}
+		}
+		resp.Jobs = s.scrapeManager.StopClient(name)
+		if resp.Connections == 0 && resp.Jobs == 0 {
+			writeAPIError(w, http.StatusNotFound, "client is not connected")
+			return
+		}
+
+		p := principalFrom(r.Context())
+		s.log.Warn("Admin disconnected client", "target", name, "connections", resp.Connections, "jobs", resp.Jobs, "client", p.Name)
+		s.audit(p, storage.AuditDisconnect, name)
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleCleanup removes history and audit entries past their configured age right away, instead of
+// waiting for the next scheduled cleanup.
+func (s *Server) handleCleanup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		retention := s.retention()
+		if !retention.Expires() {
+			writeAPIError(w, http.StatusConflict, "neither database.maxAge nor a profile's historyMaxAge is set")
+			return
+		}
+
+		p := principalFrom(r.Context())
+		s.log.Info("Admin triggered database cleanup", "client", p.Name)
+		auditMaxAge := s.current().config.Database.AuditMaxAge.Or(defaultAuditMaxAge)
+		if err := s.cleanupDatabase(retention, auditMaxAge); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "database cleanup failed")
+			return
+		}
+		s.audit(p, storage.AuditCleanup, "")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleCompact compacts the database file, returning the free pages left by cleanups to the file system.
+func (s *Server) handleCompact() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compacter, ok := s.db.(storage.Compacter)
+		if !ok {
+			writeAPIError(w, http.StatusNotImplemented, "the database can't be compacted")
+			return
+		}
+
+		p := principalFrom(r.Context())
+		s.log.Info("Admin triggered database compaction", "client", p.Name)
+		start := time.Now()
+		result, err := compacter.Compact()
+		if err != nil {
+			s.log.Error("Database compaction failed", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "database compaction failed")
+			return
+		}
+		s.log.Info("Database compacted", "before", result.Before, "after", result.After, "took", time.Since(start))
+		s.audit(p, storage.AuditCompact, fmt.Sprintf("%d -> %d bytes", result.Before, result.After))
+		writeAPIJSON(w, http.StatusOK, result)
+	}
+}
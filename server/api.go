@@ -0,0 +1,491 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopin/config"
+	"gopin/manager"
+	"gopin/pkg/tracing"
+	"gopin/query"
+	"gopin/sink"
+	"gopin/storage"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiJobRetention is how long a finished REST job and its images are kept for polling.
+	apiJobRetention = time.Hour
+	// apiImagesPerPage is the maximum number of images returned by a single images request.
+	apiImagesPerPage = 50
+	// apiJobBufferSize is the number of bytes of images a REST job buffers until they are polled. Once it
+	// is full, the job waits for the client to poll.
+	apiJobBufferSize = 64 << 20
+)
+
+// JobRequest is the body of a POST /api/jobs request.
+type JobRequest struct {
+	Queries       []string `json:"queries"`
+	Limit         int      `json:"limit"`
+	LimitPerQuery int      `json:"limitPerQuery,omitempty"`
+	// Webhook, if set, receives every image instead of buffering it for polling.
+	Webhook *sink.WebhookConfig `json:"webhook,omitempty"`
+	// Sink, if set, names a configured server-side sink such as "s3:datasets" that receives every image.
+	Sink string `json:"sink,omitempty"`
+	// Preview jobs don't mark their images as seen, leaving that to POST /api/images/{hash}/seen.
+	Preview bool `json:"preview,omitempty"`
+	// Priority is "low", "normal" or "high", up to the priority of the client's profile.
+	Priority string `json:"priority,omitempty"`
+	// MaxLifetime shortens jobs.maxLifetime for the job.
+	MaxLifetime config.Duration `json:"maxLifetime,omitempty"`
+	// Expand combines the queries with the configured scraping.modifiers.
+	Expand bool `json:"expand,omitempty"`
+	// Exclude drops the pins whose title, description or alt text contains any of these keywords.
+	Exclude []string `json:"exclude,omitempty"`
+	// Weights scrape some queries more often than others, overriding scraping.queryWeights.
+	Weights map[string]int `json:"weights,omitempty"`
+	// QueryOrder is "random", the default, "roundrobin" or "sequential".
+	QueryOrder string `json:"queryOrder,omitempty"`
+	// Variants scrape plurals, synonyms and translations of the queries once they are exhausted.
+	Variants bool `json:"variants,omitempty"`
+}
+
+// JobResponse describes a REST job and its progress.
+type JobResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Position is the job's place in the queue while its status is "queued".
+	Position int              `json:"position,omitempty"`
+	Created  time.Time        `json:"created"`
+	Summary  *CompleteMessage `json:"summary"`
+}
+
+// APIImage is an image buffered for a REST job. Data is base64 encoded in JSON.
+type APIImage struct {
+	Seq int `json:"seq"`
+	ImageMessage
+	Data []byte `json:"data"`
+}
+
+// APIError is the body of every failed REST request.
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// ImagesResponse is a page of images of a REST job.
+type ImagesResponse struct {
+	Status string     `json:"status"`
+	Images []APIImage `json:"images"`
+	Next   int        `json:"next"`
+}
+
+// apiJob is a job started through the REST API whose images are buffered until they are polled.
+type apiJob struct {
+	id         string
+	job        *manager.ScrapeJob
+	clientName string
+	created    time.Time
+	sink       sink.Sink
+	preview    bool
+
+	mu       sync.Mutex
+	images   []APIImage // Images not polled yet, the first following polled
+	polled   int        // Sequence number of the last image the client polled past, which is dropped
+	buffered int        // Bytes of image data in images
+	summary  *CompleteMessage
+	finished time.Time
+	// updated is closed and replaced whenever an image is added or the job finishes.
+	updated chan struct{}
+}
+
+// apiJobStore holds the REST jobs of all clients.
+type apiJobStore struct {
+	jobs map[string]*apiJob
+	mu   sync.Mutex
+}
+
+func newAPIJobStore() *apiJobStore {
+	return &apiJobStore{jobs: make(map[string]*apiJob)}
+}
+
+// add stores a job and drops finished jobs whose retention has passed.
+func (st *apiJobStore) add(job *apiJob) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, j := range st.jobs {
+		j.mu.Lock()
+		expired := !j.finished.IsZero() && time.Since(j.finished) > apiJobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(st.jobs, id)
+		}
+	}
+	st.jobs[job.id] = job
+}
+
+// get returns a job if it belongs to the given client.
+func (st *apiJobStore) get(id, clientName string) (*apiJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok || job.clientName != clientName {
+		return nil, false
+	}
+	return job, true
+}
+
+// response describes the job's current state.
+func (j *apiJob) response() JobResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	resp := JobResponse{
+		ID:      j.id,
+		Status:  j.status(),
+		Created: j.created,
+		Summary: j.summary.clone(),
+	}
+	if resp.Status == "queued" {
+		resp.Position = j.job.Position()
+	}
+	return resp
+}
+
+// notify wakes everyone waiting for updates of the job. The caller must hold j.mu.
+func (j *apiJob) notify() {
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// status returns "queued", "running" or "complete". The caller must hold j.mu.
+func (j *apiJob) status() string {
+	if !j.finished.IsZero() {
+		return "complete"
+	}
+	if j.job.Position() > 0 {
+		return "queued"
+	}
+	return "running"
+}
+
+// collect buffers the unseen images of a job until it ends.
+func (s *Server) collect(aj *apiJob, job *manager.ScrapeJob) {
+	log := s.log.With("job", aj.id)
+	for img := range job.Images() {
+		seen, err := alreadySeen(s.db, aj.clientName, img)
+		if err != nil {
+			log.Error("Error checking if image was seen", "error", err, "client", aj.clientName)
+			continue
+		}
+
+		aj.mu.Lock()
+		if seen {
+			aj.summary.Deduped++
+			aj.summary.query(img.Query).Deduped++
+			aj.mu.Unlock()
+			continue
+		}
+		aj.mu.Unlock()
+
+		// The job ends once its client is out of quota, the images still buffered are not delivered
+		if job.QuotaExceeded(storage.Usage{}) {
+			continue
+		}
+
+		if aj.sink != nil {
+			span := startSendSpan(img, "sink")
+			err := aj.sink.Send(s.ctx, sink.Image{ScrapedImage: img, Client: aj.clientName, JobID: aj.id})
+			endSpan(span, err)
+			if err != nil {
+				log.Error("Error delivering image to sink", "error", err, "client", aj.clientName)
+				aj.mu.Lock()
+				aj.summary.Failed++
+				aj.summary.query(img.Query).Failed++
+				aj.mu.Unlock()
+				continue
+			}
+		}
+
+		if aj.sink == nil && !s.awaitRoom(aj, job, len(img.Data)) {
+			log.Warn("Stopping REST job, its client stopped polling", "client", aj.clientName)
+			job.Stop()
+		}
+
+		aj.mu.Lock()
+		aj.summary.Sent++
+		aj.summary.query(img.Query).Sent++
+		if aj.sink == nil {
+			meta := cacheImage(s.images, img, aj.clientName)
+			aj.images = append(aj.images, APIImage{
+				Seq:          aj.polled + len(aj.images) + 1,
+				ImageMessage: meta,
+				Data:         img.Data,
+			})
+			aj.buffered += len(img.Data)
+			aj.notify()
+		}
+		aj.mu.Unlock()
+
+		s.scrapeManager.RecordUsage(aj.clientName, 1, int64(len(img.Data)))
+
+		if aj.preview {
+			continue
+		}
+		if err := s.db.MarkImageAsSeen(aj.clientName, img.Hash, img.ID); err != nil {
+			log.Error("Error marking image as seen", "error", err, "client", aj.clientName)
+		}
+	}
+
+	aj.mu.Lock()
+	defer aj.mu.Unlock()
+	aj.summary.finish(job.Reason(), job.Failed())
+	aj.finished = time.Now()
+	recordJob(s.db, log, JobKindREST, aj.clientName, job, aj.summary)
+	aj.notify()
+	log.Info("API job complete", "client", aj.clientName, "reason", aj.summary.Reason, "sent", aj.summary.Sent)
+
+	if aj.sink != nil {
+		summary := sink.Summary{
+			Client:  aj.clientName,
+			JobID:   aj.id,
+			Reason:  aj.summary.Reason,
+			Sent:    aj.summary.Sent,
+			Deduped: aj.summary.Deduped,
+			Failed:  aj.summary.Failed,
+		}
+		go func() {
+			if err := aj.sink.Complete(s.ctx, summary); err != nil {
+				log.Error("Error reporting job completion to sink", "error", err, "client", aj.clientName)
+			}
+		}()
+	}
+}
+
+// handleCreateJob starts a new REST job.
+func (s *Server) handleCreateJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r.Context())
+		clientName := p.Name
+
+		var req JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid job request")
+			return
+		}
+		opts := p.jobOptions(req.Queries, req.Limit, req.LimitPerQuery)
+		if len(opts.Queries) == 0 || opts.Limit <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "queries and a positive limit are required")
+			return
+		}
+		if req.Expand {
+			opts.Modifiers = s.current().config.Scraping.Modifiers
+		}
+		opts.Exclude = req.Exclude
+		opts.QueryWeights = req.Weights
+		if err := validateWeights(req.Weights); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		order, err := query.ParseOrder(req.QueryOrder)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.QueryOrder = order
+		opts.Variants = req.Variants
+		if err := p.checkQueryJob(opts.Limit); err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if msg := s.blockedQuery(p, opts.Queries); msg != "" {
+			writeAPIError(w, http.StatusForbidden, msg)
+			return
+		}
+		priority, err := p.priority(req.Priority)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Priority = priority
+		opts.MaxLifetime = s.current().jobLifetime(time.Duration(req.MaxLifetime))
+
+		var jobSink sink.Sink
+		if req.Sink != "" {
+			if err := p.checkSink(req.Sink); err != nil {
+				writeAPIError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			configured, ok := s.sinks[req.Sink]
+			if !ok {
+				writeAPIError(w, http.StatusBadRequest, "unknown sink "+req.Sink)
+				return
+			}
+			jobSink = configured
+		} else if req.Webhook != nil {
+			webhook, err := s.current().clientWebhook(*req.Webhook)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			jobSink = webhook
+		}
+
+		if s.memory.overloaded() {
+			writeAPIError(w, http.StatusServiceUnavailable, errOverloaded)
+			return
+		}
+		if !s.drainer.begin() {
+			writeAPIError(w, http.StatusServiceUnavailable, errDraining)
+			return
+		}
+
+		span := startRequestSpan(r.Header, "POST /api/jobs", p)
+		opts.Trace = span.SpanContext()
+		job := s.scrapeManager.Submit(clientName, opts)
+		endSpan(span, nil, tracing.JobID.String(job.ID()))
+		aj := &apiJob{
+			id:         job.ID(),
+			job:        job,
+			clientName: clientName,
+			created:    time.Now(),
+			sink:       jobSink,
+			preview:    req.Preview,
+			summary:    &CompleteMessage{Queries: make(map[string]*QueryTotals)},
+			updated:    make(chan struct{}),
+		}
+		s.apiJobs.add(aj)
+		go func() {
+			defer s.drainer.end()
+			s.collect(aj, job)
+		}()
+
+		s.log.Info("Started API job", "client", clientName, "job", aj.id, "queryCount", len(req.Queries), "limit", opts.Limit)
+		s.audit(p, storage.AuditScrape, auditQueries(req.Queries, opts.Limit))
+		recordConnect(s.db, s.log, clientName)
+		w.Header().Set("Location", "/api/jobs/"+aj.id)
+		writeAPIJSON(w, http.StatusCreated, aj.response())
+	}
+}
+
+// handleGetJob reports the state of a REST job.
+func (s *Server) handleGetJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aj, ok := s.apiJobs.get(r.PathValue("id"), principalFrom(r.Context()).Name)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "job not found")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, aj.response())
+	}
+}
+
+// awaitRoom waits until the job's buffer has room for size more bytes, which the client frees by polling.
+// It returns early once the job or the server stops, and reports false if the client hasn't polled for
+// apiJobRetention, so the job can be stopped.
+func (s *Server) awaitRoom(aj *apiJob, job *manager.ScrapeJob, size int) bool {
+	timeout := time.NewTimer(apiJobRetention)
+	defer timeout.Stop()
+	for {
+		aj.mu.Lock()
+		full := len(aj.images) > 0 && aj.buffered+size > apiJobBufferSize
+		updated := aj.updated
+		aj.mu.Unlock()
+		if !full {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-job.Done():
+			return true
+		case <-s.ctx.Done():
+			return true
+		case <-timeout.C:
+			return false
+		}
+	}
+}
+
+// drop forgets the images up to a sequence number, which the client has polled. The caller must hold j.mu.
+func (j *apiJob) drop(seq int) {
+	n := min(seq-j.polled, len(j.images))
+	if n <= 0 {
+		return
+	}
+	for _, img := range j.images[:n] {
+		j.buffered -= len(img.Data)
+	}
+	clear(j.images[:n])
+	j.images = j.images[n:]
+	j.polled += n
+	j.notify()
+}
+
+// handleJobImages returns the images of a REST job that come after the given sequence number.
+func (s *Server) handleJobImages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aj, ok := s.apiJobs.get(r.PathValue("id"), principalFrom(r.Context()).Name)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "job not found")
+			return
+		}
+
+		after := 0
+		if v := r.URL.Query().Get("after"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeAPIError(w, http.StatusBadRequest, "after must be a non-negative integer")
+				return
+			}
+			after = n
+		}
+
+		aj.mu.Lock()
+		after = min(after, aj.polled+len(aj.images))
+		aj.drop(after)
+		resp := ImagesResponse{Status: aj.status(), Images: []APIImage{}, Next: max(after, aj.polled)}
+		if start := resp.Next - aj.polled; start < len(aj.images) {
+			end := min(start+apiImagesPerPage, len(aj.images))
+			resp.Images = append(resp.Images, aj.images[start:end]...)
+			resp.Next = aj.polled + end
+		}
+		aj.mu.Unlock()
+
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
+
+// clientWebhook creates the webhook a REST job asked for. Only the hosts in delivery.webhookHosts are
+// allowed if it lists any, and the webhook may only post to public addresses unless its host is listed.
+func (st *settings) clientWebhook(cfg sink.WebhookConfig) (*sink.Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("webhook url must be an http or https url")
+	}
+	hosts := st.config.Delivery.WebhookHosts
+	listed := slices.ContainsFunc(hosts, func(host string) bool { return strings.EqualFold(host, u.Hostname()) })
+	if len(hosts) > 0 && !listed {
+		return nil, fmt.Errorf("webhook host %q is not allowed", u.Hostname())
+	}
+	cfg.PublicOnly = !listed
+	return sink.NewWebhook(cfg)
+}
+
+// writeAPIJSON writes v as a JSON response with the given status code.
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes a JSON error response.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, APIError{Error: message})
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"gopin/pkg/logger"
+	"gopin/storage"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAuditMaxAge is how long audit entries are kept unless configured otherwise.
+	defaultAuditMaxAge = 90 * 24 * time.Hour
+	// maxAuditEntries caps the entries returned by a single audit request.
+	maxAuditEntries = 500
+)
+
+// AuditResponse is a page of the audit log, newest first. Next is passed as before to get the following page.
+type AuditResponse struct {
+	Entries []storage.AuditEntry `json:"entries"`
+	Next    uint64               `json:"next,omitempty"`
+}
+
+// recordAudit appends an action of a client to the audit log.
+func recordAudit(db storage.Audit, log *logger.Logger, clientName string, addr netip.Addr, action, detail string) {
+	entry := storage.AuditEntry{
+		Time:   time.Now(),
+		Client: clientName,
+		Action: action,
+		Detail: detail,
+	}
+	if addr.IsValid() {
+		entry.Addr = addr.String()
+	}
+	if err := db.AddAuditEntry(entry); err != nil && !isReadOnly(err) {
+		log.Error("Failed to write audit entry", "error", err, "client", clientName, "action", action)
+	}
+}
+
+// audit appends an action of an authenticated client to the audit log.
+func (s *Server) audit(p *principal, action, detail string) {
+	recordAudit(s.db, s.log, p.Name, p.Addr, action, detail)
+}
+
+// audit appends an action of a WebSocket client to the audit log.
+func (c *wsHandler) audit(p *principal, action, detail string) {
+	recordAudit(c.db, c.log, p.Name, p.Addr, action, detail)
+}
+
+// auditQueries describes a scrape request for the audit log.
+func auditQueries(queries []string, limit int) string {
+	return fmt.Sprintf("limit=%d queries=%s", limit, strings.Join(queries, ", "))
+}
+
+// handleAudit returns the audit log, optionally filtered by client and action.
+func (s *Server) handleAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := storage.AuditFilter{
+			Client: q.Get("client"),
+			Action: q.Get("action"),
+			Limit:  100,
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			filter.Limit = min(n, maxAuditEntries)
+		}
+		if v := q.Get("before"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "before must be a sequence number")
+				return
+			}
+			filter.Before = n
+		}
+
+		entries, err := s.db.ListAuditEntries(filter)
+		if err != nil {
+			s.log.Error("Failed to read audit log", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to read audit log")
+			return
+		}
+		resp := AuditResponse{Entries: entries}
+		if len(entries) == filter.Limit {
+			resp.Next = entries[len(entries)-1].Seq
+		}
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gopin/config"
+	"gopin/pkg/credential"
+	"gopin/storage"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles a client can have, from least to most privileged. Clients without a configured role are scrapers.
+const (
+	// RoleReadOnly may only read job results, images and its status.
+	RoleReadOnly = "read-only"
+	// RoleScraper may additionally run scrapes and subscribe to topics.
+	RoleScraper = "scraper"
+	// RoleAdmin may additionally clear histories and use admin endpoints.
+	RoleAdmin = "admin"
+)
+
+// How long and how many successful password and API key checks are remembered, so that clients which
+// authenticate every request don't pay for bcrypt each time.
+const (
+	verifiedTTL  = time.Minute
+	verifiedSize = 10000
+)
+
+var roleRank = map[string]int{RoleReadOnly: 1, RoleScraper: 2, RoleAdmin: 3}
+
+// validateRoles checks that every configured role exists.
+func validateRoles(roles map[string]string) error {
+	for client, role := range roles {
+		if _, ok := roleRank[role]; !ok {
+			return fmt.Errorf("unknown role %q for client %q", role, client)
+		}
+	}
+	return nil
+}
+
+// SourceQueries is the token source that allows jobs with ad-hoc queries. Topics are allowed
+// with "topic:<name>" and every source with "*".
+const SourceQueries = "queries"
+
+// principal is an authenticated client together with the scopes it is restricted to.
+type principal struct {
+	Name string
+	Role string
+	// Credential is "password" or "key:<id>" for clients that authenticated with a rotatable credential.
+	Credential string
+	// Addr is the IP the client connected from.
+	Addr netip.Addr
+	// MaxLimit caps the limit of every job the client starts. Zero means no cap.
+	MaxLimit int
+	// Sources lists where the client may get images from. Nil means everywhere.
+	Sources []string
+	// Profile holds the defaults and filters configured for the client.
+	Profile config.ProfileConfig
+}
+
+// checkRole reports an error if the principal lacks the privileges of a role.
+func (p *principal) checkRole(role string) error {
+	if roleRank[p.Role] < roleRank[role] {
+		return fmt.Errorf("requires the %s role", role)
+	}
+	return nil
+}
+
+// checkLimit reports an error if a job limit exceeds the principal's cap.
+func (p *principal) checkLimit(limit int) error {
+	if p.MaxLimit > 0 && limit > p.MaxLimit {
+		return fmt.Errorf("limit %d exceeds the maximum of %d", limit, p.MaxLimit)
+	}
+	return nil
+}
+
+// checkSource reports an error if the principal may not get images from a source.
+func (p *principal) checkSource(source string) error {
+	if p.Sources == nil || slices.Contains(p.Sources, "*") || slices.Contains(p.Sources, source) {
+		return nil
+	}
+	return fmt.Errorf("not allowed to use %s", source)
+}
+
+// checkSink reports an error if the principal may not have a job's images delivered to a sink. Sinks
+// write to the operator's storage and channels, so unlike other sources they are only allowed when
+// granted, as "sink:<type>:<name>" or "*".
+func (p *principal) checkSink(name string) error {
+	source := "sink:" + name
+	if slices.Contains(p.Sources, "*") || slices.Contains(p.Sources, source) {
+		return nil
+	}
+	return fmt.Errorf("not allowed to use %s", source)
+}
+
+// checkQueryJob reports an error if the principal may not start a job with ad-hoc queries and the given limit.
+func (p *principal) checkQueryJob(limit int) error {
+	if err := p.checkRole(RoleScraper); err != nil {
+		return err
+	}
+	if err := p.checkSource(SourceQueries); err != nil {
+		return err
+	}
+	return p.checkLimit(limit)
+}
+
+// principalKey is the context key under which the authenticated principal is stored.
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the principal stored by the authentication middleware or interceptor.
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	if p == nil {
+		return &principal{}
+	}
+	return p
+}
+
+// tokenClaims are the claims of a JWT bearer token. The subject is the client name.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Role     string   `json:"role,omitempty"`
+	MaxLimit int      `json:"max_limit,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+}
+
+// jwtVerifier validates bearer tokens against the configured keys.
+type jwtVerifier struct {
+	keys   []jwtKey
+	parser *jwt.Parser
+}
+
+type jwtKey struct {
+	id  string
+	key any // []byte for HMAC secrets, a crypto public key otherwise
+}
+
+// newJWTVerifier loads the configured keys. It returns nil if JWT authentication is not configured.
+func newJWTVerifier(cfg config.JWTConfig) (*jwtVerifier, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+
+	v := &jwtVerifier{}
+	for _, k := range cfg.Keys {
+		switch {
+		case k.Secret != "" && k.PublicKeyFile == "":
+			v.keys = append(v.keys, jwtKey{id: k.ID, key: []byte(k.Secret)})
+		case k.PublicKeyFile != "" && k.Secret == "":
+			key, err := loadPublicKey(k.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load JWT key %q: %w", k.ID, err)
+			}
+			v.keys = append(v.keys, jwtKey{id: k.ID, key: key})
+		default:
+			return nil, fmt.Errorf("JWT key %q needs exactly one of secret and publicKeyFile", k.ID)
+		}
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// loadPublicKey reads a PEM encoded public key.
+func loadPublicKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verify validates a token and returns the principal it describes.
+func (v *jwtVerifier) verify(token string) (*principal, error) {
+	claims := &tokenClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	if _, ok := roleRank[claims.Role]; claims.Role != "" && !ok {
+		return nil, fmt.Errorf("token has unknown role %q", claims.Role)
+	}
+	return &principal{Name: claims.Subject, Role: claims.Role, MaxLimit: claims.MaxLimit, Sources: claims.Sources}, nil
+}
+
+// keyFunc returns the keys that may have signed a token: those matching its "kid" header, if any,
+// and the signing method's key type.
+func (v *jwtVerifier) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	_, hmac := t.Method.(*jwt.SigningMethodHMAC)
+
+	set := jwt.VerificationKeySet{}
+	for _, k := range v.keys {
+		if kid != "" && k.id != kid {
+			continue
+		}
+		if _, secret := k.key.([]byte); secret != hmac {
+			continue
+		}
+		set.Keys = append(set.Keys, k.key)
+	}
+	if len(set.Keys) == 0 {
+		return nil, errors.New("no matching key")
+	}
+	return set, nil
+}
+
+// authenticate resolves the principal of a request from a verified client certificate, a bearer token
+// in the Authorization header or the "token" query parameter, or the X-Server-Name and X-Password headers.
+func (s *Server) authenticate(r *http.Request) (*principal, bool) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return s.certPrincipal(r.TLS)
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token != "" {
+		return s.verifyToken(token)
+	}
+	return s.checkCredentials(r.Header.Get("X-Server-Name"), r.Header.Get("X-Password"), false)
+}
+
+// verifyToken validates a JWT bearer token.
+func (s *Server) verifyToken(token string) (*principal, bool) {
+	verifier := s.current().jwt
+	if verifier == nil {
+		return nil, false
+	}
+	p, err := verifier.verify(token)
+	if err != nil {
+		s.log.Warn("Rejected bearer token", "error", err)
+		return nil, false
+	}
+	if p.Role == "" {
+		p.Role = s.roleOf(p.Name)
+	}
+	return p, true
+}
+
+// checkCredentials validates a server name and password pair. The password is either the client's
+// password, as rotated in the database or else configured, or one of the client's API keys. If
+// cachedOnly is set, only a password verified within verifiedTTL is accepted, without comparing it
+// against its hash.
+func (s *Server) checkCredentials(serverName, password string, cachedOnly bool) (*principal, bool) {
+	verify := s.verified.Check
+	if cachedOnly {
+		verify = s.verified.Cached
+	}
+	if id, secret, ok := credential.ParseKey(password); ok {
+		key, err := s.db.GetAPIKey(id)
+		if err != nil {
+			s.log.Error("Failed to look up API key", "error", err)
+			return nil, false
+		}
+		if key == nil || key.Client != serverName || !key.Active() || !verify(serverName, key.Hash, secret) {
+			return nil, false
+		}
+		role := key.Role
+		if role == "" {
+			role = s.roleOf(serverName)
+		}
+		return &principal{Name: serverName, Role: role, Credential: "key:" + id}, true
+	}
+
+	// Removing a client from the config revokes its password, even if it rotated it
+	configured, exists := s.current().config.Credentials[serverName]
+	if !exists {
+		return nil, false
+	}
+	stored, err := s.rotatedPassword(serverName, configured)
+	if err != nil {
+		s.log.Error("Failed to look up password", "error", err)
+		return nil, false
+	}
+	var ok bool
+	if stored != nil {
+		ok = verify(serverName, stored.Hash, password) ||
+			(stored.Previous != "" && time.Now().Before(stored.PreviousExpires) && verify(serverName, stored.Previous, password))
+	} else {
+		ok = verify(serverName, configured, password)
+	}
+	if !ok {
+		return nil, false
+	}
+	return &principal{Name: serverName, Role: s.roleOf(serverName), Credential: "password"}, true
+}
+
+// rotatedPassword returns the rotated password of a client, or nil if it has none or the configured
+// password changed since it rotated, in which case the rotated password is deleted. Passwords rotated
+// before the configured password was recorded with them are taken to belong to the current one.
+func (s *Server) rotatedPassword(clientName, configured string) (*storage.StoredPassword, error) {
+	stored, err := s.db.GetPassword(clientName)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	if stored.Configured == "" {
+		if stored.Configured, err = credential.HashPassword(configured); err == nil {
+			err = s.db.SetPassword(clientName, *stored)
+		}
+		if err != nil {
+			s.log.Error("Failed to record the configured password of a rotated password", "error", err, "client", clientName)
+		}
+		return stored, nil
+	}
+	if s.verified.Check(clientName, stored.Configured, configured) {
+		return stored, nil
+	}
+	s.log.Info("Dropped rotated password, the configured password changed", "client", clientName)
+	if err := s.db.DeletePassword(clientName); err != nil {
+		s.log.Error("Failed to delete rotated password", "error", err, "client", clientName)
+	}
+	return nil, nil
+}
+
+// dropRotatedPasswords deletes the rotated passwords of the clients whose configured password was
+// removed or changed between two configs.
+func (s *Server) dropRotatedPasswords(old, cfg *config.Config) {
+	for name, password := range old.Credentials {
+		if current, ok := cfg.Credentials[name]; ok && current == password {
+			continue
+		}
+		if err := s.db.DeletePassword(name); err != nil {
+			s.log.Error("Failed to delete rotated password", "error", err, "client", name)
+		}
+	}
+}
+
+// roleOf returns the configured role of a client.
+func (s *Server) roleOf(clientName string) string {
+	if role, ok := s.current().config.Auth.Roles[clientName]; ok {
+		return role
+	}
+	return RoleScraper
+}
+
+// requireRole rejects authenticated requests whose principal lacks the privileges of a role.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := principalFrom(r.Context()).checkRole(role); err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
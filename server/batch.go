@@ -0,0 +1,105 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopin/pkg/imaging"
+	"gopin/scraper"
+	"gopin/storage"
+)
+
+// manifestEntry describes a single image inside a zip batch.
+type manifestEntry struct {
+	File   string `json:"file"`
+	Pin    string `json:"pin"`
+	Hash   string `json:"hash"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// zipBatch buffers images until they are packed into a zip archive.
+type zipBatch struct {
+	size   int
+	images []scraper.ScrapedImage
+}
+
+func newZipBatch(size int) *zipBatch {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	return &zipBatch{size: size}
+}
+
+// add buffers an image and reports whether the batch is full.
+func (b *zipBatch) add(img scraper.ScrapedImage) bool {
+	b.images = append(b.images, img)
+	return len(b.images) >= b.size
+}
+
+// contains reports whether an image with the given hash is already buffered.
+func (b *zipBatch) contains(hash uint64) bool {
+	for _, img := range b.images {
+		if img.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// len returns the number of buffered images.
+func (b *zipBatch) len() int {
+	return len(b.images)
+}
+
+// usage returns the quota usage of the buffered images, which is recorded once the batch is sent.
+func (b *zipBatch) usage() storage.Usage {
+	u := storage.Usage{Images: len(b.images)}
+	for _, img := range b.images {
+		u.Bytes += int64(len(img.Data))
+	}
+	return u
+}
+
+// flush packs the buffered images and a manifest.json into a zip archive and resets the batch.
+func (b *zipBatch) flush() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	manifest := make([]manifestEntry, 0, len(b.images))
+	for _, img := range b.images {
+		name := img.ID + imaging.Extension(img.Data)
+		// Images are already compressed, so store them as-is.
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zip entry: %w", err)
+		}
+		if _, err := w.Write(img.Data); err != nil {
+			return nil, fmt.Errorf("failed to write zip entry: %w", err)
+		}
+		meta := newImageMessage(img)
+		manifest = append(manifest, manifestEntry{
+			File:   name,
+			Pin:    meta.Pin,
+			Hash:   meta.Hash,
+			Size:   meta.Size,
+			SHA256: meta.SHA256,
+		})
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize zip: %w", err)
+	}
+
+	b.images = b.images[:0]
+	return buf.Bytes(), nil
+}
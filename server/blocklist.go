@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"gopin/config"
+	"gopin/pkg/logger"
+	"gopin/storage"
+	"regexp"
+	"strings"
+)
+
+// queryBlocklist matches the queries that the blocklist config rejects.
+type queryBlocklist struct {
+	rules []*regexp.Regexp
+}
+
+// newQueryBlocklist compiles the terms and patterns of the blocklist config.
+func newQueryBlocklist(cfg config.BlocklistConfig) (*queryBlocklist, error) {
+	b := &queryBlocklist{}
+	for _, term := range cfg.Terms {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("terms must not be empty")
+		}
+		// A term only matches whole words, so "ass" doesn't block "glass"
+		b.rules = append(b.rules, regexp.MustCompile(`(?i)(?:^|[^\pL\pN])`+regexp.QuoteMeta(term)+`(?:$|[^\pL\pN])`))
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		b.rules = append(b.rules, re)
+	}
+	return b, nil
+}
+
+// match returns the first of the queries that the blocklist rejects, and the rule that matched it.
+func (b *queryBlocklist) match(queries []string) (query, rule string, blocked bool) {
+	for _, q := range queries {
+		for _, re := range b.rules {
+			if re.MatchString(q) {
+				return q, re.String(), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// checkBlocklist rejects a request whose queries the blocklist matches, recording the first blocked query
+// in the log and the audit log. It returns the error for the client, or "" if no query is blocked.
+func checkBlocklist(st *settings, db storage.Audit, log *logger.Logger, p *principal, queries []string) string {
+	q, rule, blocked := st.blocklist.match(queries)
+	if !blocked {
+		return ""
+	}
+	log.Warn("Rejected blocked query.", "client", p.Name, "query", q, "rule", rule)
+	recordAudit(db, log, p.Name, p.Addr, storage.AuditBlocked, q)
+	return fmt.Sprintf("query %q is blocked by the server's content policy", q)
+}
+
+// blockedQuery checks the queries of a REST or gRPC request against the blocklist, see checkBlocklist.
+func (s *Server) blockedQuery(p *principal, queries []string) string {
+	return checkBlocklist(s.current(), s.db, s.log, p, queries)
+}
+
+// blockedQuery checks the queries of a WebSocket request against the blocklist, see checkBlocklist.
+func (c *wsHandler) blockedQuery(p *principal, queries []string) string {
+	return checkBlocklist(c.settings.Load(), c.db, c.log, p, queries)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/binary"
+
+	"github.com/lxzan/gws"
+)
+
+const (
+	// DefaultChunkThreshold is the payload size above which binary frames are split.
+	DefaultChunkThreshold = 4 << 20
+	// DefaultChunkSize is the maximum payload carried by a single continuation frame.
+	DefaultChunkSize = 1 << 20
+
+	// chunkHeaderSize is the length of the header prepended to every chunk frame:
+	// magic (4) | transfer id (4) | chunk index (4) | chunk count (4), big-endian.
+	chunkHeaderSize = 16
+)
+
+// chunkMagic marks binary frames that carry part of a chunked payload.
+var chunkMagic = []byte("RCNK")
+
+// TransferMessage announces a payload that will arrive as multiple chunk frames.
+type TransferMessage struct {
+	Type   string `json:"type"`
+	ID     uint32 `json:"id"`
+	Size   int    `json:"size"`
+	Chunks int    `json:"chunks"`
+}
+
+// writeBinary sends a binary payload, splitting it into chunk frames when it exceeds the configured threshold.
+func (c *wsHandler) writeBinary(socket *gws.Conn, data []byte) error {
+	threshold := c.delivery.ChunkThreshold
+	if threshold <= 0 {
+		threshold = DefaultChunkThreshold
+	}
+	if len(data) <= threshold {
+		return socket.WriteMessage(gws.OpcodeBinary, data)
+	}
+
+	chunkSize := c.delivery.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	count := (len(data) + chunkSize - 1) / chunkSize
+	id := c.transferID.Add(1)
+
+	if err := writeJSON(socket, TransferMessage{Type: "transfer", ID: id, Size: len(data), Chunks: count}); err != nil {
+		return err
+	}
+
+	frame := make([]byte, chunkHeaderSize+chunkSize)
+	copy(frame, chunkMagic)
+	binary.BigEndian.PutUint32(frame[4:], id)
+	binary.BigEndian.PutUint32(frame[12:], uint32(count))
+	for i := 0; i < count; i++ {
+		end := min((i+1)*chunkSize, len(data))
+		binary.BigEndian.PutUint32(frame[8:], uint32(i))
+		n := copy(frame[chunkHeaderSize:], data[i*chunkSize:end])
+		if err := socket.WriteMessage(gws.OpcodeBinary, frame[:chunkHeaderSize+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"gopin/manager"
+	"gopin/pkg/logger"
+	"gopin/storage"
+	"net/http"
+	"time"
+)
+
+// ClientStatsResponse is the body of GET /api/admin/clients/stats.
+type ClientStatsResponse struct {
+	Clients map[string]storage.ClientStats `json:"clients"`
+}
+
+// recordConnect notes in a client's statistics that it connected.
+func recordConnect(db storage.ClientStatsStore, log *logger.Logger, clientName string) {
+	if err := db.AddClientStats(clientName, storage.ClientStats{LastConnected: time.Now()}); err != nil && !isReadOnly(err) {
+		log.Error("Failed to record client stats", "error", err, "client", clientName)
+	}
+}
+
+// recordJobStats adds the dedupe hits and per-query totals of a job to its client's statistics, even if
+// the job was cut short. Images and bytes are counted as they are delivered, together with the usage.
+func recordJobStats(db storage.ClientStatsStore, log *logger.Logger, clientName string, summary *CompleteMessage) {
+	delta := storage.ClientStats{
+		Deduped: int64(summary.Deduped),
+		Jobs:    1,
+		Queries: make(map[string]storage.QueryStats, len(summary.Queries)),
+	}
+	for q, t := range summary.Queries {
+		delta.Queries[q] = storage.QueryStats{Sent: int64(t.Sent), Deduped: int64(t.Deduped), Failed: int64(t.Failed)}
+	}
+	if err := db.AddClientStats(clientName, delta); err != nil && !isReadOnly(err) {
+		log.Error("Failed to record client stats", "error", err, "client", clientName)
+	}
+}
+
+// newStatusMessage reports a client's quota usage and lifetime statistics. The statistics are left out
+// if they can't be read, as the quota is what clients act on.
+func newStatusMessage(m *manager.ScrapeManager, db storage.ClientStatsStore, log *logger.Logger, clientName string) (StatusMessage, error) {
+	st, err := m.QuotaStatus(clientName)
+	if err != nil {
+		return StatusMessage{}, err
+	}
+	msg := StatusMessage{Type: "status", Quota: newQuotaInfo(st)}
+	if stats, err := db.GetClientStats(clientName); err != nil {
+		log.Error("Failed to get client stats", "error", err, "client", clientName)
+	} else {
+		msg.Stats = &stats
+	}
+	return msg, nil
+}
+
+// handleListClientStats returns the lifetime statistics of every client that ever connected.
+func (s *Server) handleListClientStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.db.ListClientStats()
+		if err != nil {
+			s.log.Error("Failed to list client stats", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to list client stats")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, ClientStatsResponse{Clients: stats})
+	}
+}
+
+// handleClientStats returns the lifetime statistics of a client.
+func (s *Server) handleClientStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		stats, err := s.db.GetClientStats(name)
+		if err != nil {
+			s.log.Error("Failed to get client stats", "error", err, "client", name)
+			writeAPIError(w, http.StatusInternalServerError, "failed to get client stats")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, stats)
+	}
+}
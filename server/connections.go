@@ -0,0 +1,140 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lxzan/gws"
+)
+
+// WebSocket close codes sent by the server.
+const (
+	// CloseDisconnected is sent when an admin disconnects a client.
+	CloseDisconnected = 4000
+	// CloseIdle is sent when a connection has been idle for longer than websocket.idleTimeout.
+	CloseIdle = 4001
+)
+
+// idleSweepInterval is how often connections are checked against the idle timeout.
+const idleSweepInterval = 15 * time.Second
+
+// wsConn is an open WebSocket connection and what has been delivered over it.
+type wsConn struct {
+	id         string
+	clientName string
+	remoteAddr string
+	connected  time.Time
+	socket     *gws.Conn
+	images     atomic.Int64
+	bytes      atomic.Int64
+	// streams counts the jobs and subscriptions being delivered over the connection.
+	streams atomic.Int32
+	// lastActive is when the client last sent a message or a stream ended, in Unix nanoseconds.
+	lastActive atomic.Int64
+}
+
+// connOf returns the connection of a socket, or nil if it isn't registered.
+func connOf(socket *gws.Conn) *wsConn {
+	v, _ := socket.Session().Load("conn")
+	conn, _ := v.(*wsConn)
+	return conn
+}
+
+// touch marks the connection as active now.
+func (c *wsConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the connection has been idle, or zero while it has a stream.
+func (c *wsConn) idleFor(now time.Time) time.Duration {
+	if c.streams.Load() > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, c.lastActive.Load()))
+}
+
+// connRegistry tracks the open WebSocket connections by ID.
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*wsConn
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: make(map[string]*wsConn)}
+}
+
+// add tracks a new connection.
+func (r *connRegistry) add(conn *wsConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conns[conn.id] = conn
+}
+
+// remove stops tracking a closed connection.
+func (r *connRegistry) remove(conn *wsConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, conn.id)
+}
+
+// list returns the open connections, oldest first.
+func (r *connRegistry) list() []*wsConn {
+	r.mu.Lock()
+	conns := make([]*wsConn, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(conns, func(a, b *wsConn) int { return a.connected.Compare(b.connected) })
+	return conns
+}
+
+// startIdleSweeper starts a goroutine that closes connections idle for longer than websocket.idleTimeout.
+// The timeout is read on every sweep, so it can be changed by a reload.
+func (s *Server) startIdleSweeper() {
+	ticker := time.NewTicker(idleSweepInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				timeout := time.Duration(s.current().config.WebSocket.IdleTimeout)
+				if timeout <= 0 {
+					continue
+				}
+				for _, conn := range s.connections.list() {
+					if idle := conn.idleFor(now); idle > timeout {
+						s.log.Info("Closing idle connection", "conn", conn.id, "client", conn.clientName, "idle", idle.Round(time.Second))
+						conn.socket.WriteClose(CloseIdle, []byte("idle timeout"))
+					}
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// startKeepalive starts a goroutine that pings every connection each websocket.pingInterval. Clients
+// answer with a pong, which extends the connection's deadline like a ping of their own, so clients
+// that never ping stay connected as long as they answer.
+func (s *Server) startKeepalive() {
+	go func() {
+		for {
+			interval := s.current().config.WebSocket.PingInterval.Or(PingInterval)
+			select {
+			case <-time.After(interval):
+				for _, conn := range s.connections.list() {
+					conn.socket.WriteAsync(gws.OpcodePing, nil, nil) // Don't wait for slow connections
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// CloseTooManyConnections is the WebSocket close code sent when a connection exceeds a connection limit.
+const CloseTooManyConnections = 4029
+
+// CloseReason is the JSON reason of a close frame sent by the server.
+type CloseReason struct {
+	Error string `json:"error"`
+	// Scope is "client" for the per-client limit and "server" for the global one.
+	Scope string `json:"scope"`
+	Limit int    `json:"limit"`
+}
+
+// connLimiter counts open WebSocket connections per client and in total.
+type connLimiter struct {
+	mu           sync.Mutex
+	maxTotal     int // zero means no limit
+	maxPerClient int // zero means no limit
+	total        int
+	perClient    map[string]int
+}
+
+func newConnLimiter(maxTotal, maxPerClient int) *connLimiter {
+	return &connLimiter{
+		maxTotal:     maxTotal,
+		maxPerClient: maxPerClient,
+		perClient:    make(map[string]int),
+	}
+}
+
+// setLimits changes the limits. Connections already over a lowered limit stay open.
+func (l *connLimiter) setLimits(maxTotal, maxPerClient int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxTotal = maxTotal
+	l.maxPerClient = maxPerClient
+}
+
+// acquire reserves a connection for a client. If a limit is reached, it returns the reason to close with.
+func (l *connLimiter) acquire(clientName string) *CloseReason {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return &CloseReason{Error: "too_many_connections", Scope: "server", Limit: l.maxTotal}
+	}
+	if l.maxPerClient > 0 && l.perClient[clientName] >= l.maxPerClient {
+		return &CloseReason{Error: "too_many_connections", Scope: "client", Limit: l.maxPerClient}
+	}
+	l.total++
+	l.perClient[clientName]++
+	return nil
+}
+
+// release frees a connection reserved by acquire.
+func (l *connLimiter) release(clientName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perClient[clientName]--; l.perClient[clientName] <= 0 {
+		delete(l.perClient, clientName)
+	}
+}
+
+// bytes encodes the reason for a close frame.
+func (r *CloseReason) bytes() []byte {
+	data, _ := json.Marshal(r)
+	return data
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"gopin/config"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMaxAge is how long browsers may cache a preflight response unless configured otherwise.
+const defaultCORSMaxAge = 10 * time.Minute
+
+const (
+	corsAllowHeaders  = "Authorization, Content-Type, Last-Event-ID, X-Server-Name, X-Password"
+	corsExposeHeaders = "Location, Retry-After, ETag"
+)
+
+// corsPolicy decides which browser origins may call the REST API and open WebSockets.
+type corsPolicy struct {
+	origins map[string]bool
+	any     bool
+	maxAge  string
+}
+
+func newCORSPolicy(cfg config.CORSConfig) (*corsPolicy, error) {
+	c := &corsPolicy{origins: make(map[string]bool)}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			c.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", origin)
+		}
+		c.origins[normalizeOrigin(origin)] = true
+	}
+
+	maxAge := cfg.MaxAge.Or(defaultCORSMaxAge)
+	c.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	return c, nil
+}
+
+// listed reports whether an origin is configured as allowed.
+func (c *corsPolicy) listed(origin string) bool {
+	return c.any || c.origins[normalizeOrigin(origin)]
+}
+
+// allowed reports whether a request may proceed. Requests without an Origin don't come from a browser page,
+// and pages served by this server, such as the gallery, are always allowed.
+func (c *corsPolicy) allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || c.listed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// corsMethods lists the methods of the routes for preflight responses, so that every route a page may
+// call is allowed.
+func corsMethods(routes []route) string {
+	var methods []string
+	for _, r := range routes {
+		if method, _, ok := strings.Cut(r.pattern, " "); ok && !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	slices.Sort(methods)
+	return strings.Join(methods, ", ")
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers their preflight requests.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		cors := s.current().cors
+		if origin == "" || !cors.listed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", s.corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", cors.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin rejects WebSocket handshakes from pages on origins that aren't allowed, so a random website
+// can't open a connection with credentials its visitor holds.
+func (s *Server) checkOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.current().cors.allowed(r) {
+			s.log.Warn("Rejected WebSocket from disallowed origin", "origin", r.Header.Get("Origin"), "ip", remoteIP(r.RemoteAddr))
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"embed"
+	"gopin/storage"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// StatsResponse is the body of GET /api/admin/stats.
+type StatsResponse struct {
+	Started     time.Time `json:"started"`
+	Draining    bool      `json:"draining"`
+	ReadOnly    bool      `json:"readOnly"`
+	Connections int       `json:"connections"`
+	Jobs        int       `json:"jobs"`
+	// Memory is the resident memory of the process in bytes and MemoryState the load shedding it
+	// causes: "normal", "high" while browser launches are paused, or "critical" while new jobs are rejected.
+	Memory      int64  `json:"memory"`
+	MemoryState string `json:"memoryState"`
+	// ImagesDelivered and BytesDelivered count the images sent to clients since the server started.
+	ImagesDelivered int64         `json:"imagesDelivered"`
+	BytesDelivered  int64         `json:"bytesDelivered"`
+	Database        storage.Stats `json:"database"`
+	// LastCleanup is the last database cleanup since the server started.
+	LastCleanup *CleanupInfo `json:"lastCleanup,omitempty"`
+}
+
+// RecentImage is a recently delivered image and the client it was delivered to.
+type RecentImage struct {
+	GalleryImage
+	Client string `json:"client"`
+}
+
+// RecentImagesResponse is the body of GET /api/admin/images.
+type RecentImagesResponse struct {
+	Images []RecentImage `json:"images"`
+}
+
+// handleDashboard serves the embedded admin dashboard under /admin/. Like the gallery, the page itself
+// is public and asks for the credentials of an admin to call the admin API with.
+func (s *Server) handleDashboard() http.Handler {
+	sub, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err) // The embedded directory always exists
+	}
+	return http.StripPrefix("/admin/", http.FileServerFS(sub))
+}
+
+// handleStats returns the counters shown by the dashboard.
+func (s *Server) handleStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dbStats, err := s.db.Stats()
+		if err != nil {
+			s.log.Error("Failed to read database stats", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to read database stats")
+			return
+		}
+
+		images, bytes := s.scrapeManager.Delivered()
+		writeAPIJSON(w, http.StatusOK, StatsResponse{
+			Started:         s.started,
+			Draining:        s.drainer.isDraining(),
+			ReadOnly:        s.current().config.Maintenance.ReadOnly,
+			Connections:     len(s.connections.list()),
+			Jobs:            len(s.scrapeManager.Jobs()),
+			Memory:          s.memory.usage.Load(),
+			MemoryState:     memoryStateNames[s.memory.state.Load()],
+			ImagesDelivered: images,
+			BytesDelivered:  bytes,
+			Database:        dbStats,
+			LastCleanup:     s.metrics.lastCleanup.Load(),
+		})
+	}
+}
+
+// handleRecentImages lists the most recently delivered images that are still cached, of every client.
+func (s *Server) handleRecentImages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := galleryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = min(n, galleryLimit)
+		}
+
+		resp := RecentImagesResponse{Images: []RecentImage{}}
+		for _, img := range s.images.recent("", "", limit) {
+			resp.Images = append(resp.Images, RecentImage{
+				GalleryImage: GalleryImage{ImageMessage: img.meta, Query: img.query, Delivered: img.created},
+				Client:       img.client,
+			})
+		}
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
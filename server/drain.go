@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"gopin/pkg/systemd"
+	"sync"
+	"time"
+)
+
+// DefaultDrainTimeout is how long running jobs may take to finish on shutdown if no drain timeout is configured.
+const DefaultDrainTimeout = 30 * time.Second
+
+// stoppedJobsTimeout is how long shutdown waits for the jobs stopped after the drain to deliver their
+// summaries, before it closes the database.
+const stoppedJobsTimeout = 5 * time.Second
+
+// errDraining is the error of requests that would start a job while the server drains.
+const errDraining = "server draining"
+
+// DrainingMessage tells the connected clients that the server is shutting down. Running jobs are still
+// delivered for up to Timeout seconds, but no new jobs or subscriptions are accepted.
+type DrainingMessage struct {
+	Type    string `json:"type"`
+	Timeout int    `json:"timeout"`
+}
+
+// drainer tracks the deliveries of running jobs so that shutdown can wait for them.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	jobs     sync.WaitGroup
+}
+
+// begin registers the delivery of a new job, which must be ended with end.
+// It reports false if the server is draining and takes no new jobs.
+func (d *drainer) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.jobs.Add(1)
+	return true
+}
+
+// end marks the delivery of a job registered with begin as finished.
+func (d *drainer) end() {
+	d.jobs.Done()
+}
+
+// isDraining reports whether the server is draining.
+func (d *drainer) isDraining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining
+}
+
+// start stops new jobs from being accepted. It returns a channel that is closed once the running
+// jobs have been delivered.
+func (d *drainer) start() <-chan struct{} {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.jobs.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// drain stops accepting connections and jobs, tells the connected clients and waits up to the drain
+// timeout for the running jobs to be delivered. It returns a channel that is closed once they have been.
+func (s *Server) drain(ctx context.Context) <-chan struct{} {
+	timeout := time.Duration(s.current().config.Shutdown.DrainTimeout)
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	s.log.Info("Draining server", "timeout", timeout)
+	if !s.restarting.Load() {
+		systemd.Notify(systemd.Stopping) // After a restart, the service goes on in the new process
+	}
+
+	done := s.drainer.start()
+	msg := DrainingMessage{Type: "draining", Timeout: int(timeout.Seconds())}
+	for _, conn := range s.connections.list() {
+		writeJSON(conn.socket, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case <-done:
+		s.log.Info("All running jobs finished")
+	case <-ctx.Done():
+		// Shutdown stops the remaining jobs, keeping the WebSocket jobs saved for the new process to resume
+		s.log.Warn("Drain timed out, stopping the remaining jobs", "timeout", timeout)
+	}
+	return done
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ImageEvent is the payload of an SSE image event.
+type ImageEvent struct {
+	Seq int `json:"seq"`
+	ImageMessage
+}
+
+// handleJobEvents streams the metadata of a REST job's images as Server-Sent Events.
+// Each image event carries its sequence number as the event ID so that reconnecting
+// clients resume after the last event they received via the Last-Event-ID header.
+func (s *Server) handleJobEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aj, ok := s.apiJobs.get(r.PathValue("id"), principalFrom(r.Context()).Name)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "job not found")
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		next := 0
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				next = n
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			aj.mu.Lock()
+			var pending []ImageEvent
+			for _, img := range aj.images[min(next, len(aj.images)):] {
+				pending = append(pending, ImageEvent{Seq: img.Seq, ImageMessage: img.ImageMessage})
+			}
+			next = len(aj.images)
+			var summary *CompleteMessage
+			if !aj.finished.IsZero() {
+				summary = aj.summary.clone()
+			}
+			updated := aj.updated
+			aj.mu.Unlock()
+
+			for _, ev := range pending {
+				if err := writeEvent(w, "image", strconv.Itoa(ev.Seq), ev); err != nil {
+					return
+				}
+			}
+			if summary != nil {
+				writeEvent(w, "complete", "", summary)
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+
+			select {
+			case <-updated:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload.
+func writeEvent(w http.ResponseWriter, event, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// galleryLimit is the maximum number of images returned by a gallery request.
+const galleryLimit = 200
+
+//go:embed ui
+var uiFiles embed.FS
+
+// GalleryImage is a recently delivered image listed by the gallery.
+type GalleryImage struct {
+	ImageMessage
+	Query     string    `json:"query"`
+	Delivered time.Time `json:"delivered"`
+}
+
+// GalleryResponse is the body of a GET /api/gallery response.
+type GalleryResponse struct {
+	Queries []string       `json:"queries"`
+	Images  []GalleryImage `json:"images"`
+}
+
+// handleUI serves the embedded web gallery under /ui/. The page itself is public;
+// it asks for credentials and sends them with every API request it makes.
+func (s *Server) handleUI() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err) // The embedded directory always exists
+	}
+	return http.StripPrefix("/ui/", http.FileServerFS(sub))
+}
+
+// handleGallery lists the client's most recently delivered images that are still cached,
+// newest first, optionally filtered by query. Images of the image pool are only listed once they were
+// delivered to the client.
+func (s *Server) handleGallery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientName := principalFrom(r.Context()).Name
+
+		limit := galleryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = min(n, galleryLimit)
+		}
+
+		resp := GalleryResponse{Queries: s.images.queries(clientName), Images: []GalleryImage{}}
+		for _, img := range s.images.recent(clientName, r.URL.Query().Get("query"), limit) {
+			resp.Images = append(resp.Images, GalleryImage{
+				ImageMessage: img.meta,
+				Query:        img.query,
+				Delivered:    img.created,
+			})
+		}
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleMarkSeen marks an image as seen for the client so future jobs skip it.
+func (s *Server) handleMarkSeen() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientName := principalFrom(r.Context()).Name
+
+		hash, err := strconv.ParseUint(r.PathValue("hash"), 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid image hash")
+			return
+		}
+
+		// The pin is only known while the image is cached
+		pinID := ""
+		if img, ok := s.images.get(hash, clientName); ok {
+			pinID = img.meta.Pin
+		}
+		if err := s.db.MarkImageAsSeen(clientName, hash, pinID); err != nil {
+			s.log.Error("Error marking image as seen", "error", err, "client", clientName)
+			writeAPIError(w, http.StatusInternalServerError, "failed to mark image as seen")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"gopin/pkg/tracing"
+	"gopin/renderpb"
+	"gopin/storage"
+	"net/netip"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService implements the Render gRPC service on top of the scrape manager.
+type grpcService struct {
+	renderpb.UnimplementedRenderServer
+	s *Server
+}
+
+// newGRPCServer creates a gRPC server that authenticates streams with the configured credentials
+// and serves TLS if tlsConfig is set.
+func (s *Server) newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.StreamInterceptor(s.grpcAuthInterceptor)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	gs := grpc.NewServer(opts...)
+	renderpb.RegisterRenderServer(gs, &grpcService{s: s})
+	return gs
+}
+
+// grpcAuthInterceptor checks the client certificate, a bearer token in the authorization metadata,
+// or the x-server-name and x-password metadata before allowing a stream.
+func (s *Server) grpcAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+
+	var ip netip.Addr
+	if pr, ok := peer.FromContext(ss.Context()); ok {
+		ip = remoteIP(pr.Addr.String())
+	}
+	if reason := s.access.check(ip); reason != "" {
+		s.log.Warn("Rejected gRPC stream by IP", "ip", ip, "reason", reason)
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+
+	clientName := firstValue(md, "x-server-name")
+	var p *principal
+	var ok bool
+	if wait := s.access.retryAfter(ip, clientName); wait > 0 {
+		// While the IP or client name is backed off, only a password that was verified recently gets through
+		if p, ok = s.checkCredentials(clientName, firstValue(md, "x-password"), true); !ok {
+			return status.Errorf(codes.ResourceExhausted, "too many failed authentication attempts, retry in %s", wait.Round(time.Second))
+		}
+	} else {
+		if state := peerTLS(ss.Context()); state != nil && len(state.PeerCertificates) > 0 {
+			p, ok = s.certPrincipal(state)
+		} else if token, found := strings.CutPrefix(firstValue(md, "authorization"), "Bearer "); found {
+			p, ok = s.verifyToken(token)
+		} else {
+			p, ok = s.checkCredentials(clientName, firstValue(md, "x-password"), false)
+		}
+		if !ok {
+			s.access.authFailed(ip, clientName)
+			recordAudit(s.db, s.log, clientName, ip, storage.AuditAuthFailure, info.FullMethod)
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+	}
+	s.access.authSucceeded(ip, p.Name)
+	p.Addr = ip
+	s.applyProfile(p)
+
+	ctx := withPrincipal(ss.Context(), p)
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream overrides the stream context to carry the principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// StartScrape runs a job for the client and streams its unseen images until the job ends or the client goes away.
+func (g *grpcService) StartScrape(req *renderpb.ScrapeRequest, stream grpc.ServerStreamingServer[renderpb.ScrapeEvent]) error {
+	p := principalFrom(stream.Context())
+	clientName := p.Name
+	opts := p.jobOptions(req.Queries, int(req.Limit), int(req.LimitPerQuery))
+	if len(opts.Queries) == 0 || opts.Limit <= 0 {
+		return status.Error(codes.InvalidArgument, "queries and a positive limit are required")
+	}
+	if err := p.checkQueryJob(opts.Limit); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if msg := g.s.blockedQuery(p, opts.Queries); msg != "" {
+		return status.Error(codes.PermissionDenied, msg)
+	}
+	priority, err := p.priority(req.Priority)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts.Priority = priority
+	opts.MaxLifetime = g.s.current().jobLifetime(req.MaxLifetime.AsDuration())
+
+	if msg := g.s.current().readOnlyError(); msg != "" {
+		return status.Error(codes.Unavailable, msg)
+	}
+	if g.s.memory.overloaded() {
+		return status.Error(codes.ResourceExhausted, errOverloaded)
+	}
+	if !g.s.drainer.begin() {
+		return status.Error(codes.Unavailable, errDraining)
+	}
+	defer g.s.drainer.end()
+
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	span := startRequestSpan(md, "grpc StartScrape", p)
+	opts.Trace = span.SpanContext()
+	// Positions are sent by this goroutine, as a stream must not be sent on concurrently. Only the
+	// latest one is kept.
+	queued := make(chan int, 1)
+	opts.OnQueued = func(position int) {
+		select {
+		case <-queued:
+		default:
+		}
+		select {
+		case queued <- position:
+		default:
+		}
+	}
+	job := g.s.scrapeManager.Submit(clientName, opts)
+	endSpan(span, nil, tracing.JobID.String(job.ID()))
+	defer job.Stop()
+	log := g.s.log.With("job", job.ID())
+	log.Info("Started gRPC job", "client", clientName, "queryCount", len(req.Queries), "limit", opts.Limit)
+	g.s.audit(p, storage.AuditScrape, auditQueries(req.Queries, opts.Limit))
+	recordConnect(g.s.db, log, clientName)
+
+	summary := newCompleteMessage()
+	defer recordJob(g.s.db, log, JobKindGRPC, clientName, job, summary)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case position := <-queued:
+			event := &renderpb.ScrapeEvent{Event: &renderpb.ScrapeEvent_Queued{Queued: &renderpb.Queued{Position: int32(position)}}}
+			if err := stream.Send(event); err != nil {
+				log.Error("Error sending queue position to gRPC client", "error", err, "client", clientName)
+				return err
+			}
+		case img, ok := <-job.Images():
+			if !ok {
+				summary.finish(job.Reason(), job.Failed())
+				return stream.Send(&renderpb.ScrapeEvent{Event: &renderpb.ScrapeEvent_Complete{Complete: completeToProto(summary)}})
+			}
+
+			seen, err := alreadySeen(g.s.db, clientName, img)
+			if err != nil {
+				log.Error("Error checking if image was seen", "error", err, "client", clientName)
+				continue
+			}
+			if seen {
+				summary.Deduped++
+				summary.query(img.Query).Deduped++
+				continue
+			}
+			// The job ends once its client is out of quota, the images still buffered are not delivered
+			if job.QuotaExceeded(storage.Usage{}) {
+				continue
+			}
+
+			meta := newImageMessage(img)
+			event := &renderpb.ScrapeEvent{Event: &renderpb.ScrapeEvent_Image{Image: &renderpb.Image{
+				Pin:    meta.Pin,
+				Url:    meta.URL,
+				Query:  img.Query,
+				Hash:   img.Hash,
+				Data:   img.Data,
+				Crc32:  meta.CRC32,
+				Sha256: meta.SHA256,
+			}}}
+			span := startSendSpan(img, "grpc")
+			err = stream.Send(event)
+			endSpan(span, err)
+			if err != nil {
+				log.Error("Error sending image to gRPC client", "error", err, "client", clientName)
+				return err
+			}
+			summary.Sent++
+			summary.query(img.Query).Sent++
+
+			if err := g.s.db.MarkImageAsSeen(clientName, img.Hash, img.ID); err != nil {
+				log.Error("Error marking image as seen", "error", err, "client", clientName)
+			}
+			g.s.scrapeManager.RecordUsage(clientName, 1, int64(len(img.Data)))
+			cacheImage(g.s.images, img, clientName)
+		}
+	}
+}
+
+// completeToProto converts a completion summary to its protobuf form.
+func completeToProto(m *CompleteMessage) *renderpb.Complete {
+	c := &renderpb.Complete{
+		Reason:  m.Reason,
+		Sent:    int32(m.Sent),
+		Deduped: int32(m.Deduped),
+		Failed:  int32(m.Failed),
+		Queries: make(map[string]*renderpb.QueryTotals, len(m.Queries)),
+	}
+	for q, t := range m.Queries {
+		c.Queries[q] = &renderpb.QueryTotals{Sent: int32(t.Sent), Deduped: int32(t.Deduped), Failed: int32(t.Failed)}
+	}
+	return c
+}
+
+// peerTLS returns the TLS state of the connection a stream arrived on, or nil for plaintext connections.
+func peerTLS(ctx context.Context) *tls.ConnectionState {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return &info.State
+}
+
+// firstValue returns the first value of a metadata key, or an empty string.
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"container/list"
+	"gopin/scraper"
+	"sync"
+	"time"
+)
+
+// DefaultImageCacheSize is the number of bytes of image data kept for GET /images/{hash}
+// when the config does not specify a size.
+const DefaultImageCacheSize = 256 << 20
+
+// maxCachedImages caps the number of images in the cache, whose metadata takes memory of its own however
+// small the images are.
+const maxCachedImages = 50000
+
+// cachedImage is an image held by the cache.
+type cachedImage struct {
+	hash    uint64
+	data    []byte
+	meta    ImageMessage
+	query   string
+	client  string          // The client the image was last delivered to
+	clients map[string]bool // Every client the image was delivered to, the only ones that may fetch it
+	created time.Time
+}
+
+// imageCache keeps recently delivered images in memory, evicting the least recently used
+// ones once their total size exceeds limit or there are more than maxCachedImages.
+type imageCache struct {
+	maxBytes int
+	limit    int // maxBytes, or less while memory runs short
+	size     int
+	order    *list.List // front is most recently used
+	entries  map[uint64]*list.Element
+	mu       sync.Mutex
+}
+
+func newImageCache(maxBytes int) *imageCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultImageCacheSize
+	}
+	return &imageCache{
+		maxBytes: maxBytes,
+		limit:    maxBytes,
+		order:    list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}
+}
+
+// add stores an image delivered to a client. An image that is already cached is only marked as recently
+// used and as delivered to the client.
+func (c *imageCache) add(img scraper.ScrapedImage, meta ImageMessage, clientName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(img.Data) > c.limit {
+		return
+	}
+
+	if el, ok := c.entries[img.Hash]; ok {
+		entry := el.Value.(*cachedImage)
+		entry.client = clientName
+		entry.clients[clientName] = true
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[img.Hash] = c.order.PushFront(&cachedImage{
+		hash:    img.Hash,
+		data:    img.Data,
+		meta:    meta,
+		query:   img.Query,
+		client:  clientName,
+		clients: map[string]bool{clientName: true},
+		created: time.Now(),
+	})
+	c.size += len(img.Data)
+	c.evict()
+}
+
+// setLimit changes the number of bytes the cache keeps, evicting the least recently used images
+// right away if it holds more. A limit of zero or less restores the configured size.
+func (c *imageCache) setLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limit <= 0 || limit > c.maxBytes {
+		limit = c.maxBytes
+	}
+	c.limit = limit
+	c.evict()
+}
+
+// evict removes the least recently used images until the cache fits its limits. c.mu must be held.
+func (c *imageCache) evict() {
+	for c.size > c.limit || c.order.Len() > maxCachedImages {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cachedImage)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.hash)
+		c.size -= len(entry.data)
+	}
+}
+
+// get returns a cached image that was delivered to a client and marks it as recently used. Images
+// delivered only to other clients are not found.
+func (c *imageCache) get(hash uint64, clientName string) (*cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[hash]
+	if !ok || !el.Value.(*cachedImage).clients[clientName] {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedImage), true
+}
+
+// recent returns up to limit of the most recently used images delivered to a client, or to any
+// client if clientName is empty, optionally restricted to a single query.
+func (c *imageCache) recent(clientName, query string, limit int) []*cachedImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var images []*cachedImage
+	for el := c.order.Front(); el != nil && len(images) < limit; el = el.Next() {
+		entry := el.Value.(*cachedImage)
+		if (clientName != "" && !entry.clients[clientName]) || (query != "" && entry.query != query) {
+			continue
+		}
+		images = append(images, entry)
+	}
+	return images
+}
+
+// queries returns the distinct queries of the cached images delivered to a client.
+func (c *imageCache) queries(clientName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[string]bool)
+	queries := []string{}
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cachedImage)
+		if !entry.clients[clientName] || seen[entry.query] {
+			continue
+		}
+		seen[entry.query] = true
+		queries = append(queries, entry.query)
+	}
+	return queries
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"gopin/scraper"
+	"net/http"
+	"strconv"
+)
+
+// imageLink returns the URL path under which an image can be fetched from the server.
+func imageLink(hash uint64) string {
+	return fmt.Sprintf("/images/%d", hash)
+}
+
+// cacheImage keeps an image delivered to a client in the image cache, so that the client can fetch it
+// again from /images and find it in the gallery, whichever API it was delivered over.
+func cacheImage(cache *imageCache, img scraper.ScrapedImage, clientName string) ImageMessage {
+	meta := newImageMessage(img)
+	meta.Link = imageLink(img.Hash)
+	cache.add(img, meta, clientName)
+	return meta
+}
+
+// handleImage serves a cached image by its perceptual hash to a client it was delivered to. Images never
+// change once cached, so they are served with a strong ETag and a long-lived private cache lifetime.
+func (s *Server) handleImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash, err := strconv.ParseUint(r.PathValue("hash"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid image hash", http.StatusBadRequest)
+			return
+		}
+
+		img, ok := s.images.get(hash, principalFrom(r.Context()).Name)
+		if !ok {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", http.DetectContentType(img.data))
+		w.Header().Set("ETag", `"`+img.meta.SHA256+`"`)
+		w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+		http.ServeContent(w, r, "", img.created, bytes.NewReader(img.data))
+	}
+}
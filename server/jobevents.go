@@ -0,0 +1,92 @@
+package server
+
+import (
+	"gopin/manager"
+	"net/http"
+	"time"
+)
+
+// JobEvent is the payload of an SSE event of GET /api/admin/events, whose event name is its type:
+// started, query, blocked, completed or failed.
+type JobEvent struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	JobID string    `json:"jobId,omitempty"`
+	// Client owns the job, or is "topic:<name>" for the shared scrape of a topic.
+	Client string `json:"client,omitempty"`
+	// Query is the query the job started scraping, or the query a provider refused.
+	Query string `json:"query,omitempty"`
+	// Provider is the provider that refused a search, for blocked events.
+	Provider string `json:"provider,omitempty"`
+	// Reason, Sent and Errors describe how a job ended, for completed and failed events.
+	Reason string            `json:"reason,omitempty"`
+	Sent   int               `json:"sent,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// newJobEvent converts a job event of the scrape manager.
+func newJobEvent(ev manager.Event) JobEvent {
+	return JobEvent{
+		Type:     ev.Type,
+		Time:     ev.Time,
+		JobID:    ev.JobID,
+		Client:   ev.Client,
+		Query:    ev.Query,
+		Provider: ev.Provider,
+		Reason:   ev.Reason,
+		Sent:     ev.Sent,
+		Errors:   ev.Errors,
+	}
+}
+
+// handleAdminEvents streams the lifecycle events of every job as Server-Sent Events until the admin
+// disconnects. Events emitted while the admin wasn't connected, or that the admin couldn't keep up
+// with, are not sent.
+func (s *Server) handleAdminEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		events, unsubscribe := s.scrapeManager.SubscribeEvents()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case ev := <-events:
+				if err := writeEvent(w, ev.Type, "", newJobEvent(ev)); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// countJobEvents counts the job events by type for the metrics until the server stops.
+func (s *Server) countJobEvents() {
+	events, unsubscribe := s.scrapeManager.SubscribeEvents()
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				s.metrics.jobEvents.WithLabelValues(ev.Type).Inc()
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
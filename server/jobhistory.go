@@ -0,0 +1,130 @@
+package server
+
+import (
+	"gopin/config"
+	"gopin/manager"
+	"gopin/pkg/logger"
+	"gopin/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultJobHistoryMaxAge is how long finished jobs are kept unless configured otherwise.
+	defaultJobHistoryMaxAge = 30 * 24 * time.Hour
+	// maxJobRecords caps the jobs returned by a single job history request.
+	maxJobRecords = 500
+)
+
+// Kinds of jobs in the job history.
+const (
+	JobKindWebSocket = "websocket"
+	JobKindREST      = "rest"
+	JobKindGRPC      = "grpc"
+	JobKindSchedule  = "schedule"
+)
+
+// reasonDisconnected is the reason recorded for a job whose client went away before it ended.
+const reasonDisconnected = "disconnected"
+
+// JobHistoryEntry is a finished job with its duration.
+type JobHistoryEntry struct {
+	storage.JobRecord
+	Duration config.Duration `json:"duration"`
+}
+
+// JobHistoryResponse is a page of the job history, newest first. Next is passed as before to get the
+// following page.
+type JobHistoryResponse struct {
+	Jobs []JobHistoryEntry `json:"jobs"`
+	Next uint64            `json:"next,omitempty"`
+}
+
+// recordJob adds the totals of a job to its client's statistics and, unless it is a topic
+// subscription with no job of its own, records it in the job history.
+func recordJob(db storage.Store, log *logger.Logger, kind, clientName string, job *manager.ScrapeJob, summary *CompleteMessage) {
+	recordJobStats(db, log, clientName, summary)
+	if job == nil {
+		return
+	}
+
+	info := job.Info()
+	rec := storage.JobRecord{
+		ID:         info.ID,
+		Client:     clientName,
+		Kind:       kind,
+		Queries:    job.Queries(),
+		Limit:      job.Limit(),
+		Started:    info.Started,
+		Finished:   time.Now(),
+		Reason:     summary.Reason,
+		Sent:       summary.Sent,
+		Deduped:    summary.Deduped,
+		Failed:     summary.Failed,
+		QueryStats: make(map[string]storage.QueryStats, len(summary.Queries)),
+	}
+	for q, t := range summary.Queries {
+		rec.QueryStats[q] = storage.QueryStats{Sent: int64(t.Sent), Deduped: int64(t.Deduped), Failed: int64(t.Failed)}
+	}
+	if rec.Reason == "" {
+		rec.Reason = reasonDisconnected // The job is still being stopped, so its errors can't be read yet
+	} else if errors := job.Errors(); len(errors) > 0 {
+		rec.Errors = errors
+	}
+	if err := db.AddJobRecord(rec); err != nil && !isReadOnly(err) {
+		log.Error("Failed to record job", "error", err, "client", clientName)
+	}
+}
+
+// handleJobHistory returns the finished jobs of the client, newest first. Admins can ask for those of
+// another client, or of every client by leaving client out.
+func (s *Server) handleJobHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r.Context())
+		q := r.URL.Query()
+		filter := storage.JobFilter{
+			Client: q.Get("client"),
+			Limit:  100,
+		}
+		isAdmin := p.checkRole(RoleAdmin) == nil
+		if filter.Client == "" && !isAdmin {
+			filter.Client = p.Name
+		}
+		if filter.Client != p.Name && !isAdmin {
+			writeAPIError(w, http.StatusForbidden, "only admins can list the jobs of other clients")
+			return
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			filter.Limit = min(n, maxJobRecords)
+		}
+		if v := q.Get("before"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "before must be a sequence number")
+				return
+			}
+			filter.Before = n
+		}
+
+		records, err := s.db.ListJobRecords(filter)
+		if err != nil {
+			s.log.Error("Failed to read job history", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to read job history")
+			return
+		}
+		resp := JobHistoryResponse{Jobs: make([]JobHistoryEntry, len(records))}
+		for i, rec := range records {
+			resp.Jobs[i] = JobHistoryEntry{JobRecord: rec, Duration: config.Duration(rec.Finished.Sub(rec.Started))}
+		}
+		if len(records) == filter.Limit {
+			resp.Next = records[len(records)-1].Seq
+		}
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
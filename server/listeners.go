@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gopin/config"
+	"gopin/pkg/systemd"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Route groups a listener can serve.
+const (
+	// RoutesScrape is the WebSocket endpoint.
+	RoutesScrape = "scrape"
+	// RoutesAPI are the REST endpoints for jobs, images, the gallery, status and credential rotation.
+	RoutesAPI = "api"
+	// RoutesAdmin are the endpoints that require the admin role and the admin dashboard.
+	RoutesAdmin = "admin"
+	// RoutesUI is the web UI.
+	RoutesUI = "ui"
+	// RoutesSchema is the OpenAPI description.
+	RoutesSchema = "schema"
+	// RoutesDebug are the pprof profiles, if enabled.
+	RoutesDebug = "debug"
+	// RoutesMetrics are the Prometheus metrics at /metrics. They don't require credentials, so they
+	// belong on a listener that isn't public.
+	RoutesMetrics = "metrics"
+)
+
+var routeGroups = []string{RoutesScrape, RoutesAPI, RoutesAdmin, RoutesUI, RoutesSchema, RoutesDebug, RoutesMetrics}
+
+// DefaultAdminAddress is where the admin and debug routes are served if no admin address is configured.
+const DefaultAdminAddress = "127.0.0.1:8081"
+
+// route is an HTTP handler and the group it belongs to.
+type route struct {
+	group   string
+	pattern string
+	handler http.Handler
+}
+
+// newRouter returns a mux with the index and the routes of the given groups. No groups means all of them.
+// When only some groups are served, the index only answers "/" so that the others are not found.
+func (s *Server) newRouter(groups []string) *http.ServeMux {
+	router := http.NewServeMux()
+	if len(groups) == 0 {
+		router.HandleFunc("/", s.handleIndex())
+	} else {
+		router.HandleFunc("/{$}", s.handleIndex())
+	}
+	for _, r := range s.routes {
+		if len(groups) == 0 || slices.Contains(groups, r.group) {
+			router.Handle(r.pattern, r.handler)
+		}
+	}
+	return router
+}
+
+// listenerConfigs returns the configured listeners. When there are none, it returns a listener on the
+// port, with TLS if it is configured, and a plain HTTP listener on the admin address that serves the
+// admin, debug and metrics routes, which the port doesn't.
+func listenerConfigs(cfg *config.Config, tlsEnabled bool) []config.ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	adminAddress := cfg.AdminAddress
+	if adminAddress == "" {
+		adminAddress = DefaultAdminAddress
+	}
+	return []config.ListenerConfig{
+		{Name: "default", Address: ":" + cfg.Port, TLS: tlsEnabled, Routes: []string{RoutesScrape, RoutesAPI, RoutesUI, RoutesSchema}},
+		{Name: "admin", Address: adminAddress, Routes: []string{RoutesAdmin, RoutesDebug, RoutesMetrics}},
+	}
+}
+
+// validateListeners checks that every listener has a unique name, an address and known route groups,
+// and that TLS listeners have certificates to serve.
+func validateListeners(cfg *config.Config) error {
+	tlsConfigured := cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" || len(cfg.TLS.Autocert.Domains) > 0
+	names := make(map[string]bool)
+	if cfg.AdminAddress == "unix:" || cfg.AdminAddress == "systemd:" {
+		return errors.New("adminAddress has no path or name")
+	}
+	for _, l := range cfg.Listeners {
+		if l.Name == "" || names[l.Name] {
+			return fmt.Errorf("listener %q needs a unique name", l.Name)
+		}
+		names[l.Name] = true
+		if l.Address == "" || l.Address == "unix:" || l.Address == "systemd:" {
+			return fmt.Errorf("listener %q has no address", l.Name)
+		}
+		if l.TLS && !tlsConfigured {
+			return fmt.Errorf("listener %q uses TLS, but no certificate is configured", l.Name)
+		}
+		for _, group := range l.Routes {
+			if !slices.Contains(routeGroups, group) {
+				return fmt.Errorf("listener %q has unknown route group %q", l.Name, group)
+			}
+		}
+	}
+	return nil
+}
+
+// listen opens the socket of a listener. A stale Unix socket left behind by a crashed server is replaced.
+// Addresses of the form "systemd:name" use the socket of that name passed by systemd socket activation.
+func listen(address string) (net.Listener, error) {
+	if name, ok := strings.CutPrefix(address, "systemd:"); ok {
+		return systemd.Listener(name)
+	}
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// newListener builds the HTTP server of a listener and opens its socket.
+func (s *Server) newListener(cfg config.ListenerConfig, tlsConfig *tls.Config) (*http.Server, net.Listener, error) {
+	lis, err := s.listen("http/"+cfg.Name, cfg.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen on %s: %w", cfg.Address, err)
+	}
+
+	var handler http.Handler = s.newRouter(cfg.Routes)
+	handler = s.accessMiddleware(s.corsMiddleware(handler))
+	if strings.HasPrefix(cfg.Address, "unix:") {
+		handler = localPeer(handler)
+	}
+
+	srv := &http.Server{Handler: handler}
+	if cfg.TLS {
+		srv.TLSConfig = tlsConfig
+	}
+	return srv, lis, nil
+}
+
+// localPeer marks requests on a Unix socket as coming from the loopback address, since their peers
+// have no IP, so that access rules and rate limits treat them as local.
+func localPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:0"
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveListeners opens every listener and serves them until they are shut down or one of them fails.
+// Either all listeners are opened or none is served.
+func (s *Server) serveListeners(listeners []config.ListenerConfig, tlsConfig *tls.Config) error {
+	servers := make([]*http.Server, 0, len(listeners))
+	sockets := make([]net.Listener, 0, len(listeners))
+	for _, cfg := range listeners {
+		srv, lis, err := s.newListener(cfg, tlsConfig)
+		if err != nil {
+			for _, lis := range sockets {
+				lis.Close()
+			}
+			return err
+		}
+		servers = append(servers, srv)
+		sockets = append(sockets, lis)
+	}
+	s.httpServers = servers
+	closeInherited()
+	s.notifyReady()
+	if err := notifyPredecessor(); err != nil {
+		s.log.Warn("Failed to tell the old server that this one serves", "error", err)
+	}
+
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		l := listeners[i]
+		s.log.Info("Server starting", "listener", l.Name, "address", l.Address, "tls", l.TLS, "routes", l.Routes)
+		go func() {
+			var err error
+			if l.TLS {
+				err = srv.ServeTLS(sockets[i], "", "") // The certificates come from the TLS config
+			} else {
+				err = srv.Serve(sockets[i])
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				err = fmt.Errorf("listener %s failed: %w", l.Name, err)
+			} else {
+				err = nil
+			}
+			errs <- err
+		}()
+	}
+
+	for range servers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
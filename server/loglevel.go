@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"gopin/pkg/logger"
+	"gopin/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// LogLevel is the body of requests to and responses from /api/log/level.
+type LogLevel struct {
+	// Level is "debug", "info", "warn" or "error".
+	Level string `json:"level"`
+}
+
+// handleGetLogLevel returns the current log level.
+func (s *Server) handleGetLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAPIJSON(w, http.StatusOK, LogLevel{Level: strings.ToLower(s.log.Level().String())})
+	}
+}
+
+// handleSetLogLevel changes the log level until the configured level changes or the server restarts.
+func (s *Server) handleSetLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Level == "" {
+			writeAPIError(w, http.StatusBadRequest, "invalid log level request")
+			return
+		}
+		level, err := logger.ParseLevel(req.Level)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		p := principalFrom(r.Context())
+		s.log.SetLevel(level)
+		s.log.Warn("Changed log level", "level", level, "client", p.Name)
+		s.audit(p, storage.AuditLogLevel, level.String())
+		writeAPIJSON(w, http.StatusOK, LogLevel{Level: strings.ToLower(level.String())})
+	}
+}
+
+// maxLogEntries is the most log records returned by one request.
+const maxLogEntries = 1000
+
+// LogsResponse is the body of GET /api/admin/logs.
+type LogsResponse struct {
+	Entries []logger.Entry `json:"entries"`
+}
+
+// handleRecentLogs returns the newest log records kept in memory, optionally only those from a level up.
+func (s *Server) handleRecentLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		level, err := logger.ParseLevel(q.Get("level"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if q.Get("level") == "" {
+			level = slog.LevelDebug
+		}
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = min(n, maxLogEntries)
+		}
+
+		entries := s.log.Recent(level, limit)
+		if entries == nil {
+			entries = []logger.Entry{}
+		}
+		writeAPIJSON(w, http.StatusOK, LogsResponse{Entries: entries})
+	}
+}
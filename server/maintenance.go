@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"gopin/storage"
+	"net/http"
+)
+
+// errReadOnly is the error of requests that would write to the database in read-only maintenance mode,
+// unless maintenance.message replaces it.
+const errReadOnly = "server in read-only maintenance mode"
+
+// readOnlyError returns the error of requests refused in read-only maintenance mode, or "" if the
+// server isn't in it.
+func (st *settings) readOnlyError() string {
+	m := st.config.Maintenance
+	if !m.ReadOnly {
+		return ""
+	}
+	if m.Message != "" {
+		return m.Message
+	}
+	return errReadOnly
+}
+
+// requireWritable answers 503 with the maintenance message while the database is read-only.
+func (s *Server) requireWritable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if msg := s.current().readOnlyError(); msg != "" {
+			writeAPIError(w, http.StatusServiceUnavailable, msg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// isReadOnly reports whether a write failed only because the database is read-only, which the writes
+// done in the background, such as audit entries and statistics, don't log as a failure.
+func isReadOnly(err error) bool {
+	return errors.Is(err, storage.ErrReadOnly)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"gopin/config"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMemoryCheckInterval is how often the memory use is read if no interval is configured.
+const DefaultMemoryCheckInterval = 5 * time.Second
+
+// errOverloaded is the error of requests that would start a job while memory use is critical.
+const errOverloaded = "server overloaded"
+
+// memoryRecovery is the fraction of a limit memory use must drop below before the server leaves the
+// state the limit put it in, so that it doesn't flap around the limit.
+const memoryRecovery = 0.9
+
+// shrunkCacheDivisor divides the size of the image cache and the image pool while memory runs short.
+const shrunkCacheDivisor = 4
+
+// Memory pressure states, from least to most severe.
+const (
+	memoryNormal int32 = iota
+	memoryHigh
+	memoryCritical
+)
+
+var memoryStateNames = [...]string{"normal", "high", "critical"}
+
+// memoryGuard holds the memory pressure state set by the watchdog.
+type memoryGuard struct {
+	state atomic.Int32
+	usage atomic.Int64
+}
+
+// overloaded reports whether new jobs are rejected.
+func (m *memoryGuard) overloaded() bool {
+	return m.state.Load() == memoryCritical
+}
+
+// validateMemory checks the memory limits.
+func validateMemory(cfg config.MemoryConfig) error {
+	if cfg.HighWater < 0 || cfg.Critical < 0 {
+		return errors.New("highWater and critical can't be negative")
+	}
+	if cfg.HighWater > 0 && cfg.Critical > 0 && cfg.Critical < cfg.HighWater {
+		return errors.New("critical must be at least highWater")
+	}
+	return nil
+}
+
+// memoryState returns the state for the memory use, given the current state. A state is only left
+// once memory use drops below memoryRecovery of the limit that caused it. A critical limit without a
+// high water mark also acts as the high water mark.
+func memoryState(usage int64, cfg config.MemoryConfig, current int32) int32 {
+	highWater := cfg.HighWater
+	if highWater <= 0 {
+		highWater = cfg.Critical
+	}
+	above := func(limit int64, state int32) bool {
+		if limit <= 0 {
+			return false
+		}
+		if current >= state {
+			return float64(usage) >= float64(limit)*memoryRecovery
+		}
+		return usage >= limit
+	}
+
+	switch {
+	case above(cfg.Critical, memoryCritical):
+		return memoryCritical
+	case above(highWater, memoryHigh):
+		return memoryHigh
+	default:
+		return memoryNormal
+	}
+}
+
+// memoryUsage returns the memory of the process and its descendants, such as the browsers it launched,
+// so that the limits cover the whole process tree. Where /proc is missing, it falls back to the memory the
+// Go runtime has obtained from the system and not returned, leaving the browsers out.
+func memoryUsage() int64 {
+	var total int64
+	for _, pid := range processTree(os.Getpid()) {
+		total += processMemory(pid)
+	}
+	if total > 0 {
+		return total
+	}
+
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// processTree returns a process and its descendants, as listed in /proc.
+func processTree(root int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The fields after the command, which may contain spaces and parentheses, start with the state
+		// and the parent's ID
+		if i := bytes.LastIndexByte(stat, ')'); i >= 0 {
+			if fields := bytes.Fields(stat[i+1:]); len(fields) > 1 {
+				if ppid, err := strconv.Atoi(string(fields[1])); err == nil {
+					children[ppid] = append(children[ppid], pid)
+				}
+			}
+		}
+	}
+
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
+
+// processMemory returns the proportional set size of a process, which splits the memory its browsers
+// share between them instead of counting it once for each, or else its resident memory. It returns zero
+// for processes that have exited.
+func processMemory(pid int) int64 {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if data, err := os.ReadFile(filepath.Join(dir, "smaps_rollup")); err == nil {
+		for line := range bytes.Lines(data) {
+			if rest, ok := bytes.CutPrefix(line, []byte("Pss:")); ok {
+				if fields := bytes.Fields(rest); len(fields) > 0 {
+					if kb, err := strconv.ParseInt(string(fields[0]), 10, 64); err == nil {
+						return kb << 10
+					}
+				}
+			}
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "statm")); err == nil {
+		if fields := bytes.Fields(data); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(string(fields[1]), 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	return 0
+}
+
+// startMemoryWatchdog starts a goroutine that reads the memory use each memory.checkInterval and sheds
+// load above the configured limits: above the high water mark new browser launches are paused and the
+// image cache and pool are shrunk, and above the critical limit new jobs are rejected as well. Running
+// jobs keep going, so that one client's burst doesn't get every client's jobs killed by the OOM killer.
+func (s *Server) startMemoryWatchdog() {
+	go func() {
+		for {
+			cfg := s.current().config.Memory
+			select {
+			case <-time.After(cfg.CheckInterval.Or(DefaultMemoryCheckInterval)):
+				s.checkMemory(cfg)
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkMemory reads the memory use and moves to the state it calls for.
+func (s *Server) checkMemory(cfg config.MemoryConfig) {
+	usage := memoryUsage()
+	s.memory.usage.Store(usage)
+
+	old := s.memory.state.Load()
+	state := memoryState(usage, cfg, old)
+	if state == old {
+		return
+	}
+	s.memory.state.Store(state)
+
+	log := s.log.With("usage", usage, "highWater", cfg.HighWater, "critical", cfg.Critical)
+	switch {
+	case old == memoryNormal:
+		s.scraper.PauseLaunches()
+		s.images.setLimit(s.images.maxBytes / shrunkCacheDivisor)
+		if err := s.pool.SetLimit(s.pool.maxSize / shrunkCacheDivisor); err != nil {
+			log.Error("Failed to shrink the image pool", "error", err)
+		}
+		debug.FreeOSMemory()
+	case state == memoryNormal:
+		s.scraper.ResumeLaunches()
+		s.images.setLimit(0)
+		if err := s.pool.SetLimit(0); err != nil {
+			log.Error("Failed to restore the image pool", "error", err)
+		}
+	}
+	switch state {
+	case memoryCritical:
+		log.Warn("Memory use is critical, rejecting new jobs")
+	case memoryHigh:
+		log.Warn("Memory use is high, browser launches are paused")
+	default:
+		log.Info("Memory use is back to normal")
+	}
+}
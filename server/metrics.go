@@ -0,0 +1,190 @@
+package server
+
+import (
+	"gopin/config"
+	"gopin/pkg/reliability"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// CleanupInfo describes the last database cleanup.
+type CleanupInfo struct {
+	Time     time.Time       `json:"time"`
+	Duration config.Duration `json:"duration"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// promMetrics holds the Prometheus registry served at /metrics and the measurements that can't be read
+// from the database when it is scraped.
+type promMetrics struct {
+	registry        *prometheus.Registry
+	cleanupDuration prometheus.Histogram
+	lastCleanup     atomic.Pointer[CleanupInfo]
+	poolHits        prometheus.Counter
+	poolMisses      prometheus.Counter
+	poolRefills     *prometheus.CounterVec
+	jobEvents       *prometheus.CounterVec
+	breakerChanges  *prometheus.CounterVec
+	breakerState    *prometheus.GaugeVec
+}
+
+// newMetrics creates the registry with the Go runtime, process, database and image pool collectors.
+func newMetrics(s *Server) *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		cleanupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "render_db_cleanup_duration_seconds",
+			Help:    "Duration of the database cleanups.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+		poolHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "render_pool_hits_total",
+			Help: "Images that jobs were served from the image pool.",
+		}),
+		poolMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "render_pool_misses_total",
+			Help: "Jobs with pooled queries that the image pool had no unseen images for, which scraped live.",
+		}),
+		poolRefills: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "render_pool_refills_total",
+			Help: "Refills of a query of the image pool.",
+		}, []string{"query"}),
+		jobEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "render_job_events_total",
+			Help: "Job lifecycle events: jobs started and ended, queries switched to and searches refused by a provider.",
+		}, []string{"type"}),
+		breakerChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "render_circuit_breaker_transitions_total",
+			Help: "State changes of the circuit breaker of a provider, by the state it changed to.",
+		}, []string{"provider", "state"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "render_circuit_breaker_state",
+			Help: "State of the circuit breaker of a provider: 0 closed, 1 half-open, 2 open.",
+		}, []string{"provider"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.cleanupDuration,
+		m.poolHits,
+		m.poolMisses,
+		m.poolRefills,
+		m.jobEvents,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "render_topic_dropped_images_total",
+			Help: "Topic images dropped for subscribers and sinks that fell behind.",
+		}, func() float64 { return float64(s.scrapeManager.DroppedImages()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "render_shared_scrape_dropped_images_total",
+			Help: "Images dropped for jobs that fell behind a scrape shared with other jobs.",
+		}, func() float64 { return float64(s.scrapeManager.SharedDroppedImages()) }),
+		m.breakerChanges,
+		m.breakerState,
+		&dbCollector{s: s},
+		&poolCollector{s: s},
+	)
+	return m
+}
+
+// recordCleanup keeps the outcome of a database cleanup that started at start.
+func (m *promMetrics) recordCleanup(start time.Time, err error) {
+	elapsed := time.Since(start)
+	m.cleanupDuration.Observe(elapsed.Seconds())
+	info := &CleanupInfo{Time: start, Duration: config.Duration(elapsed)}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	m.lastCleanup.Store(info)
+}
+
+// breakerStateChanged logs and counts a state change of the circuit breaker of a provider.
+func (s *Server) breakerStateChanged(provider string, from, to reliability.State) {
+	if to == reliability.StateOpen {
+		s.log.Warn("Circuit breaker opened, scrapes are refused for a while.", "provider", provider, "from", from)
+	} else {
+		s.log.Info("Circuit breaker changed state.", "provider", provider, "from", from, "to", to)
+	}
+	value := map[reliability.State]float64{reliability.StateClosed: 0, reliability.StateHalfOpen: 1, reliability.StateOpen: 2}[to]
+	s.metrics.breakerChanges.WithLabelValues(provider, string(to)).Inc()
+	s.metrics.breakerState.WithLabelValues(provider).Set(value)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (s *Server) handleMetrics() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	dbSizeDesc          = prometheus.NewDesc("render_db_size_bytes", "Size of the database files, including a separate history database.", nil, nil)
+	dbHistorySizeDesc   = prometheus.NewDesc("render_db_history_size_bytes", "Size of a separate history database.", nil, nil)
+	dbBucketsDesc       = prometheus.NewDesc("render_db_buckets", "Number of buckets in the bbolt file.", nil, nil)
+	dbSchemaDesc        = prometheus.NewDesc("render_db_schema_version", "Schema version of the bbolt file.", nil, nil)
+	dbClientsDesc       = prometheus.NewDesc("render_db_history_clients", "Number of clients with a history of seen images.", nil, nil)
+	dbEntriesDesc       = prometheus.NewDesc("render_db_history_entries", "Number of history entries across all clients.", nil, nil)
+	dbClientEntriesDesc = prometheus.NewDesc("render_db_client_history_entries", "Number of history entries of a client.", []string{"client"}, nil)
+	dbAuditDesc         = prometheus.NewDesc("render_db_audit_entries", "Number of audit log entries.", nil, nil)
+	dbAPIKeysDesc       = prometheus.NewDesc("render_db_api_keys", "Number of API keys.", nil, nil)
+	dbLastCleanupDesc   = prometheus.NewDesc("render_db_last_cleanup_timestamp_seconds", "Time the last database cleanup started.", nil, nil)
+)
+
+// dbCollector reads the database stats when the metrics are scraped, so they are never stale.
+type dbCollector struct {
+	s *Server
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		dbSizeDesc, dbHistorySizeDesc, dbBucketsDesc, dbSchemaDesc, dbClientsDesc, dbEntriesDesc,
+		dbClientEntriesDesc, dbAuditDesc, dbAPIKeysDesc, dbLastCleanupDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	st, err := c.s.db.Stats()
+	if err != nil {
+		c.s.log.Error("Failed to read database stats", "error", err)
+		ch <- prometheus.NewInvalidMetric(dbSizeDesc, err)
+		return
+	}
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	gauge(dbSizeDesc, float64(st.Size))
+	gauge(dbHistorySizeDesc, float64(st.HistorySize))
+	gauge(dbBucketsDesc, float64(st.Buckets))
+	gauge(dbSchemaDesc, float64(st.SchemaVersion))
+	gauge(dbClientsDesc, float64(st.Clients))
+	gauge(dbEntriesDesc, float64(st.SeenImages))
+	for client, n := range st.ClientEntries {
+		gauge(dbClientEntriesDesc, float64(n), client)
+	}
+	gauge(dbAuditDesc, float64(st.AuditEntries))
+	gauge(dbAPIKeysDesc, float64(st.APIKeys))
+	if last := c.s.metrics.lastCleanup.Load(); last != nil {
+		gauge(dbLastCleanupDesc, float64(last.Time.Unix()))
+	}
+}
+
+var poolImagesDesc = prometheus.NewDesc("render_pool_images", "Number of images of a query in the image pool.", []string{"query"}, nil)
+
+// poolCollector reads the number of images of each query in the image pool when the metrics are scraped.
+type poolCollector struct {
+	s *Server
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolImagesDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for query, n := range c.s.pool.Counts() {
+		ch <- prometheus.MustNewConstMetric(poolImagesDesc, prometheus.GaugeValue, float64(n), query)
+	}
+}
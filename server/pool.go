@@ -1,46 +1,240 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"gopin/database"
 	"gopin/scraper"
+	"gopin/storage"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
 
+const (
+	// DefaultPoolDir is where the image pool is kept across restarts if no scraping.poolDir is configured.
+	DefaultPoolDir = "data/pool"
+)
+
+// poolIndexFile lists the images saved in a pool directory, oldest first.
+const poolIndexFile = "index.json"
+
 // ImagePool holds a collection of scraped images to be served to clients.
 type ImagePool struct {
 	images      []scraper.ScrapedImage
 	mu          sync.RWMutex
 	maxSize     int
+	limit       int // maxSize, or less while memory runs short
+	perQuery    int // Zero means no cap
 	lastRefresh time.Time
+	// dir, if set, keeps a copy of the pool on disk: every image in a file named by its hash, and an
+	// index of them in the pool's order.
+	dir string
 }
 
-// NewImagePool creates a new ImagePool.
-func NewImagePool(maxSize int) *ImagePool {
+// poolEntry is an image in the index of a pool directory.
+type poolEntry struct {
+	Hash  uint64 `json:"hash,string"`
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Query string `json:"query"`
+	// Title, Description and AltText describe the pin, so that jobs can exclude it by keyword.
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	AltText     string `json:"altText,omitempty"`
+}
+
+// NewImagePool creates a new ImagePool that holds up to maxSize images, and up to perQuery images of any
+// single query. A perQuery of zero leaves the queries uncapped.
+func NewImagePool(maxSize, perQuery int) *ImagePool {
 	return &ImagePool{
-		images:  make([]scraper.ScrapedImage, 0),
-		maxSize: maxSize,
+		images:   make([]scraper.ScrapedImage, 0),
+		maxSize:  maxSize,
+		limit:    maxSize,
+		perQuery: perQuery,
+	}
+}
+
+// SetLimit changes the number of images the pool holds, dropping the oldest images right away if it holds
+// more, from disk as well if the pool is kept there. A limit of zero or less restores the pool's size.
+func (ip *ImagePool) SetLimit(limit int) error {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+
+	if limit <= 0 || limit > ip.maxSize {
+		limit = ip.maxSize
+	}
+	ip.limit = limit
+	dropped := ip.trim()
+	if ip.dir == "" || len(dropped) == 0 {
+		return nil
+	}
+	if err := ip.writeIndex(); err != nil {
+		return err
+	}
+	for _, img := range dropped {
+		os.Remove(ip.imagePath(img.Hash))
+	}
+	return nil
+}
+
+// Open keeps the pool in dir, creating it if needed, and loads the images saved there before. Images
+// that can't be read are left out, and files that aren't in the index are removed. From then on, the
+// images added to the pool are saved to dir as well.
+func (ip *ImagePool) Open(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pool directory: %w", err)
+	}
+
+	var entries []poolEntry
+	data, err := os.ReadFile(filepath.Join(dir, poolIndexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read pool index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to parse pool index: %w", err)
+		}
+	}
+
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+
+	ip.dir = dir
+	ip.images = ip.images[:0]
+	for _, e := range entries {
+		data, err := os.ReadFile(ip.imagePath(e.Hash))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		ip.images = append(ip.images, scraper.ScrapedImage{Data: data, Hash: e.Hash, ID: e.ID, URL: e.URL, Query: e.Query,
+			Title: e.Title, Description: e.Description, AltText: e.AltText})
+	}
+	ip.trim()
+	if len(ip.images) != len(entries) {
+		if err := ip.writeIndex(); err != nil {
+			return err
+		}
 	}
+	ip.removeStale()
+	return nil
 }
 
-// AddImages adds a slice of images to the pool.
-func (ip *ImagePool) AddImages(images []scraper.ScrapedImage) {
+// AddImages adds a slice of images to the pool and returns how many were new. Images that are already
+// in it are left out. If the pool is kept on disk, the new images are saved and those that were dropped
+// are removed; the images are added to the pool even if that fails.
+func (ip *ImagePool) AddImages(images []scraper.ScrapedImage) (int, error) {
 	ip.mu.Lock()
 	defer ip.mu.Unlock()
 
 	// Add new images, remove old ones if over limit
-	ip.images = append(ip.images, images...)
-	if len(ip.images) > ip.maxSize {
-		// Keep only the newest images
-		ip.images = ip.images[len(ip.images)-ip.maxSize:]
+	var added []scraper.ScrapedImage
+	for _, img := range images {
+		if !slices.ContainsFunc(ip.images, func(pooled scraper.ScrapedImage) bool { return pooled.Hash == img.Hash }) {
+			ip.images = append(ip.images, img)
+			added = append(added, img)
+		}
 	}
+	dropped := ip.trim()
 	ip.lastRefresh = time.Now()
+
+	if ip.dir == "" || len(added) == 0 {
+		return len(added), nil
+	}
+	for _, img := range added {
+		if err := os.WriteFile(ip.imagePath(img.Hash), img.Data, 0644); err != nil {
+			return len(added), fmt.Errorf("failed to save pooled image: %w", err)
+		}
+	}
+	if err := ip.writeIndex(); err != nil {
+		return len(added), err
+	}
+	for _, img := range dropped {
+		os.Remove(ip.imagePath(img.Hash))
+	}
+	return len(added), nil
+}
+
+// trim drops the oldest images of the queries over their cap, then the oldest images over the limit of
+// the pool, and returns them. The caller must hold ip.mu.
+func (ip *ImagePool) trim() []scraper.ScrapedImage {
+	counts := make(map[string]int)
+	for _, img := range ip.images {
+		counts[img.Query]++
+	}
+
+	var dropped []scraper.ScrapedImage
+	kept := make([]scraper.ScrapedImage, 0, len(ip.images))
+	for _, img := range ip.images {
+		if ip.perQuery > 0 && counts[img.Query] > ip.perQuery {
+			counts[img.Query]--
+			dropped = append(dropped, img)
+			continue
+		}
+		kept = append(kept, img)
+	}
+	if excess := len(kept) - ip.limit; excess > 0 {
+		dropped = append(dropped, kept[:excess]...)
+		kept = kept[excess:]
+	}
+	ip.images = kept
+	return dropped
+}
+
+// imagePath returns the file an image of the pool is saved in.
+func (ip *ImagePool) imagePath(hash uint64) string {
+	return filepath.Join(ip.dir, fmt.Sprintf("%016x", hash))
+}
+
+// writeIndex replaces the index of the pool directory with the images in the pool. The caller must hold
+// ip.mu.
+func (ip *ImagePool) writeIndex() error {
+	entries := make([]poolEntry, 0, len(ip.images))
+	for _, img := range ip.images {
+		entries = append(entries, poolEntry{Hash: img.Hash, ID: img.ID, URL: img.URL, Query: img.Query,
+			Title: img.Title, Description: img.Description, AltText: img.AltText})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode pool index: %w", err)
+	}
+
+	// Written next to the index and renamed over it, so a crash never leaves half an index behind
+	path := filepath.Join(ip.dir, poolIndexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pool index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace pool index: %w", err)
+	}
+	return nil
+}
+
+// removeStale removes the files of the pool directory that aren't images in the pool, such as those
+// saved or dropped just before a crash. The caller must hold ip.mu.
+func (ip *ImagePool) removeStale() {
+	files, err := os.ReadDir(ip.dir)
+	if err != nil {
+		return
+	}
+	keep := map[string]bool{poolIndexFile: true}
+	for _, img := range ip.images {
+		keep[filepath.Base(ip.imagePath(img.Hash))] = true
+	}
+	for _, f := range files {
+		if !f.IsDir() && !keep[f.Name()] {
+			os.Remove(filepath.Join(ip.dir, f.Name()))
+		}
+	}
 }
 
 // GetRandomUnseenImage gets a random image from the pool that the client has not seen.
-func (ip *ImagePool) GetRandomUnseenImage(db *database.DB, clientName string) (*scraper.ScrapedImage, error) {
+func (ip *ImagePool) GetRandomUnseenImage(db storage.History, clientName string) (*scraper.ScrapedImage, error) {
 	ip.mu.RLock()
 	defer ip.mu.RUnlock()
 
@@ -58,3 +252,77 @@ func (ip *ImagePool) GetRandomUnseenImage(db *database.DB, clientName string) (*
 	}
 	return nil, fmt.Errorf("no unseen images in pool")
 }
+
+// Unseen returns up to n random images of the given queries from the pool that the client has not seen,
+// and the number of images of each of the queries that the client still hasn't seen after those.
+// The history is looked up without holding the pool's lock, so that refills aren't held up by it.
+func (ip *ImagePool) Unseen(db storage.History, clientName string, queries []string, n int) ([]scraper.ScrapedImage, map[string]int) {
+	ip.mu.RLock()
+	var candidates []scraper.ScrapedImage
+	for _, img := range ip.images {
+		if slices.Contains(queries, img.Query) {
+			candidates = append(candidates, img)
+		}
+	}
+	ip.mu.RUnlock()
+
+	var images []scraper.ScrapedImage
+	left := make(map[string]int)
+	for _, i := range rand.Perm(len(candidates)) {
+		img := candidates[i]
+		if seen, err := alreadySeen(db, clientName, img); err != nil || seen {
+			continue
+		}
+		if len(images) < n {
+			images = append(images, img)
+		} else {
+			left[img.Query]++
+		}
+	}
+	return images, left
+}
+
+// Len returns the number of images in the pool.
+func (ip *ImagePool) Len() int {
+	ip.mu.RLock()
+	defer ip.mu.RUnlock()
+
+	return len(ip.images)
+}
+
+// HasPin reports whether the image of a pin is in the pool.
+func (ip *ImagePool) HasPin(pinID string) bool {
+	ip.mu.RLock()
+	defer ip.mu.RUnlock()
+
+	return slices.ContainsFunc(ip.images, func(img scraper.ScrapedImage) bool { return img.ID == pinID })
+}
+
+// Counts returns the number of images of each query in the pool.
+func (ip *ImagePool) Counts() map[string]int {
+	ip.mu.RLock()
+	defer ip.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, img := range ip.images {
+		counts[img.Query]++
+	}
+	return counts
+}
+
+// openPool loads the image pool saved before a restart and keeps it on disk, if jobs are served from it.
+func (s *Server) openPool() {
+	cfg := s.current().config.Scraping
+	if !cfg.PoolFirst || cfg.PoolSize <= 0 {
+		return
+	}
+	dir := cfg.PoolDir
+	if dir == "" {
+		dir = DefaultPoolDir
+	}
+	if err := s.pool.Open(dir); err != nil {
+		s.log.Error("Failed to open image pool, keeping it in memory only", "error", err, "dir", dir)
+		return
+	}
+	s.log.Info("Loaded image pool", "images", s.pool.Len(), "dir", dir)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"gopin/config"
+	"gopin/manager"
+	"gopin/query"
+	"gopin/scraper"
+	"gopin/storage"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshInterval is the least time between two refills of the same query of the image pool if
+// no scraping.refreshInterval is configured.
+const DefaultRefreshInterval = 5 * time.Minute
+
+// poolFiller serves jobs from the image pool and refills its queries as they run low. A query is
+// refilled once a client drawing from the pool leaves fewer than lowWater of its images unseen, so
+// queries nobody asks for aren't scraped, and those that are in demand are scraped again before their
+// clients have seen all of them. Refills of a query are at least cooldown apart.
+type poolFiller struct {
+	s        *Server
+	pool     *ImagePool
+	queries  []string
+	perQuery int
+	lowWater int
+	cooldown time.Duration
+	refills  chan string
+
+	mu      sync.Mutex
+	pending map[string]bool      // Queries waiting for a refill or being refilled
+	last    map[string]time.Time // When each query was last refilled
+}
+
+// newPoolFiller creates the filler of the server's image pool from the scraping config.
+func newPoolFiller(s *Server) *poolFiller {
+	cfg := s.current().config.Scraping
+	queries := poolQueries(cfg)
+	f := &poolFiller{
+		s:        s,
+		pool:     s.pool,
+		queries:  queries,
+		perQuery: poolPerQuery(cfg.PoolSize, cfg.PoolPerQuery, len(queries)),
+		lowWater: cfg.PoolLowWater,
+		cooldown: time.Duration(cfg.RefreshInterval),
+		refills:  make(chan string, len(queries)),
+		pending:  make(map[string]bool),
+		last:     make(map[string]time.Time),
+	}
+	if f.lowWater <= 0 {
+		f.lowWater = max(f.perQuery/4, 1)
+	}
+	if f.cooldown <= 0 {
+		f.cooldown = DefaultRefreshInterval
+	}
+	return f
+}
+
+// poolQueries returns the queries the image pool is kept filled with, their templates filled in and
+// normalized.
+func poolQueries(cfg config.ScrapingConfig) []string {
+	return query.NormalizeAll(query.Fill(cfg.Queries, cfg.Placeholders))
+}
+
+// poolPerQuery returns the cap on the images of a query in the pool, which defaults to an even share of
+// the pool's size.
+func poolPerQuery(poolSize, perQuery, queries int) int {
+	if perQuery > 0 || queries == 0 {
+		return perQuery
+	}
+	return max(poolSize/queries, 1)
+}
+
+// start refills the queries that have fewer than lowWater images in the pool, then keeps refilling the
+// queries that run low until the server stops. It does nothing unless jobs are served from the pool.
+func (f *poolFiller) start() {
+	cfg := f.s.current().config
+	if !cfg.Scraping.PoolFirst || len(f.queries) == 0 || cfg.Scraping.PoolSize <= 0 {
+		return
+	}
+	if cfg.Maintenance.ReadOnly {
+		return // The pool is only served to jobs, which are refused
+	}
+
+	counts := f.pool.Counts()
+	for _, query := range f.queries {
+		if counts[query] < f.lowWater {
+			f.refill(query)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-f.s.ctx.Done():
+				return
+			case query := <-f.refills:
+				f.run(query)
+			}
+		}
+	}()
+}
+
+// serve returns up to n images of the queries that the client hasn't seen from the pool, counting the
+// images served as hits and a miss if there were none, and refills the queries the client has seen nearly all images of.
+func (f *poolFiller) serve(db storage.History, clientName string, queries []string, n int) []scraper.ScrapedImage {
+	pooled := slices.DeleteFunc(slices.Clone(queries), func(q string) bool { return !slices.Contains(f.queries, q) })
+	if len(pooled) == 0 {
+		return nil
+	}
+
+	images, left := f.pool.Unseen(db, clientName, pooled, n)
+	f.s.metrics.poolHits.Add(float64(len(images)))
+	if len(images) == 0 {
+		f.s.metrics.poolMisses.Inc()
+	}
+	for _, query := range pooled {
+		if left[query] < f.lowWater {
+			f.refill(query)
+		}
+	}
+	return images
+}
+
+// refill queues a refill of a query, unless one is already queued or running, or the last one was less
+// than the cooldown ago.
+func (f *poolFiller) refill(query string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending[query] || time.Since(f.last[query]) < f.cooldown {
+		return
+	}
+	f.pending[query] = true
+	f.refills <- query // Never blocks, as every query is queued at most once
+}
+
+// run scrapes up to perQuery images of a query that aren't in the pool yet as a low-priority job, and
+// adds them to the pool as they arrive, the oldest images of the query making room for them.
+func (f *poolFiller) run(query string) {
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		delete(f.pending, query)
+		f.last[query] = time.Now()
+	}()
+
+	job := f.s.scrapeManager.Submit("pool:"+query, manager.JobOptions{
+		Queries:     []string{query},
+		Limit:       f.perQuery,
+		Priority:    manager.PriorityLow,
+		MaxLifetime: f.s.current().jobLifetime(0),
+		SeenPin:     f.pool.HasPin, // Only new images count toward the limit
+	})
+	log := f.s.log.With("job", job.ID())
+	log.Info("Refilling image pool", "query", query)
+	f.s.metrics.poolRefills.WithLabelValues(query).Inc()
+
+	added := 0
+	for img := range job.Images() {
+		if img.Seen || img.Err != nil || len(img.Data) == 0 {
+			continue
+		}
+		n, err := f.pool.AddImages([]scraper.ScrapedImage{img})
+		if err != nil {
+			log.Error("Failed to save image pool", "error", err)
+		}
+		added += n
+	}
+	log.Info("Refilled image pool", "query", query, "reason", job.Reason(), "added", added, "size", f.pool.Len())
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofRoutes returns the net/http/pprof handlers. They are only served to admins, and only while
+// debug.pprof is enabled, so profiling can be switched on with a config reload.
+func (s *Server) pprofRoutes() []route {
+	handlers := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"/debug/pprof/", pprof.Index},
+		{"/debug/pprof/cmdline", pprof.Cmdline},
+		{"/debug/pprof/profile", pprof.Profile},
+		{"/debug/pprof/symbol", pprof.Symbol},
+		{"/debug/pprof/trace", pprof.Trace},
+	}
+
+	routes := make([]route, 0, len(handlers))
+	for _, h := range handlers {
+		routes = append(routes, route{RoutesDebug, h.pattern, s.requirePprof(s.authMiddleware(s.requireRole(RoleAdmin, h.handler)))})
+	}
+	return routes
+}
+
+// requirePprof answers 404 while profiling is disabled.
+func (s *Server) requirePprof(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.current().config.Debug.Pprof {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"gopin/manager"
+	"gopin/query"
+	"gopin/scraper"
+	"slices"
+	"strings"
+)
+
+// applyProfile applies the configured profile of a client to its principal. Where a token already
+// restricts the limit or the sources, the stricter of the two wins.
+func (s *Server) applyProfile(p *principal) {
+	prof, ok := s.current().config.Profiles[p.Name]
+	if !ok {
+		return
+	}
+	p.Profile = prof
+
+	if prof.MaxLimit > 0 && (p.MaxLimit == 0 || prof.MaxLimit < p.MaxLimit) {
+		p.MaxLimit = prof.MaxLimit
+	}
+	switch {
+	case len(prof.Sources) == 0 || slices.Contains(prof.Sources, "*"):
+	case p.Sources == nil || slices.Contains(p.Sources, "*"):
+		p.Sources = prof.Sources
+	default:
+		sources := []string{}
+		for _, source := range p.Sources {
+			if slices.Contains(prof.Sources, source) {
+				sources = append(sources, source)
+			}
+		}
+		p.Sources = sources
+	}
+}
+
+// jobOptions builds the options of a job with ad-hoc queries, normalized, filling in the defaults of the
+// client's profile.
+func (p *principal) jobOptions(queries []string, limit, limitPerQuery int) manager.JobOptions {
+	if limit == 0 {
+		limit = p.Profile.DefaultLimit
+	}
+	if limitPerQuery == 0 {
+		limitPerQuery = p.Profile.LimitPerQuery
+	}
+	return manager.JobOptions{
+		Queries:       query.NormalizeAll(queries),
+		Limit:         limit,
+		LimitPerQuery: limitPerQuery,
+		MinImageBytes: p.Profile.MinImageBytes,
+		MaxImageBytes: p.Profile.MaxImageBytes,
+		Weight:        p.Profile.Weight,
+	}
+}
+
+// validateWeights checks that the weights of queries are between 1 and query.MaxWeight.
+func validateWeights(weights map[string]int) error {
+	for q, w := range weights {
+		if w < 1 || w > query.MaxWeight {
+			return fmt.Errorf("the weight of %q must be between 1 and %d", q, query.MaxWeight)
+		}
+	}
+	return nil
+}
+
+// validatePlaceholders checks that every placeholder of query templates has a name and values.
+func validatePlaceholders(placeholders map[string][]string) error {
+	for name, values := range placeholders {
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return fmt.Errorf("invalid placeholder name %q", name)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("placeholder %q has no values", name)
+		}
+	}
+	return nil
+}
+
+// priority returns the priority of a job that asks for the given one, which may not exceed the
+// priority of the client's profile. Jobs that don't ask get the profile's priority.
+func (p *principal) priority(requested string) (manager.Priority, error) {
+	limit, _ := manager.ParsePriority(p.Profile.Priority) // Checked when the config is loaded
+	if requested == "" {
+		return limit, nil
+	}
+	prio, err := manager.ParsePriority(requested)
+	if err != nil {
+		return manager.PriorityNormal, err
+	}
+	return min(prio, limit), nil
+}
+
+// imageFilter returns the image size filter of the client's profile, or nil if it has none.
+func (p *principal) imageFilter() func(scraper.ScrapedImage) bool {
+	return manager.SizeFilter(p.Profile.MinImageBytes, p.Profile.MaxImageBytes)
+}
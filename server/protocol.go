@@ -0,0 +1,218 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gopin/config"
+	"gopin/manager"
+	"gopin/scraper"
+	"gopin/storage"
+	"hash/crc32"
+	"time"
+
+	"github.com/lxzan/gws"
+)
+
+const (
+	// ModeStream delivers every image as its own binary frame.
+	ModeStream = "stream"
+	// ModeZip buffers images and delivers them as zip archives.
+	ModeZip = "zip"
+
+	// DefaultBatchSize is the number of images per zip archive when the
+	// client does not specify one.
+	DefaultBatchSize = 50
+)
+
+// ScrapeRequest defines the structure for a client's scrape request.
+type ScrapeRequest struct {
+	Queries       []string `json:"queries,omitempty"`
+	Limit         int      `json:"limit,omitempty"`
+	LimitPerQuery int      `json:"limitPerQuery,omitempty"`
+	Command       string   `json:"command,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	BatchSize     int      `json:"batchSize,omitempty"`
+	Topic         string   `json:"topic,omitempty"`
+	Query         string   `json:"query,omitempty"`
+	Priority      string   `json:"priority,omitempty"`
+	// MaxLifetime shortens jobs.maxLifetime for the job.
+	MaxLifetime config.Duration `json:"maxLifetime,omitempty"`
+	// Expand combines the queries with the configured scraping.modifiers.
+	Expand bool `json:"expand,omitempty"`
+	// Exclude drops the pins whose title, description or alt text contains any of these keywords.
+	Exclude []string `json:"exclude,omitempty"`
+	// Weights scrape some queries more often than others, overriding scraping.queryWeights.
+	Weights map[string]int `json:"weights,omitempty"`
+	// QueryOrder is "random", the default, "roundrobin" or "sequential".
+	QueryOrder string `json:"queryOrder,omitempty"`
+	// Variants scrape plurals, synonyms and translations of the queries once they are exhausted.
+	Variants bool `json:"variants,omitempty"`
+}
+
+// ErrorMessage reports a rejected request to the client.
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// QueuedMessage tells the client that its job waits for a running job to finish, and is sent again
+// whenever the job moves up in the queue.
+type QueuedMessage struct {
+	Type     string `json:"type"`
+	Position int    `json:"position"`
+}
+
+// ImageMessage precedes an image frame and describes its payload.
+type ImageMessage struct {
+	Type   string `json:"type"`
+	Pin    string `json:"pin"`
+	URL    string `json:"url,omitempty"`
+	Link   string `json:"link,omitempty"`
+	Hash   string `json:"hash"`
+	Size   int    `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// BatchMessage precedes a zip archive frame and describes its contents.
+type BatchMessage struct {
+	Type   string `json:"type"`
+	Count  int    `json:"count"`
+	Size   int    `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// QueryTotals holds the per-query counters of a finished job.
+type QueryTotals struct {
+	Sent    int `json:"sent"`
+	Deduped int `json:"deduped"`
+	Failed  int `json:"failed"`
+}
+
+// CompleteMessage is sent once a job has ended and summarizes what it delivered.
+type CompleteMessage struct {
+	Type    string                  `json:"type,omitempty"`
+	Topic   string                  `json:"topic,omitempty"`
+	Reason  string                  `json:"reason"`
+	Sent    int                     `json:"sent"`
+	Deduped int                     `json:"deduped"`
+	Failed  int                     `json:"failed"`
+	Queries map[string]*QueryTotals `json:"queries"`
+}
+
+// newCompleteMessage creates an empty completion summary.
+func newCompleteMessage() *CompleteMessage {
+	return &CompleteMessage{Type: "complete", Queries: make(map[string]*QueryTotals)}
+}
+
+// query returns the totals for a query, creating them if needed.
+func (m *CompleteMessage) query(q string) *QueryTotals {
+	t, ok := m.Queries[q]
+	if !ok {
+		t = &QueryTotals{}
+		m.Queries[q] = t
+	}
+	return t
+}
+
+// finish records why the source ended and merges in its failures.
+func (m *CompleteMessage) finish(reason string, failed map[string]int) {
+	m.Reason = reason
+	for q, n := range failed {
+		m.Failed += n
+		m.query(q).Failed += n
+	}
+}
+
+// clone returns a deep copy of the summary.
+func (m *CompleteMessage) clone() *CompleteMessage {
+	c := *m
+	c.Queries = make(map[string]*QueryTotals, len(m.Queries))
+	for q, t := range m.Queries {
+		totals := *t
+		c.Queries[q] = &totals
+	}
+	return &c
+}
+
+// newImageMessage builds the metadata frame for an image, including checksums of its payload.
+func newImageMessage(img scraper.ScrapedImage) ImageMessage {
+	sum := sha256.Sum256(img.Data)
+	return ImageMessage{
+		Type:   "image",
+		Pin:    img.ID,
+		URL:    img.URL,
+		Hash:   fmt.Sprintf("%d", img.Hash),
+		Size:   len(img.Data),
+		CRC32:  crc32.ChecksumIEEE(img.Data),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// writeError reports a rejected request to the client.
+func writeError(socket *gws.Conn, message string) error {
+	return writeJSON(socket, ErrorMessage{Type: "error", Message: message})
+}
+
+// writeJSON sends v to the client as a JSON text frame.
+func writeJSON(socket *gws.Conn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return socket.WriteMessage(gws.OpcodeText, data)
+}
+
+// QuotaPeriod is a client's usage and limits in the current UTC day or month.
+type QuotaPeriod struct {
+	Images    int       `json:"images"`
+	Bytes     int64     `json:"bytes"`
+	MaxImages int       `json:"maxImages,omitempty"`
+	MaxBytes  int64     `json:"maxBytes,omitempty"`
+	Resets    time.Time `json:"resets"`
+}
+
+// QuotaInfo describes a client's quota.
+type QuotaInfo struct {
+	// Exceeded names the limit the client has reached, such as "daily images".
+	Exceeded string      `json:"exceeded,omitempty"`
+	Daily    QuotaPeriod `json:"daily"`
+	Monthly  QuotaPeriod `json:"monthly"`
+}
+
+// QuotaExceededMessage is sent before the completion summary of a job that ended because the client ran out of quota.
+type QuotaExceededMessage struct {
+	Type string `json:"type"`
+	QuotaInfo
+}
+
+// StatusMessage answers the status command.
+type StatusMessage struct {
+	Type  string               `json:"type"`
+	Quota QuotaInfo            `json:"quota"`
+	Stats *storage.ClientStats `json:"stats,omitempty"`
+}
+
+// newQuotaInfo converts a quota status of the scrape manager.
+func newQuotaInfo(st manager.QuotaStatus) QuotaInfo {
+	return QuotaInfo{
+		Exceeded: st.Exceeded,
+		Daily: QuotaPeriod{
+			Images:    st.Daily.Images,
+			Bytes:     st.Daily.Bytes,
+			MaxImages: st.Limits.DailyImages,
+			MaxBytes:  st.Limits.DailyBytes,
+			Resets:    st.DailyReset,
+		},
+		Monthly: QuotaPeriod{
+			Images:    st.Monthly.Images,
+			Bytes:     st.Monthly.Bytes,
+			MaxImages: st.Limits.MonthlyImages,
+			MaxBytes:  st.Limits.MonthlyBytes,
+			Resets:    st.MonthlyReset,
+		},
+	}
+}
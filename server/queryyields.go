@@ -0,0 +1,72 @@
+package server
+
+import (
+	"cmp"
+	"gopin/storage"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// QueryYieldInfo is the lifetime yield of a query with the rates derived from it.
+type QueryYieldInfo struct {
+	Query string `json:"query"`
+	storage.QueryYield
+	// UnseenPerScrape is how many new pins a scrape of the query found on average.
+	UnseenPerScrape float64 `json:"unseenPerScrape"`
+	// UnseenRate is the share of the pins found that were new to the client, from 0 to 1.
+	UnseenRate float64 `json:"unseenRate"`
+	// ExhaustionRate is the share of the scrapes that ran out of results, from 0 to 1.
+	ExhaustionRate float64 `json:"exhaustionRate"`
+}
+
+// QueryYieldsResponse is the body of GET /api/admin/queries, the queries with the lowest yield first.
+type QueryYieldsResponse struct {
+	Queries []QueryYieldInfo `json:"queries"`
+}
+
+// newQueryYieldInfo derives the rates of a query's yield.
+func newQueryYieldInfo(query string, y storage.QueryYield) QueryYieldInfo {
+	info := QueryYieldInfo{Query: query, QueryYield: y}
+	if y.Scrapes > 0 {
+		info.UnseenPerScrape = float64(y.Unseen) / float64(y.Scrapes)
+		info.ExhaustionRate = float64(y.Exhausted) / float64(y.Scrapes)
+	}
+	if y.Found > 0 {
+		info.UnseenRate = float64(y.Unseen) / float64(y.Found)
+	}
+	return info
+}
+
+// handleQueryYields returns the lifetime yield of every scraped query, the lowest number of new pins
+// per scrape first, optionally only of the queries scraped at least minScrapes times.
+func (s *Server) handleQueryYields() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minScrapes := int64(0)
+		if v := r.URL.Query().Get("minScrapes"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeAPIError(w, http.StatusBadRequest, "minScrapes must be a non-negative integer")
+				return
+			}
+			minScrapes = n
+		}
+
+		yields, err := s.db.ListQueryYields()
+		if err != nil {
+			s.log.Error("Failed to list query yields", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to list query yields")
+			return
+		}
+		resp := QueryYieldsResponse{Queries: []QueryYieldInfo{}}
+		for q, y := range yields {
+			if y.Scrapes >= minScrapes {
+				resp.Queries = append(resp.Queries, newQueryYieldInfo(q, y))
+			}
+		}
+		slices.SortFunc(resp.Queries, func(a, b QueryYieldInfo) int {
+			return cmp.Or(cmp.Compare(a.UnseenPerScrape, b.UnseenPerScrape), cmp.Compare(a.Query, b.Query))
+		})
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"gopin/config"
+	"gopin/manager"
+	"net/http"
+	"time"
+
+	"github.com/lxzan/gws"
+)
+
+// applyQuotas passes the configured quotas, including those of client profiles, to the scrape manager.
+func (s *Server) applyQuotas() {
+	cfg := s.current().config
+	quotas := cfg.Quotas
+	clients := make(map[string]manager.QuotaLimits, len(quotas.Clients))
+	for name, q := range quotas.Clients {
+		clients[name] = quotaLimits(q)
+	}
+	// A profile's quota overrides both the default and the client's entry in quotas
+	for name, prof := range cfg.Profiles {
+		if prof.Quota != nil {
+			clients[name] = quotaLimits(*prof.Quota)
+		}
+	}
+	s.scrapeManager.SetQuotas(quotaLimits(quotas.Default), clients)
+}
+
+// startUsageFlusher starts a goroutine that writes the usage counted against the quotas to the database
+// every manager.UsageFlushInterval. Shutdown writes what is left.
+func (s *Server) startUsageFlusher() {
+	ticker := time.NewTicker(manager.UsageFlushInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.scrapeManager.FlushUsage()
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func quotaLimits(q config.QuotaConfig) manager.QuotaLimits {
+	return manager.QuotaLimits{
+		DailyImages:   q.DailyImages,
+		MonthlyImages: q.MonthlyImages,
+		DailyBytes:    q.DailyBytes,
+		MonthlyBytes:  q.MonthlyBytes,
+	}
+}
+
+// handleStatus reports the client's quota usage and statistics over REST, like the WebSocket status command.
+func (s *Server) handleStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientName := principalFrom(r.Context()).Name
+
+		msg, err := newStatusMessage(s.scrapeManager, s.db, s.log, clientName)
+		if err != nil {
+			s.log.Error("Failed to get quota status", "error", err, "client", clientName)
+			writeAPIError(w, http.StatusInternalServerError, "failed to get status")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, msg)
+	}
+}
+
+// writeQuotaExceeded tells a WebSocket client which limit ended its job and when it resets.
+func (c *wsHandler) writeQuotaExceeded(socket *gws.Conn, clientName string) {
+	log := c.logger(socket)
+	st, err := c.scrapeManager.QuotaStatus(clientName)
+	if err != nil {
+		log.Error("Failed to get quota status", "error", err, "client", clientName)
+		return
+	}
+	if err := writeJSON(socket, QuotaExceededMessage{Type: "quota_exceeded", QuotaInfo: newQuotaInfo(st)}); err != nil {
+		log.Error("Error sending quota message to client", "error", err, "client", clientName)
+	}
+}
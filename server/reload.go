@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"gopin/config"
+	"gopin/database"
+	"gopin/manager"
+	"gopin/pkg/credential"
+	"gopin/pkg/logger"
+	"reflect"
+	"time"
+)
+
+// settings is the part of the server's state derived from the config that can change at runtime.
+// It is replaced as a whole on reload, so readers see either the old or the new config.
+type settings struct {
+	config        *config.Config
+	jwt           *jwtVerifier
+	cors          *corsPolicy
+	blocklist     *queryBlocklist
+	rotationGrace time.Duration
+}
+
+// newSettings validates a config and derives the settings from it. It also returns the access rules,
+// which are applied to the running access control so its failure records survive a reload.
+func newSettings(cfg *config.Config, log *logger.Logger) (*settings, *accessControl, error) {
+	if err := validateRoles(cfg.Auth.Roles); err != nil {
+		return nil, nil, fmt.Errorf("invalid auth roles: %w", err)
+	}
+	if err := validateListeners(cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid listeners: %w", err)
+	}
+	if _, err := logger.ParseLevel(cfg.Log.Level); err != nil {
+		return nil, nil, fmt.Errorf("invalid log config: %w", err)
+	}
+	if err := validateWebSocket(cfg.WebSocket); err != nil {
+		return nil, nil, fmt.Errorf("invalid websocket config: %w", err)
+	}
+	switch cfg.Database.History {
+	case "", database.HistoryBolt, database.HistorySQLite, database.HistoryPostgres, database.HistoryRedis:
+	default:
+		return nil, nil, fmt.Errorf("invalid database config: unknown history backend %q", cfg.Database.History)
+	}
+	if _, err := database.ParseEncryptionKey(cfg.Database.EncryptionKey); err != nil {
+		return nil, nil, fmt.Errorf("invalid database config: %w", err)
+	}
+	if err := validateMemory(cfg.Memory); err != nil {
+		return nil, nil, fmt.Errorf("invalid memory config: %w", err)
+	}
+	if err := validateWeights(cfg.Scraping.QueryWeights); err != nil {
+		return nil, nil, fmt.Errorf("invalid scraping config: %w", err)
+	}
+	if err := validatePlaceholders(cfg.Scraping.Placeholders); err != nil {
+		return nil, nil, fmt.Errorf("invalid scraping config: %w", err)
+	}
+	if cfg.Scraping.CircuitBreaker.SuccessThreshold < 0 {
+		return nil, nil, fmt.Errorf("invalid scraping config: circuitBreaker successThreshold must not be negative")
+	}
+	if cfg.Jobs.MaxConcurrent < 0 {
+		return nil, nil, fmt.Errorf("invalid jobs config: maxConcurrent must not be negative")
+	}
+	for name, prof := range cfg.Profiles {
+		if prof.Weight < 0 {
+			return nil, nil, fmt.Errorf("invalid profile %s: weight must not be negative", name)
+		}
+		if _, err := manager.ParsePriority(prof.Priority); err != nil {
+			return nil, nil, fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+	}
+	for _, sc := range cfg.Schedules {
+		if err := validateSchedule(cfg, sc); err != nil {
+			return nil, nil, fmt.Errorf("invalid schedule %s: %w", sc.Name, err)
+		}
+	}
+	jwtVerifier, err := newJWTVerifier(cfg.Auth.JWT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure JWT authentication: %w", err)
+	}
+	access, err := newAccessControl(cfg.Access, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure access control: %w", err)
+	}
+	cors, err := newCORSPolicy(cfg.CORS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure CORS: %w", err)
+	}
+	blocklist, err := newQueryBlocklist(cfg.Blocklist)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid blocklist: %w", err)
+	}
+
+	for name, password := range cfg.Credentials {
+		if database.IsReserved(name) {
+			return nil, nil, fmt.Errorf("invalid credentials: client name %q is reserved, names starting with _ hold server data", name)
+		}
+		if !credential.IsHash(password) {
+			log.Warn("Password is stored in plaintext, replace it with the output of renderctl hash", "client", name)
+		}
+	}
+	return &settings{config: cfg, jwt: jwtVerifier, cors: cors, blocklist: blocklist, rotationGrace: cfg.Auth.RotationGracePeriod.Or(defaultRotationGracePeriod)}, access, nil
+}
+
+// current returns the settings in effect.
+func (s *Server) current() *settings {
+	return s.settings.Load()
+}
+
+// jobLifetime returns the lifetime of a job that asks for the given one, which may not exceed
+// jobs.maxLifetime. Jobs that don't ask get jobs.maxLifetime.
+func (st *settings) jobLifetime(requested time.Duration) time.Duration {
+	limit := time.Duration(st.config.Jobs.MaxLifetime)
+	if requested > 0 && (limit == 0 || requested < limit) {
+		return requested
+	}
+	return limit
+}
+
+// Reload applies a changed config without dropping connections or jobs. Credentials, roles, JWT keys,
+// access rules, connection limits and timeouts, quotas, the job cap and lifetime, CORS, the blocklist,
+// topics, the scraping delays, user agents and query settings and the log level take effect immediately;
+// running jobs and topic subscriptions keep the queries they started with. Settings that are bound at
+// startup, such as ports, listeners, TLS, workers, the image pool and browser limits, database, delivery,
+// sinks, schedules, the log file, the WebSocket upgrader and tracing, keep their old values until a
+// restart. An invalid config is rejected as a whole.
+func (s *Server) Reload(cfg *config.Config) error {
+	next, access, err := newSettings(cfg, s.log)
+	if err != nil {
+		return err
+	}
+	if err := s.scraper.Reload(cfg.Scraping); err != nil {
+		return fmt.Errorf("invalid scraping config: %w", err)
+	}
+
+	old := s.current().config
+	if restart := restartRequired(old, cfg); len(restart) > 0 {
+		s.log.Warn("Changed settings require a restart to take effect", "settings", restart)
+	}
+	cfg.Port = old.Port
+	cfg.GRPCPort = old.GRPCPort
+	cfg.AdminAddress = old.AdminAddress
+	cfg.Listeners = old.Listeners
+	cfg.TLS = old.TLS
+	cfg.NumWorkers = old.NumWorkers
+	cfg.Scraping = withReloadable(old.Scraping, cfg.Scraping)
+	cfg.Database = old.Database
+	cfg.Delivery = old.Delivery
+	cfg.Sinks = old.Sinks
+	cfg.Schedules = old.Schedules
+	cfg.Log = withLevel(old.Log, cfg.Log.Level)
+	cfg.Tracing = old.Tracing
+	cfg.Maintenance.ReadOnly = old.Maintenance.ReadOnly
+
+	s.settings.Store(next)
+	s.access.update(access)
+	if !old.Maintenance.ReadOnly {
+		s.dropRotatedPasswords(old, cfg)
+	}
+	s.conns.setLimits(cfg.WebSocket.MaxConnections, cfg.WebSocket.MaxConnectionsPerClient)
+	s.applyQuotas()
+	s.scrapeManager.SetMaxJobs(cfg.Jobs.MaxConcurrent)
+	s.scrapeManager.SetQueryCooldown(exhaustedCooldown(cfg.Scraping))
+	s.scrapeManager.SetQueryWeights(cfg.Scraping.QueryWeights)
+	s.scrapeManager.SetPlaceholders(cfg.Scraping.Placeholders)
+	// A level set at runtime is only replaced when the configured level changes
+	if cfg.Log.Level != old.Log.Level {
+		level, _ := logger.ParseLevel(cfg.Log.Level)
+		s.log.SetLevel(level)
+		s.log.Info("Changed log level", "level", level)
+	}
+
+	s.log.Info("Configuration reloaded", "clients", len(cfg.Credentials), "topics", len(cfg.Topics))
+	return nil
+}
+
+// restartRequired lists the settings that differ between two configs but are only read at startup.
+func restartRequired(old, cfg *config.Config) []string {
+	var changed []string
+	fields := []struct {
+		name     string
+		old, new any
+	}{
+		{"port", old.Port, cfg.Port},
+		{"grpcPort", old.GRPCPort, cfg.GRPCPort},
+		{"adminAddress", old.AdminAddress, cfg.AdminAddress},
+		{"listeners", old.Listeners, cfg.Listeners},
+		{"tls", old.TLS, cfg.TLS},
+		{"numWorkers", old.NumWorkers, cfg.NumWorkers},
+		{"scraping", old.Scraping, withReloadable(old.Scraping, cfg.Scraping)},
+		{"database", old.Database, cfg.Database},
+		{"delivery", old.Delivery, cfg.Delivery},
+		{"sinks", old.Sinks, cfg.Sinks},
+		{"schedules", old.Schedules, cfg.Schedules},
+		{"log", withLevel(old.Log, ""), withLevel(cfg.Log, "")},
+		{"websocket", upgraderSettings(old.WebSocket), upgraderSettings(cfg.WebSocket)},
+		{"tracing", old.Tracing, cfg.Tracing},
+		{"maintenance.readOnly", old.Maintenance.ReadOnly, cfg.Maintenance.ReadOnly},
+	}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.old, f.new) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// withReloadable returns the scraping config old with the settings that can change at runtime taken from
+// cfg: the delays, user agents, modifiers, placeholders, query weights and exhausted cooldown. The image
+// pool, the browser limit and the circuit breaker keep the settings of old.
+func withReloadable(old, cfg config.ScrapingConfig) config.ScrapingConfig {
+	old.MinDelay, old.MaxDelay, old.Providers = cfg.MinDelay, cfg.MaxDelay, cfg.Providers
+	old.UserAgents = cfg.UserAgents
+	old.Modifiers = cfg.Modifiers
+	old.Placeholders = cfg.Placeholders
+	old.QueryWeights = cfg.QueryWeights
+	old.ExhaustedCooldown = cfg.ExhaustedCooldown
+	return old
+}
+
+// withLevel returns a log config with its level replaced, as the level is the only log setting
+// that can change at runtime.
+func withLevel(cfg config.LogConfig, level string) config.LogConfig {
+	cfg.Level = level
+	return cfg
+}
+
+// upgraderSettings returns the WebSocket settings that the upgrader is created with, leaving out the
+// connection limits and timeouts, which can change at runtime.
+func upgraderSettings(cfg config.WebSocketConfig) config.WebSocketConfig {
+	return config.WebSocketConfig{
+		MaxMessageSize: cfg.MaxMessageSize,
+		ReadBufferSize: cfg.ReadBufferSize,
+		ParallelLimit:  cfg.ParallelLimit,
+		Compression:    cfg.Compression,
+	}
+}
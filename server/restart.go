@@ -0,0 +1,284 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"gopin/pkg/systemd"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables through which a restarting server passes its sockets to the new process.
+const (
+	envListeners = "RENDER_LISTENERS"
+	envReadyFD   = "RENDER_READY_FD"
+)
+
+// inheritedFDsStart is the first file descriptor passed to the new process, after stdin, stdout and stderr.
+const inheritedFDsStart = 3
+
+// restartReadyTimeout is how long the old process waits for the new one to take over its sockets, and
+// then to serve once the old one has released the database.
+const restartReadyTimeout = time.Minute
+
+var (
+	inheritedMu sync.Mutex
+	inherited   map[string]net.Listener
+	// predecessor is the pipe to the server that restarted into this process, which is told once this
+	// one serves
+	predecessor *os.File
+)
+
+// successor is the process a server restarted into, which takes over once the server has drained.
+type successor struct {
+	cmd   *exec.Cmd
+	ready *os.File // Read end of the pipe the new process writes to once it serves
+}
+
+// takeOverSockets takes the sockets passed by the server that restarted into this process and tells
+// it that they have been taken over, so that it drains while it keeps serving, and releases the
+// database. It reports false if the process wasn't started by a restart.
+func takeOverSockets() (bool, error) {
+	keys, ok := os.LookupEnv(envListeners)
+	if !ok {
+		return false, nil
+	}
+	readyFD, err := strconv.Atoi(os.Getenv(envReadyFD))
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReadyFD)
+	if err != nil {
+		return true, fmt.Errorf("invalid %s: %w", envReadyFD, err)
+	}
+	ready := os.NewFile(uintptr(readyFD), "ready")
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	inherited = make(map[string]net.Listener)
+	if keys != "" {
+		for i, key := range strings.Split(keys, ";") {
+			f := os.NewFile(uintptr(inheritedFDsStart+i), key)
+			lis, err := net.FileListener(f)
+			f.Close() // FileListener keeps a close-on-exec duplicate
+			if err != nil {
+				return true, fmt.Errorf("failed to use inherited socket %s: %w", key, err)
+			}
+			inherited[key] = lis
+		}
+	}
+
+	if _, err := ready.Write([]byte{1}); err != nil {
+		ready.Close()
+		return true, fmt.Errorf("failed to notify the old process: %w", err)
+	}
+	predecessor = ready
+	return true, nil
+}
+
+// notifyPredecessor tells the server that restarted into this process that this one has opened the
+// database and serves, so that it can exit.
+func notifyPredecessor() error {
+	if predecessor == nil {
+		return nil
+	}
+	defer func() {
+		predecessor.Close()
+		predecessor = nil
+	}()
+	_, err := predecessor.Write([]byte{1})
+	return err
+}
+
+// closeInherited closes the inherited sockets that the current config no longer listens on.
+func closeInherited() {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	for key, lis := range inherited {
+		if u, ok := lis.(*net.UnixListener); ok {
+			u.SetUnlinkOnClose(true) // Nothing listens on the path anymore
+		}
+		lis.Close()
+		delete(inherited, key)
+	}
+}
+
+// listen opens the socket of a listener, or takes over the socket inherited under the same key and
+// address from the server that restarted into this process. The socket is passed on by Restart.
+func (s *Server) listen(key, address string) (net.Listener, error) {
+	key += "=" + address
+
+	inheritedMu.Lock()
+	lis, ok := inherited[key]
+	delete(inherited, key)
+	inheritedMu.Unlock()
+
+	if !ok {
+		var err error
+		if lis, err = listen(address); err != nil {
+			return nil, err
+		}
+	}
+
+	s.socketsMu.Lock()
+	s.sockets[key] = lis
+	s.socketsMu.Unlock()
+	return lis, nil
+}
+
+// executable returns the path the server was started from, so that a binary replaced by an upgrade is
+// run instead of the deleted one os.Executable would return.
+func executable() (string, error) {
+	path := os.Args[0]
+	if !strings.ContainsRune(path, filepath.Separator) {
+		return exec.LookPath(path)
+	}
+	return filepath.Abs(path)
+}
+
+// Restart starts a new server process from the binary on disk and hands it the listening sockets. The
+// old process keeps serving them until Shutdown has drained it and released the database to the new
+// one, which then starts serving. If the new process fails to take the sockets over, it is killed, the
+// old one keeps serving and an error is returned.
+func (s *Server) Restart() error {
+	if s.drainer.isDraining() {
+		return errors.New("server is shutting down")
+	}
+	if !s.restarting.CompareAndSwap(false, true) {
+		return errors.New("a restart is already in progress")
+	}
+	if err := s.startRestart(); err != nil {
+		s.restarting.Store(false)
+		return err
+	}
+	return nil
+}
+
+// startRestart starts the new process and waits for it to take over the sockets.
+func (s *Server) startRestart() error {
+	path, err := executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the server binary: %w", err)
+	}
+
+	s.socketsMu.Lock()
+	keys := make([]string, 0, len(s.sockets))
+	files := make([]*os.File, 0, len(s.sockets)+1)
+	for key, lis := range s.sockets {
+		filer, ok := lis.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := filer.File()
+		if err != nil {
+			s.socketsMu.Unlock()
+			closeFiles(files)
+			return fmt.Errorf("failed to pass socket %s: %w", key, err)
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	s.socketsMu.Unlock()
+	defer closeFiles(files)
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create the ready pipe: %w", err)
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(restartEnv(),
+		envListeners+"="+strings.Join(keys, ";"),
+		envReadyFD+"="+strconv.Itoa(inheritedFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		ready.Close()
+		return fmt.Errorf("failed to start the new server: %w", err)
+	}
+	s.log.Info("Started new server process", "pid", cmd.Process.Pid, "path", path)
+
+	// The new process writes to the pipe once it has taken over the sockets, and again once it serves
+	if err := awaitByte(ready); err != nil {
+		ready.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new server process failed to take over the sockets: %w", err)
+	}
+	s.successor = &successor{cmd: cmd, ready: ready}
+	return nil
+}
+
+// awaitByte waits up to restartReadyTimeout for a byte from the new process. The read fails if the
+// process exits first.
+func awaitByte(ready *os.File) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(restartReadyTimeout):
+		return errors.New("timed out")
+	}
+}
+
+// handOver waits for the new process to serve, now that the old one has released the database, and
+// makes it the service's main process. A new process that doesn't serve in time is killed.
+func (s *Server) handOver() {
+	next := s.successor
+	defer next.ready.Close()
+	if err := awaitByte(next.ready); err != nil {
+		s.log.Error("New server process failed to take over", "error", err, "pid", next.cmd.Process.Pid)
+		next.cmd.Process.Kill()
+		next.cmd.Wait()
+		return
+	}
+	s.log.Info("New server process is serving", "pid", next.cmd.Process.Pid)
+	next.cmd.Process.Release()
+
+	if _, err := systemd.Notify(fmt.Sprintf("MAINPID=%d", next.cmd.Process.Pid)); err != nil {
+		s.log.Warn("Failed to tell systemd about the new process", "error", err)
+	}
+}
+
+// restartEnv returns the environment of the new process. The systemd watchdog is meant for the main
+// process, which the new one becomes.
+func restartEnv() []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "WATCHDOG_PID=") {
+			env = append(env, v)
+		}
+	}
+	return env
+}
+
+// keepSockets keeps the Unix sockets handed to a new process from being removed when the listeners of
+// the old one are closed.
+func (s *Server) keepSockets() {
+	s.socketsMu.Lock()
+	defer s.socketsMu.Unlock()
+
+	for _, lis := range s.sockets {
+		if u, ok := lis.(*net.UnixListener); ok {
+			u.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
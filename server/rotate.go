@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"gopin/pkg/credential"
+	"gopin/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lxzan/gws"
+)
+
+// defaultRotationGracePeriod is how long a rotated credential stays valid unless configured otherwise.
+const defaultRotationGracePeriod = 24 * time.Hour
+
+// errNotRotatable is returned for clients that authenticated with a token or certificate, which the server doesn't issue.
+var errNotRotatable = errors.New("only passwords and API keys can be rotated")
+
+// RotateResponse carries a client's new credential. The secret is only ever shown once.
+type RotateResponse struct {
+	Type string `json:"type"`
+	// Credential is "password" or "key", matching what the client authenticated with.
+	Credential         string    `json:"credential"`
+	Secret             string    `json:"secret"`
+	PreviousValidUntil time.Time `json:"previousValidUntil"`
+}
+
+// rotateCredential replaces the password or API key the client authenticated with. The old credential
+// keeps working for the grace period so the client can roll out the new one.
+func rotateCredential(db storage.Store, credentials map[string]string, p *principal, grace time.Duration) (RotateResponse, error) {
+	now := time.Now()
+	resp := RotateResponse{Type: "credentials", PreviousValidUntil: now.Add(grace)}
+
+	switch {
+	case p.Credential == "password":
+		stored, err := db.GetPassword(p.Name)
+		if err != nil {
+			return resp, err
+		}
+		previous := ""
+		if stored != nil {
+			previous = stored.Hash
+		} else if configured := credentials[p.Name]; credential.IsHash(configured) {
+			previous = configured
+		} else if configured != "" {
+			if previous, err = credential.HashPassword(configured); err != nil {
+				return resp, err
+			}
+		}
+
+		password := credential.NewPassword()
+		hash, err := credential.HashPassword(password)
+		if err != nil {
+			return resp, err
+		}
+		configured, err := credential.HashPassword(credentials[p.Name])
+		if err != nil {
+			return resp, err
+		}
+		err = db.SetPassword(p.Name, storage.StoredPassword{
+			Hash:            hash,
+			Previous:        previous,
+			PreviousExpires: resp.PreviousValidUntil,
+			Rotated:         now,
+			Configured:      configured,
+		})
+		if err != nil {
+			return resp, err
+		}
+		resp.Credential = "password"
+		resp.Secret = password
+		return resp, nil
+
+	case strings.HasPrefix(p.Credential, "key:"):
+		oldID := strings.TrimPrefix(p.Credential, "key:")
+		old, err := db.GetAPIKey(oldID)
+		if err != nil {
+			return resp, err
+		}
+		if old == nil {
+			return resp, fmt.Errorf("API key %s no longer exists", oldID)
+		}
+
+		id, secret, key := credential.NewKey()
+		hash, err := credential.HashPassword(secret)
+		if err != nil {
+			return resp, err
+		}
+		if err := db.AddAPIKey(storage.APIKey{ID: id, Client: p.Name, Hash: hash, Role: old.Role, Created: now}); err != nil {
+			return resp, err
+		}
+		// A key that already expires sooner keeps its earlier deadline.
+		if old.Expires.IsZero() || old.Expires.After(resp.PreviousValidUntil) {
+			if _, err := db.ExpireAPIKey(oldID, resp.PreviousValidUntil); err != nil {
+				return resp, err
+			}
+		} else {
+			resp.PreviousValidUntil = old.Expires
+		}
+		p.Credential = "key:" + id
+		resp.Credential = "key"
+		resp.Secret = key
+		return resp, nil
+	}
+	return resp, errNotRotatable
+}
+
+// handleRotate rotates the credential the client authenticated with.
+func (s *Server) handleRotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r.Context())
+		current := s.current()
+		resp, err := rotateCredential(s.db, current.config.Credentials, p, current.rotationGrace)
+		if errors.Is(err, errNotRotatable) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			s.log.Error("Failed to rotate credential", "error", err, "client", p.Name)
+			writeAPIError(w, http.StatusInternalServerError, "failed to rotate credential")
+			return
+		}
+		s.log.Info("Rotated credential", "client", p.Name, "credential", resp.Credential, "previousValidUntil", resp.PreviousValidUntil)
+		s.audit(p, storage.AuditRotate, resp.Credential)
+		writeAPIJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleRotate rotates the credential a WebSocket client authenticated with. Later rotations on the
+// same connection rotate the new credential.
+func (c *wsHandler) handleRotate(socket *gws.Conn, p *principal) {
+	log := c.logger(socket)
+	rotated := *p
+	current := c.settings.Load()
+	resp, err := rotateCredential(c.db, current.config.Credentials, &rotated, current.rotationGrace)
+	if errors.Is(err, errNotRotatable) {
+		writeError(socket, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error("Failed to rotate credential", "error", err, "client", p.Name)
+		writeError(socket, "failed to rotate credential")
+		return
+	}
+	socket.Session().Store("principal", &rotated)
+	log.Info("Rotated credential", "client", p.Name, "credential", resp.Credential, "previousValidUntil", resp.PreviousValidUntil)
+	c.audit(p, storage.AuditRotate, resp.Credential)
+	if err := writeJSON(socket, resp); err != nil {
+		log.Error("Error sending credential to client", "error", err, "client", p.Name)
+	}
+}
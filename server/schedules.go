@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"gopin/config"
+	"gopin/manager"
+	"gopin/sink"
+	"time"
+)
+
+// validateSchedule checks a scheduled job's config.
+func validateSchedule(cfg *config.Config, sc config.ScheduleConfig) error {
+	if sc.Name == "" {
+		return errors.New("name is required")
+	}
+	sched, err := manager.ParseSchedule(sc.Cron)
+	if err != nil {
+		return err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return errors.New("the schedule never comes up")
+	}
+	switch {
+	case sc.Topic != "" && len(sc.Queries) > 0:
+		return errors.New("set either topic or queries, not both")
+	case sc.Topic != "":
+		if len(cfg.Topics[sc.Topic]) == 0 {
+			return errors.New("unknown topic " + sc.Topic)
+		}
+	case len(sc.Queries) == 0:
+		return errors.New("topic or queries is required")
+	}
+	if sc.Limit <= 0 {
+		return errors.New("a positive limit is required")
+	}
+	if (sc.Sink == "") == (sc.Webhook.URL == "") {
+		return errors.New("set either sink or webhook")
+	}
+	if sc.Webhook.URL != "" {
+		if _, err := webhookSink(sc.Webhook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSchedules runs the configured scheduled jobs.
+func (s *Server) startSchedules() {
+	if s.current().config.Maintenance.ReadOnly {
+		return // Scheduled jobs are refused like any other
+	}
+	cfg := s.current().config
+	for _, sc := range cfg.Schedules {
+		name := "schedule:" + sc.Name
+		out, err := s.scheduleSink(sc)
+		if err != nil {
+			s.log.Error("Invalid schedule in config.json", "schedule", sc.Name, "error", err)
+			continue
+		}
+		queries := sc.Queries
+		if sc.Topic != "" {
+			queries = cfg.Topics[sc.Topic]
+		}
+		sched, _ := manager.ParseSchedule(sc.Cron) // Checked when the config is loaded
+
+		s.scrapeManager.RunSchedule(s.ctx, manager.ScheduledJob{
+			Name:     sc.Name,
+			Schedule: sched,
+			Options: manager.JobOptions{
+				Queries:       queries,
+				Limit:         sc.Limit,
+				LimitPerQuery: sc.LimitPerQuery,
+				Priority:      manager.PriorityLow,
+				MaxLifetime:   s.current().jobLifetime(0),
+			},
+			Run: func(job *manager.ScrapeJob) { s.deliverScheduled(name, out, job) },
+		})
+		s.log.Info("Scheduled job", "schedule", sc.Name, "cron", sc.Cron, "next", sched.Next(time.Now()))
+	}
+}
+
+// scheduleSink returns the sink a scheduled job delivers its images to.
+func (s *Server) scheduleSink(sc config.ScheduleConfig) (sink.Sink, error) {
+	if sc.Webhook.URL != "" {
+		return webhookSink(sc.Webhook)
+	}
+	out, ok := s.sinks[sc.Sink]
+	if !ok {
+		return nil, errors.New("unknown sink " + sc.Sink)
+	}
+	return out, nil
+}
+
+// webhookSink creates the webhook a scheduled job posts its images to.
+func webhookSink(cfg config.WebhookTargetConfig) (*sink.Webhook, error) {
+	return sink.NewWebhook(sink.WebhookConfig{URL: cfg.URL, Secret: cfg.Secret, Format: cfg.Format})
+}
+
+// deliverScheduled sends the images of a run of a scheduled job to its sink that the schedule hasn't
+// delivered before, then reports the run to the sink and records it in the job history.
+func (s *Server) deliverScheduled(name string, out sink.Sink, job *manager.ScrapeJob) {
+	log := s.log.With("job", job.ID())
+	summary := newCompleteMessage()
+	for img := range job.Images() {
+		seen, err := alreadySeen(s.db, name, img)
+		if err != nil {
+			log.Error("Error checking if image was seen", "error", err, "schedule", name)
+			continue
+		}
+		if seen {
+			summary.Deduped++
+			summary.query(img.Query).Deduped++
+			continue
+		}
+
+		span := startSendSpan(img, "sink")
+		err = out.Send(s.ctx, sink.Image{ScrapedImage: img, Client: name, JobID: job.ID()})
+		endSpan(span, err)
+		if err != nil {
+			log.Error("Error delivering image to sink", "error", err, "schedule", name)
+			summary.Failed++
+			summary.query(img.Query).Failed++
+			continue
+		}
+		summary.Sent++
+		summary.query(img.Query).Sent++
+		if err := s.db.MarkImageAsSeen(name, img.Hash, img.ID); err != nil {
+			log.Error("Error marking image as seen", "error", err, "schedule", name)
+		}
+	}
+
+	summary.finish(job.Reason(), job.Failed())
+	recordJob(s.db, log, JobKindSchedule, name, job, summary)
+	log.Info("Scheduled job complete", "schedule", name, "reason", summary.Reason, "sent", summary.Sent)
+	err := out.Complete(s.ctx, sink.Summary{
+		Client:  name,
+		JobID:   job.ID(),
+		Reason:  summary.Reason,
+		Sent:    summary.Sent,
+		Deduped: summary.Deduped,
+		Failed:  summary.Failed,
+	})
+	if err != nil {
+		log.Error("Error reporting job completion to sink", "error", err, "schedule", name)
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopin/sink"
+	"gopin/storage"
+)
+
+// schemaBuilder derives JSON Schemas from Go types, collecting named structs as reusable components.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+// ref returns a schema for t, registering named struct types as components.
+func (b *schemaBuilder) ref(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "contentEncoding": "base64"}
+	case t.Kind() != reflect.Struct && t.Implements(reflect.TypeFor[encoding.TextMarshaler]()):
+		return map[string]any{"type": "string"} // Such as log levels, encoded by name
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.ref(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.ref(t.Elem())}
+	case reflect.Struct:
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = nil // Reserve the name so recursive types terminate
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// object returns the schema of a struct, flattening embedded structs like encoding/json does.
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" {
+				walk(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = b.ref(field.Type)
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// operation describes a REST endpoint that answers with status and, unless it is nil, a JSON body
+// of the response's type. request is likewise an example value of the request body type.
+func (b *schemaBuilder) operation(summary string, request any, status int, response any, errors ...int) map[string]any {
+	op := map[string]any{"summary": summary}
+	if request != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  b.content(reflect.TypeOf(request)),
+		}
+	}
+
+	success := map[string]any{"description": http.StatusText(status)}
+	if response != nil {
+		success["content"] = b.content(reflect.TypeOf(response))
+	}
+	responses := map[string]any{
+		strconv.Itoa(status): success,
+		"401":                map[string]any{"description": "Missing or invalid credentials"},
+	}
+	for _, code := range errors {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     b.content(reflect.TypeFor[APIError]()),
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// content returns a JSON media type map for t.
+func (b *schemaBuilder) content(t reflect.Type) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": b.ref(t)}}
+}
+
+// buildSchema returns the OpenAPI description of the REST endpoints and the WebSocket protocol.
+func buildSchema() map[string]any {
+	b := &schemaBuilder{components: make(map[string]any)}
+
+	paths := map[string]any{
+		"/api/jobs": map[string]any{
+			"get":  b.operation("List the finished jobs of the client, or of any client for admins, newest first", nil, http.StatusOK, JobHistoryResponse{}, http.StatusBadRequest, http.StatusForbidden),
+			"post": b.operation("Start a scraping job", JobRequest{}, http.StatusCreated, JobResponse{}, http.StatusBadRequest, http.StatusServiceUnavailable),
+		},
+		"/api/jobs/{id}": map[string]any{
+			"get": b.operation("Get the state of a job", nil, http.StatusOK, JobResponse{}, http.StatusNotFound),
+		},
+		"/api/jobs/{id}/images": map[string]any{
+			"get": b.operation("Page through the images of a job", nil, http.StatusOK, ImagesResponse{}, http.StatusBadRequest, http.StatusNotFound),
+		},
+		"/api/jobs/{id}/events": map[string]any{
+			"get": map[string]any{
+				"summary": "Follow a job as Server-Sent Events: image events carry an ImageEvent, the final complete event a CompleteMessage",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "OK", "content": map[string]any{"text/event-stream": map[string]any{}}},
+				},
+			},
+		},
+		"/api/gallery": map[string]any{
+			"get": b.operation("List recently delivered images", nil, http.StatusOK, GalleryResponse{}, http.StatusBadRequest),
+		},
+		"/api/status": map[string]any{
+			"get": b.operation("Get the quota usage and statistics of the client", nil, http.StatusOK, StatusMessage{}),
+		},
+		"/api/suggestions": map[string]any{
+			"get": b.operation("Get the related searches and hashtags seen in the results of a query", nil, http.StatusOK, SuggestionsResponse{}, http.StatusBadRequest),
+		},
+		"/api/audit": map[string]any{
+			"get": b.operation("List the audit log, newest first (admin only)", nil, http.StatusOK, AuditResponse{}, http.StatusBadRequest, http.StatusForbidden),
+		},
+		"/api/log/level": map[string]any{
+			"get": b.operation("Get the log level (admin only)", nil, http.StatusOK, LogLevel{}, http.StatusForbidden),
+			"put": b.operation("Change the log level until the configured level changes or the server restarts (admin only)", LogLevel{}, http.StatusOK, LogLevel{}, http.StatusBadRequest, http.StatusForbidden),
+		},
+		"/api/admin/logs": map[string]any{
+			"get": b.operation("List the newest log records kept in memory, newest first (admin only)", nil, http.StatusOK, LogsResponse{}, http.StatusBadRequest, http.StatusForbidden),
+		},
+		"/api/admin/clients": map[string]any{
+			"get": b.operation("List the clients with open WebSocket connections or running jobs (admin only)", nil, http.StatusOK, ClientsResponse{}, http.StatusForbidden),
+		},
+		"/api/admin/clients/stats": map[string]any{
+			"get": b.operation("List the lifetime statistics of every client (admin only)", nil, http.StatusOK, ClientStatsResponse{}, http.StatusForbidden),
+		},
+		"/api/admin/clients/{name}/stats": map[string]any{
+			"get": b.operation("Get the lifetime statistics of a client (admin only)", nil, http.StatusOK, storage.ClientStats{}, http.StatusForbidden),
+		},
+		"/api/admin/queries": map[string]any{
+			"get": b.operation("List the lifetime yield of every scraped query, lowest first (admin only)", nil, http.StatusOK, QueryYieldsResponse{}, http.StatusBadRequest, http.StatusForbidden),
+		},
+		"/api/admin/clients/{name}": map[string]any{
+			"delete": b.operation("Close the WebSocket connections of a client and stop its jobs (admin only)", nil, http.StatusOK, DisconnectResponse{}, http.StatusForbidden, http.StatusNotFound),
+		},
+		"/api/admin/jobs": map[string]any{
+			"get": b.operation("List the running jobs and topic scrapes (admin only)", nil, http.StatusOK, JobsResponse{}, http.StatusForbidden),
+		},
+		"/api/admin/jobs/{id}": map[string]any{
+			"delete": b.operation("Stop a running job (admin only)", nil, http.StatusNoContent, nil, http.StatusForbidden, http.StatusNotFound),
+		},
+		"/api/admin/events": map[string]any{
+			"get": map[string]any{
+				"summary": "Follow the lifecycle events of every job as Server-Sent Events, each carrying a JobEvent named by its type (admin only)",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "OK", "content": map[string]any{"text/event-stream": map[string]any{}}},
+				},
+			},
+		},
+		"/api/admin/stats": map[string]any{
+			"get": b.operation("Get the counters of the server and its database (admin only)", nil, http.StatusOK, StatsResponse{}, http.StatusForbidden),
+		},
+		"/api/admin/images": map[string]any{
+			"get": b.operation("List recently delivered images of every client (admin only)", nil, http.StatusOK, RecentImagesResponse{}, http.StatusBadRequest, http.StatusForbidden),
+		},
+		"/api/admin/cleanup": map[string]any{
+			"post": b.operation("Remove history, audit and job history entries past their configured age now (admin only)", nil, http.StatusNoContent, nil, http.StatusForbidden, http.StatusConflict, http.StatusServiceUnavailable),
+		},
+		"/api/admin/compact": map[string]any{
+			"post": b.operation("Compact the database file, returning the space of removed entries to the file system (admin only)", nil, http.StatusOK, storage.CompactResult{}, http.StatusForbidden, http.StatusNotImplemented, http.StatusServiceUnavailable),
+		},
+		"/api/credentials/rotate": map[string]any{
+			"post": b.operation("Rotate the password or API key the client authenticated with", nil, http.StatusOK, RotateResponse{}, http.StatusBadRequest, http.StatusServiceUnavailable),
+		},
+		"/api/images/{hash}/seen": map[string]any{
+			"post": b.operation("Mark an image as seen", nil, http.StatusNoContent, nil, http.StatusBadRequest, http.StatusServiceUnavailable),
+		},
+		"/images/{hash}": map[string]any{
+			"get": map[string]any{
+				"summary": "Fetch the bytes of a cached image",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "OK", "content": map[string]any{"image/*": map[string]any{}}},
+					"404": map[string]any{"description": "Not Found"},
+				},
+			},
+		},
+		"/api/schema": map[string]any{
+			"get": map[string]any{
+				"summary":   "This document",
+				"security":  []any{},
+				"responses": map[string]any{"200": map[string]any{"description": "OK"}},
+			},
+		},
+	}
+	for path, item := range paths {
+		var params []any
+		for _, name := range []string{"id", "hash", "name"} {
+			if strings.Contains(path, "{"+name+"}") {
+				params = append(params, parameter(name, "path", "string"))
+			}
+		}
+		if params != nil {
+			item.(map[string]any)["parameters"] = params
+		}
+	}
+	paths["/api/jobs/{id}/images"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{parameter("after", "query", "integer")}
+	paths["/api/admin/images"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{parameter("limit", "query", "integer")}
+	paths["/api/gallery"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{
+		parameter("query", "query", "string"),
+		parameter("limit", "query", "integer"),
+	}
+	paths["/api/admin/queries"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{parameter("minScrapes", "query", "integer")}
+	paths["/api/suggestions"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{
+		parameter("seed", "query", "string"),
+		parameter("limit", "query", "integer"),
+	}
+	paths["/api/admin/logs"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{
+		parameter("level", "query", "string"),
+		parameter("limit", "query", "integer"),
+	}
+	paths["/api/jobs"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{
+		parameter("client", "query", "string"),
+		parameter("limit", "query", "integer"),
+		parameter("before", "query", "integer"),
+	}
+	paths["/api/audit"].(map[string]any)["get"].(map[string]any)["parameters"] = []any{
+		parameter("client", "query", "string"),
+		parameter("action", "query", "string"),
+		parameter("limit", "query", "integer"),
+		parameter("before", "query", "integer"),
+	}
+
+	// Payloads of SSE image and job events and of webhooks and other sinks
+	b.ref(reflect.TypeFor[ImageEvent]())
+	b.ref(reflect.TypeFor[JobEvent]())
+	b.ref(reflect.TypeFor[sink.Metadata]())
+
+	// The WebSocket protocol has no OpenAPI equivalent, so it is described in an extension.
+	websocket := map[string]any{
+		"path": "/scrape",
+		"client": []any{
+			b.ref(reflect.TypeFor[ScrapeRequest]()),
+		},
+		"server": []any{
+			b.ref(reflect.TypeFor[QueuedMessage]()),
+			b.ref(reflect.TypeFor[ImageMessage]()),
+			b.ref(reflect.TypeFor[BatchMessage]()),
+			b.ref(reflect.TypeFor[TransferMessage]()),
+			b.ref(reflect.TypeFor[CompleteMessage]()),
+			b.ref(reflect.TypeFor[ErrorMessage]()),
+			b.ref(reflect.TypeFor[QuotaExceededMessage]()),
+			b.ref(reflect.TypeFor[StatusMessage]()),
+			b.ref(reflect.TypeFor[RotateResponse]()),
+			b.ref(reflect.TypeFor[DrainingMessage]()),
+		},
+		"binary": "Image bytes, ZIP batches, or chunks prefixed by a 16-byte RCNK header",
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info":    map[string]any{"title": "Render", "version": "1"},
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"serverName": map[string]any{"type": "apiKey", "in": "header", "name": "X-Server-Name"},
+				"password":   map[string]any{"type": "apiKey", "in": "header", "name": "X-Password"},
+				"bearer":     map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []any{
+			map[string]any{"serverName": []any{}, "password": []any{}},
+			map[string]any{"bearer": []any{}},
+		},
+		"paths":       paths,
+		"x-websocket": websocket,
+	}
+}
+
+// parameter describes a path or query parameter.
+func parameter(name, in, typ string) map[string]any {
+	return map[string]any{
+		"name":     name,
+		"in":       in,
+		"required": in == "path",
+		"schema":   map[string]any{"type": typ},
+	}
+}
+
+var schema = sync.OnceValue(buildSchema)
+
+// handleSchema serves the OpenAPI description of the server's protocols.
+func (s *Server) handleSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAPIJSON(w, http.StatusOK, schema())
+	}
+}
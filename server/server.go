@@ -2,21 +2,35 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"gopin/config"
 	"gopin/database"
 	"gopin/manager"
+	"gopin/pkg/credential"
 	"gopin/pkg/logger"
+	"gopin/pkg/tracing"
+	"gopin/query"
 	"gopin/scraper"
+	"gopin/sink"
+	"gopin/storage"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lxzan/gws"
+	"google.golang.org/grpc"
 )
 
+// Defaults of websocket.pingInterval and websocket.pingWait: the server pings every connection each
+// PingInterval, and a connection is closed once it hasn't pinged, answered a ping or sent a message
+// for PingInterval plus PingWait.
 const (
 	PingInterval = 5 * time.Second
 	PingWait     = 10 * time.Second
@@ -24,79 +38,227 @@ const (
 
 // Server holds the dependencies for the HTTP server.
 type Server struct {
-	router        *http.ServeMux
+	routes        []route
+	corsMethods   string // Methods of the routes, allowed in CORS preflights
 	upgrader      *gws.Upgrader
-	config        *config.Config
-	db            *database.DB
+	settings      atomic.Pointer[settings]
+	db            storage.Store
 	scraper       *scraper.Scraper
-	httpServer    *http.Server
+	httpServers   []*http.Server
+	grpcServer    *grpc.Server
 	log           *logger.Logger
 	scrapeManager *manager.ScrapeManager
+	apiJobs       *apiJobStore
+	sinks         map[string]sink.Sink
+	images        *imageCache
+	suggestions   *suggestionStore
+	pool          *ImagePool
+	poolFiller    *poolFiller
+	access        *accessControl
+	verified      *credential.Cache // Successful password and API key checks
+	conns         *connLimiter
+	drainer       *drainer
+	memory        *memoryGuard
+	metrics       *promMetrics
+	connections   *connRegistry
+	sockets       map[string]net.Listener // By the key they are passed on to a restarted server with
+	socketsMu     sync.Mutex
+	restarting    atomic.Bool
+	successor     *successor // The process a restart started, set once it took over the sockets
 	ctx           context.Context
+	cancel        context.CancelFunc
+	stopTracing   func(context.Context) error
+	started       time.Time
+
+	// challengeServer answers ACME challenges when certificates are obtained with autocert
+	challengeServer *http.Server
 }
 
 // New creates a new Server.
 func New(cfg *config.Config, log *logger.Logger) *Server {
-	db, err := database.Open("data/render.db")
+	settings, access, err := newSettings(cfg, log)
+	if err != nil {
+		log.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	restarted, err := takeOverSockets()
+	if err != nil {
+		log.Error("Failed to take over the sockets of the old server", "error", err)
+		os.Exit(1)
+	}
+
+	encryptionKey, _ := database.ParseEncryptionKey(cfg.Database.EncryptionKey) // Checked by newSettings
+	dbOptions := database.Options{
+		Timeout:       time.Second,
+		History:       cfg.Database.History,
+		SQLitePath:    cfg.Database.SQLitePath,
+		PostgresURL:   cfg.Database.PostgresURL,
+		RedisURL:      cfg.Database.RedisURL,
+		RedisPrefix:   cfg.Database.RedisPrefix,
+		EncryptionKey: encryptionKey,
+		ReadOnly:      cfg.Maintenance.ReadOnly,
+	}
+	if encryptionKey != nil && cfg.Database.History != "" && cfg.Database.History != database.HistoryBolt {
+		log.Warn("The encryption key doesn't apply to the history, which is kept in the clear outside of data/render.db", "history", cfg.Database.History)
+	}
+	if restarted {
+		// The old process holds the database until its running jobs have drained, however long they take
+		dbOptions.Timeout = 0
+		log.Info("Took over the sockets of the old server, waiting for it to release the database")
+	}
+	db, err := database.OpenOptions("data/render.db", dbOptions)
 	if err != nil {
 		log.Error("Failed to open database", "error", err)
 		os.Exit(1)
 	}
+	for _, name := range db.Migrated() {
+		log.Info("Migrated database", "migration", name)
+	}
+	if cfg.Maintenance.ReadOnly {
+		log.Warn("Read-only maintenance mode: the database is opened read-only, and jobs and other writes are refused")
+	}
 
-	scraperInstance, err := scraper.New(cfg.NumWorkers, log, cfg.Scraping.UserAgents)
+	scraperInstance, err := scraper.New(cfg.NumWorkers, log, cfg.Scraping)
 	if err != nil {
 		log.Error("Failed to create scraper", "error", err)
 		os.Exit(1)
 	}
 
+	stopTracing, err := startTracing(cfg.Tracing)
+	if err != nil {
+		log.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background()) // Use a separate context for the server
 	s := &Server{
-		router:        http.NewServeMux(),
-		config:        cfg,
 		db:            db,
 		scraper:       scraperInstance,
 		log:           log,
 		scrapeManager: manager.New(scraperInstance, db, log),
-		ctx:           context.Background(), // Use a separate context for the server
+		apiJobs:       newAPIJobStore(),
+		sinks:         make(map[string]sink.Sink),
+		images:        newImageCache(cfg.Delivery.ImageCacheSize),
+		suggestions:   newSuggestionStore(),
+		pool:          NewImagePool(cfg.Scraping.PoolSize, poolPerQuery(cfg.Scraping.PoolSize, cfg.Scraping.PoolPerQuery, len(poolQueries(cfg.Scraping)))),
+		access:        access,
+		verified:      credential.NewCache(verifiedTTL, verifiedSize),
+		conns:         newConnLimiter(cfg.WebSocket.MaxConnections, cfg.WebSocket.MaxConnectionsPerClient),
+		drainer:       new(drainer),
+		memory:        new(memoryGuard),
+		connections:   newConnRegistry(),
+		sockets:       make(map[string]net.Listener),
+		ctx:           ctx,
+		cancel:        cancel,
+		stopTracing:   stopTracing,
+		started:       time.Now(),
 	}
+	s.metrics = newMetrics(s)
+	s.settings.Store(settings)
+	s.poolFiller = newPoolFiller(s)
+
+	s.openPool()
+	s.applyQuotas()
+	s.scrapeManager.SetMaxJobs(cfg.Jobs.MaxConcurrent)
+	s.scrapeManager.SetQueryCooldown(exhaustedCooldown(cfg.Scraping))
+	s.scrapeManager.SetQueryWeights(cfg.Scraping.QueryWeights)
+	s.scrapeManager.SetPlaceholders(cfg.Scraping.Placeholders)
+	scraperInstance.OnRelated(s.suggestions.record)
+	scraperInstance.OnBreakerStateChange(s.breakerStateChanged)
 
-	upgrader := gws.NewUpgrader(s.newWsHandler(), &gws.ServerOption{
-		ParallelEnabled:   true, // This is the key change
-		Recovery:          gws.Recovery,
-		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
-	})
-	s.upgrader = upgrader
+	s.upgrader = gws.NewUpgrader(s.newWsHandler(), upgraderOption(cfg.WebSocket))
 
-	s.routes()
+	s.routes = s.buildRoutes()
+	s.corsMethods = corsMethods(s.routes)
 
 	s.startCleanupTicker()
+	s.startIdleSweeper()
+	s.startUsageFlusher()
+	s.startKeepalive()
+	s.startMemoryWatchdog()
+	s.startSinks()
+	s.startSchedules()
+	s.poolFiller.start()
+	s.countJobEvents()
+	s.access.startPruning(ctx)
 
 	return s
 }
 
 // Start runs the HTTP server.
 func (s *Server) Start() error {
-	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf(":%s", s.config.Port),
-		Handler: s.router,
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
 	}
 
-	s.log.Info("Server starting", "port", s.config.Port)
-	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	cfg := s.current().config
+	if cfg.GRPCPort != "" {
+		lis, err := s.listen("grpc", ":"+cfg.GRPCPort)
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		s.grpcServer = s.newGRPCServer(tlsConfig)
+		go func() {
+			s.log.Info("gRPC server starting", "port", cfg.GRPCPort)
+			if err := s.grpcServer.Serve(lis); err != nil {
+				s.log.Error("gRPC server failed", "error", err)
+			}
+		}()
+	}
+
+	if err := s.serveListeners(listenerConfigs(cfg, tlsConfig != nil), tlsConfig); err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 	return nil
 }
 
-// Shutdown gracefully shuts down the server.
+// Shutdown gracefully shuts down the server once the running jobs have been drained.
 func (s *Server) Shutdown(ctx context.Context) {
+	drained := s.drain(ctx)
 	s.log.Info("Shutting down server...")
 
-	// Shutdown the http server
-	if err := s.httpServer.Shutdown(ctx); err != nil {
-		s.log.Error("HTTP server shutdown error", "error", err)
+	// Stop background tasks such as cleanup and sinks, then the jobs that outlived the drain and the topics
+	s.cancel()
+	s.scrapeManager.StopAll()
+	select {
+	case <-drained:
+	case <-time.After(stoppedJobsTimeout):
+		s.log.Warn("Stopped jobs are still delivering, shutting down anyway", "timeout", stoppedJobsTimeout)
+	}
+
+	// Shutdown the http servers. After a restart, connections wait in the listen queue for the new process
+	if s.successor != nil {
+		s.keepSockets()
+	}
+	for _, srv := range s.httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.log.Error("HTTP server shutdown error", "error", err)
+		}
+	}
+
+	if s.challengeServer != nil {
+		s.challengeServer.Shutdown(ctx)
+	}
+
+	// Streams are long-lived, so don't wait for them to finish
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+
+	// Close the connections of sinks such as NATS and Kafka, now that no job delivers to them
+	s.closeSinks()
+
+	// Flush the spans that haven't been exported yet
+	if s.stopTracing != nil {
+		if err := s.stopTracing(ctx); err != nil {
+			s.log.Error("Tracing shutdown error", "error", err)
+		}
 	}
 
-	// Close the database connection
+	// Write the usage that is still in memory, then close the database connection
+	s.scrapeManager.FlushUsage()
 	if err := s.db.Close(); err != nil {
 		s.log.Error("Database close error", "error", err)
 	}
@@ -104,13 +266,51 @@ func (s *Server) Shutdown(ctx context.Context) {
 	// Close the browser manager
 	s.scraper.Close()
 
+	// The new process can open the database now, and serves once it has
+	if s.successor != nil {
+		s.handOver()
+	}
+
 	s.log.Info("Server shut down gracefully.")
 }
 
-// routes registers the HTTP handlers for the server.
-func (s *Server) routes() {
-	s.router.HandleFunc("/", s.handleIndex())
-	s.router.HandleFunc("/scrape", s.authMiddleware(s.handleScrape()))
+// buildRoutes returns the HTTP handlers of the server by route group.
+func (s *Server) buildRoutes() []route {
+	routes := []route{
+		{RoutesScrape, "/scrape", s.checkOrigin(s.authMiddleware(s.handleScrape()))},
+		{RoutesAPI, "POST /api/jobs", s.authMiddleware(s.requireRole(RoleScraper, s.requireWritable(s.handleCreateJob())))},
+		{RoutesAPI, "GET /api/jobs", s.authMiddleware(s.handleJobHistory())},
+		{RoutesAPI, "GET /api/jobs/{id}", s.authMiddleware(s.handleGetJob())},
+		{RoutesAPI, "GET /api/jobs/{id}/images", s.authMiddleware(s.handleJobImages())},
+		{RoutesAPI, "GET /api/jobs/{id}/events", s.authMiddleware(s.handleJobEvents())},
+		{RoutesAPI, "GET /images/{hash}", s.authMiddleware(s.handleImage())},
+		{RoutesAPI, "POST /api/images/{hash}/seen", s.authMiddleware(s.requireRole(RoleScraper, s.requireWritable(s.handleMarkSeen())))},
+		{RoutesAPI, "GET /api/gallery", s.authMiddleware(s.handleGallery())},
+		{RoutesAPI, "GET /api/status", s.authMiddleware(s.handleStatus())},
+		{RoutesAPI, "GET /api/suggestions", s.authMiddleware(s.handleSuggestions())},
+		{RoutesAPI, "POST /api/credentials/rotate", s.authMiddleware(s.requireWritable(s.handleRotate()))},
+		{RoutesAdmin, "GET /api/audit", s.authMiddleware(s.requireRole(RoleAdmin, s.handleAudit()))},
+		{RoutesAdmin, "GET /api/log/level", s.authMiddleware(s.requireRole(RoleAdmin, s.handleGetLogLevel()))},
+		{RoutesAdmin, "PUT /api/log/level", s.authMiddleware(s.requireRole(RoleAdmin, s.handleSetLogLevel()))},
+		{RoutesAdmin, "GET /api/admin/logs", s.authMiddleware(s.requireRole(RoleAdmin, s.handleRecentLogs()))},
+		{RoutesAdmin, "GET /api/admin/clients", s.authMiddleware(s.requireRole(RoleAdmin, s.handleListClients()))},
+		{RoutesAdmin, "GET /api/admin/clients/stats", s.authMiddleware(s.requireRole(RoleAdmin, s.handleListClientStats()))},
+		{RoutesAdmin, "GET /api/admin/clients/{name}/stats", s.authMiddleware(s.requireRole(RoleAdmin, s.handleClientStats()))},
+		{RoutesAdmin, "GET /api/admin/queries", s.authMiddleware(s.requireRole(RoleAdmin, s.handleQueryYields()))},
+		{RoutesAdmin, "DELETE /api/admin/clients/{name}", s.authMiddleware(s.requireRole(RoleAdmin, s.handleDisconnectClient()))},
+		{RoutesAdmin, "GET /api/admin/jobs", s.authMiddleware(s.requireRole(RoleAdmin, s.handleListJobs()))},
+		{RoutesAdmin, "DELETE /api/admin/jobs/{id}", s.authMiddleware(s.requireRole(RoleAdmin, s.handleStopJob()))},
+		{RoutesAdmin, "GET /api/admin/events", s.authMiddleware(s.requireRole(RoleAdmin, s.handleAdminEvents()))},
+		{RoutesAdmin, "POST /api/admin/compact", s.authMiddleware(s.requireRole(RoleAdmin, s.requireWritable(s.handleCompact())))},
+		{RoutesAdmin, "POST /api/admin/cleanup", s.authMiddleware(s.requireRole(RoleAdmin, s.requireWritable(s.handleCleanup())))},
+		{RoutesAdmin, "GET /api/admin/stats", s.authMiddleware(s.requireRole(RoleAdmin, s.handleStats()))},
+		{RoutesAdmin, "GET /api/admin/images", s.authMiddleware(s.requireRole(RoleAdmin, s.handleRecentImages()))},
+		{RoutesAdmin, "GET /admin/", s.handleDashboard()},
+		{RoutesUI, "GET /ui/", s.handleUI()},
+		{RoutesSchema, "GET /api/schema", s.handleSchema()},
+		{RoutesMetrics, "GET /metrics", s.handleMetrics()},
+	}
+	return append(routes, s.pprofRoutes()...)
 }
 
 // handleIndex is a simple handler for the root endpoint.
@@ -120,153 +320,387 @@ func (s *Server) handleIndex() http.HandlerFunc {
 	}
 }
 
-// authMiddleware checks for valid credentials before allowing access.
+// authMiddleware checks for valid credentials or a bearer token before allowing access.
 func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		serverName := r.Header.Get("X-Server-Name")
-		password := r.Header.Get("X-Password")
-
-		expectedPassword, ok := s.config.Credentials[serverName]
-		if !ok || expectedPassword != password {
+		ip := remoteIP(r.RemoteAddr)
+		clientName := r.Header.Get("X-Server-Name")
+
+		var p *principal
+		var ok bool
+		if wait := s.access.retryAfter(ip, clientName); wait > 0 {
+			// While the IP or client name is backed off, only a password that was verified recently gets through
+			if p, ok = s.checkCredentials(clientName, r.Header.Get("X-Password"), true); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+				return
+			}
+		} else if p, ok = s.authenticate(r); !ok {
+			s.access.authFailed(ip, clientName)
+			recordAudit(s.db, s.log, clientName, ip, storage.AuditAuthFailure, r.URL.Path)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		s.access.authSucceeded(ip, p.Name)
+		p.Addr = ip
+		s.applyProfile(p)
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
 	}
 }
 
 // handleScrape handles the websocket connection for scraping.
 func (s *Server) handleScrape() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Store the server name in the session context for later use
-		serverName := r.Header.Get("X-Server-Name")
+		// Store the client in the session context for later use
+		p := principalFrom(r.Context())
+		if s.drainer.isDraining() {
+			http.Error(w, errDraining, http.StatusServiceUnavailable)
+			return
+		}
 
 		socket, err := s.upgrader.Upgrade(w, r)
 		if err != nil {
 			s.log.Error("Failed to upgrade connection", "error", err)
 			return
 		}
-		socket.Session().Store("serverName", serverName)
+
+		conn := &wsConn{id: newConnID(), clientName: p.Name, remoteAddr: r.RemoteAddr, connected: time.Now(), socket: socket}
+		conn.touch()
+		log := s.log.With("conn", conn.id)
+		if reason := s.conns.acquire(p.Name); reason != nil {
+			log.Warn("Rejected connection over limit", "client", p.Name, "scope", reason.Scope, "limit", reason.Limit)
+			socket.WriteClose(CloseTooManyConnections, reason.bytes())
+			return
+		}
+		defer s.conns.release(p.Name)
+		s.connections.add(conn)
+		defer s.connections.remove(conn)
+		s.audit(p, storage.AuditConnect, "websocket")
+		recordConnect(s.db, s.log, p.Name)
+		log.Info("Client connected", "client", p.Name, "remoteAddr", r.RemoteAddr)
+
+		socket.Session().Store("serverName", p.Name)
+		socket.Session().Store("principal", p)
+		socket.Session().Store("header", r.Header) // Carries the trace context of the connection
+		socket.Session().Store("log", log)
+		socket.Session().Store("conn", conn)
 		socket.ReadLoop() // This must be a blocking call
 	}
 }
 
 // wsHandler implements the gws.Event interface.
 type wsHandler struct {
-	db            *database.DB
+	settings      *atomic.Pointer[settings]
+	db            storage.Store
 	log           *logger.Logger
 	scrapeManager *manager.ScrapeManager
+	drainer       *drainer
+	memory        *memoryGuard
+	pool          *poolFiller
+	images        *imageCache
+	delivery      config.DeliveryConfig
+	transferID    atomic.Uint32
 }
 
 func (s *Server) newWsHandler() *wsHandler {
 	return &wsHandler{
+		settings:      &s.settings,
 		db:            s.db,
 		log:           s.log,
 		scrapeManager: s.scrapeManager,
+		drainer:       s.drainer,
+		memory:        s.memory,
+		pool:          s.poolFiller,
+		images:        s.images,
+		delivery:      s.current().config.Delivery,
 	}
 }
 
-// ScrapeRequest defines the structure for a client's scrape request.
-type ScrapeRequest struct {
-	Queries []string `json:"queries,omitempty"`
-	Limit   int      `json:"limit,omitempty"`
-	Command string   `json:"command,omitempty"`
+func (c *wsHandler) OnOpen(socket *gws.Conn) {
+	_ = socket.SetDeadline(c.pingDeadline())
 }
 
-func (c *wsHandler) OnOpen(socket *gws.Conn) {
-	_ = socket.SetDeadline(time.Now().Add(PingInterval + PingWait))
+// pingDeadline returns when the connection is closed unless the client pings, answers a ping or
+// sends a message before.
+func (c *wsHandler) pingDeadline() time.Time {
+	cfg := c.settings.Load().config.WebSocket
+	return time.Now().Add(cfg.PingInterval.Or(PingInterval) + cfg.PingWait.Or(PingWait))
+}
+
+// newConnID returns a random identifier for a WebSocket connection.
+func newConnID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logger returns the logger of a connection, which tags its records with the connection ID.
+func (c *wsHandler) logger(socket *gws.Conn) *logger.Logger {
+	if v, ok := socket.Session().Load("log"); ok {
+		return v.(*logger.Logger)
+	}
+	return c.log
 }
 
 func (c *wsHandler) OnClose(socket *gws.Conn, err error) {
+	log := c.logger(socket)
 	clientNameVal, _ := socket.Session().Load("serverName")
 	clientName, _ := clientNameVal.(string)
 
-	c.scrapeManager.Stop(clientName)
-	c.log.Info("Client disconnected, stopping scrape pool", "client", clientName)
+	if c.drainer.isDraining() {
+		c.scrapeManager.Suspend(clientName) // Resumable once the server is back
+	} else {
+		c.scrapeManager.Stop(clientName)
+	}
+	c.scrapeManager.UnsubscribeAll(clientName)
+	log.Info("Client disconnected, stopping scrape pool", "client", clientName)
 
-	c.log.Info("Socket closed", "remoteAddr", socket.RemoteAddr(), "error", err, "client", clientName)
+	log.Info("Socket closed", "remoteAddr", socket.RemoteAddr(), "error", err, "client", clientName)
 }
 
 func (c *wsHandler) OnPing(socket *gws.Conn, payload []byte) {
-	_ = socket.SetDeadline(time.Now().Add(PingInterval + PingWait))
+	_ = socket.SetDeadline(c.pingDeadline())
 	_ = socket.WritePong(nil)
 }
 
-func (c *wsHandler) OnPong(socket *gws.Conn, payload []byte) {}
+// OnPong extends the deadline when a client answers the server's ping.
+func (c *wsHandler) OnPong(socket *gws.Conn, payload []byte) {
+	_ = socket.SetDeadline(c.pingDeadline())
+}
 
 func (c *wsHandler) OnMessage(socket *gws.Conn, message *gws.Message) {
 	defer message.Close()
+	log := c.logger(socket)
+	_ = socket.SetDeadline(c.pingDeadline()) // A client that sends messages is alive
+	if conn := connOf(socket); conn != nil {
+		conn.touch()
+	}
 
 	var req ScrapeRequest
 	if err := json.Unmarshal(message.Bytes(), &req); err != nil {
-		c.log.Warn("Invalid scrape request", "error", err, "remoteAddr", socket.RemoteAddr())
+		log.Warn("Invalid scrape request", "error", err, "remoteAddr", socket.RemoteAddr())
 		return
 	}
 
 	clientNameVal, _ := socket.Session().Load("serverName")
 	clientName, _ := clientNameVal.(string)
+	principalVal, _ := socket.Session().Load("principal")
+	p, _ := principalVal.(*principal)
+
+	// Everything but the status and rotate commands changes state, which read-only clients may not do
+	if req.Command != "status" && req.Command != "rotate" {
+		required := RoleScraper
+		if req.Command == "clear" {
+			required = RoleAdmin
+		}
+		if err := p.checkRole(required); err != nil {
+			writeError(socket, err.Error())
+			return
+		}
+	}
 
-	if req.Command == "clear" {
+	readOnly := c.settings.Load().readOnlyError()
+	switch req.Command {
+	case "clear":
+		if readOnly != "" {
+			writeError(socket, readOnly)
+			return
+		}
 		if err := c.db.ClearClientHistory(clientName); err != nil {
-			c.log.Error("Failed to clear client history", "error", err, "client", clientName)
+			log.Error("Failed to clear client history", "error", err, "client", clientName)
 		} else {
-			c.log.Info("Cleared client history", "client", clientName)
+			log.Info("Cleared client history", "client", clientName)
+			c.audit(p, storage.AuditClear, "")
+		}
+		return
+	case "status":
+		c.handleStatus(socket, clientName)
+		return
+	case "rotate":
+		if readOnly != "" {
+			writeError(socket, readOnly)
+			return
+		}
+		c.handleRotate(socket, p)
+		return
+	case "subscribe":
+		if err := p.checkSource("topic:" + req.Topic); err != nil {
+			writeError(socket, err.Error())
+			return
+		}
+		if readOnly != "" {
+			writeError(socket, readOnly)
+			return
+		}
+		if c.drainer.isDraining() {
+			writeError(socket, errDraining)
+			return
+		}
+		if c.memory.overloaded() {
+			writeError(socket, errOverloaded)
+			return
+		}
+		c.audit(p, storage.AuditSubscribe, req.Topic)
+		c.handleSubscribe(socket, p, req)
+		return
+	case "cancel_query":
+		if !c.scrapeManager.CancelQuery(clientName, req.Query) {
+			writeError(socket, fmt.Sprintf("query %q is not part of a running job", req.Query))
+			return
 		}
+		log.Info("Cancelled query", "client", clientName, "query", req.Query)
+		return
+	case "add_queries":
+		if err := p.checkSource(SourceQueries); err != nil {
+			writeError(socket, err.Error())
+			return
+		}
+		if msg := c.blockedQuery(p, req.Queries); msg != "" {
+			writeError(socket, msg)
+			return
+		}
+		added, ok := c.scrapeManager.AddQueries(clientName, req.Queries)
+		if !ok {
+			writeError(socket, "no running job to add queries to")
+			return
+		}
+		log.Info("Added queries to running job", "client", clientName, "added", added)
+		return
+	case "unsubscribe":
+		c.scrapeManager.Unsubscribe(clientName, req.Topic)
+		log.Info("Client unsubscribed from topic", "client", clientName, "topic", req.Topic)
 		return
 	}
 
-	if len(req.Queries) == 0 {
-		c.log.Warn("Received scrape request with no queries", "client", clientName)
+	var opts manager.JobOptions
+	if req.Command == "resume" {
+		saved, ok := c.scrapeManager.SavedJob(clientName)
+		if !ok {
+			writeError(socket, "no interrupted job to resume")
+			return
+		}
+		opts = saved
+		opts.Weight = p.Profile.Weight
+	} else {
+		opts = p.jobOptions(req.Queries, req.Limit, req.LimitPerQuery)
+		if len(opts.Queries) == 0 {
+			log.Warn("Received scrape request with no queries", "client", clientName)
+			return
+		}
+		if req.Expand {
+			opts.Modifiers = c.settings.Load().config.Scraping.Modifiers
+		}
+		opts.Exclude = req.Exclude
+		opts.QueryWeights = req.Weights
+		if err := validateWeights(req.Weights); err != nil {
+			writeError(socket, err.Error())
+			return
+		}
+		order, err := query.ParseOrder(req.QueryOrder)
+		if err != nil {
+			writeError(socket, err.Error())
+			return
+		}
+		opts.QueryOrder = order
+		opts.Variants = req.Variants
+	}
+	if err := p.checkQueryJob(opts.Limit); err != nil {
+		writeError(socket, err.Error())
+		return
+	}
+	if msg := c.blockedQuery(p, opts.Queries); msg != "" {
+		writeError(socket, msg)
+		return
+	}
+	priority, err := p.priority(req.Priority)
+	if err != nil {
+		writeError(socket, err.Error())
+		return
+	}
+	opts.Priority = priority
+	opts.MaxLifetime = c.settings.Load().jobLifetime(time.Duration(req.MaxLifetime))
+
+	if readOnly != "" {
+		writeError(socket, readOnly)
+		return
+	}
+	if c.memory.overloaded() {
+		writeError(socket, errOverloaded)
+		return
+	}
+	if !c.drainer.begin() {
+		writeError(socket, errDraining)
 		return
 	}
 
-	c.log.Info("Starting new scrape pool for client", "client", clientName, "queryCount", len(req.Queries), "limit", req.Limit)
-	imageChan := c.scrapeManager.Start(clientName, req.Queries, req.Limit)
+	c.audit(p, storage.AuditScrape, auditQueries(opts.Queries, opts.Limit))
+	headerVal, _ := socket.Session().Load("header")
+	header, _ := headerVal.(http.Header)
+	span := startRequestSpan(header, "websocket scrape", p)
+	opts.Trace = span.SpanContext()
+	opts.OnQueued = func(position int) {
+		writeJSON(socket, QueuedMessage{Type: "queued", Position: position})
+	}
+	if c.settings.Load().config.Scraping.PoolFirst {
+		opts.Pooled = c.pool.serve(c.db, clientName, opts.Queries, opts.Limit)
+	}
+	job := c.scrapeManager.Start(clientName, opts)
+	endSpan(span, nil, tracing.JobID.String(job.ID()))
+	log = log.With("job", job.ID())
+	log.Info("Starting new scrape pool for client", "client", clientName, "queryCount", len(opts.Queries), "limit", opts.Limit, "limitPerQuery", opts.LimitPerQuery, "pooled", len(opts.Pooled), "resumed", req.Command == "resume")
 
 	// Start a goroutine to stream images to this client
 	go func() {
-		for img := range imageChan {
-			// Check if the client has already seen this image
-			seen, err := c.db.HasClientSeenImage(clientName, img.Hash)
-			if err != nil {
-				c.log.Error("Error checking if image was seen", "error", err, "client", clientName)
-				continue
-			}
-			if seen {
-				continue // Skip seen images
-			}
+		defer c.drainer.end()
+		c.streamImages(socket, log, clientName, req, job)
+	}()
+}
 
-			// Send the raw image data
-			if err := socket.WriteMessage(gws.OpcodeBinary, img.Data); err != nil {
-				c.log.Error("Error sending image to client", "error", err, "client", clientName)
-				return // Stop if we can't send
-			}
+// handleStatus reports the client's quota usage and statistics.
+func (c *wsHandler) handleStatus(socket *gws.Conn, clientName string) {
+	log := c.logger(socket)
+	msg, err := newStatusMessage(c.scrapeManager, c.db, log, clientName)
+	if err != nil {
+		log.Error("Failed to get quota status", "error", err, "client", clientName)
+		writeError(socket, "failed to get status")
+		return
+	}
+	writeJSON(socket, msg)
+}
 
-			// Let the client know the pin ID
-			socket.WriteMessage(gws.OpcodeText, []byte(fmt.Sprintf("pin:%s", img.ID)))
+// handleSubscribe attaches the client to a configured topic and streams its images.
+func (c *wsHandler) handleSubscribe(socket *gws.Conn, p *principal, req ScrapeRequest) {
+	log := c.logger(socket)
+	clientName := p.Name
+	queries, ok := c.settings.Load().config.Topics[req.Topic]
+	if !ok || len(queries) == 0 {
+		log.Warn("Client subscribed to unknown topic", "client", clientName, "topic", req.Topic)
+		writeError(socket, fmt.Sprintf("unknown topic %q", req.Topic))
+		return
+	}
 
-			// Mark the image as seen for this client
-			if err := c.db.MarkImageAsSeen(clientName, img.Hash); err != nil {
-				c.log.Error("Error marking image as seen", "error", err, "client", clientName)
-			}
-		}
-	}()
+	log.Info("Client subscribed to topic", "client", clientName, "topic", req.Topic)
+	sub := c.scrapeManager.Subscribe(clientName, req.Topic, queries, p.imageFilter())
+	go c.streamImages(socket, log.With("topic", req.Topic), clientName, req, sub)
 }
 
 // startCleanupTicker starts a goroutine that periodically cleans up old entries from the database.
 func (s *Server) startCleanupTicker() {
-	cleanupInterval, err := time.ParseDuration(s.config.Database.CleanupInterval)
-	if err != nil {
-		s.log.Error("Invalid database cleanup interval in config.json", "error", err)
-		return
+	if s.current().config.Maintenance.ReadOnly {
+		return // Cleanup writes to the database
 	}
-
-	maxAge, err := time.ParseDuration(s.config.Database.MaxAge)
-	if err != nil {
-		s.log.Error("Invalid database max age in config.json", "error", err)
+	db := s.current().config.Database
+	cleanupInterval := time.Duration(db.CleanupInterval)
+	if cleanupInterval <= 0 {
+		s.log.Warn("Database cleanup is disabled, set database.cleanupInterval and database.maxAge to enable it")
 		return
 	}
+	if !s.retention().Expires() {
+		// The profiles may set a historyMaxAge on reload, so the ticker runs anyway
+		s.log.Warn("Database cleanup is disabled until database.maxAge or a profile's historyMaxAge is set")
+	}
+	auditMaxAge := db.AuditMaxAge.Or(defaultAuditMaxAge)
 
 	ticker := time.NewTicker(cleanupInterval)
 	go func() {
@@ -274,11 +708,8 @@ func (s *Server) startCleanupTicker() {
 		for {
 			select {
 			case <-ticker.C:
-				s.log.Info("Running database cleanup...")
-				if err := s.db.CleanupOldEntries(maxAge); err != nil {
-					s.log.Error("Database cleanup failed", "error", err)
-				} else {
-					s.log.Info("Database cleanup finished.")
+				if retention := s.retention(); retention.Expires() {
+					s.cleanupDatabase(retention, auditMaxAge)
 				}
 			case <-s.ctx.Done():
 				return
@@ -286,3 +717,63 @@ func (s *Server) startCleanupTicker() {
 		}
 	}()
 }
+
+// retention returns how long the history of each client is kept: database.maxAge, unless the client's
+// profile sets historyMaxAge or keepHistory.
+func (s *Server) retention() storage.Retention {
+	cfg := s.current().config
+	retention := storage.Retention{MaxAge: time.Duration(cfg.Database.MaxAge), Clients: make(map[string]time.Duration)}
+	for name, prof := range cfg.Profiles {
+		switch {
+		case prof.KeepHistory:
+			retention.Clients[name] = 0
+		case prof.HistoryMaxAge > 0:
+			retention.Clients[name] = time.Duration(prof.HistoryMaxAge)
+		}
+	}
+	return retention
+}
+
+// defaultExhaustedCooldown is how long queries that yielded no images are skipped unless configured otherwise.
+const defaultExhaustedCooldown = time.Hour
+
+// exhaustedCooldown returns how long queries that yielded no images are skipped, which is negative if
+// they aren't.
+func exhaustedCooldown(cfg config.ScrapingConfig) time.Duration {
+	return cfg.ExhaustedCooldown.Or(defaultExhaustedCooldown)
+}
+
+// cleanupDatabase removes the history entries past the retention of their client and audit entries
+// older than auditMaxAge, and forgets the queries that came off their cooldown. Its duration and
+// outcome are kept for the metrics and the admin stats.
+func (s *Server) cleanupDatabase(retention storage.Retention, auditMaxAge time.Duration) (err error) {
+	s.log.Info("Running database cleanup...")
+	start := time.Now()
+	defer func() { s.metrics.recordCleanup(start, err) }()
+	if err := s.db.CleanupOldEntries(retention); err != nil {
+		s.log.Error("Database cleanup failed", "error", err)
+		return err
+	}
+	if err := s.db.PruneAuditLog(auditMaxAge); err != nil {
+		s.log.Error("Audit log cleanup failed", "error", err)
+		return err
+	}
+	if err := s.db.PruneJobHistory(s.current().config.Database.JobHistoryMaxAge.Or(defaultJobHistoryMaxAge)); err != nil {
+		s.log.Error("Job history cleanup failed", "error", err)
+		return err
+	}
+	// The usage of the days and months before the last month is never counted toward a quota again
+	now := time.Now().UTC()
+	if err := s.db.PruneUsage(time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		s.log.Error("Usage cleanup failed", "error", err)
+		return err
+	}
+	if cooldown := exhaustedCooldown(s.current().config.Scraping); cooldown > 0 {
+		if err := s.db.PruneQueryCooldowns(cooldown); err != nil {
+			s.log.Error("Query cooldown cleanup failed", "error", err)
+			return err
+		}
+	}
+	s.log.Info("Database cleanup finished.", "duration", time.Since(start))
+	return nil
+}
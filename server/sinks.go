@@ -0,0 +1,166 @@
+package server
+
+import (
+	"gopin/config"
+	"gopin/sink"
+	"io"
+	"time"
+)
+
+// startSinks registers every configured server-side sink and subscribes those with a topic to it.
+func (s *Server) startSinks() {
+	if s.current().config.Maintenance.ReadOnly {
+		return // Sinks run jobs, which are refused
+	}
+	sinks := s.current().config.Sinks
+	for _, cfg := range sinks.Discord {
+		discord, err := sink.NewDiscord(sink.DiscordConfig{WebhookURL: cfg.WebhookURL, Username: cfg.Username})
+		if err != nil {
+			s.log.Error("Invalid Discord sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("discord:"+cfg.Name, cfg.Topic, cfg.Interval, discord)
+	}
+
+	for _, cfg := range sinks.Telegram {
+		telegram, err := sink.NewTelegram(sink.TelegramConfig{BotToken: cfg.BotToken, ChatIDs: cfg.ChatIDs})
+		if err != nil {
+			s.log.Error("Invalid Telegram sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("telegram:"+cfg.Name, cfg.Topic, cfg.Interval, telegram)
+	}
+
+	for _, cfg := range sinks.S3 {
+		bucket, err := sink.NewS3(sink.S3Config{
+			Endpoint:  cfg.Endpoint,
+			Region:    cfg.Region,
+			Bucket:    cfg.Bucket,
+			Prefix:    cfg.Prefix,
+			AccessKey: cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
+			Insecure:  cfg.Insecure,
+			PathStyle: cfg.PathStyle,
+		})
+		if err != nil {
+			s.log.Error("Invalid S3 sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("s3:"+cfg.Name, cfg.Topic, cfg.Interval, bucket)
+	}
+
+	for _, cfg := range sinks.Directory {
+		dir, err := sink.NewDirectory(sink.DirectoryConfig{Path: cfg.Path, Metadata: cfg.Metadata})
+		if err != nil {
+			s.log.Error("Invalid directory sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("directory:"+cfg.Name, cfg.Topic, cfg.Interval, dir)
+	}
+
+	// Queues come last since they may store payloads in one of the S3 sinks above.
+	for _, cfg := range sinks.NATS {
+		queue, err := sink.NewNATS(s.queueConfig(cfg), s.queueStore(cfg))
+		if err != nil {
+			s.log.Error("Invalid NATS sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("nats:"+cfg.Name, cfg.Topic, cfg.Interval, queue)
+	}
+
+	for _, cfg := range sinks.Kafka {
+		queue, err := sink.NewKafka(s.queueConfig(cfg), s.queueStore(cfg))
+		if err != nil {
+			s.log.Error("Invalid Kafka sink in config.json", "sink", cfg.Name, "error", err)
+			continue
+		}
+		s.addSink("kafka:"+cfg.Name, cfg.Topic, cfg.Interval, queue)
+	}
+}
+
+// closeSinks closes the sinks that hold connections, such as the NATS and Kafka sinks.
+func (s *Server) closeSinks() {
+	for name, out := range s.sinks {
+		if closer, ok := out.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				s.log.Error("Failed to close sink", "sink", name, "error", err)
+			}
+		}
+	}
+}
+
+// queueConfig converts a queue sink's config.
+func (s *Server) queueConfig(cfg config.QueueSinkConfig) sink.QueueConfig {
+	return sink.QueueConfig{Brokers: cfg.Brokers, Subject: cfg.Subject, Payload: cfg.Payload}
+}
+
+// queueStore returns the S3 sink a queue sink stores payloads in, if any.
+func (s *Server) queueStore(cfg config.QueueSinkConfig) *sink.S3 {
+	store, _ := s.sinks["s3:"+cfg.Store].(*sink.S3)
+	return store
+}
+
+// addSink makes a sink available to REST jobs under its name and, if it has a topic, starts feeding it.
+func (s *Server) addSink(name, topic string, interval config.Duration, out sink.Sink) {
+	s.sinks[name] = out
+	if topic != "" {
+		s.runSink("sink:"+name, topic, interval, out)
+	}
+}
+
+// runSink feeds a sink with the images of a topic it hasn't received before.
+// The sink's name doubles as the client name for its seen-history.
+func (s *Server) runSink(name, topic string, interval config.Duration, out sink.Sink) {
+	queries, ok := s.current().config.Topics[topic]
+	if !ok || len(queries) == 0 {
+		s.log.Error("Sink refers to an unknown topic", "sink", name, "topic", topic)
+		return
+	}
+
+	every := time.Duration(interval)
+	sub := s.scrapeManager.Subscribe(name, topic, queries, nil)
+	s.log.Info("Started sink", "sink", name, "topic", topic, "interval", every)
+
+	go func() {
+		defer s.scrapeManager.Unsubscribe(name, topic)
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case img, ok := <-sub.Images():
+				if !ok {
+					s.log.Warn("Sink topic ended", "sink", name, "topic", topic, "reason", sub.Reason(), "dropped", sub.Dropped())
+					return
+				}
+
+				seen, err := alreadySeen(s.db, name, img)
+				if err != nil {
+					s.log.Error("Error checking if image was seen", "error", err, "sink", name)
+					continue
+				}
+				if seen {
+					continue
+				}
+
+				span := startSendSpan(img, name)
+				err = out.Send(s.ctx, sink.Image{ScrapedImage: img, Client: name})
+				endSpan(span, err)
+				if err != nil {
+					s.log.Error("Error delivering image to sink", "error", err, "sink", name)
+					continue
+				}
+				if err := s.db.MarkImageAsSeen(name, img.Hash, img.ID); err != nil {
+					s.log.Error("Error marking image as seen", "error", err, "sink", name)
+				}
+
+				if every > 0 {
+					select {
+					case <-time.After(every):
+					case <-s.ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gopin/manager"
+	"gopin/pkg/logger"
+	"gopin/scraper"
+	"gopin/storage"
+	"hash/crc32"
+
+	"github.com/lxzan/gws"
+)
+
+// imageSource is a stream of images, such as a job or a topic subscription, that reports why it ended.
+type imageSource interface {
+	Images() <-chan scraper.ScrapedImage
+	Reason() string
+	Failed() map[string]int
+	QuotaExceeded(pending storage.Usage) bool
+}
+
+// alreadySeen reports whether a client has already seen an image, by its hash or by the ID of its pin.
+// Pins the job skipped without downloading them are seen.
+func alreadySeen(db storage.History, clientName string, img scraper.ScrapedImage) (bool, error) {
+	if img.Seen {
+		return true, nil
+	}
+	seen, err := db.HasClientSeenImage(clientName, img.Hash)
+	if err != nil || seen || img.ID == "" {
+		return seen, err
+	}
+	return db.HasClientSeenPin(clientName, img.ID)
+}
+
+// streamImages forwards unseen images from a source to the client until the source ends or the socket fails,
+// then sends a completion summary.
+func (c *wsHandler) streamImages(socket *gws.Conn, log *logger.Logger, clientName string, req ScrapeRequest, source imageSource) {
+	if conn := connOf(socket); conn != nil {
+		conn.streams.Add(1)
+		defer conn.touch() // The connection is idle from when its last stream ends
+		defer conn.streams.Add(-1)
+	}
+
+	var batch *zipBatch
+	if req.Mode == ModeZip {
+		batch = newZipBatch(req.BatchSize)
+	}
+	summary := newCompleteMessage()
+	summary.Topic = req.Topic
+	job, _ := source.(*manager.ScrapeJob) // Nil for topic subscriptions
+	defer recordJob(c.db, log, JobKindWebSocket, clientName, job, summary)
+
+	for img := range source.Images() {
+		// Check if the client has already seen this image
+		seen, err := alreadySeen(c.db, clientName, img)
+		if err != nil {
+			log.Error("Error checking if image was seen", "error", err, "client", clientName)
+			continue
+		}
+		if seen || (batch != nil && batch.contains(img.Hash)) {
+			summary.Deduped++
+			summary.query(img.Query).Deduped++
+			continue // Skip seen images
+		}
+
+		// The source ends once its client is out of quota, the images still buffered are not delivered
+		var pending storage.Usage
+		if batch != nil {
+			pending = batch.usage()
+		}
+		if source.QuotaExceeded(pending) {
+			continue
+		}
+
+		summary.Sent++
+		summary.query(img.Query).Sent++
+		if batch != nil {
+			// Batched images are marked as seen once their archive is sent.
+			if batch.add(img) {
+				if err := c.sendBatch(socket, log, clientName, batch); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		// Describe the image first so the client can verify the payload that follows
+		span := startSendSpan(img, "websocket")
+		if err := writeJSON(socket, newImageMessage(img)); err != nil {
+			log.Error("Error sending image metadata to client", "error", err, "client", clientName)
+			endSpan(span, err)
+			return
+		}
+
+		// Send the raw image data
+		err = c.writeBinary(socket, img.Data)
+		endSpan(span, err)
+		if err != nil {
+			log.Error("Error sending image to client", "error", err, "client", clientName)
+			return // Stop if we can't send
+		}
+
+		// Mark the image as seen for this client
+		if err := c.db.MarkImageAsSeen(clientName, img.Hash, img.ID); err != nil {
+			log.Error("Error marking image as seen", "error", err, "client", clientName)
+		}
+		c.recordDelivery(socket, clientName, 1, len(img.Data))
+		cacheImage(c.images, img, clientName)
+	}
+
+	// Deliver whatever is left of a partial batch once the job ends.
+	if batch != nil && batch.len() > 0 {
+		if err := c.sendBatch(socket, log, clientName, batch); err != nil {
+			return
+		}
+	}
+
+	if source.Reason() == manager.ReasonQuota {
+		c.writeQuotaExceeded(socket, clientName)
+	}
+
+	summary.finish(source.Reason(), source.Failed())
+	log.Info("Scrape job complete", "client", clientName, "reason", summary.Reason, "sent", summary.Sent, "deduped", summary.Deduped, "failed", summary.Failed)
+	if err := writeJSON(socket, summary); err != nil {
+		log.Error("Error sending completion summary to client", "error", err, "client", clientName)
+	}
+}
+
+// sendBatch packs the buffered images into a zip archive and sends it as a single binary frame.
+func (c *wsHandler) sendBatch(socket *gws.Conn, log *logger.Logger, clientName string, batch *zipBatch) error {
+	images := append([]scraper.ScrapedImage(nil), batch.images...)
+	archive, err := batch.flush()
+	if err != nil {
+		log.Error("Error building zip batch", "error", err, "client", clientName)
+		return err
+	}
+
+	sum := sha256.Sum256(archive)
+	msg := BatchMessage{
+		Type:   "batch",
+		Count:  len(images),
+		Size:   len(archive),
+		CRC32:  crc32.ChecksumIEEE(archive),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	if err := writeJSON(socket, msg); err != nil {
+		log.Error("Error sending zip batch metadata to client", "error", err, "client", clientName)
+		return err
+	}
+
+	if err := c.writeBinary(socket, archive); err != nil {
+		log.Error("Error sending zip batch to client", "error", err, "client", clientName)
+		return err
+	}
+
+	for _, img := range images {
+		if err := c.db.MarkImageAsSeen(clientName, img.Hash, img.ID); err != nil {
+			log.Error("Error marking image as seen", "error", err, "client", clientName)
+		}
+		cacheImage(c.images, img, clientName)
+	}
+	c.recordDelivery(socket, clientName, len(images), len(archive))
+
+	log.Debug("Sent zip batch", "client", clientName, "count", len(images), "bytes", len(archive))
+	return nil
+}
+
+// recordDelivery counts images sent over a connection toward the client's quota and the connection's totals.
+func (c *wsHandler) recordDelivery(socket *gws.Conn, clientName string, images, bytes int) {
+	c.scrapeManager.RecordUsage(clientName, images, int64(bytes))
+	if conn := connOf(socket); conn != nil {
+		conn.images.Add(int64(images))
+		conn.bytes.Add(int64(bytes))
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"cmp"
+	"gopin/query"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSuggestionSeeds caps the queries suggestions are kept for, the least recently scraped being
+	// dropped first.
+	maxSuggestionSeeds = 1000
+	// maxSuggestionsPerSeed caps the suggestions kept for a single query, the least seen being dropped
+	// first. It is also the most a single request returns.
+	maxSuggestionsPerSeed = 200
+	// defaultSuggestions is how many suggestions a request returns unless it asks for a limit.
+	defaultSuggestions = 20
+)
+
+// Sources of suggestions.
+const (
+	SuggestionRelated = "related"
+	SuggestionHashtag = "hashtag"
+)
+
+// Suggestion is a search term seen in the results of a query.
+type Suggestion struct {
+	Term string `json:"term"`
+	// Source is "related" for the related searches Pinterest suggests and "hashtag" for the hashtags of
+	// pins.
+	Source string `json:"source"`
+	// Count is how many times the term was seen.
+	Count int `json:"count"`
+}
+
+// SuggestionsResponse lists the suggestions for a seed query, the most seen first.
+type SuggestionsResponse struct {
+	Seed        string       `json:"seed"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// suggestionKey identifies a suggestion of a seed query.
+type suggestionKey struct {
+	term, source string
+}
+
+// seedSuggestions counts the suggestions seen in the results of a seed query.
+type seedSuggestions struct {
+	counts  map[suggestionKey]int
+	updated time.Time
+}
+
+// suggestionStore collects the related searches and hashtags seen in the results of every scraped query
+// since the server started.
+type suggestionStore struct {
+	mu    sync.Mutex
+	seeds map[string]*seedSuggestions
+}
+
+func newSuggestionStore() *suggestionStore {
+	return &suggestionStore{seeds: make(map[string]*seedSuggestions)}
+}
+
+// record counts the related searches and hashtags seen in the results of a query. It is called by the
+// scraper, see scraper.Scraper.OnRelated.
+func (st *suggestionStore) record(_, q string, related, hashtags []string) {
+	seed := query.Normalize(q)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.seeds[seed]
+	if !ok {
+		if len(st.seeds) >= maxSuggestionSeeds {
+			st.dropOldestSeed()
+		}
+		s = &seedSuggestions{counts: make(map[suggestionKey]int)}
+		st.seeds[seed] = s
+	}
+	s.updated = time.Now()
+	for _, term := range related {
+		s.add(seed, suggestionKey{query.Normalize(term), SuggestionRelated})
+	}
+	for _, tag := range hashtags {
+		s.add(seed, suggestionKey{query.Normalize(tag), SuggestionHashtag})
+	}
+}
+
+// dropOldestSeed drops the suggestions of the least recently scraped query. The caller must hold st.mu.
+func (st *suggestionStore) dropOldestSeed() {
+	oldest := ""
+	for seed, s := range st.seeds {
+		if oldest == "" || s.updated.Before(st.seeds[oldest].updated) {
+			oldest = seed
+		}
+	}
+	delete(st.seeds, oldest)
+}
+
+// add counts a suggestion, dropping the least seen one to make room if needed. Terms that are empty or
+// the seed itself are ignored.
+func (s *seedSuggestions) add(seed string, key suggestionKey) {
+	if key.term == "" || key.term == seed {
+		return
+	}
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= maxSuggestionsPerSeed {
+		least := suggestionKey{}
+		for k, n := range s.counts {
+			if least.term == "" || n < s.counts[least] {
+				least = k
+			}
+		}
+		delete(s.counts, least)
+	}
+	s.counts[key]++
+}
+
+// get returns up to limit suggestions for a seed query, the most seen first.
+func (st *suggestionStore) get(seed string, limit int) []Suggestion {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	suggestions := []Suggestion{}
+	if s, ok := st.seeds[seed]; ok {
+		for k, n := range s.counts {
+			suggestions = append(suggestions, Suggestion{Term: k.term, Source: k.source, Count: n})
+		}
+	}
+	slices.SortFunc(suggestions, func(a, b Suggestion) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Term, b.Term), cmp.Compare(a.Source, b.Source))
+	})
+	return suggestions[:min(limit, len(suggestions))]
+}
+
+// handleSuggestions returns the related searches and hashtags seen in the results of the seed query.
+func (s *Server) handleSuggestions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		seed := query.Normalize(r.URL.Query().Get("seed"))
+		if seed == "" {
+			writeAPIError(w, http.StatusBadRequest, "seed is required")
+			return
+		}
+		limit := defaultSuggestions
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = min(n, maxSuggestionsPerSeed)
+		}
+		writeAPIJSON(w, http.StatusOK, SuggestionsResponse{Seed: seed, Suggestions: s.suggestions.get(seed, limit)})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"gopin/pkg/systemd"
+	"time"
+)
+
+// notifyReady tells systemd that the server is serving and starts pinging its watchdog, if the server
+// runs as a Type=notify service.
+func (s *Server) notifyReady() {
+	ok, err := systemd.Notify(systemd.Ready)
+	if err != nil {
+		s.log.Warn("Failed to notify systemd", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	s.log.Info("Notified systemd that the server is ready")
+
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		s.log.Info("Pinging the systemd watchdog", "interval", interval/2)
+		go s.runWatchdog(interval / 2)
+	}
+}
+
+// runWatchdog pings the systemd watchdog for as long as the database answers, so that systemd
+// restarts a server that hangs.
+func (s *Server) runWatchdog(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.db.Ping(); err != nil {
+				s.log.Error("Skipping watchdog ping, the database is not responding", "error", err)
+				continue
+			}
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				s.log.Warn("Failed to ping the systemd watchdog", "error", err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig builds the TLS configuration of the HTTP and gRPC listeners from the tls config.
+// It returns nil if TLS is disabled. In autocert mode it also starts the HTTP listener that
+// answers ACME challenges and redirects plain HTTP requests to HTTPS.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	tlsConfig, err := s.serverTLSConfig()
+	if err != nil || tlsConfig == nil {
+		return tlsConfig, err
+	}
+
+	cfg := s.current().config.TLS
+	if cfg.ClientCAFile != "" {
+		pemData, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
+
+// serverTLSConfig returns the TLS configuration that provides the server's own certificate.
+func (s *Server) serverTLSConfig() (*tls.Config, error) {
+	cfg := s.current().config.TLS
+
+	switch {
+	case len(cfg.Autocert.Domains) > 0:
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			return nil, errors.New("tls.certFile and tls.keyFile can't be combined with tls.autocert")
+		}
+		cacheDir := cfg.Autocert.CacheDir
+		if cacheDir == "" {
+			cacheDir = "data/autocert"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Autocert.Domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      cfg.Autocert.Email,
+		}
+
+		httpPort := cfg.Autocert.HTTPPort
+		if httpPort == "" {
+			httpPort = "80"
+		}
+		s.challengeServer = &http.Server{
+			Addr:    fmt.Sprintf(":%s", httpPort),
+			Handler: m.HTTPHandler(nil),
+		}
+		lis, err := s.listen("acme", s.challengeServer.Addr)
+		if err != nil {
+			s.log.Error("ACME challenge server failed", "error", err)
+		} else {
+			go func() {
+				s.log.Info("ACME challenge server starting", "port", httpPort, "domains", cfg.Autocert.Domains)
+				if err := s.challengeServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					s.log.Error("ACME challenge server failed", "error", err)
+				}
+			}()
+		}
+		return m.TLSConfig(), nil
+
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+	return nil, nil
+}
+
+// certPrincipal maps the verified client certificate of a connection to a principal.
+// It reports false if there is no verified certificate or it isn't mapped to a client.
+func (s *Server) certPrincipal(state *tls.ConnectionState) (*principal, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, false
+	}
+	cert := state.VerifiedChains[0][0]
+
+	names := s.current().config.TLS.ClientNames
+	if len(names) == 0 {
+		if cert.Subject.CommonName == "" {
+			return nil, false
+		}
+		return &principal{Name: cert.Subject.CommonName, Role: s.roleOf(cert.Subject.CommonName)}, true
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	name, ok := names["sha256:"+hex.EncodeToString(fingerprint[:])]
+	if !ok {
+		name, ok = names[cert.Subject.CommonName]
+	}
+	if !ok {
+		s.log.Warn("Rejected unmapped client certificate", "commonName", cert.Subject.CommonName)
+		return nil, false
+	}
+	return &principal{Name: name, Role: s.roleOf(name)}, true
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"gopin/config"
+	"gopin/pkg/tracing"
+	"gopin/scraper"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startTracing installs the OTLP exporter if tracing is configured and returns the function that flushes it.
+func startTracing(cfg config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return nil, nil
+	}
+	return tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cfg.Endpoint,
+		Protocol:    cfg.Protocol,
+		Insecure:    cfg.Insecure,
+		Headers:     cfg.Headers,
+		SampleRatio: cfg.SampleRatio,
+		ServiceName: cfg.ServiceName,
+	})
+}
+
+// startRequestSpan starts the span of a request that starts a job. It continues the trace the caller
+// propagated in its headers or metadata, if any.
+func startRequestSpan(header map[string][]string, name string, p *principal) trace.Span {
+	_, span := tracing.Start(tracing.Extract(context.Background(), header), name, tracing.Client.String(p.Name))
+	return span
+}
+
+// startSendSpan starts the span of delivering an image as a child of the span of its download.
+func startSendSpan(img scraper.ScrapedImage, delivery string) trace.Span {
+	return tracing.StartFrom(img.Trace, "send",
+		tracing.Query.String(img.Query),
+		tracing.PinID.String(img.ID),
+		tracing.Delivery.String(delivery),
+		tracing.Bytes.Int(len(img.Data)),
+	)
+}
+
+// endSpan ends a span, marking it as failed if err is set.
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	span.SetAttributes(attrs...)
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
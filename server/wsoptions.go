@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"gopin/config"
+
+	"github.com/lxzan/gws"
+)
+
+// defaultParallelLimit is how many messages of a connection are handled at once if no limit is configured.
+const defaultParallelLimit = 8
+
+// validateWebSocket checks the message size, buffer and compression settings of the WebSocket server.
+func validateWebSocket(cfg config.WebSocketConfig) error {
+	if cfg.MaxMessageSize < 0 || cfg.ReadBufferSize < 0 || cfg.ParallelLimit < 0 {
+		return errors.New("maxMessageSize, readBufferSize and parallelLimit can't be negative")
+	}
+	c := cfg.Compression
+	if c.Level < 0 || c.Level > 9 {
+		return errors.New("compression.level must be between 1 and 9")
+	}
+	if c.WindowBits != 0 && (c.WindowBits < 8 || c.WindowBits > 15) {
+		return errors.New("compression.windowBits must be between 8 and 15")
+	}
+	if c.Threshold < 0 {
+		return errors.New("compression.threshold can't be negative")
+	}
+	return nil
+}
+
+// upgraderOption returns the options of the WebSocket upgrader. Zero sizes fall back to the defaults
+// of gws.
+func upgraderOption(cfg config.WebSocketConfig) *gws.ServerOption {
+	parallel := cfg.ParallelLimit
+	if parallel == 0 {
+		parallel = defaultParallelLimit
+	}
+	c := cfg.Compression
+	return &gws.ServerOption{
+		ParallelEnabled:    parallel > 1, // Messages such as cancel_query are handled while a job starts
+		ParallelGolimit:    parallel,
+		ReadMaxPayloadSize: cfg.MaxMessageSize,
+		ReadBufferSize:     cfg.ReadBufferSize,
+		Recovery:           gws.Recovery,
+		PermessageDeflate: gws.PermessageDeflate{
+			Enabled:               !c.Disabled,
+			Level:                 c.Level,
+			Threshold:             c.Threshold,
+			ServerContextTakeover: c.ContextTakeover,
+			ClientContextTakeover: c.ContextTakeover,
+			ServerMaxWindowBits:   c.WindowBits,
+			ClientMaxWindowBits:   c.WindowBits,
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopin/pkg/imaging"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirectoryConfig configures a local directory sink.
+type DirectoryConfig struct {
+	Path string `json:"path"`
+	// Metadata also writes a <pin>.json file next to every image.
+	Metadata bool `json:"metadata,omitempty"`
+}
+
+// Directory writes images to a local directory tree laid out as <path>/<client>/<query>/<date>/<pin>.<ext>.
+type Directory struct {
+	cfg DirectoryConfig
+}
+
+// NewDirectory creates a directory sink, creating the root directory if needed.
+func NewDirectory(cfg DirectoryConfig) (*Directory, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("directory path is required")
+	}
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	return &Directory{cfg: cfg}, nil
+}
+
+// Send writes the image, and optionally its metadata, to disk.
+func (d *Directory) Send(ctx context.Context, img Image) error {
+	dir := filepath.Join(d.cfg.Path, safeName(img.Client), safeName(img.Query), time.Now().Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	base := filepath.Join(dir, safeName(img.ID))
+	if err := os.WriteFile(base+imaging.Extension(img.Data), img.Data, 0644); err != nil {
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+
+	if d.cfg.Metadata {
+		meta, err := json.MarshalIndent(NewMetadata(img), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		if err := os.WriteFile(base+".json", meta, 0644); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// Complete does nothing; the directory tree is the result.
+func (d *Directory) Complete(ctx context.Context, summary Summary) error {
+	return nil
+}
+
+// safeName turns an arbitrary string into a portable file or directory name.
+func safeName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSpace(s))
+	name = strings.Trim(name, ".")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
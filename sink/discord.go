@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopin/pkg/imaging"
+	"gopin/pkg/reliability"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	discordAttempts  = 5
+	discordBaseDelay = time.Second
+)
+
+// DiscordConfig configures a Discord webhook sink.
+type DiscordConfig struct {
+	WebhookURL string `json:"webhookUrl"`
+	Username   string `json:"username,omitempty"`
+}
+
+// Discord posts images as attachments to a Discord channel webhook.
+// Each instance tracks the rate limit of its own channel.
+type Discord struct {
+	cfg        DiscordConfig
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// notBefore is the earliest time the next request may be sent without hitting the rate limit.
+	notBefore time.Time
+}
+
+// NewDiscord creates a Discord webhook sink.
+func NewDiscord(cfg DiscordConfig) (*Discord, error) {
+	if cfg.WebhookURL == "" {
+		return nil, fmt.Errorf("discord webhook url is required")
+	}
+	return &Discord{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Send uploads an image to the channel.
+func (d *Discord) Send(ctx context.Context, img Image) error {
+	payload, err := json.Marshal(map[string]string{"username": d.cfg.Username})
+	if err != nil {
+		return fmt.Errorf("failed to encode discord payload: %w", err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("payload_json", string(payload)); err != nil {
+		return fmt.Errorf("failed to write discord payload: %w", err)
+	}
+	part, err := mw.CreateFormFile("files[0]", img.ID+imaging.Extension(img.Data))
+	if err != nil {
+		return fmt.Errorf("failed to create discord attachment: %w", err)
+	}
+	part.Write(img.Data)
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize discord body: %w", err)
+	}
+
+	return reliability.Retry(ctx, discordAttempts, discordBaseDelay, func() error {
+		if err := d.waitForRateLimit(ctx); err != nil {
+			return reliability.Permanent(err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.cfg.WebhookURL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return reliability.Permanent(fmt.Errorf("failed to create discord request: %w", err))
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		resp, err := d.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("discord request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		d.updateRateLimit(resp)
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return fmt.Errorf("discord returned %s", resp.Status)
+		default:
+			return reliability.Permanent(fmt.Errorf("discord returned %s", resp.Status))
+		}
+	})
+}
+
+// Complete does nothing; job summaries are not posted to Discord.
+func (d *Discord) Complete(ctx context.Context, summary Summary) error {
+	return nil
+}
+
+// waitForRateLimit blocks until the channel's rate limit allows another request.
+func (d *Discord) waitForRateLimit(ctx context.Context) error {
+	d.mu.Lock()
+	wait := time.Until(d.notBefore)
+	d.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// updateRateLimit records when the next request may be sent, based on Discord's rate limit headers.
+func (d *Discord) updateRateLimit(resp *http.Response) {
+	var wait time.Duration
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var body struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
+			wait = time.Duration(body.RetryAfter * float64(time.Second))
+		}
+	} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if resetAfter, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+			wait = time.Duration(resetAfter * float64(time.Second))
+		}
+	}
+
+	if wait > 0 {
+		d.mu.Lock()
+		d.notBefore = time.Now().Add(wait)
+		d.mu.Unlock()
+	}
+}
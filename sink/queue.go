@@ -0,0 +1,173 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Payload modes of a queue sink.
+const (
+	// PayloadNone publishes metadata only.
+	PayloadNone = "none"
+	// PayloadInline includes the image bytes, base64 encoded, in every message.
+	PayloadInline = "inline"
+	// PayloadStore uploads the image to an object store and publishes its reference.
+	PayloadStore = "store"
+)
+
+// QueueConfig configures a message-queue sink.
+type QueueConfig struct {
+	// Brokers are the NATS server URLs or Kafka broker addresses.
+	Brokers []string `json:"brokers"`
+	// Subject is the NATS subject or Kafka topic messages are published to.
+	Subject string `json:"subject"`
+	// Payload is "none" (default), "inline" or "store".
+	Payload string `json:"payload,omitempty"`
+}
+
+// QueueMessage is published for every image.
+type QueueMessage struct {
+	Metadata
+	Data   []byte `json:"data,omitempty"`
+	Object string `json:"object,omitempty"`
+}
+
+// publisher sends messages to a broker.
+type publisher interface {
+	publish(ctx context.Context, key string, value []byte) error
+	// close sends the messages that are still buffered and closes the connection.
+	close() error
+}
+
+// Queue publishes image metadata, and optionally payloads or object-store references, to a message broker.
+type Queue struct {
+	cfg   QueueConfig
+	pub   publisher
+	store *S3
+}
+
+// NewNATS creates a sink that publishes to a NATS subject.
+// store is only used with the "store" payload mode.
+func NewNATS(cfg QueueConfig, store *S3) (*Queue, error) {
+	if err := cfg.validate(store); err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(strings.Join(cfg.Brokers, ","), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	return &Queue{cfg: cfg, pub: &natsPublisher{nc: nc, subject: cfg.Subject}, store: store}, nil
+}
+
+// NewKafka creates a sink that publishes to a Kafka topic, keyed by pin ID.
+// store is only used with the "store" payload mode.
+func NewKafka(cfg QueueConfig, store *S3) (*Queue, error) {
+	if err := cfg.validate(store); err != nil {
+		return nil, err
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Subject,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 100 * time.Millisecond,
+	}
+	return &Queue{cfg: cfg, pub: &kafkaPublisher{w: w}, store: store}, nil
+}
+
+// validate checks the config and fills in defaults.
+func (cfg *QueueConfig) validate(store *S3) error {
+	if len(cfg.Brokers) == 0 || cfg.Subject == "" {
+		return fmt.Errorf("queue brokers and subject are required")
+	}
+	switch cfg.Payload {
+	case "":
+		cfg.Payload = PayloadNone
+	case PayloadNone, PayloadInline:
+	case PayloadStore:
+		if store == nil {
+			return fmt.Errorf("payload mode %q requires an s3 store", PayloadStore)
+		}
+	default:
+		return fmt.Errorf("unknown payload mode %q", cfg.Payload)
+	}
+	return nil
+}
+
+// Send publishes a message for the image.
+func (q *Queue) Send(ctx context.Context, img Image) error {
+	msg := QueueMessage{Metadata: NewMetadata(img)}
+	switch q.cfg.Payload {
+	case PayloadInline:
+		msg.Data = img.Data
+	case PayloadStore:
+		if err := q.store.Send(ctx, img); err != nil {
+			return fmt.Errorf("failed to store image: %w", err)
+		}
+		msg.Object = q.store.URI(img)
+	}
+
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode queue message: %w", err)
+	}
+	if err := q.pub.publish(ctx, img.ID, value); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+// Complete publishes the job summary.
+func (q *Queue) Complete(ctx context.Context, summary Summary) error {
+	value, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Summary
+	}{Type: "complete", Summary: summary})
+	if err != nil {
+		return fmt.Errorf("failed to encode queue summary: %w", err)
+	}
+	return q.pub.publish(ctx, summary.JobID, value)
+}
+
+// Close sends the messages that are still buffered and closes the connection to the broker. The sink
+// can't be used afterwards.
+func (q *Queue) Close() error {
+	if err := q.pub.close(); err != nil {
+		return fmt.Errorf("failed to close queue connection: %w", err)
+	}
+	return nil
+}
+
+// natsPublisher publishes to a NATS subject.
+type natsPublisher struct {
+	nc      *nats.Conn
+	subject string
+}
+
+func (p *natsPublisher) publish(ctx context.Context, key string, value []byte) error {
+	return p.nc.Publish(p.subject, value)
+}
+
+func (p *natsPublisher) close() error {
+	defer p.nc.Close()
+	return p.nc.Flush()
+}
+
+// kafkaPublisher publishes to a Kafka topic.
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, key string, value []byte) error {
+	return p.w.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: value})
+}
+
+func (p *kafkaPublisher) close() error {
+	return p.w.Close()
+}
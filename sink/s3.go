@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopin/pkg/imaging"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configures an S3-compatible bucket, such as AWS S3 or MinIO.
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	// Insecure disables TLS, e.g. for a local MinIO.
+	Insecure bool `json:"insecure,omitempty"`
+	// PathStyle forces path-style bucket addressing, which most MinIO deployments need.
+	PathStyle bool `json:"pathStyle,omitempty"`
+}
+
+// S3 uploads every image and a metadata JSON next to it.
+// Objects of a job are grouped under <prefix>/<job id>/, other images under <prefix>/<date>/.
+type S3 struct {
+	cfg    S3Config
+	client *minio.Client
+}
+
+// NewS3 creates an S3 sink.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       !cfg.Insecure,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3{cfg: cfg, client: client}, nil
+}
+
+// Key returns the object key under which an image is stored.
+func (s *S3) Key(img Image) string {
+	group := img.JobID
+	if group == "" {
+		group = time.Now().UTC().Format("2006-01-02")
+	}
+	return path.Join(s.cfg.Prefix, group, img.ID) + imaging.Extension(img.Data)
+}
+
+// URI returns the s3:// reference of an image.
+func (s *S3) URI(img Image) string {
+	return "s3://" + s.cfg.Bucket + "/" + s.Key(img)
+}
+
+// Send uploads the image and its metadata.
+func (s *S3) Send(ctx context.Context, img Image) error {
+	key := s.Key(img)
+	base := strings.TrimSuffix(key, path.Ext(key))
+
+	_, err := s.client.PutObject(ctx, s.cfg.Bucket, key, bytes.NewReader(img.Data), int64(len(img.Data)), minio.PutObjectOptions{
+		ContentType: http.DetectContentType(img.Data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	meta, err := json.Marshal(NewMetadata(img))
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	_, err = s.client.PutObject(ctx, s.cfg.Bucket, base+".json", bytes.NewReader(meta), int64(len(meta)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload metadata: %w", err)
+	}
+	return nil
+}
+
+// Complete uploads the job summary as <prefix>/<job id>/summary.json.
+func (s *S3) Complete(ctx context.Context, summary Summary) error {
+	if summary.JobID == "" {
+		return nil
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	_, err = s.client.PutObject(ctx, s.cfg.Bucket, path.Join(s.cfg.Prefix, summary.JobID, "summary.json"), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload summary: %w", err)
+	}
+	return nil
+}
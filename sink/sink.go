@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"gopin/scraper"
+	"hash/crc32"
+	"strconv"
+)
+
+// Image is an unseen image handed to a sink together with where it came from.
+type Image struct {
+	scraper.ScrapedImage
+	Client string
+	JobID  string
+}
+
+// Metadata describes an image delivered by a sink.
+type Metadata struct {
+	Type   string `json:"type"`
+	Client string `json:"client"`
+	JobID  string `json:"job,omitempty"`
+	Pin    string `json:"pin"`
+	URL    string `json:"url"`
+	Query  string `json:"query"`
+	Hash   string `json:"hash"`
+	Size   int    `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewMetadata builds the metadata of an image, including checksums of its payload.
+func NewMetadata(img Image) Metadata {
+	sum := sha256.Sum256(img.Data)
+	return Metadata{
+		Type:   "image",
+		Client: img.Client,
+		JobID:  img.JobID,
+		Pin:    img.ID,
+		URL:    img.URL,
+		Query:  img.Query,
+		Hash:   strconv.FormatUint(img.Hash, 10),
+		Size:   len(img.Data),
+		CRC32:  crc32.ChecksumIEEE(img.Data),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// Summary describes a finished job for sinks that want to report it.
+type Summary struct {
+	Client  string `json:"client"`
+	JobID   string `json:"job"`
+	Reason  string `json:"reason"`
+	Sent    int    `json:"sent"`
+	Deduped int    `json:"deduped"`
+	Failed  int    `json:"failed"`
+}
+
+// Sink delivers images to a destination other than the client connection.
+type Sink interface {
+	// Send delivers a single image. An error means the image was not delivered.
+	Send(ctx context.Context, img Image) error
+	// Complete reports that a job has finished.
+	Complete(ctx context.Context, summary Summary) error
+}
@@ -0,0 +1,133 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopin/pkg/imaging"
+	"gopin/pkg/reliability"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	telegramAPI        = "https://api.telegram.org"
+	telegramAttempts   = 5
+	telegramBaseDelay  = time.Second
+	telegramPhotoLimit = 10 << 20 // larger images must be sent as documents
+)
+
+// TelegramConfig configures a Telegram bot sink.
+type TelegramConfig struct {
+	BotToken string   `json:"botToken"`
+	ChatIDs  []string `json:"chatIds"`
+}
+
+// Telegram posts images to chats and channels through the Telegram Bot API.
+type Telegram struct {
+	cfg        TelegramConfig
+	httpClient *http.Client
+}
+
+// telegramResponse is the envelope of every Bot API response.
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// NewTelegram creates a Telegram bot sink.
+func NewTelegram(cfg TelegramConfig) (*Telegram, error) {
+	if cfg.BotToken == "" {
+		return nil, fmt.Errorf("telegram bot token is required")
+	}
+	if len(cfg.ChatIDs) == 0 {
+		return nil, fmt.Errorf("at least one telegram chat id is required")
+	}
+	return &Telegram{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Send posts an image to every configured chat.
+func (t *Telegram) Send(ctx context.Context, img Image) error {
+	var errs []error
+	for _, chatID := range t.cfg.ChatIDs {
+		if err := t.sendTo(ctx, chatID, img); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Complete does nothing; job summaries are not posted to Telegram.
+func (t *Telegram) Complete(ctx context.Context, summary Summary) error {
+	return nil
+}
+
+// sendTo posts an image to a single chat, as a photo or, if it is too large, as a document.
+func (t *Telegram) sendTo(ctx context.Context, chatID string, img Image) error {
+	method, field := "sendPhoto", "photo"
+	if len(img.Data) > telegramPhotoLimit {
+		method, field = "sendDocument", "document"
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", chatID)
+	part, err := mw.CreateFormFile(field, img.ID+imaging.Extension(img.Data))
+	if err != nil {
+		return fmt.Errorf("failed to create telegram attachment: %w", err)
+	}
+	part.Write(img.Data)
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize telegram body: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/bot%s/%s", telegramAPI, t.cfg.BotToken, method)
+	return reliability.Retry(ctx, telegramAttempts, telegramBaseDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return reliability.Permanent(fmt.Errorf("failed to create telegram request: %w", err))
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		resp, err := t.httpClient.Do(req)
+		if err != nil {
+			// Strip the URL, which contains the bot token, from the error.
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("telegram request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		var result telegramResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+
+		switch {
+		case resp.StatusCode == http.StatusOK && result.OK:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait := time.Duration(result.Parameters.RetryAfter) * time.Second
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return reliability.Permanent(ctx.Err())
+			}
+			return fmt.Errorf("telegram rate limited: %s", result.Description)
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("telegram returned %s", resp.Status)
+		default:
+			return reliability.Permanent(fmt.Errorf("telegram returned %s: %s", resp.Status, result.Description))
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopin/pkg/reliability"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned by webhooks that only post to public addresses when their URL resolves
+// to a loopback, private, link-local or otherwise internal address.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+const (
+	// WebhookJSON posts image metadata, including the original URL, as JSON.
+	WebhookJSON = "json"
+	// WebhookMultipart posts the metadata and the image bytes as a multipart form.
+	WebhookMultipart = "multipart"
+
+	webhookAttempts  = 5
+	webhookBaseDelay = time.Second
+)
+
+// WebhookConfig is a client-registered webhook.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Secret signs every request with HMAC-SHA256 when set.
+	Secret string `json:"secret,omitempty"`
+	// Format is either "json" (default) or "multipart".
+	Format string `json:"format,omitempty"`
+	// PublicOnly refuses to connect to addresses that aren't public, checked on every connection after
+	// the host is resolved, so that a client can't make the server post to its own or internal services.
+	PublicOnly bool `json:"-"`
+}
+
+// Webhook posts images to an HTTP endpoint, signing and retrying every request.
+type Webhook struct {
+	cfg        WebhookConfig
+	httpClient *http.Client
+}
+
+// NewWebhook creates a webhook sink.
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook url must be an http or https url")
+	}
+	if cfg.Format == "" {
+		cfg.Format = WebhookJSON
+	}
+	if cfg.Format != WebhookJSON && cfg.Format != WebhookMultipart {
+		return nil, fmt.Errorf("unknown webhook format %q", cfg.Format)
+	}
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+		// A redirect could lead anywhere, and a POST turned into a GET delivers nothing anyway
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if cfg.PublicOnly {
+		if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !isPublic(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+		}
+		dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}
+		// Without a proxy, so that the address checked is the webhook's own
+		httpClient.Transport = &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   true,
+		}
+	}
+	return &Webhook{
+		cfg:        cfg,
+		httpClient: httpClient,
+	}, nil
+}
+
+// publicOnly is a net.Dialer.Control function refusing connections to addresses that aren't public.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip doesn't count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublic reports whether an address is reachable on the internet, rather than the host itself, a
+// private network or a link-local one such as a cloud metadata service.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// Send posts an image to the webhook.
+func (w *Webhook) Send(ctx context.Context, img Image) error {
+	meta, err := json.Marshal(NewMetadata(img))
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	if w.cfg.Format == WebhookJSON {
+		return w.post(ctx, "application/json", meta)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="metadata"`},
+		"Content-Type":        {"application/json"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create metadata part: %w", err)
+	}
+	part.Write(meta)
+	part, err = mw.CreateFormFile("image", img.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create image part: %w", err)
+	}
+	part.Write(img.Data)
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize multipart body: %w", err)
+	}
+	return w.post(ctx, mw.FormDataContentType(), body.Bytes())
+}
+
+// Complete posts the job summary to the webhook.
+func (w *Webhook) Complete(ctx context.Context, summary Summary) error {
+	body, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Summary
+	}{Type: "complete", Summary: summary})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook summary: %w", err)
+	}
+	return w.post(ctx, "application/json", body)
+}
+
+// post sends a signed request, retrying network errors, 429s and server errors with exponential backoff.
+func (w *Webhook) post(ctx context.Context, contentType string, body []byte) error {
+	return reliability.Retry(ctx, webhookAttempts, webhookBaseDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return reliability.Permanent(fmt.Errorf("failed to create webhook request: %w", err))
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "Render-Webhook")
+		if w.cfg.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-Render-Timestamp", timestamp)
+			req.Header.Set("X-Render-Signature", "sha256="+Sign(w.cfg.Secret, timestamp, body))
+		}
+
+		resp, err := w.httpClient.Do(req)
+		if errors.Is(err, ErrPrivateAddress) {
+			return reliability.Permanent(fmt.Errorf("webhook request failed: %w", err))
+		}
+		if err != nil {
+			return fmt.Errorf("webhook request failed: %w", err)
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		default:
+			return reliability.Permanent(fmt.Errorf("webhook returned %s", resp.Status))
+		}
+	})
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" with the given secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,396 @@
+// Package storage defines what the server keeps across restarts: the history of the images each client
+// has seen, its usage, API keys, rotated passwords and the audit log. The server and the scrape manager
+// only use the Store interface, so another backend can take the place of database.DB.
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReadOnly is returned by the writes of a store that was opened read-only.
+var ErrReadOnly = errors.New("the database is read-only")
+
+// Store is everything the server keeps.
+type Store interface {
+	History
+	UsageStore
+	ClientStatsStore
+	Keys
+	Passwords
+	Audit
+	SavedJobs
+	JobHistory
+	QueryCooldowns
+	QueryYields
+
+	// Stats counts the entries of the store.
+	Stats() (Stats, error)
+	// Ping checks that the store can still be read.
+	Ping() error
+	Close() error
+}
+
+// Compacter is implemented by stores whose files can be compacted while they are in use.
+type Compacter interface {
+	// Compact returns the free space of the store's file to the file system.
+	Compact() (CompactResult, error)
+}
+
+// CompactResult is the size in bytes of a store's file before and after compaction.
+type CompactResult struct {
+	Before int64 `json:"before"`
+	After  int64 `json:"after"`
+}
+
+// History keeps the hashes and pin IDs of the images each client has seen.
+type History interface {
+	// HasClientSeenImage checks if a client has already seen an image with the given hash.
+	HasClientSeenImage(clientName string, hash uint64) (bool, error)
+	// HasClientSeenPin checks if a client has already been sent the pin with the given ID.
+	HasClientSeenPin(clientName, pinID string) (bool, error)
+	// MarkImageAsSeen marks an image as seen for a specific client, by its hash and, unless pinID is
+	// empty, the ID of its pin.
+	MarkImageAsSeen(clientName string, hash uint64, pinID string) error
+	// ClearClientHistory removes all records for a given client.
+	ClearClientHistory(clientName string) error
+	// CleanupOldEntries removes the entries that are older than the retention of their client.
+	CleanupOldEntries(retention Retention) error
+}
+
+// Retention is how long history entries are kept: MaxAge, or the age in Clients for the clients listed
+// there. An age of zero keeps the entries forever.
+type Retention struct {
+	MaxAge  time.Duration
+	Clients map[string]time.Duration
+}
+
+// For returns how long the entries of a client are kept.
+func (r Retention) For(clientName string) time.Duration {
+	if age, ok := r.Clients[clientName]; ok {
+		return age
+	}
+	return r.MaxAge
+}
+
+// Expires reports whether the entries of any client expire.
+func (r Retention) Expires() bool {
+	if r.MaxAge > 0 {
+		return true
+	}
+	for _, age := range r.Clients {
+		if age > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UsageStore counts the images and bytes delivered to each client per day and month.
+type UsageStore interface {
+	// AddUsage adds delivered images and bytes to a client's usage of the UTC day and month containing
+	// at, and to the lifetime totals of its ClientStats.
+	AddUsage(clientName string, at time.Time, delta Usage) error
+	// GetUsage returns a client's usage of the UTC day and month containing at.
+	GetUsage(clientName string, at time.Time) (day, month Usage, err error)
+	// PruneUsage removes the usage of the UTC days and months that ended before the ones containing before.
+	PruneUsage(before time.Time) error
+}
+
+// ClientStatsStore keeps the lifetime counters of each client.
+type ClientStatsStore interface {
+	// AddClientStats adds counters to a client's statistics. A later LastConnected replaces the stored one.
+	AddClientStats(clientName string, delta ClientStats) error
+	// GetClientStats returns a client's statistics, which are empty if it never connected.
+	GetClientStats(clientName string) (ClientStats, error)
+	// ListClientStats returns the statistics of every client.
+	ListClientStats() (map[string]ClientStats, error)
+}
+
+// Keys keeps the API keys.
+type Keys interface {
+	// AddAPIKey stores an API key, replacing the key with the same ID.
+	AddAPIKey(key APIKey) error
+	// GetAPIKey returns the API key with the given ID, or nil if there is none.
+	GetAPIKey(id string) (*APIKey, error)
+	// ListAPIKeys returns all API keys, oldest first.
+	ListAPIKeys() ([]APIKey, error)
+	// RevokeAPIKey marks an API key as revoked. It reports false if there is no such key.
+	RevokeAPIKey(id string) (bool, error)
+	// ExpireAPIKey ends the validity of an API key at the given time. It reports false if there is no such key.
+	ExpireAPIKey(id string, at time.Time) (bool, error)
+}
+
+// Passwords keeps the passwords that clients rotated, which take precedence over the config.
+type Passwords interface {
+	// GetPassword returns the rotated password of a client, or nil if it never rotated its password.
+	GetPassword(clientName string) (*StoredPassword, error)
+	// SetPassword stores the rotated password of a client.
+	SetPassword(clientName string, stored StoredPassword) error
+	// DeletePassword removes the rotated password of a client, so that its configured password applies
+	// again. Deleting a password that doesn't exist is not an error.
+	DeletePassword(clientName string) error
+}
+
+// SavedJobs keeps the interactive job of each client while it runs, so that a job interrupted by a
+// restart can be resumed.
+type SavedJobs interface {
+	// SaveJob stores the progress of a client's job, replacing the one saved before.
+	SaveJob(job SavedJob) error
+	// GetSavedJob returns the saved job of a client, or nil if it has none.
+	GetSavedJob(clientName string) (*SavedJob, error)
+	// DeleteSavedJob removes the saved job of a client.
+	DeleteSavedJob(clientName string) error
+}
+
+// JobHistory keeps the jobs that ended.
+type JobHistory interface {
+	// AddJobRecord appends a finished job to the history.
+	AddJobRecord(rec JobRecord) error
+	// ListJobRecords returns the finished jobs matching a filter, newest first.
+	ListJobRecords(filter JobFilter) ([]JobRecord, error)
+	// PruneJobHistory removes the jobs that finished more than maxAge ago.
+	PruneJobHistory(maxAge time.Duration) error
+}
+
+// QueryCooldowns keeps when the queries of each source, such as "pinterest", were last found exhausted
+// without any results, so that they are skipped for a while across jobs and restarts.
+type QueryCooldowns interface {
+	// MarkQueryExhausted records that a query of a source ran out of results at the given time.
+	MarkQueryExhausted(source, query string, at time.Time) error
+	// ExhaustedQueries returns when each query of a source was last found exhausted.
+	ExhaustedQueries(source string) (map[string]time.Time, error)
+	// PruneQueryCooldowns forgets the queries that were found exhausted more than maxAge ago.
+	PruneQueryCooldowns(maxAge time.Duration) error
+}
+
+// QueryYields keeps the lifetime yield of each query across every client, so that operators can prune
+// the queries that yield little.
+type QueryYields interface {
+	// AddQueryYield adds counters to a query's yield. A later LastScraped replaces the stored one.
+	AddQueryYield(query string, delta QueryYield) error
+	// ListQueryYields returns the yield of every query that was scraped.
+	ListQueryYields() (map[string]QueryYield, error)
+}
+
+// Audit keeps the audit log.
+type Audit interface {
+	// AddAuditEntry appends an entry to the audit log.
+	AddAuditEntry(entry AuditEntry) error
+	// ListAuditEntries returns the audit entries matching a filter, newest first.
+	ListAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+	// PruneAuditLog removes audit entries older than maxAge.
+	PruneAuditLog(maxAge time.Duration) error
+}
+
+// Usage is the amount of images and bytes delivered to a client in a period.
+type Usage struct {
+	Images int   `json:"images"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// Add adds the counters of delta to the usage.
+func (u *Usage) Add(delta Usage) {
+	u.Images += delta.Images
+	u.Bytes += delta.Bytes
+}
+
+// ClientStats are the lifetime counters of a client.
+type ClientStats struct {
+	// Images and Bytes count what was delivered to the client.
+	Images int64 `json:"images"`
+	Bytes  int64 `json:"bytes"`
+	// Deduped counts the images skipped because the client had already seen them.
+	Deduped int64 `json:"deduped"`
+	Jobs    int64 `json:"jobs"`
+	// LastConnected is when the client last opened a WebSocket connection or started a job over REST or gRPC.
+	LastConnected time.Time             `json:"lastConnected,omitzero"`
+	Queries       map[string]QueryStats `json:"queries,omitempty"`
+}
+
+// Add adds the counters of delta to the statistics and keeps the later LastConnected.
+func (s *ClientStats) Add(delta ClientStats) {
+	s.Images += delta.Images
+	s.Bytes += delta.Bytes
+	s.Deduped += delta.Deduped
+	s.Jobs += delta.Jobs
+	if delta.LastConnected.After(s.LastConnected) {
+		s.LastConnected = delta.LastConnected
+	}
+	for q, d := range delta.Queries {
+		if s.Queries == nil {
+			s.Queries = make(map[string]QueryStats)
+		}
+		t := s.Queries[q]
+		t.Sent += d.Sent
+		t.Deduped += d.Deduped
+		t.Failed += d.Failed
+		s.Queries[q] = t
+	}
+}
+
+// QueryStats are the lifetime totals of a client's query.
+type QueryStats struct {
+	Sent    int64 `json:"sent"`
+	Deduped int64 `json:"deduped"`
+	Failed  int64 `json:"failed"`
+}
+
+// QueryYield are the lifetime counters of the scrapes of a query by every job.
+type QueryYield struct {
+	// Scrapes counts the scrapes of the query, of which Exhausted ran out of results and Failed failed.
+	Scrapes   int64 `json:"scrapes"`
+	Exhausted int64 `json:"exhausted"`
+	Failed    int64 `json:"failed"`
+	// Found counts the pins the scrapes found, of which Unseen were new to the client of the job.
+	Found       int64     `json:"found"`
+	Unseen      int64     `json:"unseen"`
+	LastScraped time.Time `json:"lastScraped,omitzero"`
+}
+
+// Add adds the counters of delta to the yield and keeps the later LastScraped.
+func (y *QueryYield) Add(delta QueryYield) {
+	y.Scrapes += delta.Scrapes
+	y.Exhausted += delta.Exhausted
+	y.Failed += delta.Failed
+	y.Found += delta.Found
+	y.Unseen += delta.Unseen
+	if delta.LastScraped.After(y.LastScraped) {
+		y.LastScraped = delta.LastScraped
+	}
+}
+
+// APIKey is a client API key. Only the bcrypt hash of its secret is stored.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client"`
+	Hash    string    `json:"hash"`
+	Role    string    `json:"role,omitempty"` // overrides the client's configured role if set
+	Created time.Time `json:"created"`
+	Revoked time.Time `json:"revoked,omitzero"`
+	// Expires is set when the key was rotated and ends its grace period.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Active reports whether the key can still be used.
+func (k *APIKey) Active() bool {
+	return k.Revoked.IsZero() && (k.Expires.IsZero() || time.Now().Before(k.Expires))
+}
+
+// StoredPassword is a rotated client password. The previous password remains valid until PreviousExpires.
+type StoredPassword struct {
+	Hash            string    `json:"hash"`
+	Previous        string    `json:"previous,omitempty"`
+	PreviousExpires time.Time `json:"previousExpires,omitzero"`
+	Rotated         time.Time `json:"rotated"`
+	// Configured is a hash of the configured password of the client when it rotated, so that the rotated
+	// password is dropped once the configured one changes.
+	Configured string `json:"configured,omitempty"`
+}
+
+// SavedJob is what is left of a client's interactive job.
+type SavedJob struct {
+	Client string `json:"client"`
+	// Queries are the queries that weren't retired yet.
+	Queries []string `json:"queries"`
+	// Limit is the number of images the job still delivers.
+	Limit         int `json:"limit"`
+	LimitPerQuery int `json:"limitPerQuery,omitempty"`
+	// SentPerQuery counts the images delivered for each query, which LimitPerQuery caps.
+	SentPerQuery  map[string]int `json:"sentPerQuery,omitempty"`
+	MinImageBytes int            `json:"minImageBytes,omitempty"`
+	MaxImageBytes int            `json:"maxImageBytes,omitempty"`
+	// Exclude are the keywords of the pins the job drops.
+	Exclude []string `json:"exclude,omitempty"`
+	// QueryWeights are the weights of the queries that don't weigh 1.
+	QueryWeights map[string]int `json:"queryWeights,omitempty"`
+	// QueryOrder is the order the queries are scraped in, such as "sequential".
+	QueryOrder string `json:"queryOrder,omitempty"`
+	// Variants scrape the variants of the queries once they are exhausted, unless they already were.
+	Variants bool      `json:"variants,omitempty"`
+	Saved    time.Time `json:"saved"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditConnect     = "connect"
+	AuditAuthFailure = "auth_failure"
+	AuditScrape      = "scrape"
+	AuditSubscribe   = "subscribe"
+	AuditClear       = "clear"
+	AuditRotate      = "rotate_credential"
+	AuditKeyAdd      = "key_add"
+	AuditKeyRevoke   = "key_revoke"
+	AuditLogLevel    = "log_level"
+	AuditStopJob     = "stop_job"
+	AuditDisconnect  = "disconnect"
+	AuditCleanup     = "cleanup"
+	AuditCompact     = "compact"
+	AuditBlocked     = "blocked_query"
+)
+
+// AuditEntry records who did what, when and from where.
+type AuditEntry struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+	Addr   string    `json:"addr,omitempty"`
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	Client string
+	Action string
+	// Before only returns entries with a lower sequence number, for paging backwards.
+	Before uint64
+	Limit  int
+}
+
+// JobRecord describes a finished job.
+type JobRecord struct {
+	Seq    uint64 `json:"seq"`
+	ID     string `json:"id"`
+	Client string `json:"client"`
+	// Kind is how the job was started: websocket, rest or grpc.
+	Kind     string    `json:"kind"`
+	Queries  []string  `json:"queries"`
+	Limit    int       `json:"limit"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Reason is why the job ended, or "disconnected" if its client went away before.
+	Reason  string `json:"reason"`
+	Sent    int    `json:"sent"`
+	Deduped int    `json:"deduped"`
+	Failed  int    `json:"failed"`
+	// QueryStats holds the totals of each query, and Errors the last error of each query that failed.
+	QueryStats map[string]QueryStats `json:"queryStats,omitempty"`
+	Errors     map[string]string     `json:"errors,omitempty"`
+}
+
+// JobFilter selects finished jobs. Zero fields match everything.
+type JobFilter struct {
+	Client string
+	// Before only returns jobs with a lower sequence number, for paging backwards.
+	Before uint64
+	Limit  int
+}
+
+// Stats describes the size and contents of a store.
+type Stats struct {
+	// Size is the size of the database files in bytes, including a separate history database.
+	Size int64 `json:"size"`
+	// HistorySize is the part of Size taken by a separate history database, such as SQLite or Postgres.
+	HistorySize int64 `json:"historySize"`
+	// Buckets is the number of buckets in the bbolt file, and SchemaVersion the version of its schema.
+	Buckets       int `json:"buckets"`
+	SchemaVersion int `json:"schemaVersion"`
+	// Clients is the number of clients with a history of seen images.
+	Clients int `json:"clients"`
+	// SeenImages is the number of history entries across all clients, and ClientEntries that of each client.
+	SeenImages    int            `json:"seenImages"`
+	ClientEntries map[string]int `json:"clientEntries"`
+	AuditEntries  int            `json:"auditEntries"`
+	APIKeys       int            `json:"apiKeys"`
+}